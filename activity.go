@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// ActivityReport counts the lines and bytes of output by wall-clock bucket,
+// such as each minute, to locate quiet periods of long runs.
+type ActivityReport struct {
+	Bucket time.Duration
+
+	start   time.Time
+	buckets []activityBucket
+}
+
+type activityBucket struct {
+	lines int
+	bytes int
+}
+
+func NewActivityReport(bucket time.Duration, start time.Time) *ActivityReport {
+	return &ActivityReport{Bucket: bucket, start: start}
+}
+
+// bucketStart returns the start of the bucket containing t, aligned to the
+// wall clock of t's location, so that minutes start on the minute.
+func (r *ActivityReport) bucketStart(t time.Time) time.Time {
+	_, offset := t.Zone()
+	shift := time.Duration(offset) * time.Second
+	return t.Add(shift).Truncate(r.Bucket).Add(-shift)
+}
+
+func (r *ActivityReport) bucketIndex(t time.Time) int {
+	i := int(r.bucketStart(t).Sub(r.bucketStart(r.start)) / r.Bucket)
+	if i < 0 {
+		return 0
+	}
+	return i
+}
+
+// Record accounts for a line of n bytes printed at time t.
+func (r *ActivityReport) Record(t time.Time, n int) {
+	if r == nil {
+		return
+	}
+	i := r.bucketIndex(t)
+	for len(r.buckets) <= i {
+		r.buckets = append(r.buckets, activityBucket{})
+	}
+	r.buckets[i].lines++
+	r.buckets[i].bytes += n
+}
+
+// Print writes a table of the buckets from the start of the run to end to
+// w, including the empty ones, labeled with the time each starts.
+func (r *ActivityReport) Print(w io.Writer, end time.Time) {
+	layout := "15:04"
+	if r.Bucket%time.Minute != 0 {
+		layout = "15:04:05"
+	}
+	first := r.bucketStart(r.start)
+	if first.YearDay() != r.bucketStart(end).YearDay() || first.Year() != end.Year() {
+		layout = "2006-01-02 " + layout
+	}
+	n := r.bucketIndex(end) + 1
+	if n < len(r.buckets) {
+		n = len(r.buckets)
+	}
+	labelWidth := len(layout)
+	fmt.Fprintf(w, "ets activity report (per %s):\n", formatBucketBound(r.Bucket))
+	fmt.Fprintf(w, "  %-*s  %8s  %10s\n", labelWidth, "time", "lines", "bytes")
+	for i := 0; i < n; i++ {
+		var bucket activityBucket
+		if i < len(r.buckets) {
+			bucket = r.buckets[i]
+		}
+		label := first.Add(time.Duration(i) * r.Bucket).Format(layout)
+		fmt.Fprintf(w, "  %-*s  %8d  %10d\n", labelWidth, label, bucket.lines, bucket.bytes)
+	}
+}
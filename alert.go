@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// How often alert conditions are checked.
+const alertCheckInterval = time.Second
+
+var alertVariables = map[string]*ruleExpr{
+	"duration": {ruleDuration, func(env *ruleEnv) interface{} { return env.elapsed }},
+	"gap":      {ruleDuration, func(env *ruleEnv) interface{} { return env.gap }},
+	"lines":    {ruleNumber, func(env *ruleEnv) interface{} { return float64(env.lineno) }},
+}
+
+// Alert posts to a webhook while the run is in progress when its condition,
+// given by --alert-on, starts to hold, for escalating stuck jobs.
+type Alert struct {
+	URL       string
+	Condition string
+	when      *ruleExpr
+	// Whether the condition held at the previous check, so that the alert
+	// fires again only after it stopped holding.
+	firing bool
+	posts  sync.WaitGroup
+}
+
+// The payload posted by --alert-webhook. Durations are in seconds.
+type alertJSON struct {
+	Alert   string    `json:"alert"`
+	Command []string  `json:"command,omitempty"`
+	Time    time.Time `json:"time"`
+	Elapsed float64   `json:"elapsed"`
+	Gap     float64   `json:"gap"`
+	Lines   int       `json:"lines"`
+}
+
+// NewAlert parses an alert posted to url when condition starts to hold.
+func NewAlert(url string, condition string) (*Alert, error) {
+	expr, err := parseRuleExpr(condition, alertVariables)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --alert-on %q: %s", condition, err)
+	}
+	if expr.typ != ruleBool {
+		return nil, fmt.Errorf("invalid --alert-on %q: not a condition", condition)
+	}
+	return &Alert{URL: url, Condition: condition, when: expr}, nil
+}
+
+// watchAlert checks the condition of alert every alertCheckInterval until
+// done is closed.
+func watchAlert(printer *Printer, alert *Alert, done <-chan struct{}) {
+	ticker := time.NewTicker(alertCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			printer.CheckAlert(alert)
+		}
+	}
+}
+
+// CheckAlert fires alert, noting it in an annotation, if its condition has
+// started to hold.
+func (p *Printer) CheckAlert(alert *Alert) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	env := &ruleEnv{
+		elapsed: now.Sub(p.Summary.Start),
+		gap:     now.Sub(p.Summary.lastLine),
+		lineno:  p.Summary.Lines,
+	}
+	holds := alert.when.eval(env).(bool)
+	if !holds || alert.firing {
+		alert.firing = holds
+		return
+	}
+	alert.firing = true
+	p.printAnnotation("alert: " + alert.Condition)
+	body, err := json.Marshal(alertJSON{
+		Alert:   alert.Condition,
+		Command: p.Summary.Command,
+		Time:    now,
+		Elapsed: env.elapsed.Seconds(),
+		Gap:     env.gap.Seconds(),
+		Lines:   env.lineno,
+	})
+	if err != nil {
+		log.Printf("error posting alert: %s", err)
+		return
+	}
+	alert.posts.Add(1)
+	go func() {
+		defer alert.posts.Done()
+		if err := postJSON(alert.URL, body); err != nil {
+			log.Printf("error posting alert to %s: %s", alert.URL, err)
+		}
+	}()
+}
+
+// Wait waits for the alerts fired to be posted.
+func (a *Alert) Wait() {
+	a.posts.Wait()
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// Number of hex digits of the chain hash shown on each line.
+const auditTagDigits = 12
+
+// AuditChain links the lines of the output in a hash chain, so that edits to
+// a captured log after the fact are detectable. The hash of each line is the
+// SHA-256 of the hash of the previous line (32 zero bytes for the first),
+// the text preceding its tag, a space, and the text following the tag and
+// its separating space, including the line ending, all without ANSI escape
+// sequences.
+type AuditChain struct {
+	// Key, if not nil, signs the head of the chain.
+	Key ed25519.PrivateKey
+
+	head  [sha256.Size]byte
+	lines int
+}
+
+// Next chains a line made of prefix and text, and returns the tag to insert
+// between them.
+func (c *AuditChain) Next(prefix string, text string) string {
+	h := sha256.New()
+	h.Write(c.head[:])
+	h.Write([]byte(ansiEscapes.ReplaceAllString(prefix, "")))
+	h.Write([]byte(" "))
+	h.Write([]byte(ansiEscapes.ReplaceAllString(text, "")))
+	h.Sum(c.head[:0])
+	c.lines++
+	return "#" + hex.EncodeToString(c.head[:])[:auditTagDigits]
+}
+
+// Head returns the full hash of the last line chained.
+func (c *AuditChain) Head() string {
+	return hex.EncodeToString(c.head[:])
+}
+
+// Signature returns the base64-encoded Ed25519 signature of the 32 bytes of
+// the hash of the last line chained.
+func (c *AuditChain) Signature() string {
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(c.Key, c.head[:]))
+}
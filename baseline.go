@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// baseline records the phase durations of a reference run, in seconds.
+type baseline struct {
+	Command  []string        `json:"command,omitempty"`
+	Duration float64         `json:"duration"`
+	Phases   []baselinePhase `json:"phases"`
+}
+
+type baselinePhase struct {
+	Name     string  `json:"name"`
+	Duration float64 `json:"duration"`
+}
+
+func newBaseline(s *Summary) *baseline {
+	b := &baseline{Command: s.Command, Duration: s.Duration().Seconds(), Phases: []baselinePhase{}}
+	for _, phase := range s.Phases {
+		b.Phases = append(b.Phases, baselinePhase{phase.Name, phase.Duration().Seconds()})
+	}
+	return b
+}
+
+// readBaseline reads the baseline at path, returning nil if there is none.
+func readBaseline(path string) (*baseline, error) {
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	b := &baseline{}
+	if err := json.Unmarshal(content, b); err != nil {
+		return nil, fmt.Errorf("invalid baseline %s: %s", path, err)
+	}
+	return b, nil
+}
+
+// writeBaseline replaces the baseline at path with the run recorded in s.
+func writeBaseline(path string, s *Summary) error {
+	content, err := json.MarshalIndent(newBaseline(s), "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(content, '\n')); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (b *baseline) timedPhases() []timedPhase {
+	phases := make([]timedPhase, len(b.Phases))
+	for i, phase := range b.Phases {
+		phases[i] = timedPhase{phase.Name, secondsDuration(phase.Duration)}
+	}
+	return phases
+}
+
+func secondsDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// compareBaseline writes the changes in the durations of the phases of the
+// run recorded in s, and of the whole run, from the baseline at path, by at
+// least threshold percent and minChange, as ets diff does, and returns
+// whether any got slower by that much.
+func compareBaseline(w io.Writer, path string, b *baseline, s *Summary, threshold float64, minChange time.Duration, color bool) bool {
+	phases := make([]timedPhase, len(s.Phases))
+	for i, phase := range s.Phases {
+		phases[i] = timedPhase{phase.Name, phase.Duration()}
+	}
+	changes, missing := phaseChanges(path, b.timedPhases(), "this run", phases)
+
+	fmt.Fprintf(w, "ets baseline %s:\n", path)
+	regressed := false
+	for _, c := range changes {
+		if c.significant(threshold, minChange) {
+			fmt.Fprintln(w, c.format(color))
+			regressed = regressed || c.change() > 0
+		}
+	}
+	for _, m := range missing {
+		fmt.Fprintln(w, m)
+	}
+	total := timingChange{before: secondsDuration(b.Duration), after: s.Duration()}
+	fmt.Fprintf(w, "%-7s %s\n", "total", total.columns())
+	if total.significant(threshold, minChange) && total.change() > 0 {
+		regressed = true
+	}
+	return regressed
+}
+
+// parsePercentage parses a percentage such as 20% or 20.
+func parsePercentage(s string) (float64, error) {
+	percentage, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+	if err != nil || percentage < 0 {
+		return 0, fmt.Errorf("invalid percentage %q", s)
+	}
+	return percentage, nil
+}
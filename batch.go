@@ -0,0 +1,237 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// BatchLimits bound the batches of lines written to a file or sent over the
+// network at once. Zero values leave the choice to the writer or sink.
+type BatchLimits struct {
+	// A batch is written once it has Lines lines or Bytes bytes, or
+	// Interval after the previous one.
+	Lines    int
+	Bytes    int
+	Interval time.Duration
+	// Stats, if not nil, accounts for the batches.
+	Stats *BatchStats
+}
+
+// withDefaults returns the limits with the given defaults for those unset.
+func (l BatchLimits) withDefaults(lines int, bytes int, interval time.Duration) BatchLimits {
+	if l.Lines <= 0 {
+		l.Lines = lines
+	}
+	if l.Bytes <= 0 {
+		l.Bytes = bytes
+	}
+	if l.Interval <= 0 {
+		l.Interval = interval
+	}
+	return l
+}
+
+// full reports whether a batch of lines and bytes reaches the limits.
+// Limits of 0 are no limits.
+func (l BatchLimits) full(lines int, bytes int) bool {
+	return (l.Lines > 0 && lines >= l.Lines) || (l.Bytes > 0 && bytes >= l.Bytes)
+}
+
+// BatchStats counts the batches written or sent, and their sizes, to tell
+// how many lines each write or request carries.
+type BatchStats struct {
+	mu       sync.Mutex
+	Batches  int
+	Lines    int
+	Bytes    int64
+	MaxLines int
+	MaxBytes int64
+}
+
+// Record accounts for a batch of lines and bytes.
+func (s *BatchStats) Record(lines int, bytes int) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Batches++
+	s.Lines += lines
+	s.Bytes += int64(bytes)
+	if lines > s.MaxLines {
+		s.MaxLines = lines
+	}
+	if int64(bytes) > s.MaxBytes {
+		s.MaxBytes = int64(bytes)
+	}
+}
+
+// Averages returns the average lines and bytes per batch.
+func (s *BatchStats) Averages() (lines float64, bytes float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Batches == 0 {
+		return 0, 0
+	}
+	return float64(s.Lines) / float64(s.Batches), float64(s.Bytes) / float64(s.Batches)
+}
+
+func (s *BatchStats) String() string {
+	lines, bytes := s.Averages()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return fmt.Sprintf("%d (%.1f lines, %s on average; %d lines, %s at most)",
+		s.Batches, lines, formatBytes(int64(bytes)), s.MaxLines, formatBytes(s.MaxBytes))
+}
+
+// eventBatcher queues events in memory for a sink sending them over the
+// network in batches in the background, backing off while sending fails.
+// When the queue is full, the oldest events are dropped.
+type eventBatcher struct {
+	name     string
+	send     func(events [][]byte) error
+	maxQueue int
+	limits   BatchLimits
+
+	mu    sync.Mutex
+	queue [][]byte
+	// queuedBytes is the size of the events in the queue.
+	queuedBytes int
+	// removed counts the events ever removed from the head of the queue,
+	// sent or dropped.
+	removed int
+	dropped int
+	closing bool
+	wake    chan struct{}
+	done    chan struct{}
+}
+
+// Bounds of the delay between attempts at sending a batch.
+const (
+	batchMinBackoff = time.Second
+	batchMaxBackoff = 30 * time.Second
+)
+
+// newEventBatcher starts sending batches of events within limits with send,
+// at most every limits.Interval unless a batch is full, holding up to
+// maxQueue events. name identifies the sink in errors.
+func newEventBatcher(name string, send func(events [][]byte) error, maxQueue int, limits BatchLimits) *eventBatcher {
+	b := &eventBatcher{
+		name:     name,
+		send:     send,
+		maxQueue: maxQueue,
+		limits:   limits,
+		wake:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+// Add queues an event.
+func (b *eventBatcher) Add(event []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.queue) >= b.maxQueue {
+		b.queuedBytes -= len(b.queue[0])
+		b.queue = b.queue[1:]
+		b.removed++
+		b.dropped++
+	}
+	b.queue = append(b.queue, event)
+	b.queuedBytes += len(event)
+	if b.limits.full(len(b.queue), b.queuedBytes) {
+		b.signal()
+	}
+}
+
+func (b *eventBatcher) signal() {
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (b *eventBatcher) run() {
+	defer close(b.done)
+	backoff := time.Duration(0)
+	for {
+		b.mu.Lock()
+		ready := b.closing || b.limits.full(len(b.queue), b.queuedBytes)
+		b.mu.Unlock()
+		if backoff > 0 || !ready {
+			delay := b.limits.Interval
+			if backoff > 0 {
+				delay = backoff
+			}
+			select {
+			case <-b.wake:
+			case <-time.After(delay):
+			}
+		}
+		b.mu.Lock()
+		// Take at least one event, however large.
+		n, size := 0, 0
+		for n < len(b.queue) && (n == 0 || !b.limits.full(n, size+len(b.queue[n]))) {
+			size += len(b.queue[n])
+			n++
+		}
+		batch := b.queue[:n:n]
+		first := b.removed
+		closing := b.closing
+		b.mu.Unlock()
+		if n == 0 {
+			if closing {
+				return
+			}
+			continue
+		}
+		if err := b.send(batch); err != nil {
+			if backoff == 0 {
+				log.Printf("error sending to %s: %s; retrying", b.name, err)
+				backoff = batchMinBackoff
+			} else if backoff *= 2; backoff > batchMaxBackoff {
+				backoff = batchMaxBackoff
+			}
+			continue
+		}
+		if backoff > 0 {
+			log.Printf("sending to %s again", b.name)
+			backoff = 0
+		}
+		b.mu.Lock()
+		// Some of the batch may have been dropped from the queue meanwhile.
+		if sent := first + n - b.removed; sent > 0 {
+			for _, event := range b.queue[:sent] {
+				b.queuedBytes -= len(event)
+			}
+			b.queue = b.queue[sent:]
+			b.removed += sent
+		}
+		b.mu.Unlock()
+		b.limits.Stats.Record(n, size)
+	}
+}
+
+// Close sends the events queued, waiting up to timeout, and reports those
+// dropped or left unsent.
+func (b *eventBatcher) Close(timeout time.Duration) {
+	b.mu.Lock()
+	b.closing = true
+	b.mu.Unlock()
+	b.signal()
+	select {
+	case <-b.done:
+	case <-time.After(timeout):
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.dropped > 0 {
+		log.Printf("%d events dropped from the full queue for %s", b.dropped, b.name)
+	}
+	if len(b.queue) > 0 {
+		log.Printf("%d events not sent to %s", len(b.queue), b.name)
+	}
+}
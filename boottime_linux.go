@@ -0,0 +1,20 @@
+package main
+
+import (
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// CLOCK_BOOTTIME, missing from package syscall.
+const clockBoottime = 7
+
+// bootClock returns the time since boot by CLOCK_BOOTTIME, which, unlike
+// CLOCK_MONOTONIC, includes the time the system spent suspended.
+func bootClock() (time.Duration, bool) {
+	var ts syscall.Timespec
+	if _, _, errno := syscall.Syscall(syscall.SYS_CLOCK_GETTIME, clockBoottime, uintptr(unsafe.Pointer(&ts)), 0); errno != 0 {
+		return 0, false
+	}
+	return time.Duration(ts.Nano()), true
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "time"
+
+func bootClock() (time.Duration, bool) {
+	return 0, false
+}
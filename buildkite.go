@@ -0,0 +1,14 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// buildkiteTimestamp returns the APC escape sequence with which the
+// Buildkite agent marks the time of a line, in milliseconds since the epoch.
+// Terminals ignore it, and the Buildkite UI renders it as the line's
+// timestamp.
+func buildkiteTimestamp(t time.Time) string {
+	return fmt.Sprintf("\x1b_bk;t=%d\x07", t.UnixNano()/int64(time.Millisecond))
+}
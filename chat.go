@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The number of phases listed in chat messages.
+const chatSlowestPhases = 3
+
+// postChatMessage posts a message reporting the run summarized in s to a
+// Slack or Discord incoming webhook at url, which take the text of the
+// message in field, text or content respectively.
+func postChatMessage(url string, field string, s *Summary) error {
+	body, err := json.Marshal(map[string]string{field: describeRunForChat(s)})
+	if err != nil {
+		return err
+	}
+	return postJSON(url, body)
+}
+
+// describeRunForChat describes in a few words how the run summarized in s
+// went, and its slowest phases, e.g.
+//
+//	:x: `make test` exited with status 2 after 12m34s
+//	slowest phases: build 8m02s, test 3m10s, lint 12s
+//
+// in the markdown of both Slack and Discord.
+func describeRunForChat(s *Summary) string {
+	icon := ":white_check_mark:"
+	if s.Exited && s.ExitCode != 0 {
+		icon = ":x:"
+	}
+	run := "ets"
+	if len(s.Command) > 0 {
+		run = "`" + strings.ReplaceAll(strings.Join(s.Command, " "), "`", "'") + "`"
+	}
+	duration := formatChatDuration(s.Duration())
+	var message string
+	if s.Exited {
+		message = fmt.Sprintf("%s %s exited with status %d after %s", icon, run, s.ExitCode, duration)
+	} else {
+		message = fmt.Sprintf("%s %s: input ended after %s", icon, run, duration)
+	}
+	if len(s.Phases) == 0 {
+		return message
+	}
+	phases := make([]*Phase, len(s.Phases))
+	copy(phases, s.Phases)
+	sort.SliceStable(phases, func(i, j int) bool { return phases[i].Duration() > phases[j].Duration() })
+	if len(phases) > chatSlowestPhases {
+		phases = phases[:chatSlowestPhases]
+	}
+	slowest := make([]string, len(phases))
+	for i, phase := range phases {
+		slowest[i] = phase.Name + " " + formatChatDuration(phase.Duration())
+	}
+	return message + "\nslowest phases: " + strings.Join(slowest, ", ")
+}
+
+// formatChatDuration formats d with units scaled to its magnitude, as 3.4s,
+// 45s, 12m34s, or 2h05m.
+func formatChatDuration(d time.Duration) string {
+	return strings.TrimPrefix(formatHumanDuration(d), "+")
+}
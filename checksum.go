@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+)
+
+// Algorithms accepted by --checksum.
+var checksumAlgorithms = []string{"md5", "sha1", "sha256", "sha512"}
+
+// Checksum digests the raw output of a run, as received from the command
+// and before it is filtered, split into lines or timestamped.
+type Checksum struct {
+	Algorithm string
+	hash      hash.Hash
+}
+
+func NewChecksum(algorithm string) (*Checksum, error) {
+	var h hash.Hash
+	switch algorithm {
+	case "md5":
+		h = md5.New()
+	case "sha1":
+		h = sha1.New()
+	case "sha256":
+		h = sha256.New()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return nil, fmt.Errorf("unknown checksum algorithm %q: expected md5, sha1, sha256, or sha512", algorithm)
+	}
+	return &Checksum{Algorithm: algorithm, hash: h}, nil
+}
+
+// Add adds a chunk of raw output.
+func (c *Checksum) Add(b []byte) {
+	if c == nil {
+		return
+	}
+	c.hash.Write(b)
+}
+
+// Sum returns the hex digest of the output so far.
+func (c *Checksum) Sum() string {
+	return hex.EncodeToString(c.hash.Sum(nil))
+}
+
+func (c *Checksum) String() string {
+	return c.Algorithm + ":" + c.Sum()
+}
+
+// checksumWriter feeds the output of a stream, as it is read, to the
+// checksum of the printer's current run. The output of a command on a
+// pseudo-terminal has its line feeds turned into CRLF by the terminal
+// driver, which is undone with terminal.
+type checksumWriter struct {
+	printer  *Printer
+	terminal bool
+	// cr holds back a CR ending the previous chunk, which may be the start
+	// of a CRLF.
+	cr bool
+}
+
+func (w *checksumWriter) Write(b []byte) (int, error) {
+	n := len(b)
+	if n == 0 {
+		return 0, nil
+	}
+	if w.terminal {
+		var out []byte
+		if w.cr && b[0] != '\n' {
+			out = append(out, '\r')
+		}
+		w.cr = b[n-1] == '\r'
+		if w.cr {
+			b = b[:n-1]
+		}
+		b = append(out, bytes.Replace(b, []byte("\r\n"), []byte("\n"), -1)...)
+	}
+	w.printer.mu.Lock()
+	w.printer.Summary.Checksum.Add(b)
+	w.printer.mu.Unlock()
+	return n, nil
+}
+
+// Flush adds a CR held back at the end of the stream.
+func (w *checksumWriter) Flush() {
+	if w.cr {
+		w.cr = false
+		w.printer.mu.Lock()
+		w.printer.Summary.Checksum.Add([]byte("\r"))
+		w.printer.mu.Unlock()
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// chunkedFile writes to a sequence of files named after path, as
+// out.0001.log, out.0002.log, and so on for out.log, starting a new one once
+// the current one would exceed size bytes, or has been written to for
+// interval. Lines are never split between files. Limits of 0 are no limits.
+type chunkedFile struct {
+	path     string
+	size     int64
+	interval time.Duration
+
+	n       int
+	f       *os.File
+	written int64
+	started time.Time
+}
+
+func newChunkedFile(path string, size int64, interval time.Duration) (*chunkedFile, error) {
+	c := &chunkedFile{path: path, size: size, interval: interval}
+	if err := c.next(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Write writes b to the current file, or to a new one if it is full. Writes
+// are kept whole, so that lines aren't split between files.
+func (c *chunkedFile) Write(b []byte) (int, error) {
+	full := c.size > 0 && c.written > 0 && c.written+int64(len(b)) > c.size
+	if full || (c.interval > 0 && time.Since(c.started) >= c.interval) {
+		if err := c.next(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := c.f.Write(b)
+	c.written += int64(n)
+	return n, err
+}
+
+// next closes the current file, if any, and creates the next one, skipping
+// those left by previous runs.
+func (c *chunkedFile) next() error {
+	if c.f != nil {
+		_ = c.f.Close()
+	}
+	ext := filepath.Ext(c.path)
+	base := strings.TrimSuffix(c.path, ext)
+	for {
+		c.n++
+		f, err := os.OpenFile(fmt.Sprintf("%s.%04d%s", base, c.n, ext), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		c.f, c.written, c.started = f, 0, time.Now()
+		return nil
+	}
+}
+
+// parseSize parses a number of bytes with an optional binary suffix, K, M,
+// G, or T, as 100M.
+func parseSize(s string) (int64, error) {
+	shift := uint(0)
+	if i := strings.IndexAny(s, "KMGT"); i >= 0 && i == len(s)-1 {
+		shift = 10 * uint(strings.IndexByte("KMGT", s[i])+1)
+		s = s[:i]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 || n > math.MaxInt64>>shift {
+		return 0, fmt.Errorf("expected a positive size, e.g. 100M")
+	}
+	return n << shift, nil
+}
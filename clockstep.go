@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Steps of the wall clock smaller than this are taken for drift.
+const clockStepThreshold = time.Second
+
+// ClockStep is a step of the wall clock during a run, such as an NTP
+// correction, which absolute timestamps silently include.
+type ClockStep struct {
+	// Time is the time of the first line after the step, by the stepped
+	// wall clock.
+	Time time.Time
+	// Step is how far the wall clock jumped, forward or backward.
+	Step time.Duration
+}
+
+func (step ClockStep) String() string {
+	direction := "forward"
+	d := step.Step
+	if d < 0 {
+		direction, d = "backward", -d
+	}
+	return fmt.Sprintf("wall clock stepped %s %s", direction, formatSummaryDuration(d))
+}
+
+// clockWatch detects steps of the wall clock by comparing its progression
+// since start with that of a clock of elapsed time. On Linux, that is
+// CLOCK_BOOTTIME, which keeps counting while the system is suspended, like
+// the wall clock, so that resuming from suspend isn't taken for a step.
+// Elsewhere, it is the monotonic clock, which stops during suspend on most
+// systems, so that a resume is reported as a forward step.
+type clockWatch struct {
+	start time.Time
+	// elapsed returns the time elapsed since start by the clock of elapsed
+	// time, as of now.
+	elapsed func(now time.Time) time.Duration
+	// offset is the divergence of the wall clock from the clock of elapsed
+	// time as of the latest step detected.
+	offset time.Duration
+}
+
+// newClockWatch returns a clockWatch from start, which must carry a
+// monotonic clock reading, as returned by time.Now.
+func newClockWatch(start time.Time) *clockWatch {
+	c := &clockWatch{
+		start:   start,
+		elapsed: func(now time.Time) time.Duration { return now.Sub(start) },
+	}
+	if boot, ok := bootClock(); ok {
+		c.elapsed = func(time.Time) time.Duration {
+			now, _ := bootClock()
+			return now - boot
+		}
+	}
+	return c
+}
+
+// Check returns the step of the wall clock as of now since the previous
+// step detected, if any. now must carry a monotonic clock reading, as
+// returned by time.Now.
+func (c *clockWatch) Check(now time.Time) (ClockStep, bool) {
+	if c == nil {
+		return ClockStep{}, false
+	}
+	// Round(0) strips the monotonic clock reading, so that Sub compares
+	// wall clock readings.
+	offset := now.Round(0).Sub(c.start.Round(0)) - c.elapsed(now)
+	step := offset - c.offset
+	if step > -clockStepThreshold && step < clockStepThreshold {
+		return ClockStep{}, false
+	}
+	c.offset = offset
+	return ClockStep{Time: now, Step: step}, true
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClockWatch(t *testing.T) {
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	// The fake clock of elapsed time, advanced by the steps below along
+	// with the wall clock, unless the wall clock is stepped.
+	var elapsed time.Duration
+	c := &clockWatch{
+		start:   start,
+		elapsed: func(time.Time) time.Duration { return elapsed },
+	}
+	wall := start
+	for _, step := range []struct {
+		description string
+		advance     time.Duration
+		jump        time.Duration
+		expected    time.Duration
+	}{
+		{"steady", 10 * time.Second, 0, 0},
+		{"drift", time.Minute, 300 * time.Millisecond, 0},
+		{"NTP step forward", time.Second, 5 * time.Second, 5*time.Second + 300*time.Millisecond},
+		// Both clocks keep counting during suspend.
+		{"suspend", 8 * time.Hour, 0, 0},
+		{"NTP step backward", time.Second, -2 * time.Second, -2 * time.Second},
+		{"steady after step", time.Minute, 0, 0},
+	} {
+		elapsed += step.advance
+		wall = wall.Add(step.advance + step.jump)
+		got, ok := c.Check(wall)
+		if ok != (step.expected != 0) || got.Step != step.expected {
+			t.Errorf("%s: expected step %s, got %s (%v)", step.description, step.expected, got.Step, ok)
+		}
+	}
+}
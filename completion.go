@@ -0,0 +1,432 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	flag "github.com/spf13/pflag"
+)
+
+var completionShells = []string{"bash", "zsh", "fish", "powershell"}
+
+// completionFlag is the subset of a pflag definition needed to write
+// completion scripts.
+type completionFlag struct {
+	name       string
+	shorthand  string
+	usage      string
+	takesValue bool
+	values     []string
+	dynamic    bool
+}
+
+// Value candidates offered for flags taking an argument. Flags not listed
+// here fall back to the shell's default completion.
+var completionValues = map[string]func() []string{
+	"format":   formatAliasNames,
+	"timezone": timezoneNames,
+	"profile":  profileNames,
+
+	"second-timezone":  timezoneNames,
+	"parse-timestamps": timestampParserNames,
+	"pager":            func() []string { return []string{"always", "auto", "never"} },
+	"lock-mode":        func() []string { return lockModes },
+	"sparkline":        func() []string { return sparklineModes },
+	"checksum":         func() []string { return checksumAlgorithms },
+	"elapsed-from":     func() []string { return elapsedFromModes },
+	"mail-on":          func() []string { return []string{"failure", "always"} },
+}
+
+// Value candidates that depend on the host, the time zones installed and
+// the profiles in the config file, are listed by the script running ets
+// completion values when they are completed, rather than written into the
+// script, which would go stale.
+var dynamicCompletionValues = map[string]bool{
+	"timezone":        true,
+	"second-timezone": true,
+	"profile":         true,
+}
+
+func collectCompletionFlags(flags *flag.FlagSet) []*completionFlag {
+	cflags := make([]*completionFlag, 0)
+	flags.VisitAll(func(f *flag.Flag) {
+		if f.Hidden || f.Deprecated != "" {
+			return
+		}
+		cf := &completionFlag{
+			name:       f.Name,
+			shorthand:  f.Shorthand,
+			usage:      f.Usage,
+			takesValue: f.NoOptDefVal == "" && f.Value.Type() != "bool",
+		}
+		if cf.takesValue {
+			if dynamicCompletionValues[f.Name] {
+				cf.dynamic = true
+			} else if valuesFunc, ok := completionValues[f.Name]; ok {
+				cf.values = valuesFunc()
+			}
+		}
+		cflags = append(cflags, cf)
+	})
+	return cflags
+}
+
+func formatAliasNames() []string {
+	names := make([]string, 0, len(formatAliases))
+	for name := range formatAliases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// timezoneNames lists IANA time zone names found in the system zoneinfo
+// database, searched in the same locations as the time package.
+func timezoneNames() []string {
+	dirs := []string{"/usr/share/zoneinfo", "/usr/share/lib/zoneinfo", "/usr/lib/locale/TZ"}
+	if zoneinfo := os.Getenv("ZONEINFO"); zoneinfo != "" {
+		dirs = append([]string{zoneinfo}, dirs...)
+	}
+	for _, dir := range dirs {
+		names := make([]string, 0)
+		_ = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+			rel, err := filepath.Rel(dir, path)
+			if err != nil || rel == "." {
+				return nil
+			}
+			// Zone names and their path components are capitalized; this
+			// excludes posix/, right/, and assorted data files.
+			if rel[0] < 'A' || rel[0] > 'Z' {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if info.Mode().IsRegular() && isTZif(path) {
+				names = append(names, filepath.ToSlash(rel))
+			}
+			return nil
+		})
+		if len(names) > 0 {
+			sort.Strings(names)
+			return names
+		}
+	}
+	return nil
+}
+
+func isTZif(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(f, magic); err != nil {
+		return false
+	}
+	return string(magic) == "TZif"
+}
+
+// writeCompletion writes the completion script for shell to w. flags are the
+// options accepted before the wrapped command; subcommands are offered in
+// place of the wrapped command.
+func writeCompletion(w io.Writer, shell string, flags *flag.FlagSet, subcommands []string) error {
+	cflags := collectCompletionFlags(flags)
+	var buf bytes.Buffer
+	switch shell {
+	case "bash":
+		writeBashCompletion(&buf, cflags, subcommands)
+	case "zsh":
+		writeZshCompletion(&buf, cflags, subcommands)
+	case "fish":
+		writeFishCompletion(&buf, cflags, subcommands)
+	case "powershell":
+		writePowershellCompletion(&buf, cflags, subcommands)
+	default:
+		return fmt.Errorf("unsupported shell %q (supported: %s)", shell, strings.Join(completionShells, ", "))
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// writeCompletionValues writes the value candidates of the named flag to w,
+// one per line, for completion scripts to call at completion time.
+func writeCompletionValues(w io.Writer, name string) error {
+	valuesFunc, ok := completionValues[name]
+	if !ok {
+		return fmt.Errorf("no completion values for %q", name)
+	}
+	for _, value := range valuesFunc() {
+		if _, err := fmt.Fprintln(w, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// valuesCommand returns the command listing the candidates of a dynamic
+// flag.
+func valuesCommand(cf *completionFlag) string {
+	return "ets completion values " + cf.name + " 2>/dev/null"
+}
+
+func flagSpellings(cf *completionFlag) []string {
+	spellings := []string{"--" + cf.name}
+	if cf.shorthand != "" {
+		spellings = append([]string{"-" + cf.shorthand}, spellings...)
+	}
+	return spellings
+}
+
+func writeBashCompletion(w io.Writer, cflags []*completionFlag, subcommands []string) {
+	allFlags := make([]string, 0)
+	valueFlags := make([]string, 0)
+	for _, cf := range cflags {
+		allFlags = append(allFlags, flagSpellings(cf)...)
+		if cf.takesValue {
+			valueFlags = append(valueFlags, flagSpellings(cf)...)
+		}
+	}
+
+	fmt.Fprint(w, "# bash completion for ets\n\n_ets() {\n")
+	fmt.Fprint(w, "    local cur prev i subcommands\n")
+	fmt.Fprintf(w, "    subcommands=(%s)\n", strings.Join(subcommands, " "))
+	fmt.Fprint(w, "    cur=\"${COMP_WORDS[COMP_CWORD]}\"\n")
+	fmt.Fprint(w, "    prev=\"${COMP_WORDS[COMP_CWORD-1]}\"\n\n")
+
+	// Locate the wrapped command, if any, and hand completion over to it.
+	fmt.Fprint(w, "    for ((i = 1; i < COMP_CWORD; i++)); do\n")
+	fmt.Fprint(w, "        case \"${COMP_WORDS[i]}\" in\n")
+	if len(valueFlags) > 0 {
+		fmt.Fprintf(w, "            %s) ((i++)) ;;\n", strings.Join(valueFlags, "|"))
+	}
+	fmt.Fprint(w, "            -*) ;;\n")
+	fmt.Fprint(w, "            completion)\n")
+	fmt.Fprintf(w, "                COMPREPLY=($(compgen -W %s -- \"$cur\"))\n", shellSingleQuote(strings.Join(completionShells, " ")))
+	fmt.Fprint(w, "                return\n")
+	fmt.Fprint(w, "                ;;\n")
+	fmt.Fprint(w, "            *)\n")
+	fmt.Fprint(w, "                if ((i == 1)) && [[ \" ${subcommands[*]} \" == *\" ${COMP_WORDS[i]} \"* ]]; then\n")
+	fmt.Fprint(w, "                    continue\n")
+	fmt.Fprint(w, "                fi\n")
+	fmt.Fprint(w, "                if declare -F _command_offset >/dev/null; then\n")
+	fmt.Fprint(w, "                    _command_offset $i\n")
+	fmt.Fprint(w, "                else\n")
+	fmt.Fprint(w, "                    COMPREPLY=($(compgen -f -- \"$cur\"))\n")
+	fmt.Fprint(w, "                fi\n")
+	fmt.Fprint(w, "                return\n")
+	fmt.Fprint(w, "                ;;\n")
+	fmt.Fprint(w, "        esac\n")
+	fmt.Fprint(w, "    done\n\n")
+
+	fmt.Fprint(w, "    case \"$prev\" in\n")
+	for _, cf := range cflags {
+		if !cf.takesValue {
+			continue
+		}
+		fmt.Fprintf(w, "        %s)\n", strings.Join(flagSpellings(cf), "|"))
+		if cf.dynamic {
+			fmt.Fprintf(w, "            COMPREPLY=($(compgen -W \"$(%s)\" -- \"$cur\"))\n", valuesCommand(cf))
+		} else if len(cf.values) > 0 {
+			fmt.Fprintf(w, "            COMPREPLY=($(compgen -W %s -- \"$cur\"))\n", shellSingleQuote(strings.Join(cf.values, " ")))
+		} else {
+			fmt.Fprint(w, "            COMPREPLY=($(compgen -f -- \"$cur\"))\n")
+		}
+		fmt.Fprint(w, "            return\n")
+		fmt.Fprint(w, "            ;;\n")
+	}
+	fmt.Fprint(w, "    esac\n\n")
+
+	fmt.Fprint(w, "    if [[ $cur == -* ]]; then\n")
+	fmt.Fprintf(w, "        COMPREPLY=($(compgen -W %s -- \"$cur\"))\n", shellSingleQuote(strings.Join(allFlags, " ")))
+	fmt.Fprint(w, "    elif ((COMP_CWORD == 1)); then\n")
+	fmt.Fprint(w, "        COMPREPLY=($(compgen -W \"${subcommands[*]}\" -- \"$cur\") $(compgen -c -- \"$cur\"))\n")
+	fmt.Fprint(w, "    else\n")
+	fmt.Fprint(w, "        COMPREPLY=($(compgen -c -- \"$cur\"))\n")
+	fmt.Fprint(w, "    fi\n")
+	fmt.Fprint(w, "}\n\n")
+	fmt.Fprint(w, "complete -F _ets -o default ets\n")
+}
+
+func writeZshCompletion(w io.Writer, cflags []*completionFlag, subcommands []string) {
+	fmt.Fprint(w, "#compdef ets\n\n_ets() {\n")
+	fmt.Fprint(w, "    _arguments -s -S \\\n")
+	for _, cf := range cflags {
+		description := "[" + zshEscape(cf.usage) + "]"
+		action := ""
+		if cf.takesValue {
+			if cf.dynamic {
+				action = ":" + cf.name + ":{compadd -- ${(f)\"$(" + valuesCommand(cf) + ")\"}}"
+			} else if len(cf.values) > 0 {
+				quoted := make([]string, len(cf.values))
+				for i, v := range cf.values {
+					quoted[i] = zshEscape(v)
+				}
+				action = ":" + cf.name + ":(" + strings.Join(quoted, " ") + ")"
+			} else {
+				action = ":" + cf.name + ":_files"
+			}
+		}
+		if cf.shorthand != "" {
+			exclusion := fmt.Sprintf("(-%s --%s)", cf.shorthand, cf.name)
+			fmt.Fprintf(w, "        %s{-%s,--%s}%s \\\n",
+				shellSingleQuote(exclusion), cf.shorthand, cf.name, shellSingleQuote(description+action))
+		} else {
+			fmt.Fprintf(w, "        %s \\\n", shellSingleQuote("--"+cf.name+description+action))
+		}
+	}
+	fmt.Fprint(w, "        '(-)1: :->command' \\\n")
+	fmt.Fprint(w, "        '*:: :->arguments'\n\n")
+	fmt.Fprint(w, "    case $state in\n")
+	fmt.Fprint(w, "        command)\n")
+	fmt.Fprintf(w, "            _alternative 'subcommands:subcommand:(%s)' 'commands:command:_command_names -e'\n", strings.Join(subcommands, " "))
+	fmt.Fprint(w, "            ;;\n")
+	fmt.Fprint(w, "        arguments)\n")
+	fmt.Fprint(w, "            case $words[1] in\n")
+	fmt.Fprintf(w, "                completion) _values shell %s ;;\n", strings.Join(completionShells, " "))
+	fmt.Fprintf(w, "                %s) _ets ;;\n", strings.Join(subcommandsExcept(subcommands, "completion"), "|"))
+	fmt.Fprint(w, "                *) _normal ;;\n")
+	fmt.Fprint(w, "            esac\n")
+	fmt.Fprint(w, "            ;;\n")
+	fmt.Fprint(w, "    esac\n")
+	fmt.Fprint(w, "}\n\n")
+	fmt.Fprint(w, "_ets \"$@\"\n")
+}
+
+func writeFishCompletion(w io.Writer, cflags []*completionFlag, subcommands []string) {
+	valueFlags := make([]string, 0)
+	for _, cf := range cflags {
+		if cf.takesValue {
+			valueFlags = append(valueFlags, flagSpellings(cf)...)
+		}
+	}
+	fmt.Fprint(w, "# fish completion for ets\n\n")
+	for _, cf := range cflags {
+		fmt.Fprint(w, "complete -c ets")
+		if cf.shorthand != "" {
+			fmt.Fprintf(w, " -s %s", cf.shorthand)
+		}
+		fmt.Fprintf(w, " -l %s", cf.name)
+		if cf.takesValue {
+			if cf.dynamic {
+				fmt.Fprintf(w, " -x -a %s", shellSingleQuote("("+valuesCommand(cf)+")"))
+			} else if len(cf.values) > 0 {
+				fmt.Fprintf(w, " -x -a %s", shellSingleQuote(strings.Join(cf.values, " ")))
+			} else {
+				fmt.Fprint(w, " -r")
+			}
+		}
+		fmt.Fprintf(w, " -d %s\n", shellSingleQuote(cf.usage))
+	}
+	fmt.Fprintf(w, "complete -c ets -n __fish_use_subcommand -x -a %s\n", shellSingleQuote(strings.Join(subcommands, " ")))
+	fmt.Fprintf(w, "complete -c ets -n %s -x -a %s\n",
+		shellSingleQuote("__fish_seen_subcommand_from completion"), shellSingleQuote(strings.Join(completionShells, " ")))
+	fmt.Fprintf(w, "complete -c ets -n %s -x -a %s\n",
+		shellSingleQuote("not __fish_seen_subcommand_from completion"),
+		shellSingleQuote("(__fish_complete_subcommand -- "+strings.Join(valueFlags, " ")+")"))
+}
+
+func writePowershellCompletion(w io.Writer, cflags []*completionFlag, subcommands []string) {
+	fmt.Fprint(w, "# powershell completion for ets\n\n")
+	fmt.Fprint(w, "Register-ArgumentCompleter -Native -CommandName ets -ScriptBlock {\n")
+	fmt.Fprint(w, "    param($wordToComplete, $commandAst, $cursorPosition)\n\n")
+
+	fmt.Fprint(w, "    $flags = @(\n")
+	for _, cf := range cflags {
+		for _, spelling := range flagSpellings(cf) {
+			fmt.Fprintf(w, "        @{ Name = %s; Description = %s }\n", powershellQuote(spelling), powershellQuote(cf.usage))
+		}
+	}
+	fmt.Fprint(w, "    )\n")
+
+	fmt.Fprint(w, "    $values = @{\n")
+	for _, cf := range cflags {
+		if !cf.takesValue {
+			continue
+		}
+		if cf.dynamic {
+			for _, spelling := range flagSpellings(cf) {
+				fmt.Fprintf(w, "        %s = { ets completion values %s 2>$null }\n", powershellQuote(spelling), cf.name)
+			}
+			continue
+		}
+		quoted := make([]string, len(cf.values))
+		for i, v := range cf.values {
+			quoted[i] = powershellQuote(v)
+		}
+		for _, spelling := range flagSpellings(cf) {
+			fmt.Fprintf(w, "        %s = @(%s)\n", powershellQuote(spelling), strings.Join(quoted, ", "))
+		}
+	}
+	fmt.Fprint(w, "    }\n\n")
+
+	fmt.Fprint(w, "    $elements = @($commandAst.CommandElements | Where-Object { $_.Extent.EndOffset -lt $cursorPosition })\n")
+	fmt.Fprint(w, "    if ($wordToComplete -eq '' -or $elements.Count -lt 2) {\n")
+	fmt.Fprint(w, "        $prev = if ($elements.Count -ge 1) { $elements[-1].ToString() } else { '' }\n")
+	fmt.Fprint(w, "    } else {\n")
+	fmt.Fprint(w, "        $prev = $elements[-2].ToString()\n")
+	fmt.Fprint(w, "    }\n\n")
+
+	fmt.Fprint(w, "    if ($values.ContainsKey($prev)) {\n")
+	fmt.Fprint(w, "        $candidates = $values[$prev]\n")
+	fmt.Fprint(w, "        if ($candidates -is [scriptblock]) { $candidates = & $candidates }\n")
+	fmt.Fprint(w, "        $candidates | Where-Object { $_ -like \"$wordToComplete*\" } | ForEach-Object {\n")
+	fmt.Fprint(w, "            [System.Management.Automation.CompletionResult]::new($_, $_, 'ParameterValue', $_)\n")
+	fmt.Fprint(w, "        }\n")
+	fmt.Fprint(w, "        return\n")
+	fmt.Fprint(w, "    }\n\n")
+
+	fmt.Fprint(w, "    if ($wordToComplete -like '-*') {\n")
+	fmt.Fprint(w, "        $flags | Where-Object { $_.Name -like \"$wordToComplete*\" } | ForEach-Object {\n")
+	fmt.Fprint(w, "            [System.Management.Automation.CompletionResult]::new($_.Name, $_.Name, 'ParameterName', $_.Description)\n")
+	fmt.Fprint(w, "        }\n")
+	fmt.Fprint(w, "    } elseif ($elements.Count -eq 1) {\n")
+	quoted := make([]string, len(subcommands))
+	for i, sc := range subcommands {
+		quoted[i] = powershellQuote(sc)
+	}
+	fmt.Fprintf(w, "        @(%s) | Where-Object { $_ -like \"$wordToComplete*\" } | ForEach-Object {\n", strings.Join(quoted, ", "))
+	fmt.Fprint(w, "            [System.Management.Automation.CompletionResult]::new($_, $_, 'Command', $_)\n")
+	fmt.Fprint(w, "        }\n")
+	fmt.Fprint(w, "    } elseif ($elements.Count -eq 2 -and $elements[1].ToString() -eq 'completion') {\n")
+	quoted = make([]string, len(completionShells))
+	for i, shell := range completionShells {
+		quoted[i] = powershellQuote(shell)
+	}
+	fmt.Fprintf(w, "        @(%s) | Where-Object { $_ -like \"$wordToComplete*\" } | ForEach-Object {\n", strings.Join(quoted, ", "))
+	fmt.Fprint(w, "            [System.Management.Automation.CompletionResult]::new($_, $_, 'ParameterValue', $_)\n")
+	fmt.Fprint(w, "        }\n")
+	fmt.Fprint(w, "    }\n")
+	fmt.Fprint(w, "}\n")
+}
+
+func subcommandsExcept(subcommands []string, except string) []string {
+	filtered := make([]string, 0, len(subcommands))
+	for _, sc := range subcommands {
+		if sc != except {
+			filtered = append(filtered, sc)
+		}
+	}
+	return filtered
+}
+
+func shellSingleQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func zshEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "[", `\[`, "]", `\]`, ":", `\:`).Replace(s)
+}
+
+func powershellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	flag "github.com/spf13/pflag"
+)
+
+// The config file is a list of option settings, one per line, in the form
+//
+//	name = value
+//
+// where name is the long name of an option. Settings before the first
+// [section] header apply to every invocation; settings under [name] form a
+// profile, applied only when selected with --profile name. Options given on
+// the command line always take precedence, followed by the selected
+// profile, followed by the top-level settings. Lines starting with # or ;
+// are comments.
+
+// Options that make no sense in a config file.
+var unconfigurableOptions = map[string]bool{
+	"help":    true,
+	"version": true,
+	"profile": true,
+}
+
+type configSetting struct {
+	name   string
+	value  string
+	lineno int
+	// bare is whether the name was given alone, without a value.
+	bare bool
+}
+
+// configPath returns the path of the config file: $ETS_CONFIG if set,
+// otherwise ets/config under $XDG_CONFIG_HOME or ~/.config.
+func configPath() string {
+	if path := os.Getenv("ETS_CONFIG"); path != "" {
+		return path
+	}
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		configHome = filepath.Join(home, ".config")
+	}
+	return filepath.Join(configHome, "ets", "config")
+}
+
+// loadConfig parses the config file at path into settings keyed by
+// profile name, with the top-level settings under the empty name. A
+// missing file is not an error.
+func loadConfig(path string) (map[string][]configSetting, error) {
+	profiles := make(map[string][]configSetting)
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return profiles, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	section := ""
+	profiles[section] = nil
+	scanner := bufio.NewScanner(f)
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if line[0] == '[' {
+			if line[len(line)-1] != ']' {
+				return nil, fmt.Errorf("%s:%d: malformed section header", path, lineno)
+			}
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			if section == "" {
+				return nil, fmt.Errorf("%s:%d: empty profile name", path, lineno)
+			}
+			if _, ok := profiles[section]; ok {
+				return nil, fmt.Errorf("%s:%d: duplicate profile %s", path, lineno, section)
+			}
+			profiles[section] = nil
+			continue
+		}
+		// A bare name is shorthand for name = true, for boolean options, or
+		// sets the value an option takes when given without one on the
+		// command line, such as color for --levels.
+		setting := configSetting{name: line, value: "true", lineno: lineno, bare: true}
+		if i := strings.IndexByte(line, '='); i >= 0 {
+			setting.name = strings.TrimSpace(line[:i])
+			setting.value = strings.TrimSpace(line[i+1:])
+			setting.bare = false
+		}
+		profiles[section] = append(profiles[section], setting)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return profiles, nil
+}
+
+// applyConfig applies the top-level settings in the config file, and the
+// named profile if profile is non-empty, to options not already set on the
+// command line.
+func applyConfig(flags *flag.FlagSet, path string, profile string) error {
+	profiles, err := loadConfig(path)
+	if err != nil {
+		return err
+	}
+	scopes := make([][]configSetting, 0, 2)
+	if profile != "" {
+		profileSettings, ok := profiles[profile]
+		if !ok {
+			return fmt.Errorf("profile %s not found in %s", profile, path)
+		}
+		scopes = append(scopes, profileSettings)
+	}
+	scopes = append(scopes, profiles[""])
+
+	// Options set on the command line or in a higher precedence scope are
+	// left alone. Within a scope, repeated settings accumulate for options
+	// taking multiple values, and the last one wins otherwise.
+	settled := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) { settled[f.Name] = true })
+	for _, settings := range scopes {
+		applied := make(map[string]bool)
+		for _, setting := range settings {
+			f := flags.Lookup(setting.name)
+			if f == nil && isOption(setting.name) && !unconfigurableOptions[setting.name] {
+				// An option of other subcommands.
+				continue
+			}
+			if f == nil || unconfigurableOptions[setting.name] {
+				return fmt.Errorf("%s:%d: unknown option %s", path, setting.lineno, setting.name)
+			}
+			if settled[f.Name] {
+				continue
+			}
+			if setting.bare && f.NoOptDefVal != "" {
+				setting.value = f.NoOptDefVal
+			}
+			if err := flags.Set(f.Name, setting.value); err != nil {
+				return fmt.Errorf("%s:%d: invalid value %q for option %s: %s", path, setting.lineno, setting.value, setting.name, err)
+			}
+			applied[f.Name] = true
+		}
+		for name := range applied {
+			settled[name] = true
+		}
+	}
+	return nil
+}
+
+func profileNames() []string {
+	profiles, err := loadConfig(configPath())
+	if err != nil {
+		return nil
+	}
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// formatTimestampParser returns a parser of the timestamps of logs written
+// with format, or a format alias, for ets convert --from-format. They are
+// read in the named timezone, the local one by default, or UTC for aliases
+// rendered in UTC, unless they carry an offset.
+func formatTimestampParser(format string, timezoneName string) (TimestampParser, error) {
+	timezone := time.Local
+	if utcFormatAliases[format] {
+		timezone = time.UTC
+	}
+	if alias, ok := formatAliases[format]; ok {
+		format = alias
+	}
+	if timezoneName != "" {
+		location, err := time.LoadLocation(timezoneName)
+		if err != nil {
+			return nil, err
+		}
+		timezone = location
+	}
+	parser, err := NewFormatParser(format, timezone)
+	if err != nil {
+		return nil, err
+	}
+	if !parser.HasDate {
+		return nil, fmt.Errorf("--from-format %q does not include the date", format)
+	}
+	return parser.Parse, nil
+}
+
+// convertLogs prints the logs at paths, or stdin if there are none, one
+// after the other.
+func convertLogs(paths []string, printer *Printer) error {
+	if len(paths) == 0 {
+		printer.StatusBar.SetState("reading stdin")
+		printer.PrintStream(os.Stdin)
+		return nil
+	}
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		printer.StatusBar.SetState("reading " + path)
+		printer.PrintStream(f)
+		_ = f.Close()
+	}
+	return nil
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// CronSchedule is a parsed five-field crontab(5) schedule. Each field is a
+// bit set of the values it matches.
+type CronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// Whether the day of month and day of week fields are restricted, in
+	// which case a day matching either matches.
+	domRestricted, dowRestricted bool
+}
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var cronMonthNames = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+var cronDayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// ParseCronSchedule parses a schedule such as "*/5 * * * *", with fields for
+// the minute, hour, day of month, month, and day of week, each a list of
+// values, ranges, and steps, or one of the macros such as @hourly.
+func ParseCronSchedule(spec string) (*CronSchedule, error) {
+	if expansion, ok := cronMacros[strings.ToLower(spec)]; ok {
+		spec = expansion
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule %q: expected five fields", spec)
+	}
+	s := &CronSchedule{}
+	var err error
+	if s.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("invalid minute in schedule %q: %s", spec, err)
+	}
+	if s.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("invalid hour in schedule %q: %s", spec, err)
+	}
+	if s.dom, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("invalid day of month in schedule %q: %s", spec, err)
+	}
+	if s.month, err = parseCronField(fields[3], 1, 12, cronMonthNames); err != nil {
+		return nil, fmt.Errorf("invalid month in schedule %q: %s", spec, err)
+	}
+	// 7 is Sunday as well.
+	if s.dow, err = parseCronField(fields[4], 0, 7, cronDayNames); err != nil {
+		return nil, fmt.Errorf("invalid day of week in schedule %q: %s", spec, err)
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.domRestricted = !strings.HasPrefix(fields[2], "*")
+	s.dowRestricted = !strings.HasPrefix(fields[4], "*")
+	return s, nil
+}
+
+func parseCronField(field string, min int, max int, names []string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		if i := strings.IndexByte(part, '/'); i >= 0 {
+			rangePart = part[:i]
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q", part[i+1:])
+			}
+		}
+		first, last := min, max
+		if rangePart != "*" {
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err error
+			if first, err = parseCronValue(bounds[0], min, max, names); err != nil {
+				return 0, err
+			}
+			last = first
+			if len(bounds) == 2 {
+				if last, err = parseCronValue(bounds[1], min, max, names); err != nil {
+					return 0, err
+				}
+			} else if step > 1 {
+				// N/step means N-max/step.
+				last = max
+			}
+			if last < first {
+				return 0, fmt.Errorf("invalid range %q", rangePart)
+			}
+		}
+		for v := first; v <= last; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func parseCronValue(s string, min int, max int, names []string) (int, error) {
+	for i, name := range names {
+		if strings.EqualFold(s, name) {
+			return i + min, nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < min || v > max {
+		return 0, fmt.Errorf("invalid value %q: expected %d-%d", s, min, max)
+	}
+	return v, nil
+}
+
+// Next returns the first time after t matching the schedule, or the zero
+// time if there is none within five years.
+//
+// The steps are taken on the wall clock of the location of t rather than by
+// rounding the absolute time, which would be off by the fraction of an hour
+// in zones such as +05:30.
+func (s *CronSchedule) Next(t time.Time) time.Time {
+	t = cronStep(t, time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()+1, 0, 0, t.Location()))
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = cronStep(t, time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location()))
+			continue
+		}
+		if !s.matchDay(t) {
+			t = cronStep(t, time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location()))
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = cronStep(t, time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location()))
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = cronStep(t, time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()+1, 0, 0, t.Location()))
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// cronStep returns next, the wall clock time following t, unless it is
+// ambiguous and resolved to an earlier time, as 01:30 may be once clocks go
+// back, in which case it returns the next minute instead.
+func cronStep(t time.Time, next time.Time) time.Time {
+	if !next.After(t) {
+		return t.Truncate(time.Minute).Add(time.Minute)
+	}
+	return next
+}
+
+func (s *CronSchedule) matchDay(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domRestricted && s.dowRestricted {
+		return dom || dow
+	}
+	return dom && dow
+}
+
+// runCron runs the command on schedule until interrupted, starting each run
+// with a fresh summary, which is written to the output after the run along
+// with trends across runs, and rotating the output file, if any, beforehand.
+func runCron(schedule *CronSchedule, args []string, printer *Printer, opts *options, output *rotatingFile) error {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	stats := &IterationStats{Name: "run"}
+	for run := 1; ; run++ {
+		next := schedule.Next(time.Now())
+		if next.IsZero() {
+			return fmt.Errorf("schedule never matches")
+		}
+		printer.StatusBar.SetState("next run at " + next.Format("2006-01-02 15:04"))
+		select {
+		case <-sigs:
+			return nil
+		case <-time.After(time.Until(next)):
+		}
+
+		if output != nil {
+			if err := output.Rotate(); err != nil {
+				return err
+			}
+		}
+		summary := printer.StartRun(args)
+		printer.PrintAnnotation(fmt.Sprintf("run %d started", run))
+		err := runCommandWithPrinter(args, printer, opts)
+		summary.Exited = true
+		if err != nil {
+			exitErr, ok := err.(*exec.ExitError)
+			if !ok {
+				return err
+			}
+			summary.ExitCode = exitErr.ExitCode()
+		}
+		printer.ClosePhase()
+		summary.Finish(printer.Now())
+		stats.Record(Iteration{summary.ExitCode, summary.Duration(), summary.Lines})
+		printer.PrintAnnotation(fmt.Sprintf("run %d finished: %s", run, stats.Describe(run-1)))
+		summary.Iterations = stats
+		printer.PrintSummary(summary)
+
+		select {
+		case <-sigs:
+			// Interrupted during the run.
+			return nil
+		default:
+		}
+	}
+}
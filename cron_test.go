@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronScheduleNext(t *testing.T) {
+	tests := []struct {
+		timezone string
+		spec     string
+		from     time.Time
+		expected time.Time
+	}{
+		// The top of the hour by the clock of zones off by a fraction of
+		// an hour, not that of UTC.
+		{"Asia/Kolkata", "0 * * * *", time.Date(2024, 6, 1, 4, 40, 0, 0, time.UTC), time.Date(2024, 6, 1, 5, 30, 0, 0, time.UTC)},
+		{"Asia/Kolkata", "30 9 * * *", time.Date(2024, 6, 1, 4, 40, 0, 0, time.UTC), time.Date(2024, 6, 2, 4, 0, 0, 0, time.UTC)},
+		// 08:50 IST, where stepping to the next hour of UTC would skip to
+		// 09:30 IST, past 09:10.
+		{"Asia/Kolkata", "10 9 * * *", time.Date(2024, 6, 1, 3, 20, 0, 0, time.UTC), time.Date(2024, 6, 1, 3, 40, 0, 0, time.UTC)},
+		{"Asia/Kathmandu", "5 9 * * *", time.Date(2024, 6, 1, 3, 5, 0, 0, time.UTC), time.Date(2024, 6, 1, 3, 20, 0, 0, time.UTC)},
+		{"Asia/Kathmandu", "0 * * * *", time.Date(2024, 6, 1, 4, 40, 0, 0, time.UTC), time.Date(2024, 6, 1, 5, 15, 0, 0, time.UTC)},
+		{"Asia/Kathmandu", "*/20 * * * *", time.Date(2024, 6, 1, 4, 40, 0, 0, time.UTC), time.Date(2024, 6, 1, 4, 55, 0, 0, time.UTC)},
+		// 01:30 EST, after clocks went back from 01:59 EDT: the next
+		// minute is 01:31 EST rather than 01:31 EDT, an hour earlier.
+		{"America/New_York", "* * * * *", time.Date(2024, 11, 3, 6, 30, 0, 0, time.UTC), time.Date(2024, 11, 3, 6, 31, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		loc, err := time.LoadLocation(test.timezone)
+		if err != nil {
+			t.Skip(err)
+		}
+		schedule, err := ParseCronSchedule(test.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if next := schedule.Next(test.from.In(loc)); !next.Equal(test.expected) {
+			t.Errorf("%s in %s from %s: expected %s, got %s", test.spec, test.timezone, test.from.In(loc), test.expected.In(loc), next)
+		}
+	}
+}
+
+func TestCronStepAmbiguous(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	// 01:30 EST, the second 01:30 of the day clocks went back.
+	now := time.Date(2024, 11, 3, 6, 30, 0, 0, time.UTC).In(loc)
+	next := time.Date(2024, 11, 3, 1, 31, 0, 0, loc)
+	expected := time.Date(2024, 11, 3, 6, 31, 0, 0, time.UTC)
+	if step := cronStep(now, next); !step.Equal(expected) {
+		t.Errorf("expected %s, got %s", expected.In(loc), step)
+	}
+	// Unambiguous times are taken as they are.
+	now = time.Date(2024, 11, 3, 5, 30, 0, 0, time.UTC).In(loc)
+	next = time.Date(2024, 11, 3, 1, 31, 0, 0, loc)
+	if step := cronStep(now, next); !step.Equal(next) {
+		t.Errorf("expected %s, got %s", next, step)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// daemonEnv marks the detached copy of ets started by daemonize.
+const daemonEnv = "ETS_DAEMON"
+
+// daemonize starts a copy of ets with the same arguments in a new session,
+// detached from the terminal, with stdin from /dev/null and stdout and stderr
+// appended to outputFile, and returns its pid.
+func daemonize(outputFile string) (int, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+	output, err := os.OpenFile(outputFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer output.Close()
+	devnull, err := os.Open(os.DevNull)
+	if err != nil {
+		return 0, err
+	}
+	defer devnull.Close()
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), daemonEnv+"=1")
+	cmd.Stdin = devnull
+	cmd.Stdout = output
+	cmd.Stderr = output
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+	pid := cmd.Process.Pid
+	_ = cmd.Process.Release()
+	return pid, nil
+}
+
+// isDaemon reports whether this is the detached copy of ets started by
+// daemonize, and hides the marker from the command.
+func isDaemon() bool {
+	daemon := os.Getenv(daemonEnv) != ""
+	_ = os.Unsetenv(daemonEnv)
+	return daemon
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-runewidth"
+)
+
+// timedLine is a line of a timestamped log.
+type timedLine struct {
+	number int
+	// Time since the start of the log.
+	offset time.Duration
+	text   string
+}
+
+// readTimedLog reads the lines of the log at path carrying timestamps
+// parsed by parser in the given mode, skipping ets annotations.
+func readTimedLog(path string, parser *FormatParser, mode TimestampMode) ([]timedLine, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var lines []timedLine
+	clock := &logClock{hasDate: parser.HasDate, mode: mode}
+	reader := bufio.NewReader(f)
+	for number := 1; ; number++ {
+		line, err := reader.ReadString('\n')
+		if line == "" && err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		line = strings.TrimRight(ansiEscapes.ReplaceAllString(line, ""), "\r\n")
+		t, text, ok := parser.Parse(line)
+		if !ok || strings.HasPrefix(text, annotationTag) {
+			continue
+		}
+		lines = append(lines, timedLine{number, clock.Offset(t), text})
+	}
+	return lines, nil
+}
+
+// logClock turns the timestamps of the successive lines of a log, in the
+// given mode, into times since the start of the log.
+type logClock struct {
+	hasDate         bool
+	mode            TimestampMode
+	first, previous time.Time
+	offset, wrap    time.Duration
+}
+
+// Offset returns the time since the start of the log of the line with
+// timestamp t, following the previous ones.
+func (c *logClock) Offset(t time.Time) time.Duration {
+	if !c.hasDate && c.mode != IncrementalTimeMode && t.Before(c.previous) {
+		// Past midnight, or past 24 hours elapsed.
+		c.wrap += 24 * time.Hour
+	}
+	c.previous = t
+	switch c.mode {
+	case AbsoluteTimeMode:
+		if c.first.IsZero() {
+			c.first = t
+		}
+		c.offset = t.Sub(c.first) + c.wrap
+	case ElapsedTimeMode:
+		c.offset = t.Sub(time.Unix(0, 0)) + c.wrap
+	case IncrementalTimeMode:
+		c.offset += t.Sub(time.Unix(0, 0))
+	}
+	return c.offset
+}
+
+// Digits are ignored when matching lines, as they tend to differ between
+// runs, e.g. in durations and counters.
+var diffDigits = regexp.MustCompile(`\d+`)
+
+// alignLines pairs up matching lines of a and b, in order, with the
+// patience algorithm: lines occurring once in each are matched first, and
+// the stretches in between are aligned recursively.
+func alignLines(a []string, b []string) [][2]int {
+	var pairs [][2]int
+	patienceAlign(a, b, 0, len(a), 0, len(b), &pairs)
+	return pairs
+}
+
+func patienceAlign(a []string, b []string, alo, ahi, blo, bhi int, pairs *[][2]int) {
+	for alo < ahi && blo < bhi && a[alo] == b[blo] {
+		*pairs = append(*pairs, [2]int{alo, blo})
+		alo++
+		blo++
+	}
+	var suffix [][2]int
+	for alo < ahi && blo < bhi && a[ahi-1] == b[bhi-1] {
+		ahi--
+		bhi--
+		suffix = append(suffix, [2]int{ahi, bhi})
+	}
+	anchors := uniqueAnchors(a, b, alo, ahi, blo, bhi)
+	for _, anchor := range anchors {
+		patienceAlign(a, b, alo, anchor[0], blo, anchor[1], pairs)
+		*pairs = append(*pairs, anchor)
+		alo, blo = anchor[0]+1, anchor[1]+1
+	}
+	if len(anchors) > 0 {
+		patienceAlign(a, b, alo, ahi, blo, bhi, pairs)
+	}
+	for i := len(suffix) - 1; i >= 0; i-- {
+		*pairs = append(*pairs, suffix[i])
+	}
+}
+
+// uniqueAnchors returns the longest increasing sequence of pairs of lines
+// occurring exactly once in a[alo:ahi] and once in b[blo:bhi].
+func uniqueAnchors(a []string, b []string, alo, ahi, blo, bhi int) [][2]int {
+	counts := make(map[string][2]int)
+	positions := make(map[string]int)
+	for i := alo; i < ahi; i++ {
+		c := counts[a[i]]
+		c[0]++
+		counts[a[i]] = c
+		positions[a[i]] = i
+	}
+	var candidates [][2]int
+	for j := blo; j < bhi; j++ {
+		if c, ok := counts[b[j]]; ok {
+			c[1]++
+			counts[b[j]] = c
+		}
+	}
+	for j := blo; j < bhi; j++ {
+		if c := counts[b[j]]; c[0] == 1 && c[1] == 1 {
+			candidates = append(candidates, [2]int{positions[b[j]], j})
+		}
+	}
+	// Candidates are ordered by b; find the longest run increasing in a by
+	// patience sorting.
+	var piles []int
+	back := make([]int, len(candidates))
+	for k, c := range candidates {
+		pile := sort.Search(len(piles), func(p int) bool { return candidates[piles[p]][0] > c[0] })
+		back[k] = -1
+		if pile > 0 {
+			back[k] = piles[pile-1]
+		}
+		if pile == len(piles) {
+			piles = append(piles, k)
+		} else {
+			piles[pile] = k
+		}
+	}
+	if len(piles) == 0 {
+		return nil
+	}
+	anchors := make([][2]int, len(piles))
+	for k, i := piles[len(piles)-1], len(piles)-1; k >= 0; k, i = back[k], i-1 {
+		anchors[i] = candidates[k]
+	}
+	return anchors
+}
+
+// timingChange is the time taken by a stretch of both logs.
+type timingChange struct {
+	before, after time.Duration
+	description   string
+}
+
+func (c timingChange) change() time.Duration {
+	return c.after - c.before
+}
+
+// significant is whether the change is by at least minChange and threshold
+// percent.
+func (c timingChange) significant(threshold float64, minChange time.Duration) bool {
+	change := c.change()
+	if change < 0 {
+		change = -change
+	}
+	if change == 0 || change < minChange {
+		return false
+	}
+	return c.before == 0 || float64(change)*100 >= threshold*float64(c.before)
+}
+
+func (c timingChange) format(color bool) string {
+	verdict, sgr := "slower", "\x1b[31m"
+	if c.change() < 0 {
+		verdict, sgr = "faster", "\x1b[32m"
+	}
+	s := fmt.Sprintf("%-7s %s  %s", verdict, c.columns(), c.description)
+	if color {
+		s = sgr + s + "\x1b[0m"
+	}
+	return s
+}
+
+// columns formats the change, absolute and relative, and the durations
+// compared.
+func (c timingChange) columns() string {
+	percent := ""
+	if c.before > 0 {
+		percent = fmt.Sprintf("%+.0f%%", float64(c.change())*100/float64(c.before))
+	}
+	return fmt.Sprintf("%6s %6s  %s -> %s", formatSignedDuration(c.change()), percent,
+		formatSummaryDuration(c.before), formatSummaryDuration(c.after))
+}
+
+// lineChanges compares the time taken to reach each line of a matched in b
+// since the previous matched line.
+func lineChanges(pathA string, a []timedLine, pathB string, b []timedLine) []timingChange {
+	keysA := make([]string, len(a))
+	for i, line := range a {
+		keysA[i] = diffDigits.ReplaceAllString(line.text, "0")
+	}
+	keysB := make([]string, len(b))
+	for i, line := range b {
+		keysB[i] = diffDigits.ReplaceAllString(line.text, "0")
+	}
+	var changes []timingChange
+	var previousA, previousB time.Duration
+	for _, pair := range alignLines(keysA, keysB) {
+		lineA, lineB := a[pair[0]], b[pair[1]]
+		changes = append(changes, timingChange{
+			before: lineA.offset - previousA,
+			after:  lineB.offset - previousB,
+			description: fmt.Sprintf("before %q (%s:%d, %s:%d)", runewidth.Truncate(lineA.text, 60, "..."),
+				pathA, lineA.number, pathB, lineB.number),
+		})
+		previousA, previousB = lineA.offset, lineB.offset
+	}
+	return changes
+}
+
+// timedPhase is a phase of a timestamped log.
+type timedPhase struct {
+	name     string
+	duration time.Duration
+}
+
+// logPhases splits lines into phases started by lines matching pattern,
+// each lasting until the next, or the last line.
+func logPhases(lines []timedLine, pattern *regexp.Regexp) []timedPhase {
+	var phases []timedPhase
+	var start time.Duration
+	for _, line := range lines {
+		name, ok := matchPhase(pattern, line.text)
+		if !ok {
+			continue
+		}
+		if len(phases) > 0 {
+			phases[len(phases)-1].duration = line.offset - start
+		}
+		phases = append(phases, timedPhase{name: name})
+		start = line.offset
+	}
+	if len(phases) > 0 {
+		phases[len(phases)-1].duration = lines[len(lines)-1].offset - start
+	}
+	return phases
+}
+
+// phaseChanges compares the durations of the phases of a and b of the same
+// name, the nth occurrence of a name in a being matched with the nth in b.
+// Phases missing from either log are returned separately.
+func phaseChanges(pathA string, a []timedPhase, pathB string, b []timedPhase) ([]timingChange, []string) {
+	occurrence := func(phases []timedPhase) []string {
+		seen := make(map[string]int)
+		keys := make([]string, len(phases))
+		for i, phase := range phases {
+			seen[phase.name]++
+			keys[i] = fmt.Sprintf("%s\x00%d", phase.name, seen[phase.name])
+		}
+		return keys
+	}
+	keysA, keysB := occurrence(a), occurrence(b)
+	indexB := make(map[string]int)
+	for i, key := range keysB {
+		indexB[key] = i
+	}
+	var changes []timingChange
+	var missing []string
+	matched := make(map[int]bool)
+	for i, key := range keysA {
+		j, ok := indexB[key]
+		if !ok {
+			missing = append(missing, fmt.Sprintf("phase %q (%s) only in %s", a[i].name, formatSummaryDuration(a[i].duration), pathA))
+			continue
+		}
+		matched[j] = true
+		changes = append(changes, timingChange{a[i].duration, b[j].duration, fmt.Sprintf("phase %q", a[i].name)})
+	}
+	for j, phase := range b {
+		if !matched[j] {
+			missing = append(missing, fmt.Sprintf("phase %q (%s) only in %s", phase.name, formatSummaryDuration(phase.duration), pathB))
+		}
+	}
+	return changes, missing
+}
+
+// runDiff compares the timing of the logs at pathA and pathB, writing the
+// changes by at least threshold percent and minChange, either between
+// matching lines or, given a phase pattern, between phases of the same
+// name, followed by the change in total duration. It returns whether any
+// stretch got slower by that much.
+func runDiff(w io.Writer, pathA string, pathB string, parser *FormatParser, mode TimestampMode, phasePattern *regexp.Regexp,
+	threshold float64, minChange time.Duration, color bool) (bool, error) {
+	a, err := readTimedLog(pathA, parser, mode)
+	if err != nil {
+		return false, err
+	}
+	b, err := readTimedLog(pathB, parser, mode)
+	if err != nil {
+		return false, err
+	}
+	if len(a) == 0 {
+		return false, fmt.Errorf("%s: no timestamped lines", pathA)
+	}
+	if len(b) == 0 {
+		return false, fmt.Errorf("%s: no timestamped lines", pathB)
+	}
+
+	var changes []timingChange
+	var missing []string
+	if phasePattern != nil {
+		changes, missing = phaseChanges(pathA, logPhases(a, phasePattern), pathB, logPhases(b, phasePattern))
+	} else {
+		changes = lineChanges(pathA, a, pathB, b)
+	}
+	regressed := false
+	for _, c := range changes {
+		if c.significant(threshold, minChange) {
+			fmt.Fprintln(w, c.format(color))
+			regressed = regressed || c.change() > 0
+		}
+	}
+	for _, m := range missing {
+		fmt.Fprintln(w, m)
+	}
+	total := timingChange{before: a[len(a)-1].offset, after: b[len(b)-1].offset}
+	fmt.Fprintf(w, "%-7s %s\n", "total", total.columns())
+	return regressed, nil
+}
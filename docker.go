@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	flag "github.com/spf13/pflag"
+)
+
+// dockerCommand returns the command line running docker for the arguments of
+// ets docker, which start with run or logs, and applies defaults suiting it
+// to options not set explicitly: the container name as the label, and for
+// logs, the timestamps recorded by docker in place of the time lines are
+// read.
+func dockerCommand(args []string, flags *flag.FlagSet, opts *options) ([]string, error) {
+	if len(args) == 0 || (args[0] != "run" && args[0] != "logs") {
+		return nil, fmt.Errorf("ets docker requires run or logs")
+	}
+	dockerArgs := args[1:]
+	container := ""
+	command := []string{"docker", args[0]}
+	switch args[0] {
+	case "run":
+		container = dockerRunName(dockerArgs)
+	case "logs":
+		container = dockerLogsContainer(dockerArgs)
+		if container == "" {
+			return nil, fmt.Errorf("ets docker logs requires a container")
+		}
+		command = append(command, "--timestamps")
+		if !flags.Changed("parse-timestamps") {
+			opts.parseTimestamps = "rfc3339"
+		}
+	}
+	if container != "" && !flags.Changed("label") {
+		opts.label = container
+	}
+	return append(command, dockerArgs...), nil
+}
+
+// Options of docker logs taking a value as the next argument.
+var dockerLogsValueOptions = map[string]bool{"--since": true, "--until": true, "--tail": true, "-n": true}
+
+// dockerLogsContainer returns the container among the arguments of docker
+// logs, the first argument that is neither an option nor the value of one,
+// or the empty string.
+func dockerLogsContainer(args []string) string {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if dockerLogsValueOptions[arg] {
+			i++
+			continue
+		}
+		if arg == "--" {
+			if i+1 < len(args) {
+				return args[i+1]
+			}
+			return ""
+		}
+		if !strings.HasPrefix(arg, "-") {
+			return arg
+		}
+	}
+	return ""
+}
+
+// dockerRunName returns the value of the --name option among the arguments
+// of docker run, or the empty string. Telling options apart from the image
+// and the container's command would require knowing every option of docker
+// run, so the first --name anywhere is taken.
+func dockerRunName(args []string) string {
+	for i, arg := range args {
+		if arg == "--name" && i+1 < len(args) {
+			return args[i+1]
+		}
+		if strings.HasPrefix(arg, "--name=") {
+			return strings.TrimPrefix(arg, "--name=")
+		}
+	}
+	return ""
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// zoneWatch detects changes of the UTC offset of a timezone between lines,
+// such as DST transitions, which make absolute timestamps appear to jump.
+type zoneWatch struct {
+	location *time.Location
+	// The zone of the previous line, if any.
+	name    string
+	offset  int
+	started bool
+}
+
+func newZoneWatch(location *time.Location) *zoneWatch {
+	return &zoneWatch{location: location}
+}
+
+// Check returns a description of the change of offset at t since the
+// previous call, if any.
+func (w *zoneWatch) Check(t time.Time) (string, bool) {
+	if w == nil {
+		return "", false
+	}
+	name, offset := t.In(w.location).Zone()
+	previousName, previousOffset, started := w.name, w.offset, w.started
+	w.name, w.offset, w.started = name, offset, true
+	if !started || offset == previousOffset {
+		return "", false
+	}
+	direction := "forward"
+	d := time.Duration(offset-previousOffset) * time.Second
+	if d < 0 {
+		direction, d = "back", -d
+	}
+	zone := w.location.String()
+	if w.location == time.Local {
+		zone = "local time"
+	}
+	return fmt.Sprintf("DST transition in %s: clocks went %s %s, from %s (%s) to %s (%s)",
+		zone, direction, formatSummaryDuration(d),
+		previousName, formatOffset(previousOffset), name, formatOffset(offset)), true
+}
+
+// formatOffset formats an offset from UTC in seconds as -07:00.
+func formatOffset(offset int) string {
+	return time.Unix(0, 0).In(time.FixedZone("", offset)).Format("-07:00")
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/zmwangx/ets/sink"
+)
+
+// Limits of the queue and batches of documents indexed in Elasticsearch, and
+// the time allowed to index those left when ets exits.
+const (
+	elasticQueueSize    = 10000
+	elasticBatchSize    = 500
+	elasticInterval     = time.Second
+	elasticCloseTimeout = 10 * time.Second
+)
+
+// The document indexed for each line.
+type elasticDocument struct {
+	Timestamp time.Time `json:"@timestamp"`
+	Message   string    `json:"message"`
+	Elapsed   float64   `json:"elapsed"`
+	Delta     float64   `json:"delta"`
+	Line      int       `json:"line"`
+	Run       string    `json:"run"`
+	Command   []string  `json:"command,omitempty"`
+	Label     string    `json:"label,omitempty"`
+	Level     string    `json:"level,omitempty"`
+}
+
+// ElasticSink indexes every line with its timing as a document in
+// Elasticsearch, in bulk, from a bounded queue.
+type ElasticSink struct {
+	bulkURL string
+	action  []byte
+	command []string
+	batcher *eventBatcher
+	// Whether documents were rejected, which is reported once.
+	rejected bool
+}
+
+// NewElasticSink starts indexing lines of command in the index at spec,
+// http[s]://[user:pass@]host:port/index, in batches within limits, of up to
+// 500 documents every second by default.
+func NewElasticSink(spec string, limits BatchLimits, command []string) (*ElasticSink, error) {
+	u, err := url.Parse(spec)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid Elasticsearch URL %q: expected http://host:9200/index", spec)
+	}
+	path := strings.TrimSuffix(u.Path, "/")
+	i := strings.LastIndexByte(path, '/')
+	index := path[i+1:]
+	if index == "" {
+		return nil, fmt.Errorf("invalid Elasticsearch URL %q: no index", spec)
+	}
+	u.Path = path[:i+1] + "_bulk"
+	action, _ := json.Marshal(map[string]map[string]string{"index": {"_index": index}})
+	s := &ElasticSink{
+		bulkURL: u.String(),
+		action:  action,
+		command: command,
+	}
+	limits = limits.withDefaults(elasticBatchSize, 0, elasticInterval)
+	s.batcher = newEventBatcher("Elasticsearch", s.index, elasticQueueSize, limits)
+	return s, nil
+}
+
+// Send queues the line of e.
+func (s *ElasticSink) Send(e *LineEvent) {
+	if e.Kind != sink.Output {
+		return
+	}
+	document, _ := json.Marshal(elasticDocument{
+		Timestamp: e.Time,
+		Message:   e.Text,
+		Elapsed:   e.Elapsed.Seconds(),
+		Delta:     e.Delta.Seconds(),
+		Line:      e.Line,
+		Run:       e.Run,
+		Command:   s.command,
+		Label:     e.Label,
+		Level:     e.Level,
+	})
+	s.batcher.Add(document)
+}
+
+// index indexes documents with the bulk API.
+func (s *ElasticSink) index(documents [][]byte) error {
+	var body bytes.Buffer
+	for _, document := range documents {
+		body.Write(s.action)
+		body.WriteByte('\n')
+		body.Write(document)
+		body.WriteByte('\n')
+	}
+	resp, err := http.Post(s.bulkURL, "application/x-ndjson", &body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(content))
+	}
+	var result struct {
+		Errors bool `json:"errors"`
+	}
+	if err := json.Unmarshal(content, &result); err != nil {
+		return err
+	}
+	if result.Errors {
+		// Retrying would duplicate the documents indexed.
+		if !s.rejected {
+			log.Printf("some documents were rejected by Elasticsearch")
+			s.rejected = true
+		}
+	}
+	return nil
+}
+
+// Close indexes the documents queued, waiting for a while.
+func (s *ElasticSink) Close() {
+	s.batcher.Close(elasticCloseTimeout)
+}
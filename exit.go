@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+// exitHooks are run by exit, and by fatal and fatalf, most recently
+// registered first, to undo what ets has done to its surroundings.
+var exitHooks []func()
+
+// atExit registers f to be run when ets exits through exit, fatal, or
+// fatalf.
+func atExit(f func()) {
+	exitHooks = append(exitHooks, f)
+}
+
+// exit runs the exit hooks, then exits with code.
+func exit(code int) {
+	for i := len(exitHooks) - 1; i >= 0; i-- {
+		exitHooks[i]()
+	}
+	os.Exit(code)
+}
+
+// fatal is log.Fatal, running the exit hooks before exiting.
+func fatal(v ...interface{}) {
+	log.Print(v...)
+	exit(1)
+}
+
+// fatalf is log.Fatalf, running the exit hooks before exiting.
+func fatalf(format string, v ...interface{}) {
+	log.Print(fmt.Sprintf(format, v...))
+	exit(1)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+type extraInput struct {
+	file  *os.File
+	label string
+}
+
+// openExtraInputs opens the inherited file descriptors given to --fd, each
+// as N or N=label, labeled fdN by default.
+func openExtraInputs(specs []string) ([]extraInput, error) {
+	inputs := make([]extraInput, 0, len(specs))
+	for _, spec := range specs {
+		fdString, label := spec, ""
+		if i := strings.IndexByte(spec, '='); i >= 0 {
+			fdString, label = spec[:i], spec[i+1:]
+		}
+		fd, err := strconv.Atoi(fdString)
+		if err != nil || fd < 3 {
+			return nil, fmt.Errorf("invalid file descriptor %q: expected a number greater than 2", fdString)
+		}
+		if label == "" {
+			label = "fd" + fdString
+		}
+		f := os.NewFile(uintptr(fd), label)
+		if _, err := f.Stat(); err != nil {
+			return nil, fmt.Errorf("file descriptor %d: %s", fd, err)
+		}
+		// Keep it from leaking into the command.
+		syscall.CloseOnExec(fd)
+		inputs = append(inputs, extraInput{f, label})
+	}
+	return inputs, nil
+}
+
+// printExtraInputs prints the lines read from each input, labeled, in the
+// background. The returned WaitGroup is done once all of them reach EOF.
+func printExtraInputs(inputs []extraInput, printer *Printer) *sync.WaitGroup {
+	var wg sync.WaitGroup
+	for _, input := range inputs {
+		wg.Add(1)
+		go func(input extraInput) {
+			defer wg.Done()
+			printer.PrintLabeledStream(input.file, input.label)
+			_ = input.file.Close()
+		}(input)
+	}
+	return &wg
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+// readFIFO timestamps whatever other processes write into the FIFO at path
+// until interrupted. The FIFO is created if it doesn't exist, and removed
+// afterwards in that case.
+func readFIFO(path string, printer *Printer) error {
+	f, created, err := openFIFO(path)
+	if err != nil {
+		return err
+	}
+	if created {
+		defer os.Remove(path)
+	}
+	defer f.Close()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	go func() {
+		<-sigs
+		_ = f.Close()
+	}()
+
+	printer.StatusBar.SetState("reading " + path)
+	printer.PrintStream(f)
+	return nil
+}
+
+// openFIFO opens the FIFO at path for reading, creating it if it doesn't
+// exist, in which case created is true.
+func openFIFO(path string) (f *os.File, created bool, err error) {
+	if err := syscall.Mkfifo(path, 0600); err == nil {
+		created = true
+	} else if err != syscall.EEXIST {
+		return nil, false, &os.PathError{Op: "mkfifo", Path: path, Err: err}
+	}
+	// Holding the FIFO open for writing as well means reading doesn't hit
+	// EOF when the last writer disconnects.
+	f, err = os.OpenFile(path, os.O_RDWR, 0)
+	if err == nil {
+		if info, statErr := f.Stat(); statErr != nil {
+			err = statErr
+		} else if info.Mode()&os.ModeNamedPipe == 0 {
+			err = fmt.Errorf("%s is not a FIFO", path)
+		}
+		if err != nil {
+			f.Close()
+		}
+	}
+	if err != nil {
+		if created {
+			os.Remove(path)
+		}
+		return nil, false, err
+	}
+	return f, created, nil
+}
+
+// annotateFromFIFO prints each line written into the FIFO at path, created
+// if needed, as a note in the background, until the returned function is
+// called, which removes the FIFO if it was created.
+func annotateFromFIFO(path string, printer *Printer) (func(), error) {
+	f, created, err := openFIFO(path)
+	if err != nil {
+		return nil, err
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if text := strings.TrimSpace(scanner.Text()); text != "" {
+				printer.PrintNote(text)
+			}
+		}
+	}()
+	return func() {
+		f.Close()
+		<-done
+		if created {
+			os.Remove(path)
+		}
+	}, nil
+}
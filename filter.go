@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+)
+
+// filterStream returns the output of the shell command filter fed with r,
+// e.g. sed -u or jq --unbuffered. Should the filter fail before the end of
+// r, the rest is passed through unfiltered; should it exit successfully
+// early, like head, the rest is discarded.
+func filterStream(r io.Reader, filter string) io.Reader {
+	cmd := exec.Command("sh", "-c", filter)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		log.Printf("error starting filter: %s", err)
+		return r
+	}
+	stdout, pw, err := os.Pipe()
+	if err != nil {
+		log.Printf("error starting filter: %s", err)
+		return r
+	}
+	cmd.Stdout = pw
+	if err := cmd.Start(); err != nil {
+		pw.Close()
+		stdout.Close()
+		log.Printf("error starting filter: %s", err)
+		return r
+	}
+	pw.Close()
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+	rest, restWriter := io.Pipe()
+	go func() {
+		defer restWriter.Close()
+		unwritten, copyErr := copyUntilWriteError(stdin, r)
+		stdin.Close()
+		if copyErr == nil {
+			if err := <-exited; err != nil {
+				log.Printf("filter failed: %s", err)
+			}
+			return
+		}
+		// The filter quit reading.
+		if err := <-exited; err != nil {
+			log.Printf("filter failed: %s; passing the rest of the output through unfiltered", err)
+			restWriter.Write(unwritten)
+			io.Copy(restWriter, r)
+			return
+		}
+		io.Copy(ioutil.Discard, r)
+	}()
+	// The output of the filter ends when it exits, followed by the rest of the
+	// output if it failed.
+	return io.MultiReader(closingReader{stdout}, rest)
+}
+
+// copyUntilWriteError copies r to w until the end of r, or an error writing
+// to w, in which case it returns what was read but not written.
+func copyUntilWriteError(w io.Writer, r io.Reader) ([]byte, error) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			written, writeErr := w.Write(buf[:n])
+			if writeErr != nil {
+				return buf[written:n], writeErr
+			}
+		}
+		if err != nil {
+			return nil, nil
+		}
+	}
+}
+
+// closingReader closes the file it reads at the end.
+type closingReader struct {
+	*os.File
+}
+
+func (r closingReader) Read(b []byte) (int, error) {
+	n, err := r.File.Read(b)
+	if err == io.EOF {
+		r.File.Close()
+	}
+	return n, err
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"time"
+)
+
+// Size of the buffer of a flushingWriter, flushed early once full, unless
+// --batch-bytes says otherwise.
+const flushBufferSize = 64 * 1024
+
+// flushingWriter buffers writes to a file or pipe, flushing them in batches
+// within limits, trading latency for fewer write system calls on heavy
+// streams.
+type flushingWriter struct {
+	w      io.Writer
+	limits BatchLimits
+
+	mu    sync.Mutex
+	buf   bytes.Buffer
+	lines int
+	err   error
+	done  chan struct{}
+	wg    sync.WaitGroup
+}
+
+func newFlushingWriter(w io.Writer, limits BatchLimits) *flushingWriter {
+	f := &flushingWriter{
+		w:      w,
+		limits: limits,
+		done:   make(chan struct{}),
+	}
+	f.wg.Add(1)
+	go f.loop()
+	return f
+}
+
+func (f *flushingWriter) loop() {
+	defer f.wg.Done()
+	ticker := time.NewTicker(f.limits.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-f.done:
+			return
+		case <-ticker.C:
+			f.mu.Lock()
+			f.flush()
+			f.mu.Unlock()
+		}
+	}
+}
+
+func (f *flushingWriter) flush() {
+	if f.buf.Len() == 0 {
+		return
+	}
+	f.limits.Stats.Record(f.lines, f.buf.Len())
+	if _, err := f.w.Write(f.buf.Bytes()); err != nil && f.err == nil {
+		f.err = err
+	}
+	f.buf.Reset()
+	f.lines = 0
+}
+
+// Write buffers p, flushing the batch once full. It reports the error of a
+// failed flush, if any, since the writer's errors are otherwise only seen
+// asynchronously.
+func (f *flushingWriter) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return 0, f.err
+	}
+	f.buf.Write(p)
+	f.lines += bytes.Count(p, []byte("\n"))
+	if f.limits.full(f.lines, f.buf.Len()) {
+		f.flush()
+	}
+	return len(p), nil
+}
+
+// Close stops the periodic flushes and flushes what is left.
+func (f *flushingWriter) Close() error {
+	close(f.done)
+	f.wg.Wait()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.flush()
+	return f.err
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// PhaseTree records phases together with sub-phases nested within them,
+// started by lines matching a pattern for each level of nesting, to be
+// written as folded stacks for flame graphs.
+type PhaseTree struct {
+	// SubphasePatterns start sub-phases, the first within phases, the
+	// second within those, and so on.
+	SubphasePatterns []*regexp.Regexp
+
+	root *phaseNode
+	// The open phase and its open sub-phases, outermost first.
+	open []*phaseNode
+}
+
+type phaseNode struct {
+	name       string
+	start, end time.Time
+	children   []*phaseNode
+}
+
+// NewPhaseTree returns a tree for the run of the named command.
+func NewPhaseTree(name string, subphasePatterns []*regexp.Regexp) *PhaseTree {
+	return &PhaseTree{
+		SubphasePatterns: subphasePatterns,
+		root:             &phaseNode{name: name},
+	}
+}
+
+// Start starts a phase, or a sub-phase with depth > 0, at time t, ending
+// the open phases at that depth or deeper. A sub-phase outside of any phase
+// at the depth above is attached to the innermost open one.
+func (tree *PhaseTree) Start(depth int, name string, t time.Time) {
+	if tree == nil {
+		return
+	}
+	tree.end(depth, t)
+	parent := tree.root
+	if len(tree.open) > 0 {
+		parent = tree.open[len(tree.open)-1]
+	}
+	node := &phaseNode{name: name, start: t}
+	parent.children = append(parent.children, node)
+	tree.open = append(tree.open, node)
+}
+
+// End ends the open phase, if any, and its sub-phases at time t.
+func (tree *PhaseTree) End(t time.Time) {
+	if tree == nil {
+		return
+	}
+	tree.end(0, t)
+}
+
+func (tree *PhaseTree) end(depth int, t time.Time) {
+	for len(tree.open) > depth {
+		tree.open[len(tree.open)-1].end = t
+		tree.open = tree.open[:len(tree.open)-1]
+	}
+}
+
+// MatchSubphase returns the depth and name of the sub-phase started by line,
+// if any, trying the outermost level first.
+func (tree *PhaseTree) MatchSubphase(line string) (int, string, bool) {
+	if tree == nil {
+		return 0, "", false
+	}
+	for i, pattern := range tree.SubphasePatterns {
+		if name, ok := matchPhase(pattern, line); ok {
+			return i + 1, name, true
+		}
+	}
+	return 0, "", false
+}
+
+// Finish ends the open phases with the run recorded in s, which spans the
+// whole tree.
+func (tree *PhaseTree) Finish(s *Summary) {
+	tree.End(s.End)
+	tree.root.start = s.Start
+	tree.root.end = s.End
+}
+
+// WriteFolded writes the tree to path in the folded stack format of
+// flamegraph.pl, one line per stack with the time spent in its innermost
+// frame but not in any nested one, in milliseconds.
+func (tree *PhaseTree) WriteFolded(path string) error {
+	var b strings.Builder
+	tree.root.writeFolded(&b, nil)
+	return ioutil.WriteFile(path, []byte(b.String()), 0644)
+}
+
+func (node *phaseNode) writeFolded(b *strings.Builder, stack []string) {
+	// Semicolons separate frames.
+	stack = append(stack, strings.Replace(node.name, ";", ",", -1))
+	self := node.end.Sub(node.start)
+	for _, child := range node.children {
+		self -= child.end.Sub(child.start)
+	}
+	if ms := self.Milliseconds(); ms > 0 {
+		fmt.Fprintf(b, "%s %d\n", strings.Join(stack, ";"), ms)
+	}
+	for _, child := range node.children {
+		child.writeFolded(b, stack)
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Expansions of the composite strftime directives.
+var formatParserExpansions = map[byte]string{
+	'F': "%Y-%m-%d",
+	'T': "%H:%M:%S",
+	'D': "%m/%d/%y",
+	'R': "%H:%M",
+	'r': "%I:%M:%S %p",
+	'v': "%e-%b-%Y",
+	'x': "%m/%d/%y",
+	'X': "%H:%M:%S",
+}
+
+// Patterns matched by the strftime directives that can be parsed back.
+var formatParserPatterns = map[byte]string{
+	'Y': `\d{4}`,
+	'C': `\d{2}`,
+	'y': `\d{2}`,
+	'G': `\d{4}`,
+	'g': `\d{2}`,
+	'm': `\d{2}`,
+	'b': `[A-Za-z]{3}`,
+	'h': `[A-Za-z]{3}`,
+	'B': `[A-Za-z]+`,
+	'd': `\d{2}`,
+	'e': ` ?\d{1,2}`,
+	'j': `\d{3}`,
+	'H': `\d{2}`,
+	'k': ` ?\d{1,2}`,
+	'I': `\d{2}`,
+	'l': ` ?\d{1,2}`,
+	'M': `\d{2}`,
+	'S': `\d{2}`,
+	'L': `\d{3}`,
+	'f': `\d{6}`,
+	'J': `\d+`,
+	's': `\d+`,
+	'p': `AM|PM`,
+	'z': `[+-]\d{4}`,
+	'Z': `[A-Za-z0-9+-]+`,
+	'Q': ` *\d+\.\d`,
+	'~': ` *-?\d+\.\d{3}`,
+	'a': `[A-Za-z]+`,
+	'A': `[A-Za-z]+`,
+	'u': `\d`,
+	'w': `\d`,
+	'U': `\d{2}`,
+	'V': `\d{2}`,
+	'W': `\d{2}`,
+	'n': `\n`,
+	't': `\t`,
+
+	// %:z and %::z.
+	colonOffsetDirective:       `[+-]\d{2}:\d{2}`,
+	doubleColonOffsetDirective: `[+-]\d{2}:\d{2}:\d{2}`,
+}
+
+// Directives that pin down the date, without which consecutive timestamps
+// going backwards are taken to cross midnight.
+const formatParserDateDirectives = "YymbhBdejsGg"
+
+// FormatParser parses timestamps rendered with a strftime format, so that
+// logs timestamped by ets can be read back.
+type FormatParser struct {
+	re         *regexp.Regexp
+	directives []byte
+	tz         *time.Location
+	zones      *zoneAbbreviations
+	// HasDate is whether the format includes the date.
+	HasDate bool
+}
+
+// NewFormatParser returns a parser of timestamps in format, taken to be in
+// timezone unless they carry an offset.
+func NewFormatParser(format string, timezone *time.Location) (*FormatParser, error) {
+	p := &FormatParser{tz: timezone, zones: newZoneAbbreviations(timezone)}
+	var pattern strings.Builder
+	pattern.WriteString("^")
+	if err := p.compile(format, &pattern); err != nil {
+		return nil, err
+	}
+	p.re = regexp.MustCompile(pattern.String())
+	return p, nil
+}
+
+func (p *FormatParser) compile(format string, pattern *strings.Builder) error {
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		if c != '%' {
+			pattern.WriteString(regexp.QuoteMeta(string(c)))
+			continue
+		}
+		i++
+		if i == len(format) {
+			return fmt.Errorf("format %q ends with a lone %%", format)
+		}
+		directive, n, err := directiveAt(format, i)
+		if err != nil {
+			return err
+		}
+		d := directive[0]
+		i += n - 1
+		if d == '%' {
+			pattern.WriteString("%")
+			continue
+		}
+		if expansion, ok := formatParserExpansions[d]; ok {
+			if err := p.compile(expansion, pattern); err != nil {
+				return err
+			}
+			continue
+		}
+		sub, ok := formatParserPatterns[d]
+		if !ok {
+			return fmt.Errorf("cannot parse timestamps with directive %%%c", d)
+		}
+		pattern.WriteString("(" + sub + ")")
+		p.directives = append(p.directives, d)
+		if strings.IndexByte(formatParserDateDirectives, d) >= 0 {
+			p.HasDate = true
+		}
+	}
+	return nil
+}
+
+// Parse extracts the timestamp at the start of line, returning its time and
+// the rest of the line, less the separating space. Timestamps without a date
+// fall on January 1, 1970, so that elapsed times parse to time.Unix(0, 0)
+// plus the elapsed time, as they are formatted.
+func (p *FormatParser) Parse(line string) (time.Time, string, bool) {
+	m := p.re.FindStringSubmatchIndex(line)
+	if m == nil {
+		return time.Time{}, line, false
+	}
+	year, month, day, yday, days := 1970, time.January, 1, 0, 0
+	isoYear, isoWeek, weekday := 0, 0, 1
+	hour, min, sec, nsec := 0, 0, 0, 0
+	pm, twelveHour := false, false
+	var unix int64
+	hasUnix := false
+	loc := p.tz
+	zone := ""
+	for i, d := range p.directives {
+		s := strings.TrimSpace(line[m[2*i+2]:m[2*i+3]])
+		n, _ := strconv.Atoi(s)
+		switch d {
+		case 'Y':
+			year = n
+		case 'C':
+			year = n*100 + year%100
+		case 'y':
+			year = 2000 + n
+		case 'm':
+			month = time.Month(n)
+		case 'b', 'h', 'B':
+			for mo := time.January; mo <= time.December; mo++ {
+				if strings.EqualFold(s, mo.String()) || strings.EqualFold(s, mo.String()[:3]) {
+					month = mo
+				}
+			}
+		case 'd', 'e':
+			day = n
+		case 'j':
+			yday = n
+		case 'G':
+			isoYear = n
+		case 'g':
+			isoYear = 2000 + n
+		case 'V':
+			isoWeek = n
+		case 'u':
+			weekday = n
+		case 'J':
+			days = n
+		case 'H', 'k':
+			hour = n
+		case 'I', 'l':
+			hour = n % 12
+			twelveHour = true
+		case 'M':
+			min = n
+		case 'S':
+			sec = n
+		case 'L':
+			nsec = n * int(time.Millisecond)
+		case 'f':
+			nsec = n * int(time.Microsecond)
+		case 's':
+			unix, _ = strconv.ParseInt(s, 10, 64)
+			hasUnix = true
+		case 'p':
+			pm = s == "PM"
+		case 'z':
+			offset := n/100*3600 + n%100*60
+			loc = time.FixedZone("", offset)
+		case 'Z':
+			zone = s
+		case colonOffsetDirective, doubleColonOffsetDirective:
+			var h, m, sec int
+			fmt.Sscanf(s[1:], "%d:%d:%d", &h, &m, &sec)
+			offset := h*3600 + m*60 + sec
+			if s[0] == '-' {
+				offset = -offset
+			}
+			loc = time.FixedZone("", offset)
+		}
+	}
+	if twelveHour && pm {
+		hour += 12
+	}
+	if !p.HasDate {
+		loc = time.UTC
+	}
+	var t time.Time
+	switch {
+	case hasUnix:
+		t = time.Unix(unix, int64(nsec))
+	case yday > 0:
+		t = time.Date(year, time.January, yday, hour, min, sec, nsec, loc)
+	case isoYear > 0 && isoWeek > 0:
+		// Week 1 is the one with January 4 in it.
+		jan4 := time.Date(isoYear, time.January, 4, hour, min, sec, nsec, loc)
+		monday := (int(jan4.Weekday()) + 6) % 7
+		t = jan4.AddDate(0, 0, (isoWeek-1)*7+weekday-1-monday)
+	default:
+		t = time.Date(year, month, day, hour, min, sec, nsec, loc)
+	}
+	if !p.HasDate {
+		// Days since the epoch, or days elapsed.
+		t = t.AddDate(0, 0, days)
+	} else if name, _ := t.Zone(); zone != "" && zone != name && loc == p.tz && !hasUnix {
+		// The wall clock reads the same twice when DST ends, as 01:30 EDT
+		// then 01:30 EST; the abbreviation tells which.
+		if offset, ok := p.zones.Offset(zone, t); ok {
+			year, month, day := t.Date()
+			t = time.Date(year, month, day, hour, min, sec, nsec, time.FixedZone(zone, offset)).In(loc)
+		}
+	}
+	return t, strings.TrimPrefix(line[m[1]:], " "), true
+}
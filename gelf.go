@@ -0,0 +1,141 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/zmwangx/ets/sink"
+)
+
+const (
+	// Largest datagram sent, chunked beyond.
+	gelfChunkSize = 8192
+	// Magic bytes, message ID, sequence number, and sequence count.
+	gelfChunkHeaderSize = 12
+	gelfMaxChunks       = 128
+)
+
+// The GELF 1.1 message of a line, with timing fields.
+type gelfMessage struct {
+	Version      string  `json:"version"`
+	Host         string  `json:"host"`
+	ShortMessage string  `json:"short_message"`
+	Timestamp    float64 `json:"timestamp"`
+	Level        int     `json:"level"`
+	Command      string  `json:"_command,omitempty"`
+	Elapsed      float64 `json:"_elapsed"`
+	Delta        float64 `json:"_delta"`
+	Line         int     `json:"_line"`
+	Run          string  `json:"_run"`
+	Label        string  `json:"_label,omitempty"`
+}
+
+// GELFSink sends every line to Graylog as a GELF message with timing fields,
+// over UDP, chunked if large, or TCP.
+type GELFSink struct {
+	conn     net.Conn
+	stream   bool
+	hostname string
+	command  string
+	failed   bool
+}
+
+// NewGELFSink connects to the GELF input at spec, udp://host:port or
+// tcp://host:port, sending messages about command.
+func NewGELFSink(spec string, command []string) (*GELFSink, error) {
+	u, err := url.Parse(spec)
+	if err != nil || (u.Scheme != "udp" && u.Scheme != "tcp") || u.Host == "" {
+		return nil, fmt.Errorf("invalid GELF address %q: expected udp://host:port or tcp://host:port", spec)
+	}
+	conn, err := net.Dial(u.Scheme, u.Host)
+	if err != nil {
+		return nil, err
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return &GELFSink{
+		conn:     conn,
+		stream:   u.Scheme == "tcp",
+		hostname: hostname,
+		command:  strings.Join(command, " "),
+	}, nil
+}
+
+// Send sends the line of e, at the severity of its level. Failures are
+// reported once.
+func (s *GELFSink) Send(e *LineEvent) {
+	if e.Kind != sink.Output {
+		return
+	}
+	severity, ok := syslogSeverities[e.Level]
+	if !ok {
+		severity = syslogSeverities["info"]
+	}
+	message, err := json.Marshal(gelfMessage{
+		Version:      "1.1",
+		Host:         s.hostname,
+		ShortMessage: e.Text,
+		Timestamp:    float64(e.Time.UnixNano()/int64(time.Microsecond)) / 1e6,
+		Level:        severity,
+		Command:      s.command,
+		Elapsed:      e.Elapsed.Seconds(),
+		Delta:        e.Delta.Seconds(),
+		Line:         e.Line,
+		Run:          e.Run,
+		Label:        e.Label,
+	})
+	if err == nil {
+		err = s.write(message)
+	}
+	if err != nil && !s.failed {
+		log.Printf("error sending to GELF input: %s", err)
+		s.failed = true
+	}
+}
+
+func (s *GELFSink) write(message []byte) error {
+	if s.stream {
+		// Messages over TCP are delimited by null bytes.
+		_, err := s.conn.Write(append(message, 0))
+		return err
+	}
+	if len(message) <= gelfChunkSize {
+		_, err := s.conn.Write(message)
+		return err
+	}
+	const payloadSize = gelfChunkSize - gelfChunkHeaderSize
+	count := (len(message) + payloadSize - 1) / payloadSize
+	if count > gelfMaxChunks {
+		return fmt.Errorf("message of %d bytes exceeds %d chunks", len(message), gelfMaxChunks)
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	for i := 0; i < count; i++ {
+		end := (i + 1) * payloadSize
+		if end > len(message) {
+			end = len(message)
+		}
+		chunk := append([]byte{0x1e, 0x0f}, id...)
+		chunk = append(chunk, byte(i), byte(count))
+		chunk = append(chunk, message[i*payloadSize:end]...)
+		if _, err := s.conn.Write(chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *GELFSink) Close() {
+	s.conn.Close()
+}
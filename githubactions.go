@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// GitHub Actions workflow commands, such as ::group::name or
+// ::error file=app.js,line=1::message, capturing the command and its data.
+var workflowCommand = regexp.MustCompile(`^::([A-Za-z-]+)(?: [^\r\n]*?)?::(.*)$`)
+
+// parseWorkflowCommand returns the command and data of line if it is a
+// workflow command.
+func parseWorkflowCommand(line string) (command string, data string, ok bool) {
+	line = strings.TrimRight(ansiEscapes.ReplaceAllString(line, ""), "\r\n")
+	m := workflowCommand.FindStringSubmatch(line)
+	if m == nil {
+		return "", "", false
+	}
+	return m[1], m[2], true
+}
+
+// githubNotice returns a workflow command annotating the run with the
+// duration of phase.
+func githubNotice(phase *Phase) string {
+	message := fmt.Sprintf("%s took %s (%d %s)", phase.Name, formatSummaryDuration(phase.Duration()),
+		phase.Lines, pluralize(phase.Lines, "line", "lines"))
+	return "::notice title=ets::" + escapeWorkflowData(message) + "\n"
+}
+
+var workflowDataEscaper = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A")
+
+func escapeWorkflowData(s string) string {
+	return workflowDataEscaper.Replace(s)
+}
@@ -3,9 +3,11 @@ module github.com/zmwangx/ets
 go 1.14
 
 require (
+	filippo.io/age v1.0.0
 	github.com/creack/pty v1.1.11
 	github.com/lestrrat-go/strftime v1.0.2-0.20200511001955-47fd69319961
 	github.com/mattn/go-runewidth v0.0.9
 	github.com/riywo/loginshell v0.0.0-20190610082906-2ed199a032f6
 	github.com/spf13/pflag v1.0.5
+	go.starlark.net v0.0.0-20200306205701-8dd3e2ee1dd5
 )
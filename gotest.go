@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// goTestEvent is an event in the output of go test -json, as documented by
+// go doc test2json.
+type goTestEvent struct {
+	Time    time.Time
+	Action  string
+	Package string
+	Test    string
+	Output  string
+}
+
+type goTestResult struct {
+	Package string
+	Test    string
+	// Action ending the test, i.e. pass, fail, or skip, or empty if the test
+	// never finished.
+	Result    string
+	Start     time.Time
+	End       time.Time
+	MaxGap    time.Duration
+	lastEvent time.Time
+}
+
+func (r *goTestResult) Duration() time.Duration {
+	return r.End.Sub(r.Start)
+}
+
+// GoTestReport tracks the duration of tests from a go test -json event
+// stream, and the longest gap between their events.
+type GoTestReport struct {
+	tests   map[string]*goTestResult
+	results []*goTestResult
+}
+
+func NewGoTestReport() *GoTestReport {
+	return &GoTestReport{tests: make(map[string]*goTestResult)}
+}
+
+// Record accounts for line if it is a go test event, using the time of the
+// event, or now if it has none. It reports whether line was an event.
+func (r *GoTestReport) Record(line string, now time.Time) bool {
+	line = strings.TrimSpace(ansiEscapes.ReplaceAllString(line, ""))
+	if !strings.HasPrefix(line, "{") {
+		return false
+	}
+	var event goTestEvent
+	if err := json.Unmarshal([]byte(line), &event); err != nil || event.Action == "" {
+		return false
+	}
+	if event.Test == "" {
+		return true
+	}
+	t := event.Time
+	if t.IsZero() {
+		t = now
+	}
+	key := event.Package + " " + event.Test
+	result, ok := r.tests[key]
+	if !ok {
+		result = &goTestResult{Package: event.Package, Test: event.Test, Start: t, lastEvent: t}
+		r.tests[key] = result
+		r.results = append(r.results, result)
+	}
+	if gap := t.Sub(result.lastEvent); gap > result.MaxGap {
+		result.MaxGap = gap
+	}
+	result.lastEvent = t
+	result.End = t
+	switch event.Action {
+	case "pass", "fail", "skip":
+		result.Result = event.Action
+	}
+	return true
+}
+
+// Print writes the tests seen to w, slowest first.
+func (r *GoTestReport) Print(w io.Writer) {
+	if len(r.results) == 0 {
+		return
+	}
+	results := append([]*goTestResult{}, r.results...)
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Duration() > results[j].Duration()
+	})
+	fmt.Fprintln(w, "ets go test report:")
+	for _, result := range results {
+		status := strings.ToUpper(result.Result)
+		if status == "" {
+			status = "RUN"
+		}
+		fmt.Fprintf(w, "  %-4s  %10s  max gap %-10s  %s %s\n", status,
+			formatSummaryDuration(result.Duration()), formatSummaryDuration(result.MaxGap),
+			result.Package, result.Test)
+	}
+}
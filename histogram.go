@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Upper bounds of the buckets of gap histograms, roughly log-scaled. The
+// last bucket is unbounded.
+var gapBucketBounds = []time.Duration{
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+	10 * time.Second,
+	time.Minute,
+	10 * time.Minute,
+	time.Hour,
+}
+
+// Width of the longest bar of a histogram.
+const histogramWidth = 40
+
+// GapHistogram counts the gaps between consecutive lines by order of
+// magnitude.
+type GapHistogram struct {
+	counts []int
+}
+
+func NewGapHistogram() *GapHistogram {
+	return &GapHistogram{counts: make([]int, len(gapBucketBounds)+1)}
+}
+
+func (h *GapHistogram) Record(gap time.Duration) {
+	i := 0
+	for i < len(gapBucketBounds) && gap >= gapBucketBounds[i] {
+		i++
+	}
+	h.counts[i]++
+}
+
+// Print writes the histogram to w, from the shortest to the longest
+// nonempty bucket, with bars scaled to the largest bucket.
+func (h *GapHistogram) Print(w io.Writer) {
+	first, last, max := -1, -1, 0
+	for i, count := range h.counts {
+		if count == 0 {
+			continue
+		}
+		if first < 0 {
+			first = i
+		}
+		last = i
+		if count > max {
+			max = count
+		}
+	}
+	fmt.Fprintln(w, "ets gap histogram:")
+	if first < 0 {
+		fmt.Fprintln(w, "  no gaps between lines")
+		return
+	}
+	labels := make([]string, len(h.counts))
+	labelWidth := 0
+	for i := first; i <= last; i++ {
+		labels[i] = gapBucketLabel(i)
+		if len(labels[i]) > labelWidth {
+			labelWidth = len(labels[i])
+		}
+	}
+	for i := first; i <= last; i++ {
+		count := h.counts[i]
+		bar := count * histogramWidth / max
+		if bar == 0 && count > 0 {
+			bar = 1
+		}
+		fmt.Fprintf(w, "  %*s  %-*s  %d\n", labelWidth, labels[i], histogramWidth, strings.Repeat("#", bar), count)
+	}
+}
+
+func gapBucketLabel(i int) string {
+	switch i {
+	case 0:
+		return "< " + gapBucketBounds[0].String()
+	case len(gapBucketBounds):
+		return ">= " + formatBucketBound(gapBucketBounds[i-1])
+	default:
+		return formatBucketBound(gapBucketBounds[i-1]) + " - " + formatBucketBound(gapBucketBounds[i])
+	}
+}
+
+// formatBucketBound formats d without the zero units of time.Duration's
+// String, e.g. 1m rather than 1m0s.
+func formatBucketBound(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
+
+// Buckets per doubling of a durationSketch, for quantiles within about 2%.
+const sketchBucketsPerDoubling = 16
+
+// durationSketch approximates the quantiles of a stream of durations in
+// constant space by counting them in buckets growing exponentially.
+type durationSketch struct {
+	counts map[int]int
+	n      int
+	max    time.Duration
+}
+
+func newDurationSketch() *durationSketch {
+	return &durationSketch{counts: make(map[int]int)}
+}
+
+func (s *durationSketch) Add(d time.Duration) {
+	s.counts[sketchBucket(d)]++
+	s.n++
+	if d > s.max {
+		s.max = d
+	}
+}
+
+func sketchBucket(d time.Duration) int {
+	if d < 1 {
+		return math.MinInt32
+	}
+	return int(math.Floor(math.Log2(float64(d)) * sketchBucketsPerDoubling))
+}
+
+// Quantile returns the q-quantile, 0 < q <= 1, as the midpoint of the bucket
+// it falls in, which is never more than the maximum.
+func (s *durationSketch) Quantile(q float64) time.Duration {
+	if s.n == 0 {
+		return 0
+	}
+	buckets := make([]int, 0, len(s.counts))
+	for bucket := range s.counts {
+		buckets = append(buckets, bucket)
+	}
+	sort.Ints(buckets)
+	rank := int(math.Ceil(q * float64(s.n)))
+	seen := 0
+	for _, bucket := range buckets {
+		seen += s.counts[bucket]
+		if seen < rank {
+			continue
+		}
+		if bucket == math.MinInt32 {
+			return 0
+		}
+		d := time.Duration(math.Exp2((float64(bucket) + 0.5) / sketchBucketsPerDoubling))
+		if d > s.max {
+			d = s.max
+		}
+		return d
+	}
+	return s.max
+}
+
+// durationPercentiles are the percentiles reported for a set of durations.
+type durationPercentiles struct {
+	P50, P90, P99, Max time.Duration
+}
+
+func (s *durationSketch) Percentiles() durationPercentiles {
+	return durationPercentiles{s.Quantile(0.5), s.Quantile(0.9), s.Quantile(0.99), s.max}
+}
+
+// exactPercentiles computes the percentiles of a few durations by the
+// nearest-rank method.
+func exactPercentiles(durations []time.Duration) durationPercentiles {
+	sorted := append([]time.Duration(nil), durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	rank := func(q float64) time.Duration {
+		return sorted[int(math.Ceil(q*float64(len(sorted))))-1]
+	}
+	return durationPercentiles{rank(0.5), rank(0.9), rank(0.99), sorted[len(sorted)-1]}
+}
+
+func (p durationPercentiles) String() string {
+	return fmt.Sprintf("p50 %s, p90 %s, p99 %s, max %s", formatSummaryDuration(p.P50),
+		formatSummaryDuration(p.P90), formatSummaryDuration(p.P99), formatSummaryDuration(p.Max))
+}
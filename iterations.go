@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Iteration is a run of the command in a repeat mode, such as an attempt of
+// --until-success or a run of ets cron.
+type Iteration struct {
+	ExitCode int
+	Duration time.Duration
+	Lines    int
+}
+
+// IterationStats collects the iterations of a repeat mode to report trends
+// across them.
+type IterationStats struct {
+	// Name is what an iteration is called, e.g. attempt.
+	Name string
+	// List lists every iteration in the summary, rather than only the trend.
+	List       bool
+	Iterations []Iteration
+}
+
+func (s *IterationStats) Record(it Iteration) {
+	s.Iterations = append(s.Iterations, it)
+}
+
+// Describe describes the ith iteration, compared to the previous one, e.g.
+// "exit status 1 after 1.2s, 42 lines (+300ms, -5 lines from previous)".
+func (s *IterationStats) Describe(i int) string {
+	it := s.Iterations[i]
+	description := fmt.Sprintf("exit status %d after %s, %d %s",
+		it.ExitCode, formatSummaryDuration(it.Duration), it.Lines, pluralize(it.Lines, "line", "lines"))
+	if i > 0 {
+		prev := s.Iterations[i-1]
+		diff, n := it.Lines-prev.Lines, it.Lines-prev.Lines
+		if n < 0 {
+			n = -n
+		}
+		description += fmt.Sprintf(" (%s, %+d %s from previous)",
+			formatSignedDuration(it.Duration-prev.Duration), diff, pluralize(n, "line", "lines"))
+	}
+	return description
+}
+
+// Trend summarizes the durations and output volumes of all iterations.
+func (s *IterationStats) Trend() string {
+	n := len(s.Iterations)
+	if n == 0 {
+		return "none"
+	}
+	minDuration, maxDuration, totalDuration := s.Iterations[0].Duration, s.Iterations[0].Duration, time.Duration(0)
+	minLines, maxLines, totalLines := s.Iterations[0].Lines, s.Iterations[0].Lines, 0
+	for _, it := range s.Iterations {
+		if it.Duration < minDuration {
+			minDuration = it.Duration
+		}
+		if it.Duration > maxDuration {
+			maxDuration = it.Duration
+		}
+		totalDuration += it.Duration
+		if it.Lines < minLines {
+			minLines = it.Lines
+		}
+		if it.Lines > maxLines {
+			maxLines = it.Lines
+		}
+		totalLines += it.Lines
+	}
+	parts := []string{
+		fmt.Sprintf("%d", n),
+		fmt.Sprintf("duration min %s, mean %s, max %s", formatSummaryDuration(minDuration),
+			formatSummaryDuration(totalDuration/time.Duration(n)), formatSummaryDuration(maxDuration)),
+		fmt.Sprintf("lines min %d, mean %.1f, max %d", minLines, float64(totalLines)/float64(n), maxLines),
+	}
+	return strings.Join(parts, "; ")
+}
+
+func (s *IterationStats) rows() []summaryRow {
+	rows := make([]summaryRow, 0)
+	if s.List {
+		for i := range s.Iterations {
+			rows = append(rows, summaryRow{s.Name, fmt.Sprintf("%d: %s", i+1, s.Describe(i))})
+		}
+	}
+	return append(rows, summaryRow{s.Name + "s", s.Trend()})
+}
+
+func formatSignedDuration(d time.Duration) string {
+	if d < 0 {
+		if s := formatSummaryDuration(-d); s != "0s" {
+			return "-" + s
+		}
+		d = 0
+	}
+	return "+" + formatSummaryDuration(d)
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"regexp"
+	"time"
+)
+
+// Timestamps as embedded in pipeline logs by the Jenkins Timestamper plugin,
+// e.g. [2020-06-01T12:34:56.789Z], followed by a space.
+var jenkinsTimestamp = regexp.MustCompile(`^\[(\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(?:\.\d+)?Z)\] `)
+
+func parseJenkinsTimestamp(line string) (time.Time, string, bool) {
+	m := jenkinsTimestamp.FindStringSubmatchIndex(line)
+	if m == nil {
+		return time.Time{}, line, false
+	}
+	t, err := time.Parse(time.RFC3339Nano, line[m[2]:m[3]])
+	if err != nil {
+		return time.Time{}, line, false
+	}
+	return t, line[m[1]:], true
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	Cases     []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+}
+
+// writeJUnitReport writes the phases of the run to path as JUnit test cases,
+// or the whole run as a single test case if there are no phases. If the
+// command failed, the last phase is reported as failed.
+func writeJUnitReport(path string, s *Summary) error {
+	name := strings.Join(s.Command, " ")
+	if name == "" {
+		name = "ets"
+	}
+	phases := s.Phases
+	if len(phases) == 0 {
+		phases = []*Phase{{Name: name, Start: s.Start, End: s.End, Lines: s.Lines}}
+	}
+	suite := junitTestSuite{
+		Name:      name,
+		Tests:     len(phases),
+		Time:      junitSeconds(s.Duration().Seconds()),
+		Timestamp: s.Start.UTC().Format("2006-01-02T15:04:05"),
+	}
+	for i, phase := range phases {
+		testCase := junitTestCase{
+			Name:      phase.Name,
+			Classname: "ets",
+			Time:      junitSeconds(phase.Duration().Seconds()),
+		}
+		if s.Exited && s.ExitCode != 0 && i == len(phases)-1 {
+			testCase.Failure = &junitFailure{Message: fmt.Sprintf("command exited with status %d", s.ExitCode)}
+			suite.Failures++
+		}
+		suite.Cases = append(suite.Cases, testCase)
+	}
+	out, err := xml.MarshalIndent(junitTestSuites{Suites: []junitTestSuite{suite}}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append([]byte(xml.Header), append(out, '\n')...), 0644)
+}
+
+func junitSeconds(seconds float64) string {
+	return fmt.Sprintf("%.3f", seconds)
+}
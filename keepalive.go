@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// keepAlive prints a note with the elapsed time and the number of lines
+// buffered every interval while output is held back, until done is closed.
+func keepAlive(printer *Printer, interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			printer.KeepAlive()
+		}
+	}
+}
+
+// KeepAlive prints a note with the elapsed time and the number of lines held
+// back by --only-on-failure or --tail-buffer, past the buffer. Notes written
+// to an output file are dropped when the tail rewrites it.
+func (p *Printer) KeepAlive() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.Tail == nil {
+		return
+	}
+	elapsed := strings.TrimPrefix(formatHumanDuration(time.Since(p.Summary.Start)), "+")
+	lines := len(p.Tail.lines)
+	fmt.Fprint(p.Tail.out, p.formatAnnotation(fmt.Sprintf("still running, %s elapsed, %s %s buffered",
+		elapsed, formatCount(lines), pluralize(lines, "line", "lines")), p.now(), true))
+}
+
+// formatCount formats a count n with commas between groups of thousands,
+// as 3,412.
+func formatCount(n int) string {
+	s := strconv.Itoa(n)
+	start := (len(s)-1)%3 + 1
+	var b strings.Builder
+	b.WriteString(s[:start])
+	for i := start; i < len(s); i += 3 {
+		b.WriteString("," + s[i:i+3])
+	}
+	return b.String()
+}
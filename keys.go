@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"github.com/creack/pty"
+)
+
+// keyPrefix introduces the keys handled by ets itself rather than forwarded
+// to the command: Ctrl-].
+const keyPrefix = 0x1d
+
+var keyBindings = []struct {
+	key         byte
+	description string
+}{
+	{'p', "pause or resume the display"},
+	{'t', "hide or show timestamps"},
+	{'m', "drop a numbered bookmark"},
+	{'n', "type a note, ended by Enter"},
+	{'s', "show the status of the command"},
+	{keyPrefix, "send Ctrl-] to the command"},
+}
+
+// KeyHandler forwards input to the command, intercepting the keys following
+// keyPrefix.
+type KeyHandler struct {
+	printer *Printer
+	pid     int
+	pending bool
+	restore func()
+	// note is the note being typed, if any, which is echoed to echo.
+	note []byte
+	echo io.Writer
+}
+
+// startKeyHandler puts stdin in cbreak mode so that keys are read as they
+// are typed, and returns a KeyHandler for the command with the given pid,
+// whose restore function restores stdin. It returns nil if key bindings are
+// disabled or stdin isn't a terminal.
+func startKeyHandler(printer *Printer, pid int, opts *options) *KeyHandler {
+	if opts.noKeys {
+		return nil
+	}
+	if _, err := pty.GetsizeFull(os.Stdin); err != nil {
+		return nil
+	}
+	restore, err := enterCbreakMode(os.Stdin)
+	if err != nil {
+		return nil
+	}
+	return &KeyHandler{printer: printer, pid: pid, restore: restore, echo: os.Stderr}
+}
+
+// Forward copies r to w until either fails, handling key bindings on the
+// way.
+func (k *KeyHandler) Forward(w io.Writer, r io.Reader) {
+	buf := make([]byte, 1024)
+	for {
+		n, err := r.Read(buf)
+		if input := k.filter(buf[:n]); len(input) > 0 {
+			if _, err := w.Write(input); err != nil {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// filter handles the key bindings in data and returns the rest of the input.
+func (k *KeyHandler) filter(data []byte) []byte {
+	input := make([]byte, 0, len(data))
+	for _, c := range data {
+		if k.note != nil {
+			k.typeNote(c)
+			continue
+		}
+		if !k.pending {
+			if c == keyPrefix {
+				k.pending = true
+			} else {
+				input = append(input, c)
+			}
+			continue
+		}
+		k.pending = false
+		if c == keyPrefix {
+			input = append(input, keyPrefix)
+		} else {
+			k.handle(c)
+		}
+	}
+	return input
+}
+
+func (k *KeyHandler) handle(key byte) {
+	switch key {
+	case 'p':
+		k.printer.TogglePause()
+	case 't':
+		if k.printer.ToggleTimestamps() {
+			k.printer.printNotice("timestamps shown")
+		} else {
+			k.printer.printNotice("timestamps hidden")
+		}
+	case 'm':
+		k.printer.Bookmark()
+	case 'n':
+		k.note = []byte{}
+		fmt.Fprint(k.echo, "note: ")
+	case 's':
+		k.printer.printNotice(k.status())
+	default:
+		k.printer.printNotice(describeKeyBindings())
+	}
+}
+
+// typeNote handles a key typed into a note, which is printed on Enter, and
+// discarded on Esc or Ctrl-C, rather than forwarded to the command.
+func (k *KeyHandler) typeNote(c byte) {
+	switch c {
+	case '\r', '\n':
+		text := strings.TrimSpace(string(k.note))
+		k.note = nil
+		fmt.Fprint(k.echo, "\n")
+		if text != "" {
+			k.printer.PrintNote(text)
+		}
+	case 0x1b, 0x03:
+		k.note = nil
+		fmt.Fprint(k.echo, " (discarded)\n")
+	case 0x7f, '\b':
+		if len(k.note) > 0 {
+			_, size := utf8.DecodeLastRune(k.note)
+			k.note = k.note[:len(k.note)-size]
+			fmt.Fprint(k.echo, "\b \b")
+		}
+	default:
+		if c >= ' ' {
+			k.note = append(k.note, c)
+			k.echo.Write([]byte{c})
+		}
+	}
+}
+
+func (k *KeyHandler) status() string {
+	p := k.printer
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	fields := []string{
+		"elapsed " + formatClock(now.Sub(p.Summary.Start)),
+		fmt.Sprintf("%d lines", p.Summary.Lines),
+		describeLastOutput(p.Summary, now),
+		fmt.Sprintf("running (pid %d)", k.pid),
+	}
+	if p.paused {
+		fields = append(fields, fmt.Sprintf("display paused with %d lines held back", p.heldLines))
+	}
+	return strings.Join(fields, " | ")
+}
+
+func describeKeyBindings() string {
+	descriptions := make([]string, len(keyBindings))
+	for i, binding := range keyBindings {
+		key := string(binding.key)
+		if binding.key == keyPrefix {
+			key = "Ctrl-]"
+		}
+		descriptions[i] = key + " " + binding.description
+	}
+	return "keys after Ctrl-]: " + strings.Join(descriptions, ", ")
+}
+
+// handleUserSignals pauses or resumes the display on SIGUSR1, or dumps the
+// --tail-buffer if any, and drops a bookmark on SIGUSR2, like the
+// corresponding keys.
+func handleUserSignals(printer *Printer) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		for sig := range sigs {
+			switch sig {
+			case syscall.SIGUSR1:
+				if printer.Tail != nil {
+					printer.DumpTail()
+				} else {
+					printer.TogglePause()
+				}
+			case syscall.SIGUSR2:
+				printer.Bookmark()
+			}
+		}
+	}()
+}
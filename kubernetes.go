@@ -0,0 +1,21 @@
+package main
+
+import (
+	"regexp"
+	"time"
+)
+
+// The prefix added by kubectl logs --prefix, e.g. [pod/web-5d8f/nginx].
+var kubectlPrefix = regexp.MustCompile(`^\[[^\]\s]+\] `)
+
+// parseKubectlTimestamp parses the timestamp of a line of kubectl logs
+// --timestamps output. A pod and container prefix from kubectl logs --prefix,
+// which precedes the timestamp, is kept at the start of the rest of the line.
+func parseKubectlTimestamp(line string) (time.Time, string, bool) {
+	prefix := kubectlPrefix.FindString(line)
+	t, rest, ok := parseRFC3339Timestamp(line[len(prefix):])
+	if !ok {
+		return time.Time{}, line, false
+	}
+	return t, prefix + rest, true
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// LevelStyle controls how detected log levels are rendered.
+type LevelStyle int
+
+const (
+	LevelColor LevelStyle = 1 << iota
+	LevelTag
+)
+
+// Canonical level names, most severe first.
+var levelNames = []string{"error", "warn", "info", "debug"}
+
+var levelColors = map[string]string{
+	"error": "\x1b[31m",
+	"warn":  "\x1b[33m",
+	"info":  "",
+	"debug": "\x1b[90m",
+}
+
+// Built-in level patterns, matching conventional uppercase severity tokens
+// as well as logfmt-style level=... fields.
+var defaultLevelPatterns = []string{
+	`error=\b(?:FATAL|PANIC|CRITICAL|CRIT|ERROR|ERR)\b|(?i:\blevel=(?:fatal|panic|critical|crit|error|err)\b)`,
+	`warn=\b(?:WARNING|WARN)\b|(?i:\blevel=warn(?:ing)?\b)`,
+	`info=\b(?:INFO|NOTICE)\b|(?i:\blevel=(?:info|notice)\b)`,
+	`debug=\b(?:DEBUG|TRACE)\b|(?i:\blevel=(?:debug|trace)\b)`,
+}
+
+const levelTagWidth = 5
+
+type levelPattern struct {
+	level string
+	re    *regexp.Regexp
+}
+
+// LevelDetector determines the severity of log lines.
+type LevelDetector struct {
+	patterns []levelPattern
+}
+
+// NewLevelDetector returns a detector using the given patterns, each of the
+// form level=regexp, in addition to the built-in ones. When patterns of
+// several levels match a line, the leftmost match wins, and ties go to the
+// pattern listed first, with the given patterns preceding the built-in ones.
+func NewLevelDetector(patterns []string) (*LevelDetector, error) {
+	d := &LevelDetector{}
+	all := append(append([]string{}, patterns...), defaultLevelPatterns...)
+	for _, pattern := range all {
+		i := strings.IndexByte(pattern, '=')
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid level pattern %q: expected level=regexp", pattern)
+		}
+		level := normalizeLevel(pattern[:i])
+		re, err := regexp.Compile(pattern[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid level pattern %q: %s", pattern, err)
+		}
+		d.patterns = append(d.patterns, levelPattern{level, re})
+	}
+	return d, nil
+}
+
+// Detect returns the level of line, or the empty string if no pattern
+// matches.
+func (d *LevelDetector) Detect(line string) string {
+	level := ""
+	leftmost := -1
+	for _, p := range d.patterns {
+		loc := p.re.FindStringIndex(line)
+		if loc != nil && (leftmost < 0 || loc[0] < leftmost) {
+			level = p.level
+			leftmost = loc[0]
+		}
+	}
+	return level
+}
+
+func normalizeLevel(level string) string {
+	level = strings.ToLower(level)
+	switch level {
+	case "warning":
+		return "warn"
+	case "err", "fatal", "critical":
+		return "error"
+	}
+	return level
+}
+
+func levelColor(level string) string {
+	return levelColors[level]
+}
+
+// levelTag renders level as a fixed-width uppercase tag; lines without a
+// level get a blank tag to keep columns aligned.
+func levelTag(level string) string {
+	tag := strings.ToUpper(level)
+	if len(tag) > levelTagWidth {
+		tag = tag[:levelTagWidth]
+	}
+	return fmt.Sprintf("%-*s", levelTagWidth, tag)
+}
+
+// parseLevelStyle parses the argument of --levels: color, tag, or both
+// joined with a comma, or none to only detect levels for the summary.
+func parseLevelStyle(s string) (LevelStyle, error) {
+	var style LevelStyle
+	for _, part := range strings.Split(s, ",") {
+		switch strings.TrimSpace(part) {
+		case "color":
+			style |= LevelColor
+		case "tag":
+			style |= LevelTag
+		case "none":
+		default:
+			return 0, fmt.Errorf("invalid level style %q: expected color, tag, or none", part)
+		}
+	}
+	return style, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// parseListenAddress parses the argument of --listen, unix:path or
+// tcp:host:port, into a network and an address.
+func parseListenAddress(s string) (network string, address string, err error) {
+	i := strings.IndexByte(s, ':')
+	if i >= 0 {
+		network, address = s[:i], s[i+1:]
+		if (network == "unix" || network == "tcp") && address != "" {
+			return network, address, nil
+		}
+	}
+	return "", "", fmt.Errorf("invalid listen address %q: expected unix:path or tcp:host:port", s)
+}
+
+// readListener accepts connections on the given address until interrupted,
+// and timestamps the lines received on each, labeled with the address of
+// the client, or for Unix sockets, the sequence number of the connection.
+func readListener(spec string, printer *Printer) error {
+	network, address, err := parseListenAddress(spec)
+	if err != nil {
+		return err
+	}
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return err
+	}
+
+	var mu sync.Mutex
+	conns := make(map[net.Conn]bool)
+	stopped := false
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	go func() {
+		<-sigs
+		mu.Lock()
+		defer mu.Unlock()
+		stopped = true
+		_ = listener.Close()
+		for conn := range conns {
+			_ = conn.Close()
+		}
+	}()
+
+	printer.StatusBar.SetState("listening on " + spec)
+	var wg sync.WaitGroup
+	for n := 1; ; n++ {
+		conn, err := listener.Accept()
+		if err != nil {
+			mu.Lock()
+			interrupted := stopped
+			mu.Unlock()
+			if interrupted {
+				break
+			}
+			return err
+		}
+		client := fmt.Sprintf("#%d", n)
+		if network == "tcp" {
+			client = conn.RemoteAddr().String()
+		}
+		mu.Lock()
+		conns[conn] = true
+		mu.Unlock()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			printer.PrintAnnotation(fmt.Sprintf("client %s connected", client))
+			printer.PrintLabeledStream(conn, client)
+			_ = conn.Close()
+			mu.Lock()
+			delete(conns, conn)
+			mu.Unlock()
+			printer.PrintAnnotation(fmt.Sprintf("client %s disconnected", client))
+		}()
+	}
+	wg.Wait()
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var lockModes = []string{"wait", "fail", "steal"}
+
+// acquireLock takes an exclusive flock on the file at path, created if
+// needed, and records our pid in it. If another instance holds the lock,
+// mode decides whether to wait for it, fail, or steal it by terminating the
+// holder, then wait. The lock is held until ets exits; the file descriptor
+// is deliberately never closed.
+func acquireLock(path string, mode string, printer *Printer) error {
+	switch mode {
+	case "wait", "fail", "steal":
+	default:
+		return fmt.Errorf("invalid lock mode %q: expected %s", mode, strings.Join(lockModes, ", "))
+	}
+	fd, err := syscall.Open(path, syscall.O_RDWR|syscall.O_CREAT|syscall.O_CLOEXEC, 0644)
+	if err != nil {
+		return &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	err = syscall.Flock(fd, syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		holder := lockHolder(path)
+		switch mode {
+		case "fail":
+			return fmt.Errorf("lock %s is held by %s", path, describeLockHolder(holder))
+		case "steal":
+			if holder > 0 {
+				printer.PrintAnnotation(fmt.Sprintf("stealing lock %s from %s", path, describeLockHolder(holder)))
+				_ = syscall.Kill(holder, syscall.SIGTERM)
+			}
+		}
+		printer.PrintAnnotation(fmt.Sprintf("waiting for lock %s held by %s", path, describeLockHolder(holder)))
+		start := time.Now()
+		err = syscall.Flock(fd, syscall.LOCK_EX)
+		if err == nil {
+			printer.PrintAnnotation(fmt.Sprintf("acquired lock %s after %s", path, formatSummaryDuration(time.Since(start))))
+		}
+	}
+	if err != nil {
+		_ = syscall.Close(fd)
+		return &os.PathError{Op: "flock", Path: path, Err: err}
+	}
+	pid := []byte(strconv.Itoa(os.Getpid()) + "\n")
+	if err := syscall.Ftruncate(fd, 0); err == nil {
+		_, _ = syscall.Pwrite(fd, pid, 0)
+	}
+	return nil
+}
+
+// lockHolder returns the pid recorded in the lock file at path, or 0.
+func lockHolder(path string) int {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil {
+		return 0
+	}
+	return pid
+}
+
+func describeLockHolder(pid int) string {
+	if pid <= 0 {
+		return "another process"
+	}
+	return fmt.Sprintf("pid %d", pid)
+}
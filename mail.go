@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+// Mailer sends the summary of the run by email when it ends, for --mail-to.
+type Mailer struct {
+	To []string
+	// From defaults to ets@ the hostname.
+	From string
+	// OnFailure is whether to send mail only if the command fails.
+	OnFailure bool
+	// Server is the SMTP server as host:port, authenticated to with User
+	// and Password if User is set.
+	Server   string
+	User     string
+	Password string
+	// Tail, if not nil, keeps the last lines of output to include.
+	Tail *tailBuffer
+}
+
+// Send mails the summary s of a run that exited with exitCode, unless mail
+// is only wanted on failure and it succeeded.
+func (m *Mailer) Send(s *Summary, exitCode int) error {
+	if m.OnFailure && exitCode == 0 {
+		return nil
+	}
+	from := m.From
+	if from == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			hostname = "localhost"
+		}
+		from = "ets@" + hostname
+	}
+	var auth smtp.Auth
+	if m.User != "" {
+		host, _, err := net.SplitHostPort(m.Server)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", m.User, m.Password, host)
+	}
+	return smtp.SendMail(m.Server, auth, from, m.To, m.message(from, s))
+}
+
+// message composes the mail from from reporting the summary s, with the
+// lines kept by Tail.
+func (m *Mailer) message(from string, s *Summary) []byte {
+	subject := "ets"
+	if len(s.Command) > 0 {
+		subject += ": " + strings.Join(s.Command, " ")
+	}
+	subject += ": " + describeCompletion(s)
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(m.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mailHeaderValue(subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("\r\n")
+
+	var body bytes.Buffer
+	s.Print(&body)
+	if m.Tail != nil && len(m.Tail.lines) > 0 {
+		fmt.Fprintf(&body, "\nlast %d %s of output:\n", len(m.Tail.lines), pluralize(len(m.Tail.lines), "line", "lines"))
+		for _, line := range m.Tail.lines {
+			body.WriteString(ansiEscapes.ReplaceAllString(strings.TrimRight(line, "\r\n"), "") + "\n")
+		}
+	}
+	// net/smtp escapes the lines starting with a dot.
+	msg.WriteString(strings.ReplaceAll(body.String(), "\n", "\r\n"))
+	return msg.Bytes()
+}
+
+// mailHeaderValue makes s safe to use as the value of a header, on one line
+// and encoded as an RFC 2047 word if it isn't plain ASCII.
+func mailHeaderValue(s string) string {
+	return mime.QEncoding.Encode("utf-8", strings.NewReplacer("\r", " ", "\n", " ").Replace(s))
+}
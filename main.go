@@ -1,22 +1,26 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
+	"crypto/ed25519"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
 	"os/signal"
+	"path/filepath"
 	"regexp"
+	"strings"
 	"syscall"
 	"time"
 
+	"filippo.io/age"
 	"github.com/creack/pty"
-	"github.com/mattn/go-runewidth"
 	"github.com/riywo/loginshell"
 	flag "github.com/spf13/pflag"
+
+	"github.com/zmwangx/ets/sink"
 )
 
 var version = "unknown"
@@ -26,44 +30,7 @@ var version = "unknown"
 // https://github.com/acarl005/stripansi/blob/5a71ef0e047df0427e87a79f27009029921f1f9b/stripansi.go#L7
 var ansiEscapes = regexp.MustCompile("[\u001B\u009B][[\\]()#;?]*(?:(?:(?:[a-zA-Z\\d]*(?:;[a-zA-Z\\d]*)*)?\u0007)|(?:(?:\\d{1,4}(?:;\\d{0,4})*)?[\\dA-PRZcf-ntqry=><~]))")
 
-func printStreamWithTimestamper(r io.Reader, timestamper *Timestamper) {
-	scanner := bufio.NewScanner(r)
-	// Split on \r\n|\r|\n, and return the line as well as the line ending (\r
-	// or \n is preserved, \r\n is collapsed to \n). Adaptation of
-	// bufio.ScanLines.
-	scanner.Split(func(data []byte, atEOF bool) (advance int, token []byte, err error) {
-		if atEOF && len(data) == 0 {
-			return 0, nil, nil
-		}
-		lfpos := bytes.IndexByte(data, '\n')
-		crpos := bytes.IndexByte(data, '\r')
-		if crpos >= 0 {
-			if lfpos < 0 || lfpos > crpos+1 {
-				// We have a CR-terminated "line".
-				return crpos + 1, data[0 : crpos+1], nil
-			}
-			if lfpos == crpos+1 {
-				// We have a CRLF-terminated line.
-				return lfpos + 1, append(data[0:crpos], '\n'), nil
-			}
-		}
-		if lfpos >= 0 {
-			// We have a LF-terminated line.
-			return lfpos + 1, data[0 : lfpos+1], nil
-		}
-		// If we're at EOF, we have a final, non-terminated line. Return it.
-		if atEOF {
-			return len(data), data, nil
-		}
-		// Request more data.
-		return 0, nil, nil
-	})
-	for scanner.Scan() {
-		fmt.Print(timestamper.CurrentTimestampString(), " ", scanner.Text())
-	}
-}
-
-func runCommandWithTimestamper(args []string, timestamper *Timestamper) error {
+func runCommandWithPrinter(args []string, printer *Printer, opts *options) error {
 	// Calculate optimal pty size, taking into account horizontal space taken up by timestamps.
 	getPtyWinsize := func() *pty.Winsize {
 		winsize, err := pty.GetsizeFull(os.Stdin)
@@ -71,10 +38,9 @@ func runCommandWithTimestamper(args []string, timestamper *Timestamper) error {
 			// Most likely stdin isn't a tty, in which case we don't care.
 			return winsize
 		}
+		winsize.Rows -= printer.StatusBar.ReservedRows()
 		totalCols := winsize.Cols
-		plainTimestampString := ansiEscapes.ReplaceAllString(timestamper.CurrentTimestampString(), "")
-		// Timestamp width along with one space character.
-		occupiedWidth := uint16(runewidth.StringWidth(plainTimestampString)) + 1
+		occupiedWidth := uint16(printer.PrefixWidth())
 		var effectiveCols uint16 = 0
 		if occupiedWidth < totalCols {
 			effectiveCols = totalCols - occupiedWidth
@@ -92,10 +58,28 @@ func runCommandWithTimestamper(args []string, timestamper *Timestamper) error {
 	if err != nil {
 		return err
 	}
+	if opts.elapsedFrom == "exec" {
+		printer.RebaseTimestamps(time.Now())
+	}
 	defer func() { _ = ptmx.Close() }()
+	printer.StatusBar.SetState(fmt.Sprintf("running (pid %d)", command.Process.Pid))
+	if opts.pidfile != "" {
+		if err := writePidfile(opts.pidfile, command.Process.Pid); err != nil {
+			log.Printf("error writing pidfile: %s", err)
+		}
+	}
+
+	exited := make(chan struct{})
+	if opts.sampleResources > 0 {
+		go sampleResources(printer, command.Process.Pid, opts.sampleResources, exited)
+	}
 
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGWINCH, syscall.SIGINT, syscall.SIGTERM)
+	defer func() {
+		signal.Stop(sigs)
+		close(sigs)
+	}()
 	go func() {
 		for sig := range sigs {
 			switch sig {
@@ -116,28 +100,572 @@ func runCommandWithTimestamper(args []string, timestamper *Timestamper) error {
 	}()
 	sigs <- syscall.SIGWINCH
 
-	go func() { _, _ = io.Copy(ptmx, os.Stdin) }()
+	if keys := startKeyHandler(printer, command.Process.Pid, opts); keys != nil {
+		defer keys.restore()
+		go keys.Forward(ptmx, os.Stdin)
+	} else {
+		go func() { _, _ = io.Copy(ptmx, os.Stdin) }()
+	}
 
-	printStreamWithTimestamper(ptmx, timestamper)
+	printer.PrintTerminalStream(ptmx)
 
-	return command.Wait()
+	err = command.Wait()
+	close(exited)
+	printer.Summary.Usage = resourceUsageOf(command.ProcessState)
+	return err
 }
 
-func main() {
-	log.SetFlags(log.Flags() &^ (log.Ldate | log.Ltime))
+// runPipedCommandWithPrinter runs a command with its stdout and stderr
+// connected to a pipe rather than a pty, for commands such as docker that
+// behave differently on a terminal.
+func runPipedCommandWithPrinter(args []string, printer *Printer, opts *options) error {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = r.Close() }()
+	command := exec.Command(args[0], args[1:]...)
+	command.Stdin = os.Stdin
+	command.Stdout = w
+	command.Stderr = w
+	err = command.Start()
+	_ = w.Close()
+	if err != nil {
+		return err
+	}
+	if opts.elapsedFrom == "exec" {
+		printer.RebaseTimestamps(time.Now())
+	}
+	printer.StatusBar.SetState(fmt.Sprintf("running (pid %d)", command.Process.Pid))
+	if opts.pidfile != "" {
+		if err := writePidfile(opts.pidfile, command.Process.Pid); err != nil {
+			log.Printf("error writing pidfile: %s", err)
+		}
+	}
+
+	exited := make(chan struct{})
+	if opts.sampleResources > 0 {
+		go sampleResources(printer, command.Process.Pid, opts.sampleResources, exited)
+	}
+
+	// The command is in our process group, so it receives SIGINT from the
+	// terminal directly; keep reading its output until it exits.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	go func() {
+		for sig := range sigs {
+			if sig == syscall.SIGTERM {
+				_ = command.Process.Signal(sig)
+			}
+		}
+	}()
+
+	printer.PrintStream(r)
+
+	err = command.Wait()
+	close(exited)
+	printer.Summary.Usage = resourceUsageOf(command.ProcessState)
+	return err
+}
+
+// options holds the settings of the subcommands other than completion, each
+// of which takes a subset of them.
+type options struct {
+	elapsedMode     bool
+	incrementalMode bool
+	round           time.Duration
+	truncate        time.Duration
+	dedupTimestamps bool
+	relativeDates   bool
+	quietFast       time.Duration
+	adaptiveDelta   bool
+	human           bool
+	elapsedFrom     string
+	since           string
+	format          string
+	utc             bool
+	timezoneName    string
+	secondTimezone  string
+	color           bool
+	parseTimestamps string
+	k8s             bool
+	remoteTime      bool
+	hostsFile       string
+	serial          string
+	baud            int
+	fifo            string
+	annotateFIFO    string
+	listen          string
+	fds             []string
+	label           string
+	levels          string
+	levelPatterns   []string
+	when            []string
+	then            []string
+	script          string
+	summary         bool
+	notify          bool
+	bell            bool
+	lock            string
+	lockMode        string
+	pidfile         string
+	etsPidfile      string
+	diffThreshold   float64
+	diffMinChange   time.Duration
+	setTitle        bool
+	quiet           bool
+	tee             string
+	outputFile      string
+	encrypt         string
+	daemon          bool
+	keep            int
+	splitSize       string
+	splitInterval   time.Duration
+	tailBuffer      string
+	onlyOnFailure   bool
+	keepAlive       time.Duration
+	mailTo          []string
+	mailOn          string
+	mailLines       int
+	mailFrom        string
+	smtpServer      string
+	smtpUser        string
+	smtpPassword    string
+	webhook         string
+	slackWebhook    string
+	discordWebhook  string
+	alertWebhook    string
+	alertOn         string
+	untilSuccess    bool
+	maxAttempts     int
+	retryDelay      time.Duration
+	sdNotify        bool
+	readyPattern    string
+	pager           string
+	maxGap          time.Duration
+	maxGapExit      int
+	markSlow        time.Duration
+	markSlowStyle   string
+	statusBar       bool
+	noKeys          bool
+	pauseBuffer     int
+	githubActions   bool
+	phasePattern    string
+	markerPattern   string
+	teamcity        bool
+	buildkite       bool
+	goTest          bool
+	junitOut        string
+	phasesJSON      string
+	foldedOut       string
+	splitByPhase    string
+	subphasePattern []string
+	baseline        string
+	regressionLimit string
+	updateBaseline  bool
+	histogram       bool
+	sparkline       string
+	activityReport  time.Duration
+	checksum        string
+	audit           bool
+	sign            string
+	upload          string
+	syslog          string
+	gelf            string
+	nats            string
+	natsSubject     string
+	natsJetStream   bool
+	elastic         string
+	filter          string
+	sample          string
+	sampleEvery     time.Duration
+	squashRepeats   time.Duration
+	markEvery       time.Duration
+	flushInterval   time.Duration
+	batchLines      int
+	batchBytes      int
+	splunkHEC       string
+	splunkToken     string
+	splunkType      string
+	sinks           []string
+	sinkFormats     []string
+	summaryJSON     string
+	tap             bool
+	sampleResources time.Duration
+	timeVerbose     bool
+	fromFormat      string
+	fromTimezone    string
+	speed           float64
+	maxWait         time.Duration
+	profile         string
+	printHelp       bool
+	printVersion    bool
+}
+
+// optionGroup registers a group of related options.
+type optionGroup func(flags *flag.FlagSet, opts *options)
+
+// Each subcommand takes only the groups of options it has a use for, so that
+// the options of one mode are rejected by another rather than ignored.
+var subcommands = []struct {
+	name        string
+	description string
+	options     []optionGroup
+}{
+	{"run", "run a command in a pty and timestamp its output", []optionGroup{
+		logFormatOptions, displayOptions, lineOptions, reportOptions, outputOptions, sinkOptions,
+		processOptions, commandOptions, retryOptions, keyOptions}},
+	{"pipe", "timestamp output piped into stdin", []optionGroup{
+		logFormatOptions, displayOptions, lineOptions, reportOptions, outputOptions, sinkOptions,
+		processOptions, inputOptions}},
+	{"docker", "run docker run or docker logs with suitable defaults", []optionGroup{
+		logFormatOptions, displayOptions, lineOptions, reportOptions, outputOptions, sinkOptions,
+		processOptions, commandOptions, retryOptions}},
+	{"ssh", "run a command on a remote host over ssh", []optionGroup{
+		logFormatOptions, displayOptions, lineOptions, reportOptions, outputOptions, sinkOptions,
+		processOptions, commandOptions, retryOptions, sshOptions}},
+	{"cron", "run a command on a schedule, logging and summarizing each run", []optionGroup{
+		logFormatOptions, displayOptions, lineOptions, reportOptions, outputOptions, sinkOptions,
+		processOptions, commandOptions, cronOptions}},
+	{"convert", "re-timestamp a log that already carries timestamps", []optionGroup{
+		logFormatOptions, displayOptions, lineOptions, reportOptions, outputOptions, sinkOptions,
+		convertOptions}},
+	{"replay", "write out a timestamped log with its original timing", []optionGroup{
+		logFormatOptions, replayOptions}},
+	{"diff", "compare the timing of two timestamped logs", []optionGroup{
+		logFormatOptions, diffOptions}},
+	{"tmux-pane", "timestamp the output of a tmux pane into a file", []optionGroup{
+		logFormatOptions, displayOptions, lineOptions, reportOptions, outputOptions, sinkOptions}},
+	{"completion", "print a shell completion script", nil},
+}
+
+func subcommandNames() []string {
+	names := make([]string, len(subcommands))
+	for i, sc := range subcommands {
+		names[i] = sc.name
+	}
+	return names
+}
+
+// subcommandOptions returns the option groups of the named subcommand. The
+// bare form, which acts as ets run or ets pipe depending on whether it is
+// given a command, takes the options of both.
+func subcommandOptions(name string) []optionGroup {
+	if name == "" {
+		return []optionGroup{
+			logFormatOptions, displayOptions, lineOptions, reportOptions, outputOptions, sinkOptions,
+			processOptions, commandOptions, retryOptions, keyOptions, inputOptions}
+	}
+	for _, sc := range subcommands {
+		if sc.name == name {
+			return sc.options
+		}
+	}
+	return nil
+}
+
+// isOption reports whether name is an option of any subcommand.
+func isOption(name string) bool {
+	if newFlagSet("", &options{}).Lookup(name) != nil {
+		return true
+	}
+	for _, sc := range subcommands {
+		if newFlagSet(sc.name, &options{}).Lookup(name) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// logFormatOptions select the timestamp mode, format, and timezone, whether
+// of the output or of the logs read.
+func logFormatOptions(flags *flag.FlagSet, opts *options) {
+	flags.BoolVarP(&opts.elapsedMode, "elapsed", "s", false, "show elapsed timestamps")
+	flags.BoolVarP(&opts.incrementalMode, "incremental", "i", false, "show incremental timestamps")
+	flags.StringVarP(&opts.format, "format", "f", "", "show timestamps in this format")
+	flags.BoolVarP(&opts.utc, "utc", "u", false, "show absolute timestamps in UTC")
+	flags.StringVarP(&opts.timezoneName, "timezone", "z", "", "show absolute timestamps in this timezone, e.g. America/New_York")
+}
+
+// displayOptions refine how timestamps are shown.
+func displayOptions(flags *flag.FlagSet, opts *options) {
+	flags.BoolVar(&opts.human, "human", false, "show elapsed timestamps with auto-scaled units, e.g. +2.3s, +45s, or +3m12s")
+	flags.StringVar(&opts.secondTimezone, "second-timezone", "", "also show absolute timestamps in this timezone, with directives qualified with tz2, e.g. %{tz2}T")
+	flags.BoolVarP(&opts.color, "color", "c", false, "show timestamps in color")
+	flags.DurationVar(&opts.round, "round", 0, "round displayed timestamps to this bucket, e.g. 1s or 100ms")
+	flags.DurationVar(&opts.truncate, "truncate", 0, "truncate displayed timestamps to this bucket, e.g. 1s or 100ms")
+	flags.BoolVar(&opts.dedupTimestamps, "dedup-ts", false, "blank timestamps identical to the previous line's")
+	flags.BoolVar(&opts.relativeDates, "relative-dates", false, "show the day of absolute timestamps as today, yesterday, or the date, and the full date when it changes")
+	flags.StringVar(&opts.elapsedFrom, "elapsed-from", "now", "measure elapsed timestamps from the start of ets (now), of the command (exec), or its first line of output (first-output)")
+	flags.StringVar(&opts.since, "since", "", "measure elapsed timestamps from this time, e.g. 2024-05-01T00:00:00Z, or this long ago, e.g. 90m")
+	flags.DurationVar(&opts.quietFast, "quiet-fast", 0, "with --incremental, blank the timestamps of lines following the previous one within this threshold, e.g. 100ms")
+	flags.BoolVar(&opts.adaptiveDelta, "adaptive-delta", false, "with --incremental, show short deltas compactly, e.g. +12ms, and long ones in full, e.g. +1m 03.2s")
+	flags.StringVar(&opts.markerPattern, "marker-pattern", "", "measure directives qualified with mark, e.g. %{mark}T, from the latest line matching this regexp")
+}
+
+// inputOptions read from elsewhere than stdin, in place of a command.
+func inputOptions(flags *flag.FlagSet, opts *options) {
+	flags.StringVar(&opts.serial, "serial", "", "read from this serial device instead of stdin, reconnecting when it reappears")
+	flags.IntVar(&opts.baud, "baud", 115200, "baud rate of the --serial device")
+	flags.StringVar(&opts.fifo, "fifo", "", "read from this FIFO, created if needed, instead of stdin, until interrupted")
+	flags.StringVar(&opts.listen, "listen", "", "accept connections on unix:path or tcp:host:port instead of reading stdin, until interrupted")
+}
+
+// lineOptions process, decorate, and check the lines of the output.
+func lineOptions(flags *flag.FlagSet, opts *options) {
+	flags.StringVar(&opts.parseTimestamps, "parse-timestamps", "", "take the time of each line from the timestamp it already carries in this format: jenkins, rfc3339, or kubectl")
+	flags.BoolVar(&opts.k8s, "k8s", false, "re-stamp kubectl logs --timestamps output, same as --parse-timestamps kubectl")
+	flags.StringVar(&opts.annotateFIFO, "annotate-fifo", "", "print each line written to this FIFO, created if needed, as a timestamped note")
+	flags.StringArrayVar(&opts.fds, "fd", nil, "also timestamp lines from this inherited file descriptor, given as N or N=label (repeatable)")
+	flags.StringVarP(&opts.label, "label", "l", "", "prefix every line with this label after the timestamp")
+	flags.StringVar(&opts.levels, "levels", "", "detect log levels and show them as color, tag, or color,tag, or only count them with none (default color)")
+	flags.Lookup("levels").NoOptDefVal = "color"
+	flags.StringArrayVar(&opts.levelPatterns, "level-pattern", nil, "detect lines matching regexp as level, given as level=regexp (repeatable)")
+	flags.StringArrayVar(&opts.when, "when", nil, "take the actions of the matching --then for lines meeting this condition, such as 'delta > 2s && line matches \"ERROR\"' (repeatable)")
+	flags.StringArrayVar(&opts.then, "then", nil, "actions for lines meeting the matching --when: color=NAME, mark, bell, annotate=TEXT, comma-separated (repeatable)")
+	flags.StringVar(&opts.script, "script", "", "call the on_start, on_line, and on_exit hooks of this Starlark script, which may drop, replace, or decorate lines")
+	flags.BoolVar(&opts.githubActions, "github-actions", false, "pass GitHub Actions workflow commands through and report the duration of log groups")
+	flags.StringVar(&opts.phasePattern, "phase-pattern", "", "start a new phase at lines matching this regexp, named by its first group or the match")
+	flags.BoolVar(&opts.teamcity, "teamcity", false, "emit TeamCity service messages delimiting phases and reporting their durations")
+	flags.BoolVar(&opts.buildkite, "buildkite", false, "mark the time of each line for the Buildkite UI")
+	flags.BoolVar(&opts.goTest, "go-test", false, "report the duration of tests in go test -json output to stderr on exit")
+	flags.BoolVar(&opts.tap, "tap", false, "keep TAP output valid, timestamping test points with comments and recording their durations")
+	flags.BoolVar(&opts.audit, "audit", false, "tag each line with a hash chaining it to the previous lines, and end with the head of the chain, so that edits are detectable")
+	flags.StringVar(&opts.filter, "filter", "", "pipe the output through this shell command, e.g. 'sed -u s/foo/bar/', before timestamping it")
+	flags.StringVar(&opts.sample, "sample", "", "print only k of every n lines, given as k/n such as 1/100, counting the rest")
+	flags.DurationVar(&opts.sampleEvery, "sample-every", 0, "print at most one line per interval, counting the rest")
+	flags.DurationVar(&opts.squashRepeats, "squash-repeats", 0, "suppress lines identical to one seen within this window, printing how many times they were seen instead")
+	flags.DurationVar(&opts.markEvery, "mark-every", 0, "print a separator line with the number of lines read at fixed intervals of the wall clock, e.g. 10m")
+	flags.DurationVar(&opts.maxGap, "max-gap", 0, "fail if any gap between lines exceeds this duration, e.g. 30s")
+	flags.IntVar(&opts.maxGapExit, "max-gap-exit", 1, "exit code when --max-gap is exceeded")
+	flags.DurationVar(&opts.markSlow, "mark-slow", 0, "mark lines following a gap longer than this duration, e.g. 5s")
+	flags.StringVar(&opts.markSlowStyle, "mark-slow-style", "line", "mark slow gaps with a marker line or by coloring the timestamp: line or color")
+}
+
+// reportOptions report on the run when it ends, or while it is in progress.
+func reportOptions(flags *flag.FlagSet, opts *options) {
+	flags.BoolVar(&opts.summary, "summary", false, "print a summary to stderr on exit")
+	flags.StringVar(&opts.summaryJSON, "summary-json", "", "write the summary as JSON to this file on exit")
+	flags.BoolVar(&opts.notify, "notify", false, "show a desktop notification with the exit status and duration on completion")
+	flags.StringArrayVar(&opts.mailTo, "mail-to", nil, "mail the summary to this address when the run ends (repeatable)")
+	flags.StringVar(&opts.mailOn, "mail-on", "always", "with --mail-to, mail on failure or always")
+	flags.IntVar(&opts.mailLines, "mail-lines", 0, "with --mail-to, include the last this many lines of output")
+	flags.StringVar(&opts.mailFrom, "mail-from", "", "with --mail-to, the sender address (default ets@hostname)")
+	flags.StringVar(&opts.smtpServer, "smtp-server", "", "with --mail-to, the SMTP server as host:port (default $SMTP_SERVER, or localhost:25)")
+	flags.StringVar(&opts.smtpUser, "smtp-user", "", "with --mail-to, the user to authenticate to the SMTP server as (default $SMTP_USER)")
+	flags.StringVar(&opts.smtpPassword, "smtp-password", "", "with --mail-to, the password of --smtp-user (default $SMTP_PASSWORD)")
+	flags.StringVar(&opts.webhook, "webhook", "", "post the summary as JSON, with the location of the log, to this URL when the run ends")
+	flags.StringVar(&opts.slackWebhook, "slack-webhook", "", "post a message with the exit status, duration, and slowest phases to this Slack incoming webhook URL when the run ends")
+	flags.StringVar(&opts.discordWebhook, "discord-webhook", "", "post a message with the exit status, duration, and slowest phases to this Discord webhook URL when the run ends")
+	flags.StringVar(&opts.alertWebhook, "alert-webhook", "", "post an alert as JSON to this URL while the run is in progress whenever the --alert-on condition starts to hold")
+	flags.StringVar(&opts.alertOn, "alert-on", "", "with --alert-webhook, the condition on gap, duration, and lines to alert on, e.g. 'gap > 5m || duration > 2h'")
+	flags.StringVar(&opts.junitOut, "junit-out", "", "write phases as JUnit test cases with their durations to this file on exit")
+	flags.StringVar(&opts.foldedOut, "folded-out", "", "write phases as folded stacks for flame graphs to this file on exit")
+	flags.StringArrayVar(&opts.subphasePattern, "subphase-pattern", nil, "with --folded-out, start a sub-phase nested one level deeper than the previous pattern at lines matching this regexp (repeatable)")
+	flags.StringVar(&opts.phasesJSON, "phases-json", "", "write the name, start and end, duration, and line count of each phase as JSON to this file on exit")
+	flags.StringVar(&opts.splitByPhase, "split-by-phase", "", "also write the lines of each phase to a file of its own in this directory")
+	flags.StringVar(&opts.baseline, "baseline", "", "compare the durations of phases with this JSON baseline on exit, failing on regressions")
+	flags.StringVar(&opts.regressionLimit, "regression-threshold", "20%", "with --baseline, fail if a phase is slower by at least this percentage")
+	flags.BoolVar(&opts.updateBaseline, "update-baseline", false, "with --baseline, write the durations of this run to the baseline if the command succeeds")
+	flags.DurationVar(&opts.diffMinChange, "min-change", time.Second, "with ets diff and --baseline, report changes in timing by at least this duration")
+	flags.BoolVar(&opts.histogram, "histogram", false, "print a histogram of the gaps between lines to stderr on exit")
+	flags.StringVar(&opts.sparkline, "sparkline", "", "add a sparkline of the output rate, or of gaps, over the run to the summary: rate or gaps")
+	flags.Lookup("sparkline").NoOptDefVal = "rate"
+	flags.DurationVar(&opts.activityReport, "activity-report", 0, "print a table of lines and bytes of output per minute, or per the given bucket, to stderr on exit")
+	flags.Lookup("activity-report").NoOptDefVal = "1m"
+	flags.StringVar(&opts.checksum, "checksum", "", "print a digest of the raw output of the command in the summary: md5, sha1, sha256, or sha512")
+	flags.StringVar(&opts.sign, "sign", "", "sign the --tee or --output-file file with this Ed25519 private key on exit, writing file.sig, or else sign the head of the --audit chain")
+	flags.StringVar(&opts.upload, "upload", "", "upload the --tee or --output-file file with a manifest to s3://bucket/prefix/ or gs://bucket/prefix/ when the run ends")
+}
+
+// outputOptions control where and when the timestamped output is written.
+func outputOptions(flags *flag.FlagSet, opts *options) {
+	flags.BoolVar(&opts.bell, "bell", false, "ring the terminal bell on completion")
+	flags.BoolVar(&opts.setTitle, "set-title", false, "keep the terminal title updated with the elapsed time and the final status")
+	flags.BoolVarP(&opts.quiet, "quiet", "q", false, "discard the timestamped output and only print the summary")
+	flags.StringVarP(&opts.outputFile, "output-file", "o", "", "append the timestamped output to this file instead of writing it to stdout")
+	flags.BoolVar(&opts.daemon, "daemon", false, "detach from the terminal, appending output and messages to --output-file, and print the pid")
+	flags.StringVar(&opts.splitSize, "split-size", "", "with --output-file, start a new numbered file once the current one would exceed this size, e.g. 100M")
+	flags.DurationVar(&opts.splitInterval, "split-interval", 0, "with --output-file, start a new numbered file once the current one has been written to for this long, e.g. 1h")
+	flags.StringVar(&opts.tee, "tee", "", "also write the timestamped output to this file")
+	flags.StringVar(&opts.encrypt, "encrypt", "", "encrypt the --tee file in the age format to the recipients listed in this file")
+	flags.StringVar(&opts.pager, "pager", "never", "show the output in $PAGER once the stream ends: always, auto (ets pipe or convert with a terminal), or never")
+	flags.Lookup("pager").NoOptDefVal = "always"
+	flags.BoolVar(&opts.statusBar, "status-bar", false, "show a status bar at the bottom of the terminal")
+	flags.IntVar(&opts.pauseBuffer, "pause-buffer", 10000, "hold back up to this many lines while the display is paused")
+	flags.StringVar(&opts.tailBuffer, "tail-buffer", "", "keep only the last this many lines, or bytes with a K, M, G, or T suffix, of the output, written out on exit or SIGUSR1")
+	flags.DurationVar(&opts.keepAlive, "keepalive", 0, "with --only-on-failure or --tail-buffer, print that the command is still running at this interval, e.g. 5m")
+	flags.DurationVar(&opts.flushInterval, "flush-interval", 0, "buffer output to files, pipes, and batched network sinks, flushing it at this interval")
+	flags.IntVar(&opts.batchLines, "batch-lines", 0, "write output to files and pipes, and send it to batched network sinks, in batches of this many lines")
+	flags.IntVar(&opts.batchBytes, "batch-bytes", 0, "write output to files and pipes, and send it to batched network sinks, in batches of this many bytes")
+}
+
+// sinkOptions also send every line to a network service.
+func sinkOptions(flags *flag.FlagSet, opts *options) {
+	flags.StringVar(&opts.syslog, "syslog", "", "also send each line as an RFC 5424 message with timing fields to the syslog server at udp:host:port, tcp:host:port, or unix:path")
+	flags.StringVar(&opts.gelf, "gelf", "", "also send each line as a GELF message with timing fields to Graylog at udp://host:port or tcp://host:port")
+	flags.StringVar(&opts.nats, "nats", "", "also publish each line with its timing as JSON to the NATS server at nats://host:port")
+	flags.StringVar(&opts.natsSubject, "nats-subject", "ets", "with --nats, the subject to publish to")
+	flags.BoolVar(&opts.natsJetStream, "nats-jetstream", false, "with --nats, wait for JetStream to acknowledge each message")
+	flags.StringVar(&opts.elastic, "elastic", "", "also index each line with its timing in Elasticsearch at http://host:9200/index")
+	flags.StringVar(&opts.splunkHEC, "splunk-hec", "", "also send each line with its timing to the Splunk HTTP Event Collector at https://host:8088")
+	flags.StringVar(&opts.splunkToken, "splunk-token", "", "with --splunk-hec, the token of the collector (default $SPLUNK_HEC_TOKEN)")
+	flags.StringVar(&opts.splunkType, "splunk-sourcetype", "ets", "with --splunk-hec, the sourcetype of the events")
+	flags.StringArrayVar(&opts.sinks, "sink", nil, "also send each line to the sink given as name=argument: file=path, metrics=path, syslog, gelf, elastic, splunk-hec, nats, or one built in by a third party (repeatable)")
+	flags.StringArrayVar(&opts.sinkFormats, "sink-format", nil, "print lines and annotations sent to a --sink with their own timestamp format, given as name=format (repeatable)")
+}
+
+// processOptions tie the run of ets to other processes.
+func processOptions(flags *flag.FlagSet, opts *options) {
+	flags.BoolVar(&opts.sdNotify, "sd-notify", false, "report readiness, liveness by output activity, and shutdown to systemd via $NOTIFY_SOCKET")
+	flags.StringVar(&opts.readyPattern, "ready-pattern", "", "with --sd-notify, report readiness at the first line matching this regexp rather than on start")
+	flags.StringVar(&opts.lock, "lock", "", "run only while holding an exclusive lock on this file, so one instance runs at a time")
+	flags.StringVar(&opts.lockMode, "lock-mode", "wait", "when the --lock is held: wait, fail, or steal (terminate the holder, then wait)")
+	flags.StringVar(&opts.etsPidfile, "ets-pidfile", "", "write the pid of ets itself to this file while it runs")
+}
+
+// commandOptions apply to the command ets runs.
+func commandOptions(flags *flag.FlagSet, opts *options) {
+	flags.BoolVar(&opts.timeVerbose, "time-verbose", false, "print a resource usage report formatted like GNU time -v to stderr on exit")
+	flags.DurationVar(&opts.sampleResources, "sample-resources", 0, "annotate the output with the command's CPU and memory usage at this interval (Linux only)")
+	flags.StringVar(&opts.pidfile, "pidfile", "", "write the pid of the command to this file while it runs")
+}
+
+// retryOptions decide what becomes of a command once it exits.
+func retryOptions(flags *flag.FlagSet, opts *options) {
+	flags.BoolVar(&opts.onlyOnFailure, "only-on-failure", false, "hold back the timestamped output, and write it out on exit only if the command fails")
+	flags.BoolVar(&opts.untilSuccess, "until-success", false, "run the command again until it exits zero")
+	flags.IntVar(&opts.maxAttempts, "max-attempts", 0, "with --until-success, give up after this many attempts (default unlimited)")
+	flags.DurationVar(&opts.retryDelay, "retry-delay", time.Second, "with --until-success, wait this long between attempts")
+}
+
+// keyOptions control the keys typed to a command in a pty.
+func keyOptions(flags *flag.FlagSet, opts *options) {
+	flags.BoolVar(&opts.noKeys, "no-keys", false, "forward all input to the command, without handling Ctrl-] key bindings")
+}
+
+// sshOptions apply to ets ssh.
+func sshOptions(flags *flag.FlagSet, opts *options) {
+	flags.BoolVar(&opts.remoteTime, "remote-time", false, "with ets ssh, show absolute timestamps by the clock of the remote host")
+	flags.StringVar(&opts.hostsFile, "hosts", "", "with ets ssh, run the command on every host listed in this file in parallel")
+}
+
+// cronOptions apply to ets cron.
+func cronOptions(flags *flag.FlagSet, opts *options) {
+	flags.IntVar(&opts.keep, "keep", 10, "with ets cron and --output-file, keep the logs of this many previous runs")
+}
+
+// diffOptions apply to ets diff.
+func diffOptions(flags *flag.FlagSet, opts *options) {
+	flags.BoolVarP(&opts.color, "color", "c", false, "show timestamps in color")
+	flags.StringVar(&opts.phasePattern, "phase-pattern", "", "start a new phase at lines matching this regexp, named by its first group or the match")
+	flags.Float64Var(&opts.diffThreshold, "threshold", 10, "with ets diff, report changes in timing by at least this percentage")
+	flags.DurationVar(&opts.diffMinChange, "min-change", time.Second, "with ets diff and --baseline, report changes in timing by at least this duration")
+}
+
+// convertOptions apply to ets convert.
+func convertOptions(flags *flag.FlagSet, opts *options) {
+	flags.StringVar(&opts.fromFormat, "from-format", "", "read the timestamps of the log in this format, which must include the date, or a format alias")
+	flags.StringVar(&opts.fromTimezone, "from-timezone", "", "read the timestamps of the log in this timezone rather than the local one, unless they carry an offset")
+}
+
+// replayOptions apply to ets replay.
+func replayOptions(flags *flag.FlagSet, opts *options) {
+	flags.Float64Var(&opts.speed, "speed", 1, "replay the log this many times faster than it was recorded")
+	flags.DurationVar(&opts.maxWait, "max-wait", 0, "wait at most this long between lines, e.g. 5s")
+}
+
+func newFlagSet(name string, opts *options) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	for _, group := range subcommandOptions(name) {
+		group(flags, opts)
+	}
+	flags.StringVar(&opts.profile, "profile", "", "apply settings from this profile in the config file")
+	flags.BoolVarP(&opts.printHelp, "help", "h", false, "print help and exit")
+	flags.BoolVarP(&opts.printVersion, "version", "v", false, "print version and exit")
+	flags.SortFlags = false
+	flags.SetInterspersed(false)
+	flags.Usage = func() {
+		switch name {
+		case "run":
+			fmt.Fprintf(os.Stderr, `
+Usage:
+
+  %[1]s run [-s | -i] [-f format] [-u | -z timezone] command [arg ...]
+  %[1]s run [options] shell_command
+
+Options:
+`, os.Args[0])
+		case "pipe":
+			fmt.Fprintf(os.Stderr, `
+Usage:
+
+  %[1]s pipe [options]
+
+Options:
+`, os.Args[0])
+		case "ssh":
+			fmt.Fprintf(os.Stderr, `
+Usage:
+
+  %[1]s ssh [options] [user@]host [--] command [arg ...]
+  %[1]s ssh [options] --hosts file [--] command [arg ...]
+
+Options:
+`, os.Args[0])
+		case "cron":
+			fmt.Fprintf(os.Stderr, `
+Usage:
+
+  %[1]s cron [options] schedule [--] command [arg ...]
+
+Options:
+`, os.Args[0])
+		case "convert":
+			fmt.Fprintf(os.Stderr, `
+Usage:
+
+  %[1]s convert --from-format format [options] [log ...]
+  %[1]s convert --parse-timestamps jenkins|rfc3339|kubectl [options] [log ...]
+
+Options:
+`, os.Args[0])
+		case "replay":
+			fmt.Fprintf(os.Stderr, `
+Usage:
+
+  %[1]s replay [-s | -i] [-f format] [-u | -z timezone] [--speed factor] [log]
 
-	var elapsedMode = flag.BoolP("elapsed", "s", false, "show elapsed timestamps")
-	var incrementalMode = flag.BoolP("incremental", "i", false, "show incremental timestamps")
-	var format = flag.StringP("format", "f", "", "show timestamps in this format")
-	var utc = flag.BoolP("utc", "u", false, "show absolute timestamps in UTC")
-	var timezoneName = flag.StringP("timezone", "z", "", "show absolute timestamps in this timezone, e.g. America/New_York")
-	var color = flag.BoolP("color", "c", false, "show timestamps in color")
-	var printHelp = flag.BoolP("help", "h", false, "print help and exit")
-	var printVersion = flag.BoolP("version", "v", false, "print version and exit")
-	flag.CommandLine.SortFlags = false
-	flag.SetInterspersed(false)
-	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, `
+Options:
+`, os.Args[0])
+		case "diff":
+			fmt.Fprintf(os.Stderr, `
+Usage:
+
+  %[1]s diff [options] old_log new_log
+
+Options:
+`, os.Args[0])
+		case "tmux-pane":
+			fmt.Fprintf(os.Stderr, `
+Usage:
+
+  %[1]s tmux-pane [options] [target_pane]
+
+Options:
+`, os.Args[0])
+		case "docker":
+			fmt.Fprintf(os.Stderr, `
+Usage:
+
+  %[1]s docker [options] run [docker_option ...] image [arg ...]
+  %[1]s docker [options] logs [docker_option ...] container
+
+Options:
+`, os.Args[0])
+		default:
+			fmt.Fprintf(os.Stderr, `
 ets -- command output timestamper
 
 ets prefixes each line of a command's output with a timestamp. Lines are
@@ -145,11 +673,32 @@ delimited by CR, LF, or CRLF.
 
 Usage:
 
-  %s [-s | -i] [-f format] [-u | -z timezone] command [arg ...]
-  %s [options] shell_command
-  %s [options]
+  %[1]s [run] [-s | -i] [-f format] [-u | -z timezone] command [arg ...]
+  %[1]s [run] [options] shell_command
+  %[1]s [pipe] [options]
+  %[1]s docker [options] run|logs docker_arg ...
+  %[1]s ssh [options] [user@]host [--] command [arg ...]
+  %[1]s cron [options] schedule [--] command [arg ...]
+  %[1]s convert [options] [log ...]
+  %[1]s replay [options] [log]
+  %[1]s diff [options] old_log new_log
+  %[1]s tmux-pane [options] [target_pane]
+  %[1]s completion bash|zsh|fish|powershell
+
+Subcommands:
 
-The three usage strings correspond to three command execution modes:
+`, os.Args[0])
+			for _, sc := range subcommands {
+				fmt.Fprintf(os.Stderr, "  %-12s%s\n", sc.name, sc.description)
+			}
+			fmt.Fprint(os.Stderr, `
+The subcommand may be omitted: with a command, ets acts as ets run; without
+one, as ets pipe. To run a command that shares its name with a subcommand,
+spell out ets run. Each subcommand takes only the options that apply to it;
+ets SUBCOMMAND -h lists them. The options below are those of ets run and ets
+pipe.
+
+The run and pipe subcommands correspond to three command execution modes:
 
 * If given a single command without whitespace(s), or a command and its
   arguments, execute the command with exec in a pty;
@@ -181,84 +730,1053 @@ The timezone for absolute timestamps can be controlled via the -u, --utc
 and -z, --timezone options. --timezone accepts IANA time zone names, e.g.,
 America/Los_Angeles. Local time is used by default.
 
+Options may also be set in the config file; see the man page for it and for
+the details of the options below.
+
 Options:
-`, os.Args[0], os.Args[0], os.Args[0])
-		flag.PrintDefaults()
+`)
+		}
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+func main() {
+	log.SetFlags(log.Flags() &^ (log.Ldate | log.Ltime))
+
+	args := os.Args[1:]
+	subcommand := ""
+	if len(args) > 0 {
+		for _, sc := range subcommands {
+			if args[0] == sc.name {
+				subcommand = sc.name
+				args = args[1:]
+				break
+			}
+		}
 	}
-	flag.Parse()
 
-	if *printHelp {
-		flag.Usage()
+	if subcommand == "completion" {
+		if len(args) == 2 && args[0] == "values" {
+			if err := writeCompletionValues(os.Stdout, args[1]); err != nil {
+				fatal(err)
+			}
+			os.Exit(0)
+		}
+		if len(args) != 1 {
+			fatalf("usage: %s completion %s", os.Args[0], strings.Join(completionShells, "|"))
+		}
+		if err := writeCompletion(os.Stdout, args[0], newFlagSet("", &options{}), subcommandNames()); err != nil {
+			fatal(err)
+		}
 		os.Exit(0)
 	}
 
-	if *printVersion {
+	opts := &options{}
+	flags := newFlagSet(subcommand, opts)
+	_ = flags.Parse(args)
+
+	if opts.printHelp {
+		flags.Usage()
+		os.Exit(0)
+	}
+
+	if opts.printVersion {
 		fmt.Println(version)
 		os.Exit(0)
 	}
 
-	mode := AbsoluteTimeMode
-	if *elapsedMode && *incrementalMode {
-		log.Fatal("conflicting flags --elapsed and --incremental")
+	if subcommand == "" {
+		// Which of ets run and ets pipe the bare form stands for is only known
+		// once the command, if any, is found past the options; parse them
+		// again as those of that subcommand.
+		subcommand = "pipe"
+		if flags.NArg() > 0 {
+			subcommand = "run"
+		}
+		resolved := newFlagSet(subcommand, &options{})
+		flags.Visit(func(f *flag.Flag) {
+			if resolved.Lookup(f.Name) != nil {
+				return
+			}
+			if subcommand == "pipe" {
+				fatalf("--%s requires a command", f.Name)
+			}
+			fatalf("--%s does not take a command", f.Name)
+		})
+		opts = &options{}
+		flags = newFlagSet(subcommand, opts)
+		_ = flags.Parse(args)
 	}
-	if *elapsedMode {
-		mode = ElapsedTimeMode
+
+	if err := applyConfig(flags, configPath(), opts.profile); err != nil {
+		fatal(err)
 	}
-	if *incrementalMode {
-		mode = IncrementalTimeMode
+
+	if subcommand == "diff" {
+		if len(flags.Args()) != 2 {
+			fatalf("usage: %s diff [options] old_log new_log", os.Args[0])
+		}
+		mode, format, timezone := timestampSettings(opts)
+		parser, err := NewFormatParser(format, timezone)
+		if err != nil {
+			fatal(err)
+		}
+		var phasePattern *regexp.Regexp
+		if opts.phasePattern != "" {
+			if phasePattern, err = regexp.Compile(opts.phasePattern); err != nil {
+				fatalf("invalid phase pattern: %s", err)
+			}
+		}
+		regressed, err := runDiff(os.Stdout, flags.Arg(0), flags.Arg(1), parser, mode, phasePattern,
+			opts.diffThreshold, opts.diffMinChange, opts.color)
+		if err != nil {
+			fatal(err)
+		}
+		if regressed {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if subcommand == "tmux-pane" {
+		optionArgs := withoutTeeOption(args[:len(args)-len(flags.Args())])
+		target := ""
+		switch len(flags.Args()) {
+		case 0:
+		case 1:
+			target = flags.Arg(0)
+		default:
+			fatalf("usage: %s tmux-pane [options] [target_pane]", os.Args[0])
+		}
+		message, err := toggleTmuxPane(target, optionArgs, opts.tee)
+		if err != nil {
+			fatal(err)
+		}
+		log.Print(message)
+		os.Exit(0)
+	}
+
+	if subcommand == "replay" {
+		if len(flags.Args()) > 1 {
+			fatalf("usage: %s replay [options] [log]", os.Args[0])
+		}
+		if opts.speed <= 0 {
+			fatalf("invalid --speed %g: expected a positive factor", opts.speed)
+		}
+		if opts.maxWait < 0 {
+			fatalf("invalid --max-wait %s: expected a positive duration", opts.maxWait)
+		}
+		mode, format, timezone := timestampSettings(opts)
+		parser, err := NewFormatParser(format, timezone)
+		if err != nil {
+			fatal(err)
+		}
+		if err := replayLog(os.Stdout, flags.Arg(0), parser, mode, opts.speed, opts.maxWait); err != nil {
+			fatal(err)
+		}
+		os.Exit(0)
+	}
+
+	args = flags.Args()
+	if subcommand == "pipe" && len(args) > 0 {
+		fatalf("%s pipe does not take a command; use %s run instead", os.Args[0], os.Args[0])
+	}
+	if subcommand == "run" && len(args) == 0 {
+		fatalf("%s run requires a command", os.Args[0])
+	}
+	var logs []string
+	if subcommand == "convert" {
+		// The arguments are the logs to convert, not a command.
+		logs, args = args, nil
+	}
+	var schedule *CronSchedule
+	if subcommand == "cron" {
+		if len(args) > 1 && args[1] == "--" {
+			args = append(args[:1], args[2:]...)
+		}
+		if len(args) < 2 {
+			fatalf("usage: %s cron [options] schedule [--] command [arg ...]", os.Args[0])
+		}
+		var err error
+		if schedule, err = ParseCronSchedule(args[0]); err != nil {
+			fatal(err)
+		}
+		args = args[1:]
+	}
+	regressionThreshold, err := parsePercentage(opts.regressionLimit)
+	if err != nil {
+		fatalf("invalid --regression-threshold: %s", err)
+	}
+	if opts.sparkline != "" && opts.sparkline != "rate" && opts.sparkline != "gaps" {
+		fatalf("invalid --sparkline %q: expected rate or gaps", opts.sparkline)
+	}
+	if opts.audit && opts.tap {
+		fatal("--audit cannot be used with --tap")
+	}
+	if opts.sign != "" && opts.tee == "" && opts.outputFile == "" && !opts.audit {
+		fatal("--sign requires --tee, --output-file, or --audit")
+	}
+	var recipients []age.Recipient
+	if opts.encrypt != "" {
+		if opts.tee == "" {
+			fatal("--encrypt requires --tee")
+		}
+		if recipients, err = readRecipients(opts.encrypt); err != nil {
+			fatal(err)
+		}
+	}
+	if opts.upload != "" {
+		if opts.tee == "" && opts.outputFile == "" {
+			fatal("--upload requires --tee or --output-file")
+		}
+		if err := checkUploadDestination(opts.upload); err != nil {
+			fatal(err)
+		}
+	}
+	var splitSize int64
+	if opts.splitSize != "" {
+		if splitSize, err = parseSize(opts.splitSize); err != nil {
+			fatalf("invalid --split-size %q: %s", opts.splitSize, err)
+		}
+	}
+	if opts.splitInterval < 0 {
+		fatalf("invalid --split-interval %s: expected a positive interval", opts.splitInterval)
+	}
+	if splitSize > 0 || opts.splitInterval > 0 {
+		switch {
+		case opts.outputFile == "":
+			fatal("--split-size and --split-interval require --output-file")
+		case subcommand == "cron" || opts.daemon:
+			fatal("--split-size and --split-interval cannot be used with ets cron or --daemon")
+		case opts.tee == "" && (opts.sign != "" || opts.upload != ""):
+			fatal("--split-size and --split-interval cannot be used with --sign or --upload of the output file")
+		}
+	}
+	var tailLines, tailBytes int
+	if opts.tailBuffer != "" {
+		if tailLines, tailBytes, err = parseTailBufferSize(opts.tailBuffer); err != nil {
+			fatalf("invalid --tail-buffer %q: %s", opts.tailBuffer, err)
+		}
+		switch {
+		case opts.quiet:
+			fatal("conflicting flags --quiet and --tail-buffer")
+		case subcommand == "cron" || splitSize > 0 || opts.splitInterval > 0:
+			fatal("--tail-buffer cannot be used with ets cron, --split-size, or --split-interval")
+		}
+	}
+	if opts.onlyOnFailure && opts.quiet {
+		fatal("conflicting flags --quiet and --only-on-failure")
+	}
+	if opts.keepAlive < 0 {
+		fatalf("invalid --keepalive %s: expected a positive interval", opts.keepAlive)
+	}
+	if opts.keepAlive > 0 && !opts.onlyOnFailure && opts.tailBuffer == "" {
+		fatal("--keepalive requires --only-on-failure or --tail-buffer")
+	}
+	if opts.mailOn != "failure" && opts.mailOn != "always" {
+		fatalf("invalid --mail-on %q: expected failure or always", opts.mailOn)
+	}
+	if opts.mailLines < 0 {
+		fatalf("invalid --mail-lines %d: expected a positive number", opts.mailLines)
+	}
+	var alert *Alert
+	if (opts.alertWebhook == "") != (opts.alertOn == "") {
+		fatal("--alert-webhook and --alert-on must be given together")
+	} else if opts.alertWebhook != "" {
+		if alert, err = NewAlert(opts.alertWebhook, opts.alertOn); err != nil {
+			fatal(err)
+		}
+	}
+	if opts.sample != "" && opts.sampleEvery != 0 {
+		fatal("--sample and --sample-every are mutually exclusive")
+	}
+	if opts.batchLines < 0 || opts.batchBytes < 0 {
+		fatal("--batch-lines and --batch-bytes must be positive")
+	}
+	if opts.squashRepeats < 0 {
+		fatalf("invalid --squash-repeats %s: expected a positive window", opts.squashRepeats)
+	}
+	if opts.markEvery < 0 {
+		fatalf("invalid --mark-every %s: expected a positive interval", opts.markEvery)
+	}
+	if opts.sampleEvery < 0 {
+		fatalf("invalid --sample-every %s: expected a positive interval", opts.sampleEvery)
+	}
+	if opts.activityReport < 0 {
+		fatalf("invalid --activity-report %s: expected a positive bucket", opts.activityReport)
+	}
+	if len(opts.subphasePattern) > 0 && opts.foldedOut == "" {
+		fatal("--subphase-pattern requires --folded-out")
+	}
+	if opts.phasesJSON != "" && opts.phasePattern == "" && !opts.githubActions {
+		fatal("--phases-json requires --phase-pattern or --github-actions")
+	}
+	if opts.splitByPhase != "" && opts.phasePattern == "" && !opts.githubActions {
+		fatal("--split-by-phase requires --phase-pattern or --github-actions")
+	}
+	if opts.updateBaseline && opts.baseline == "" {
+		fatal("--update-baseline requires --baseline")
+	}
+	var reference *baseline
+	if opts.baseline != "" {
+		if reference, err = readBaseline(opts.baseline); err != nil {
+			fatal(err)
+		}
+	}
+	if opts.untilSuccess && opts.hostsFile != "" {
+		fatal("--until-success is not supported with --hosts")
+	}
+	inputs := 0
+	for _, input := range []string{opts.serial, opts.fifo, opts.listen} {
+		if input != "" {
+			inputs++
+		}
+	}
+	if inputs > 1 {
+		fatal("conflicting flags among --serial, --fifo, and --listen")
+	}
+	if subcommand == "docker" {
+		var err error
+		if args, err = dockerCommand(args, flags, opts); err != nil {
+			fatal(err)
+		}
 	}
-	if *format == "" {
-		if mode == AbsoluteTimeMode {
-			*format = "[%F %T]"
+	sshHost := ""
+	var sshHosts []string
+	if subcommand == "ssh" {
+		var err error
+		if opts.hostsFile != "" {
+			if opts.remoteTime {
+				fatal("--remote-time is not supported with --hosts")
+			}
+			if opts.sampleResources > 0 {
+				fatal("--sample-resources is not supported with --hosts")
+			}
+			if opts.pidfile != "" {
+				fatal("--pidfile is not supported with --hosts")
+			}
+			if sshHosts, err = readHostsFile(opts.hostsFile); err != nil {
+				fatal(err)
+			}
+			args, err = sshRemoteCommand(args)
 		} else {
-			*format = "[%T]"
+			sshHost, args, err = sshCommand(args)
+		}
+		if err != nil {
+			fatal(err)
 		}
 	}
-	timezone := time.Local
-	if *utc && *timezoneName != "" {
-		log.Fatal("conflicting flags --utc and --timezone")
+
+	if opts.daemon {
+		if opts.outputFile == "" {
+			fatal("--daemon requires --output-file")
+		}
+		if subcommand == "pipe" && inputs == 0 {
+			fatal("--daemon requires a command, or --serial, --fifo, or --listen")
+		}
+		if !isDaemon() {
+			pid, err := daemonize(opts.outputFile)
+			if err != nil {
+				fatal(err)
+			}
+			fmt.Println(pid)
+			os.Exit(0)
+		}
 	}
-	if *utc {
-		timezone = time.UTC
+
+	mode, format, timezone := timestampSettings(opts)
+	if opts.color {
+		format = "\x1b[32m" + format + "\x1b[0m"
+	}
+
+	timestamper, err := NewTimestamper(format, mode, timezone)
+	if err != nil {
+		fatal(err)
+	}
+	if opts.secondTimezone != "" {
+		if timestamper.SecondTZ, err = time.LoadLocation(opts.secondTimezone); err != nil {
+			fatal(err)
+		}
+		if !timestamper.Uses(secondTimezoneQualifier) {
+			fatal("--second-timezone requires %{tz2} directives in the format")
+		}
+	} else if timestamper.Uses(secondTimezoneQualifier) {
+		fatal("%{tz2} directives require --second-timezone")
+	}
+	switch opts.elapsedFrom {
+	case "now", "exec", "first-output":
+	default:
+		fatalf("invalid --elapsed-from %q: expected %s", opts.elapsedFrom, strings.Join(elapsedFromModes, ", "))
+	}
+	if opts.since != "" {
+		if mode != ElapsedTimeMode {
+			fatal("--since requires --elapsed")
+		}
+		if opts.elapsedFrom != "now" {
+			fatal("--since and --elapsed-from are mutually exclusive")
+		}
+		if timestamper.Since, err = parseSince(opts.since, timestamper.StartTimestamp, timezone); err != nil {
+			fatal(err)
+		}
 	}
-	if *timezoneName != "" {
-		location, err := time.LoadLocation(*timezoneName)
+	if opts.relativeDates && mode != AbsoluteTimeMode {
+		fatal("--relative-dates requires absolute time mode")
+	}
+	if opts.quietFast != 0 && mode != IncrementalTimeMode {
+		fatal("--quiet-fast requires --incremental")
+	}
+	if opts.adaptiveDelta && mode != IncrementalTimeMode {
+		fatal("--adaptive-delta requires --incremental")
+	}
+	if opts.round != 0 && opts.truncate != 0 {
+		fatal("--round and --truncate are mutually exclusive")
+	}
+	if opts.round < 0 || opts.truncate < 0 {
+		fatal("--round and --truncate require a positive bucket")
+	}
+	if opts.truncate > 0 {
+		timestamper.Round, timestamper.Truncate = opts.truncate, true
+	} else {
+		timestamper.Round = opts.round
+	}
+	if opts.remoteTime {
+		if timestamper.Offset, err = measureSSHClockOffset(sshHost); err != nil {
+			fatal(err)
+		}
+	}
+
+	// With --flush-interval or batches, output not going to a terminal is
+	// buffered.
+	batchLimits := BatchLimits{Lines: opts.batchLines, Bytes: opts.batchBytes, Interval: opts.flushInterval}
+	if opts.batchLines > 0 || opts.batchBytes > 0 {
+		batchLimits.Stats = &BatchStats{}
+	}
+	var flushers []*flushingWriter
+	buffered := func(w io.Writer) io.Writer {
+		if opts.flushInterval <= 0 && opts.batchLines <= 0 && opts.batchBytes <= 0 {
+			return w
+		}
+		f := newFlushingWriter(w, batchLimits.withDefaults(0, flushBufferSize, time.Second))
+		flushers = append(flushers, f)
+		return f
+	}
+	var out io.Writer = os.Stdout
+	var encrypted io.WriteCloser
+	var cronOutput *rotatingFile
+	var outputFile *os.File
+	if subcommand == "cron" && opts.outputFile != "" {
+		if cronOutput, err = newRotatingFile(opts.outputFile, opts.keep); err != nil {
+			fatal(err)
+		}
+		out = cronOutput
+	} else if splitSize > 0 || opts.splitInterval > 0 {
+		if out, err = newChunkedFile(opts.outputFile, splitSize, opts.splitInterval); err != nil {
+			fatal(err)
+		}
+		out = buffered(out)
+	} else if opts.outputFile != "" && !opts.daemon {
+		// A daemon's stdout is the output file already.
+		if outputFile, err = os.OpenFile(opts.outputFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644); err != nil {
+			fatal(err)
+		}
+		out = buffered(outputFile)
+	} else if _, err := pty.GetsizeFull(os.Stdout); err != nil {
+		out = buffered(out)
+	}
+	page, err := shouldPage(opts.pager, subcommand)
+	if err != nil {
+		fatal(err)
+	}
+	// With --quiet there is nothing to page; the summary goes to stderr.
+	page = page && !opts.quiet
+	var capture *os.File
+	if page {
+		if capture, err = pagerCapture(); err != nil {
+			fatal(err)
+		}
+		out = capture
+	}
+	var tail *tailBuffer
+	if opts.tailBuffer != "" || opts.onlyOnFailure {
+		tail = &tailBuffer{maxLines: tailLines, maxBytes: tailBytes, out: out}
+		if opts.tailBuffer != "" {
+			// The output file, if any, is rewritten with the tail rather
+			// than appended to, to stay bounded.
+			tail.file = outputFile
+			if opts.daemon {
+				// A daemon's stdout is the output file.
+				tail.file = os.Stdout
+			}
+		}
+		out = tail
+	}
+	if opts.sparkline != "" || opts.checksum != "" {
+		opts.summary = true
+	}
+	if opts.quiet {
+		out = ioutil.Discard
+		if !opts.timeVerbose {
+			opts.summary = true
+		}
+	}
+	if opts.tee != "" {
+		teeFile, err := os.Create(opts.tee)
 		if err != nil {
-			log.Fatal(err)
+			fatal(err)
 		}
-		timezone = location
+		var tee io.Writer = teeFile
+		if recipients != nil {
+			// Encrypted chunk by chunk as the output goes, so that nothing
+			// reaches the file in the clear.
+			if encrypted, err = age.Encrypt(teeFile, recipients...); err != nil {
+				fatal(err)
+			}
+			tee = encrypted
+		}
+		out = io.MultiWriter(out, buffered(tee))
 	}
-	if *color {
-		*format = "\x1b[32m" + *format + "\x1b[0m"
+	var mailer *Mailer
+	if len(opts.mailTo) > 0 {
+		mailer = &Mailer{
+			To:        opts.mailTo,
+			From:      opts.mailFrom,
+			OnFailure: opts.mailOn == "failure",
+			Server:    opts.smtpServer,
+			User:      opts.smtpUser,
+			Password:  opts.smtpPassword,
+		}
+		if mailer.Server == "" {
+			mailer.Server = os.Getenv("SMTP_SERVER")
+		}
+		if mailer.Server == "" {
+			mailer.Server = "localhost:25"
+		}
+		if mailer.User == "" {
+			mailer.User = os.Getenv("SMTP_USER")
+		}
+		if mailer.Password == "" {
+			mailer.Password = os.Getenv("SMTP_PASSWORD")
+		}
+		if opts.mailLines > 0 {
+			mailer.Tail = &tailBuffer{maxLines: opts.mailLines}
+			out = io.MultiWriter(out, mailer.Tail)
+		}
 	}
-	args := flag.Args()
 
-	timestamper, err := NewTimestamper(*format, mode, timezone)
+	printer := &Printer{
+		Out:         out,
+		Timestamper: timestamper,
+		Summary:     NewSummary(args, timestamper.StartTimestamp),
+		Label:       opts.label,
+		Filter:      opts.filter,
+
+		GitHubActions: opts.githubActions,
+		TeamCity:      opts.teamcity,
+		Buildkite:     opts.buildkite,
+		TAP:           opts.tap,
+		PauseBuffer:   opts.pauseBuffer,
+		Tail:          tail,
+
+		DedupTimestamps: opts.dedupTimestamps,
+		DayMarkers:      opts.relativeDates,
+		QuietFast:       opts.quietFast,
+
+		ElapsedFromFirstOutput: opts.elapsedFrom == "first-output",
+	}
+	printer.AddSink(writerSink{printer})
+	printer.Summary.Batches = batchLimits.Stats
+	if opts.goTest {
+		printer.GoTest = NewGoTestReport()
+	}
+	if opts.histogram {
+		printer.Summary.Gaps = NewGapHistogram()
+	}
+	if opts.foldedOut != "" {
+		subphasePatterns := make([]*regexp.Regexp, len(opts.subphasePattern))
+		for i, pattern := range opts.subphasePattern {
+			if subphasePatterns[i], err = regexp.Compile(pattern); err != nil {
+				fatalf("invalid sub-phase pattern: %s", err)
+			}
+		}
+		name := strings.Join(args, " ")
+		if name == "" {
+			name = "ets"
+		}
+		printer.PhaseTree = NewPhaseTree(name, subphasePatterns)
+	}
+	if opts.sparkline != "" {
+		printer.Summary.Activity = NewSparkline(opts.sparkline, printer.Summary.Start)
+	}
+	if opts.sample != "" {
+		printer.Sampler, err = ParseSampleRatio(opts.sample)
+		if err != nil {
+			fatal(err)
+		}
+	} else if opts.sampleEvery > 0 {
+		printer.Sampler = &Sampler{Interval: opts.sampleEvery}
+	}
+	repeatsDone := make(chan struct{})
+	if opts.squashRepeats > 0 {
+		printer.Repeats = NewRepeatSquasher(opts.squashRepeats)
+		go reportRepeats(printer, opts.squashRepeats/2, repeatsDone)
+	}
+	if opts.markEvery > 0 {
+		go markPeriods(printer, opts.markEvery, repeatsDone)
+	}
+	if opts.keepAlive > 0 {
+		go keepAlive(printer, opts.keepAlive, repeatsDone)
+	}
+	if alert != nil {
+		go watchAlert(printer, alert, repeatsDone)
+	}
+	stopNotes := func() {}
+	if opts.annotateFIFO != "" {
+		if stopNotes, err = annotateFromFIFO(opts.annotateFIFO, printer); err != nil {
+			fatal(err)
+		}
+	}
+	if opts.activityReport != 0 {
+		printer.Summary.ActivityReport = NewActivityReport(opts.activityReport, printer.Summary.Start)
+	}
+	if opts.audit {
+		printer.Audit = &AuditChain{}
+	}
+	specs, err := parseSinkSpecs(sinkArgs(opts), opts.sinkFormats)
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
+	}
+	config := sinkConfig(flags, batchLimits, args)
+	for _, spec := range specs {
+		config.Arg = spec.arg
+		s, err := sink.Open(spec.name, config)
+		if err != nil {
+			fatal(err)
+		}
+		if spec.format != "" {
+			format := spec.format
+			if alias, ok := formatAliases[format]; ok {
+				format = alias
+			}
+			t, err := NewTimestamper(format, mode, timezone)
+			if err != nil {
+				fatalf("invalid --sink-format for %s: %s", spec.name, err)
+			}
+			s = formattedSink{Sink: s, timestamper: t}
+		}
+		printer.AddSink(s)
+	}
+	logFile := opts.tee
+	if logFile == "" {
+		logFile = opts.outputFile
+	}
+	var signedFile string
+	var signingKey ed25519.PrivateKey
+	if opts.sign != "" {
+		if signingKey, err = readSigningKey(opts.sign); err != nil {
+			fatal(err)
+		}
+		if logFile != "" {
+			signedFile = logFile
+		} else {
+			printer.Audit.Key = signingKey
+		}
+	}
+	if opts.checksum != "" {
+		if printer.Summary.Checksum, err = NewChecksum(opts.checksum); err != nil {
+			fatal(err)
+		}
+	}
+	if opts.phasePattern != "" {
+		printer.PhasePattern, err = regexp.Compile(opts.phasePattern)
+		if err != nil {
+			fatalf("invalid phase pattern: %s", err)
+		}
+	}
+	if opts.splitByPhase != "" {
+		if printer.SplitByPhase, err = newPhaseSplitter(opts.splitByPhase); err != nil {
+			fatal(err)
+		}
+	}
+	if opts.k8s {
+		if opts.parseTimestamps != "" && opts.parseTimestamps != "kubectl" {
+			fatal("conflicting flags --k8s and --parse-timestamps")
+		}
+		opts.parseTimestamps = "kubectl"
+	}
+	if opts.parseTimestamps != "" {
+		parser, ok := timestampParsers[opts.parseTimestamps]
+		if !ok {
+			fatalf("unknown timestamp format %q for --parse-timestamps: expected %s",
+				opts.parseTimestamps, strings.Join(timestampParserNames(), ", "))
+		}
+		printer.ParseTimestamps = parser
+	}
+	if opts.fromFormat != "" {
+		if printer.ParseTimestamps != nil {
+			fatal("--from-format cannot be used with --parse-timestamps or --k8s")
+		}
+		if printer.ParseTimestamps, err = formatTimestampParser(opts.fromFormat, opts.fromTimezone); err != nil {
+			fatal(err)
+		}
+	} else if opts.fromTimezone != "" {
+		fatal("--from-timezone requires --from-format")
+	}
+	if subcommand == "convert" && printer.ParseTimestamps == nil {
+		fatal("ets convert requires --from-format, --parse-timestamps, or --k8s")
+	}
+	if opts.markSlow > 0 {
+		printer.SlowThreshold = opts.markSlow
+		printer.SlowStyle, err = parseSlowStyle(opts.markSlowStyle)
+		if err != nil {
+			fatal(err)
+		}
+	}
+	if opts.markerPattern != "" {
+		printer.MarkerPattern, err = regexp.Compile(opts.markerPattern)
+		if err != nil {
+			fatal(err)
+		}
+		if !timestamper.Uses(markQualifier) {
+			fatal("--marker-pattern requires %{mark} directives in the format")
+		}
+	}
+	if opts.levels != "" || len(opts.levelPatterns) > 0 {
+		if opts.levels == "" {
+			opts.levels = "color"
+		}
+		printer.LevelStyle, err = parseLevelStyle(opts.levels)
+		if err != nil {
+			fatal(err)
+		}
+		printer.Levels, err = NewLevelDetector(opts.levelPatterns)
+		if err != nil {
+			fatal(err)
+		}
 	}
 
+	if len(opts.when) != len(opts.then) {
+		fatal("each --when requires a --then, and each --then a --when")
+	}
+	for i, when := range opts.when {
+		rule, err := NewRule(when, opts.then[i])
+		if err != nil {
+			fatal(err)
+		}
+		printer.Rules = append(printer.Rules, rule)
+	}
+	if opts.script != "" {
+		if printer.Script, err = LoadScript(opts.script); err != nil {
+			fatal(err)
+		}
+	}
+
+	if opts.statusBar {
+		printer.StatusBar = StartStatusBar(printer, os.Stdout)
+	}
+	handleUserSignals(printer)
+	var title *TitleUpdater
+	if opts.setTitle {
+		title = StartTitleUpdater(printer)
+		atExit(title.Restore)
+	}
+
+	extraInputs, err := openExtraInputs(opts.fds)
+	if err != nil {
+		fatal(err)
+	}
+	extraInputsDone := printExtraInputs(extraInputs, printer)
+
+	if opts.sdNotify {
+		var readyPattern *regexp.Regexp
+		if opts.readyPattern != "" {
+			if readyPattern, err = regexp.Compile(opts.readyPattern); err != nil {
+				fatalf("invalid ready pattern: %s", err)
+			}
+		}
+		if printer.SdNotifier, err = NewSdNotifier(readyPattern); err != nil {
+			fatal(err)
+		}
+	}
+	if opts.lock != "" {
+		if err := acquireLock(opts.lock, opts.lockMode, printer); err != nil {
+			fatal(err)
+		}
+	}
+	atExit(removePidfiles)
+	for _, pidfile := range []string{opts.pidfile, opts.etsPidfile} {
+		if pidfile != "" {
+			if err := checkPidfile(pidfile); err != nil {
+				fatal(err)
+			}
+		}
+	}
+	if opts.etsPidfile != "" {
+		if err := writePidfile(opts.etsPidfile, os.Getpid()); err != nil {
+			fatal(err)
+		}
+	}
+
+	printer.SdNotifier.Started()
+	printer.StartScript()
 	exitCode := 0
-	if len(args) == 0 {
-		printStreamWithTimestamper(os.Stdin, timestamper)
+	if subcommand == "pipe" && opts.serial != "" {
+		if err := readSerial(opts.serial, opts.baud, printer); err != nil {
+			fatal(err)
+		}
+	} else if subcommand == "pipe" && opts.fifo != "" {
+		if err := readFIFO(opts.fifo, printer); err != nil {
+			fatal(err)
+		}
+	} else if subcommand == "pipe" && opts.listen != "" {
+		if err := readListener(opts.listen, printer); err != nil {
+			fatal(err)
+		}
+	} else if subcommand == "pipe" {
+		printer.StatusBar.SetState("reading stdin")
+		printer.PrintStream(os.Stdin)
+	} else if subcommand == "convert" {
+		if err := convertLogs(logs, printer); err != nil {
+			fatal(err)
+		}
+	} else if subcommand == "cron" {
+		if err := runCron(schedule, args, printer, opts, cronOutput); err != nil {
+			fatal(err)
+		}
+	} else if len(sshHosts) > 0 {
+		printer.StatusBar.SetState(fmt.Sprintf("running on %d hosts", len(sshHosts)))
+		results := runSSHHosts(sshHosts, args, printer)
+		printHostResults(os.Stderr, results)
+		exitCode = hostsExitCode(results)
+		printer.Summary.Exited = true
+		printer.Summary.ExitCode = exitCode
 	} else {
-		if len(args) == 1 {
-			arg0 := args[0]
-			if matched, _ := regexp.MatchString(`\s`, arg0); matched {
-				shell, err := loginshell.Shell()
-				if err != nil {
-					shell = "sh"
+		run := func() error { return runPipedCommandWithPrinter(args, printer, opts) }
+		if subcommand != "docker" && subcommand != "ssh" {
+			if len(args) == 1 {
+				arg0 := args[0]
+				if matched, _ := regexp.MatchString(`\s`, arg0); matched {
+					shell, err := loginshell.Shell()
+					if err != nil {
+						shell = "sh"
+					}
+					args = []string{shell, "-c", arg0}
 				}
-				args = []string{shell, "-c", arg0}
 			}
+			run = func() error { return runCommandWithPrinter(args, printer, opts) }
+		}
+		if opts.untilSuccess {
+			err = runUntilSuccess(run, printer, opts.maxAttempts, opts.retryDelay)
+		} else {
+			err = run()
 		}
-		if err = runCommandWithTimestamper(args, timestamper); err != nil {
+		if err != nil {
 			if exitErr, ok := err.(*exec.ExitError); ok {
 				exitCode = exitErr.ExitCode()
 			} else {
-				log.Fatal(err)
+				fatal(err)
 			}
 		}
+		printer.Summary.Exited = true
+		printer.Summary.ExitCode = exitCode
+	}
+	extraInputsDone.Wait()
+	close(repeatsDone)
+	stopNotes()
+	printer.ReportRepeats(true)
+	printer.ExitScript()
+	printer.SdNotifier.Stop()
+	printer.Resume()
+	printer.StatusBar.Stop()
+	printer.ClosePhase()
+	printer.PrintAuditHead()
+	if !opts.onlyOnFailure || exitCode != 0 {
+		printer.DumpTail()
+	}
+	printer.CloseSinks()
+	for _, f := range flushers {
+		if err := f.Close(); err != nil {
+			log.Printf("error writing output: %s", err)
+		}
+	}
+	if encrypted != nil {
+		if err := encrypted.Close(); err != nil {
+			log.Printf("error writing output: %s", err)
+		}
+	}
+	if signedFile != "" {
+		if err := signFile(signedFile, signingKey); err != nil {
+			log.Printf("error signing %s: %s", signedFile, err)
+		}
+	}
+	printer.Summary.Finish(printer.Now())
+	title.Finish(printer.Summary)
+	if opts.bell {
+		ringBell()
+	}
+	if opts.notify {
+		if err := notify(printer.Summary); err != nil {
+			log.Printf("error sending notification: %s", err)
+		}
+	}
+	if mailer != nil {
+		if err := mailer.Send(printer.Summary, exitCode); err != nil {
+			log.Printf("error sending mail: %s", err)
+		}
+	}
+	if capture != nil {
+		if err := runPager(capture); err != nil {
+			log.Printf("error running pager: %s", err)
+		}
+	}
+	if opts.summary {
+		printer.Summary.Print(os.Stderr)
+	}
+	if opts.timeVerbose {
+		printTimeVerbose(os.Stderr, printer.Summary)
+	}
+	if printer.Summary.Gaps != nil {
+		printer.Summary.Gaps.Print(os.Stderr)
+	}
+	if printer.Summary.ActivityReport != nil {
+		printer.Summary.ActivityReport.Print(os.Stderr, printer.Summary.End)
+	}
+	if printer.GoTest != nil {
+		printer.GoTest.Print(os.Stderr)
+	}
+	if opts.summaryJSON != "" {
+		if err := writeSummaryJSON(opts.summaryJSON, printer.Summary); err != nil {
+			log.Printf("error writing JSON summary: %s", err)
+		}
+	}
+	if printer.PhaseTree != nil {
+		printer.PhaseTree.Finish(printer.Summary)
+		if err := printer.PhaseTree.WriteFolded(opts.foldedOut); err != nil {
+			log.Printf("error writing folded stacks: %s", err)
+		}
+	}
+	if opts.phasesJSON != "" {
+		if err := writePhasesJSON(opts.phasesJSON, printer.Summary); err != nil {
+			log.Printf("error writing phases: %s", err)
+		}
+	}
+	if opts.junitOut != "" {
+		if err := writeJUnitReport(opts.junitOut, printer.Summary); err != nil {
+			log.Printf("error writing JUnit report: %s", err)
+		}
+	}
+	logLocation := ""
+	if logFile != "" {
+		if logLocation, err = filepath.Abs(logFile); err != nil {
+			logLocation = logFile
+		}
+	}
+	if opts.upload != "" {
+		if url, err := uploadLog(opts.upload, logFile, signedFile != "", printer.Summary); err != nil {
+			log.Printf("error uploading %s: %s", logFile, err)
+		} else {
+			logLocation = url
+		}
+	}
+	if opts.webhook != "" {
+		if err := postWebhook(opts.webhook, printer.Summary, logLocation); err != nil {
+			log.Printf("error posting to %s: %s", opts.webhook, err)
+		}
+	}
+	if opts.slackWebhook != "" {
+		if err := postChatMessage(opts.slackWebhook, "text", printer.Summary); err != nil {
+			log.Printf("error posting to Slack: %s", err)
+		}
+	}
+	if opts.discordWebhook != "" {
+		if err := postChatMessage(opts.discordWebhook, "content", printer.Summary); err != nil {
+			log.Printf("error posting to Discord: %s", err)
+		}
+	}
+	if alert != nil {
+		alert.Wait()
+	}
+	commandSucceeded := exitCode == 0
+	if reference != nil {
+		if compareBaseline(os.Stderr, opts.baseline, reference, printer.Summary, regressionThreshold, opts.diffMinChange, opts.color) && exitCode == 0 {
+			exitCode = 1
+		}
+	} else if opts.baseline != "" && !opts.updateBaseline {
+		log.Printf("no baseline at %s yet; record one with --update-baseline", opts.baseline)
+	}
+	if opts.updateBaseline && commandSucceeded {
+		if err := writeBaseline(opts.baseline, printer.Summary); err != nil {
+			log.Printf("error writing baseline: %s", err)
+		}
+	}
+	if opts.maxGap > 0 && printer.Summary.MaxGap > opts.maxGap {
+		log.Printf("max gap %s exceeded --max-gap %s", printer.Summary.describeMaxGap(), opts.maxGap)
+		if exitCode == 0 {
+			exitCode = opts.maxGapExit
+		}
+	}
+	exit(exitCode)
+}
+
+// timestampSettings returns the timestamp mode, format, and timezone
+// selected by opts.
+func timestampSettings(opts *options) (TimestampMode, string, *time.Location) {
+	mode := AbsoluteTimeMode
+	if opts.elapsedMode && opts.incrementalMode {
+		fatal("conflicting flags --elapsed and --incremental")
+	}
+	if opts.human && opts.incrementalMode {
+		fatal("conflicting flags --human and --incremental")
+	}
+	if opts.elapsedMode || opts.human {
+		mode = ElapsedTimeMode
+	}
+	if opts.incrementalMode {
+		mode = IncrementalTimeMode
+	}
+	format := opts.format
+	if opts.adaptiveDelta && format != "" {
+		fatal("conflicting flags --adaptive-delta and --format; use %P in the format instead")
+	}
+	if opts.human && format != "" {
+		fatal("conflicting flags --human and --format; use %i in the format instead")
+	}
+	if alias, ok := formatAliases[format]; ok {
+		format = alias
+	}
+	if format == "" {
+		if mode == AbsoluteTimeMode && opts.relativeDates {
+			format = "[%K %T]"
+		} else if mode == IncrementalTimeMode && opts.adaptiveDelta {
+			format = "[%P]"
+		} else if opts.human {
+			format = "[%i]"
+		} else if mode == AbsoluteTimeMode {
+			format = "[%F %T]"
+		} else {
+			format = "[%T]"
+		}
+		if mode == AbsoluteTimeMode && opts.secondTimezone != "" {
+			// Both times, each with its zone, within the brackets.
+			format = strings.TrimSuffix(format, "]") + " %Z | %{tz2}T %{tz2}Z]"
+		}
+		if opts.markerPattern != "" {
+			format = strings.TrimSuffix(format, "]") + " +%{mark}T]"
+		}
+	}
+	timezone := time.Local
+	if opts.utc && opts.timezoneName != "" {
+		fatal("conflicting flags --utc and --timezone")
+	}
+	if opts.utc || utcFormatAliases[opts.format] {
+		timezone = time.UTC
+	}
+	if opts.timezoneName != "" {
+		location, err := time.LoadLocation(opts.timezoneName)
+		if err != nil {
+			fatal(err)
+		}
+		timezone = location
 	}
-	os.Exit(exitCode)
+	return mode, format, timezone
 }
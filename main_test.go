@@ -1,20 +1,37 @@
 package main_test
 
 import (
+	"bufio"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/exec"
 	"path"
 	"reflect"
 	"regexp"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"syscall"
 	"testing"
 	"time"
 
+	"filippo.io/age"
 	"github.com/creack/pty"
 )
 
@@ -49,6 +66,9 @@ func TestMain(m *testing.M) {
 
 	executable = path.Join(tempdir, "ets")
 
+	// Keep the user's config file out of the way.
+	os.Setenv("ETS_CONFIG", os.DevNull)
+
 	// Build ets and test fixtures to tempdir.
 	compile(rootdir, executable)
 	fixturesdir := path.Join(rootdir, "fixtures")
@@ -397,3 +417,3150 @@ func TestWindowSize(t *testing.T) {
 		})
 	}
 }
+
+func TestFormatAlias(t *testing.T) {
+	cmd := exec.Command("./ets", "-f", "jenkins", "./basic")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("command failed: %s", err)
+	}
+	parsed := parseOutput(output, `\[\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{3}Z\]`)
+	for _, pl := range parsed {
+		if pl.prefix == "" {
+			t.Errorf("unexpected line: %s", pl.raw)
+		}
+	}
+}
+
+func TestCompletion(t *testing.T) {
+	tests := []struct {
+		shell    string
+		expected []string
+	}{
+		{"bash", []string{"complete -F _ets", "--elapsed", "-z|--timezone)", "jenkins", "subcommands=(run pipe docker ssh cron convert replay diff tmux-pane completion)"}},
+		{"zsh", []string{"#compdef ets", "{-s,--elapsed}", "ets completion values timezone"}},
+		{"fish", []string{"complete -c ets -s s -l elapsed", "-l timezone -x -a '(ets completion values timezone"}},
+		{"powershell", []string{"Register-ArgumentCompleter", "'--incremental'", "{ ets completion values profile"}},
+	}
+	for _, test := range tests {
+		t.Run(test.shell, func(t *testing.T) {
+			cmd := exec.Command("./ets", "completion", test.shell)
+			output, err := cmd.Output()
+			if err != nil {
+				t.Fatalf("command failed: %s", err)
+			}
+			for _, s := range test.expected {
+				if !strings.Contains(string(output), s) {
+					t.Errorf("expected %#v in completion script", s)
+				}
+			}
+		})
+	}
+
+	cmd := exec.Command("./ets", "completion", "tcsh")
+	if err := cmd.Run(); err == nil {
+		t.Fatalf("expected unsupported shell to fail")
+	}
+
+	cmd = exec.Command("./ets", "completion", "values", "timezone")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("command failed: %s", err)
+	}
+	if !strings.Contains(string(output), "\nAmerica/New_York\n") {
+		t.Errorf("expected America/New_York among time zones, got %#v", string(output))
+	}
+}
+
+func TestSubcommands(t *testing.T) {
+	cmd := exec.Command("./ets", "run", "-s", "./basic")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("command failed: %s", err)
+	}
+	parsed := parseOutput(output, `\[00:00:00\]`)
+	if len(parsed) != 6 {
+		t.Fatalf("expected 6 lines, got %#v", string(output))
+	}
+
+	cmd = exec.Command("./ets", "pipe", "-f", "[timestamp]")
+	cmd.Stdin = strings.NewReader("out1\nout2\n")
+	output, err = cmd.Output()
+	if err != nil {
+		t.Fatalf("command failed: %s", err)
+	}
+	expectedOutput := "[timestamp] out1\n[timestamp] out2\n"
+	if string(output) != expectedOutput {
+		t.Fatalf("wrong output: expected %#v, got %#v", expectedOutput, string(output))
+	}
+
+	for _, args := range [][]string{
+		{"pipe", "./basic"},
+		{"run"},
+		// Options of other subcommands.
+		{"pipe", "--until-success"},
+		{"--until-success"},
+		{"--serial", "/dev/null", "./basic"},
+		{"run", "--hosts", "hosts", "./basic"},
+		{"cron", "--until-success", "@hourly", "./basic"},
+		{"diff", "--summary", "a", "b"},
+		{"replay", "--tee", "out"},
+	} {
+		cmd = exec.Command("./ets", args...)
+		cmd.Stdin = strings.NewReader("")
+		if err := cmd.Run(); err == nil {
+			t.Errorf("expected %v to fail", args)
+		}
+	}
+}
+
+func TestConvert(t *testing.T) {
+	log := path.Join(tempdir, "convert.log")
+	err := ioutil.WriteFile(log, []byte("[2024-05-01 12:00:00] out1\n[2024-05-01 12:00:02] out2\ncontinued\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(log)
+	cmd := exec.Command("./ets", "convert", "--from-format", "[%F %T]", "--from-timezone", "UTC", "-s", log)
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("command failed: %s", err)
+	}
+	expectedOutput := "[00:00:00] out1\n[00:00:02] out2\n[00:00:02] continued\n"
+	if string(output) != expectedOutput {
+		t.Fatalf("wrong output: expected %#v, got %#v", expectedOutput, string(output))
+	}
+
+	cmd = exec.Command("./ets", "convert", "--parse-timestamps", "jenkins", "-f", "%H:%M:%S", "-z", "Asia/Tokyo")
+	cmd.Stdin = strings.NewReader("[2024-05-01T12:00:00.000Z] out1\n")
+	output, err = cmd.Output()
+	if err != nil {
+		t.Fatalf("command failed: %s", err)
+	}
+	expectedOutput = "21:00:00 out1\n"
+	if string(output) != expectedOutput {
+		t.Fatalf("wrong output: expected %#v, got %#v", expectedOutput, string(output))
+	}
+
+	for _, args := range [][]string{{"convert", log}, {"convert", "--from-format", "[%T]", log}} {
+		cmd = exec.Command("./ets", args...)
+		if err := cmd.Run(); err == nil {
+			t.Errorf("expected %v to fail", args)
+		}
+	}
+}
+
+func TestReplay(t *testing.T) {
+	cmd := exec.Command("./ets", "replay", "-s", "--speed", "10")
+	cmd.Stdin = strings.NewReader("[00:00:00] out1\n[00:00:01] [ets] annotation\n[00:00:02] out2\nbare\n")
+	start := time.Now()
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("command failed: %s", err)
+	}
+	elapsed := time.Since(start)
+	expectedOutput := "out1\nout2\nbare\n"
+	if string(output) != expectedOutput {
+		t.Fatalf("wrong output: expected %#v, got %#v", expectedOutput, string(output))
+	}
+	if elapsed < 200*time.Millisecond || elapsed > 1500*time.Millisecond {
+		t.Errorf("expected a replay of about 200ms, took %s", elapsed)
+	}
+
+	cmd = exec.Command("./ets", "replay", "-s", "--max-wait", "10ms")
+	cmd.Stdin = strings.NewReader("[00:00:00] out1\n[01:00:00] out2\n")
+	start = time.Now()
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("command failed: %s", err)
+	}
+	if elapsed := time.Since(start); elapsed > 1500*time.Millisecond {
+		t.Errorf("expected --max-wait to cut the wait short, took %s", elapsed)
+	}
+}
+
+func TestProfile(t *testing.T) {
+	config := path.Join(tempdir, "config")
+	err := ioutil.WriteFile(config, []byte(`
+format = [top]
+
+[ci]
+elapsed
+format = [ci %T]
+
+[levels]
+levels
+level-pattern = error=out
+
+[broken]
+nonexistent = 1
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(config)
+
+	tests := []struct {
+		name           string
+		args           []string
+		expectedOutput string
+	}{
+		{"top-level", []string{}, "[top] out1\n"},
+		{"profile", []string{"--profile", "ci"}, "[ci 00:00:00] out1\n"},
+		{"override", []string{"--profile", "ci", "-f", "[cli]"}, "[cli] out1\n"},
+		{"bare-value", []string{"--profile", "levels", "-f", "[t]"}, "[t] \x1b[31mout1\x1b[0m\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cmd := exec.Command("./ets", test.args...)
+			cmd.Env = append(os.Environ(), "ETS_CONFIG="+config)
+			cmd.Stdin = strings.NewReader("out1\n")
+			output, err := cmd.Output()
+			if err != nil {
+				t.Fatalf("command failed: %s", err)
+			}
+			if string(output) != test.expectedOutput {
+				t.Fatalf("wrong output: expected %#v, got %#v", test.expectedOutput, string(output))
+			}
+		})
+	}
+
+	for _, profile := range []string{"broken", "missing"} {
+		cmd := exec.Command("./ets", "--profile", profile)
+		cmd.Env = append(os.Environ(), "ETS_CONFIG="+config)
+		cmd.Stdin = strings.NewReader("out1\n")
+		if err := cmd.Run(); err == nil {
+			t.Errorf("expected profile %s to fail", profile)
+		}
+	}
+}
+
+func TestLevels(t *testing.T) {
+	input := "ERROR: boom\nlevel=warn x\nplain\nINFO a WARN b\npanic: oops\n"
+	cmd := exec.Command("./ets", "-f", "[t]", "--levels=tag", "--level-pattern", "error=^panic:", "--summary")
+	cmd.Stdin = strings.NewReader(input)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("command failed: %s", err)
+	}
+	expectedOutput := "[t] ERROR ERROR: boom\n[t] WARN  level=warn x\n[t]       plain\n[t] INFO  INFO a WARN b\n[t] ERROR panic: oops\n"
+	if string(output) != expectedOutput {
+		t.Fatalf("wrong output: expected %#v, got %#v", expectedOutput, string(output))
+	}
+	if !regexp.MustCompile(`levels\s+error 2, warn 1, info 1\n`).MatchString(stderr.String()) {
+		t.Fatalf("level counts not found in summary %#v", stderr.String())
+	}
+
+	cmd = exec.Command("./ets", "-f", "[t]", "--levels")
+	cmd.Stdin = strings.NewReader("WARNING: careful\r\n")
+	output, err = cmd.Output()
+	if err != nil {
+		t.Fatalf("command failed: %s", err)
+	}
+	expectedOutput = "[t] \x1b[33mWARNING: careful\x1b[0m\n"
+	if string(output) != expectedOutput {
+		t.Fatalf("wrong output: expected %#v, got %#v", expectedOutput, string(output))
+	}
+
+	// Lines left out of the output are counted all the same.
+	for _, args := range [][]string{{"--sample", "1/2"}, {"--tap"}} {
+		cmd = exec.Command("./ets", append([]string{"--levels", "--summary"}, args...)...)
+		cmd.Stdin = strings.NewReader("ERROR: a\nERROR: b\nWARN: c\nWARN: d\n")
+		stderr.Reset()
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			t.Fatalf("command failed: %s", err)
+		}
+		if !regexp.MustCompile(`levels\s+error 2, warn 2\n`).MatchString(stderr.String()) {
+			t.Errorf("%v: level counts not found in summary %#v", args, stderr.String())
+		}
+	}
+}
+
+func TestMaxGap(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping slow test in short mode")
+	}
+	tests := []struct {
+		name         string
+		args         []string
+		expectedCode int
+	}{
+		{"exceeded", []string{"--max-gap", "500ms", "--max-gap-exit", "42"}, 42},
+		{"exceeded-default-code", []string{"--max-gap", "500ms"}, 1},
+		{"within", []string{"--max-gap", "5s", "--max-gap-exit", "42"}, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cmd := exec.Command("./ets", append(test.args, "echo out1; sleep 1; echo out2")...)
+			err := cmd.Run()
+			code := 0
+			if errExit, ok := err.(*exec.ExitError); ok {
+				code = errExit.ExitCode()
+			} else if err != nil {
+				t.Fatalf("command failed: %s", err)
+			}
+			if code != test.expectedCode {
+				t.Fatalf("expected exit code %d, got %d", test.expectedCode, code)
+			}
+		})
+	}
+}
+
+func TestMarkSlow(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping slow test in short mode")
+	}
+	cmd := exec.Command("./ets", "-f", "[t]", "--mark-slow", "500ms", "echo out1; sleep 1; echo out2")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("command failed: %s", err)
+	}
+	if !regexp.MustCompile(`^\[t\] out1\n\[t\] >>>>> 1(\.\d)?s gap\n\[t\] out2\n$`).Match(output) {
+		t.Fatalf("wrong output: %#v", string(output))
+	}
+}
+
+func TestStatusBar(t *testing.T) {
+	cmd := exec.Command("./ets", "--status-bar", "./winsize")
+	ptmx, err := pty.StartWithSize(cmd, &pty.Winsize{Rows: 24, Cols: 80, X: 0, Y: 0})
+	if err != nil {
+		t.Fatalf("failed to start command in pty: %s", err)
+	}
+	defer func() { _ = ptmx.Close() }()
+	output, err := ioutil.ReadAll(ptmx)
+	if len(output) == 0 && err != nil {
+		t.Fatalf("failed to read pty output: %s", err)
+	}
+	for _, expected := range []string{
+		"\x1b[0;23r",   // scroll region set up above the status line
+		" 58x23\r\n",   // command sees one row less
+		"elapsed 0:00", // status line
+		"\x1b[0;24r",   // scroll region restored
+	} {
+		if !strings.Contains(string(output), expected) {
+			t.Errorf("expected %#v in output %#v", expected, string(output))
+		}
+	}
+}
+
+func TestSummary(t *testing.T) {
+	cmd := exec.Command("./ets", "--summary", "./basic", "-exitcode", "3")
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	_ = cmd.Run()
+	for _, pattern := range []string{
+		`command\s+\./basic -exitcode 3\n`,
+		`exit status\s+3\n`,
+		`user time\s+\d`,
+		`max rss\s+[\d.]+ [KMG]iB\n`,
+		`context switches\s+\d+ voluntary, \d+ involuntary\n`,
+		`lines\s+6\n`,
+	} {
+		if !regexp.MustCompile(pattern).MatchString(stderr.String()) {
+			t.Errorf("expected %#v in summary %#v", pattern, stderr.String())
+		}
+	}
+}
+
+func TestSampleResources(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("resource sampling is only supported on Linux")
+	}
+	if testing.Short() {
+		t.Skip("skipping slow test in short mode")
+	}
+	cmd := exec.Command("./ets", "-f", "[t]", "--sample-resources", "300ms", "sleep 1; echo done")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("command failed: %s", err)
+	}
+	pattern := regexp.MustCompile(`(?m)^\[t\] \[ets\] cpu \d+\.\d%, rss [\d.]+ (B|[KMG]iB), \d+ process(es)?$`)
+	if !pattern.Match(output) {
+		t.Fatalf("no resource sample in output %#v", string(output))
+	}
+}
+
+func TestTimeVerbose(t *testing.T) {
+	cmd := exec.Command("./ets", "--time-verbose", "./basic", "-exitcode", "2")
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	_ = cmd.Run()
+	for _, pattern := range []string{
+		`^Command exited with non-zero status 2\n`,
+		`\n\tCommand being timed: "\./basic -exitcode 2"\n`,
+		`\n\tUser time \(seconds\): \d+\.\d\d\n`,
+		`\n\tPercent of CPU this job got: \d+%\n`,
+		`\n\tElapsed \(wall clock\) time \(h:mm:ss or m:ss\): \d+:\d\d\.\d\d\n`,
+		`\n\tMaximum resident set size \(kbytes\): \d+\n`,
+		`\n\tExit status: 2\n$`,
+	} {
+		if !regexp.MustCompile(pattern).MatchString(stderr.String()) {
+			t.Errorf("expected %#v in report %#v", pattern, stderr.String())
+		}
+	}
+}
+
+func TestQuiet(t *testing.T) {
+	teeFile := path.Join(tempdir, "tee-output")
+	cmd := exec.Command("./ets", "--quiet", "--tee", teeFile, "./basic")
+	var stdout, stderr strings.Builder
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if stdout.String() != "" {
+		t.Errorf("expected no output, got %#v", stdout.String())
+	}
+	if !regexp.MustCompile(`lines\s+6\n`).MatchString(stderr.String()) {
+		t.Errorf("expected summary, got %#v", stderr.String())
+	}
+	content, err := ioutil.ReadFile(teeFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n"); len(lines) != 6 || !regexp.MustCompile(`^\[[\d :-]+\] out1$`).MatchString(lines[0]) {
+		t.Errorf("wrong tee file content %#v", string(content))
+	}
+}
+
+func TestLabel(t *testing.T) {
+	output, err := exec.Command("./ets", "-s", "--label", "[build]", "./basic").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(string(output), "\n"), "\n") {
+		if !regexp.MustCompile(`^\[00:00:00\] \[build\] (out|err)\d$`).MatchString(line) {
+			t.Errorf("unexpected labeled line %#v", line)
+		}
+	}
+}
+
+func TestGitHubActions(t *testing.T) {
+	cmd := exec.Command("./ets", "-s", "--github-actions", "--summary")
+	cmd.Stdin = strings.NewReader("::group::build\nout1\n::warning file=a.go::careful\n::endgroup::\n::group::test\nout2\n")
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(output), "\n"), "\n")
+	expected := []string{
+		`^::group::build$`,
+		`^\[00:00:00\] out1$`,
+		`^::warning file=a.go::careful$`,
+		`^::endgroup::$`,
+		`^::notice title=ets::build took \S+ \(1 line\)$`,
+		`^::group::test$`,
+		`^\[00:00:00\] out2$`,
+		`^::notice title=ets::test took \S+ \(1 line\)$`,
+	}
+	if len(lines) != len(expected) {
+		t.Fatalf("wrong output %#v", string(output))
+	}
+	for i, pattern := range expected {
+		if !regexp.MustCompile(pattern).MatchString(lines[i]) {
+			t.Errorf("expected line %d to match %#v, got %#v", i, pattern, lines[i])
+		}
+	}
+	if !regexp.MustCompile(`phase\s+build: \S+ \(1 line\)\n\s+phase\s+test: `).MatchString(stderr.String()) {
+		t.Errorf("expected phases in summary %#v", stderr.String())
+	}
+}
+
+func TestJenkins(t *testing.T) {
+	cmd := exec.Command("./ets", "-f", "jenkins", "--parse-timestamps", "jenkins")
+	cmd.Stdin = strings.NewReader("[2020-06-01T12:34:56.789Z] out1\nout2\n[2020-06-01T12:35:00.000Z] out3\n")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "[2020-06-01T12:34:56.789Z] out1\n[2020-06-01T12:34:56.789Z] out2\n[2020-06-01T12:35:00.000Z] out3\n"
+	if string(output) != expected {
+		t.Errorf("expected %#v, got %#v", expected, string(output))
+	}
+
+	cmd = exec.Command("./ets", "-s", "-f", "%T", "--parse-timestamps", "jenkins", "--summary")
+	cmd.Stdin = strings.NewReader("[2020-06-01T12:34:56.789Z] out1\n[2020-06-01T12:36:00.000Z] out2\n")
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	output, err = cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "00:00:00 out1\n00:01:03 out2\n"; string(output) != expected {
+		t.Errorf("expected %#v, got %#v", expected, string(output))
+	}
+	if !regexp.MustCompile(`duration\s+1m3.211s\n`).MatchString(stderr.String()) {
+		t.Errorf("wrong duration in summary %#v", stderr.String())
+	}
+}
+
+func TestTeamCity(t *testing.T) {
+	cmd := exec.Command("./ets", "-s", "--teamcity", "--phase-pattern", `^==> (.*)`)
+	cmd.Stdin = strings.NewReader("==> build it\nout1\n==> test\n")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(output), "\n"), "\n")
+	expected := []string{
+		`^##teamcity\[blockOpened name='build it'\]$`,
+		`^\[00:00:00\] ==> build it$`,
+		`^\[00:00:00\] out1$`,
+		`^##teamcity\[blockClosed name='build it'\]$`,
+		`^##teamcity\[buildStatisticValue key='ets\.phase\.build it\.duration' value='\d+'\]$`,
+		`^##teamcity\[blockOpened name='test'\]$`,
+		`^\[00:00:00\] ==> test$`,
+		`^##teamcity\[blockClosed name='test'\]$`,
+		`^##teamcity\[buildStatisticValue key='ets\.phase\.test\.duration' value='\d+'\]$`,
+	}
+	if len(lines) != len(expected) {
+		t.Fatalf("wrong output %#v", string(output))
+	}
+	for i, pattern := range expected {
+		if !regexp.MustCompile(pattern).MatchString(lines[i]) {
+			t.Errorf("expected line %d to match %#v, got %#v", i, pattern, lines[i])
+		}
+	}
+}
+
+func TestBuildkite(t *testing.T) {
+	cmd := exec.Command("./ets", "--buildkite", "-f", "%s.%L")
+	cmd.Stdin = strings.NewReader("out1\nout2\n")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	re := regexp.MustCompile(`^\x1b_bk;t=(\d+)\x07(\d+)\.(\d{3}) out\d$`)
+	for _, line := range strings.Split(strings.TrimSuffix(string(output), "\n"), "\n") {
+		m := re.FindStringSubmatch(line)
+		if m == nil {
+			t.Errorf("unexpected line %#v", line)
+		} else if m[1] != m[2]+m[3] {
+			t.Errorf("marker disagrees with timestamp in %#v", line)
+		}
+	}
+}
+
+func TestGoTest(t *testing.T) {
+	cmd := exec.Command("./ets", "--go-test")
+	cmd.Stdin = strings.NewReader(`{"Time":"2020-06-01T12:00:00Z","Action":"run","Package":"p","Test":"TestFast"}
+{"Time":"2020-06-01T12:00:00.5Z","Action":"pass","Package":"p","Test":"TestFast","Elapsed":0.5}
+{"Time":"2020-06-01T12:00:01Z","Action":"run","Package":"p","Test":"TestSlow"}
+{"Time":"2020-06-01T12:00:02Z","Action":"output","Package":"p","Test":"TestSlow","Output":"x\n"}
+{"Time":"2020-06-01T12:00:05Z","Action":"fail","Package":"p","Test":"TestSlow","Elapsed":4}
+{"Time":"2020-06-01T12:00:05Z","Action":"run","Package":"p","Test":"TestHung"}
+{"Time":"2020-06-01T12:00:05Z","Action":"fail","Package":"p","Elapsed":5}
+`)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	expected := `ets go test report:
+  FAIL          4s  max gap 3s          p TestSlow
+  PASS       500ms  max gap 500ms       p TestFast
+  RUN           0s  max gap 0s          p TestHung
+`
+	if stderr.String() != expected {
+		t.Errorf("expected report %#v, got %#v", expected, stderr.String())
+	}
+}
+
+func TestJUnitOut(t *testing.T) {
+	report := path.Join(tempdir, "junit.xml")
+	cmd := exec.Command("./ets", "--junit-out", report, "--phase-pattern", `^(out\d)`, "./basic", "-exitcode", "1")
+	_ = cmd.Run()
+	content, err := ioutil.ReadFile(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pattern := range []string{
+		`<testsuite name="\./basic -exitcode 1" tests="3" failures="1" time="[\d.]+" timestamp="[\dT:-]+">`,
+		`<testcase name="out1" classname="ets" time="[\d.]+"></testcase>`,
+		`<testcase name="out3" classname="ets" time="[\d.]+">\s*<failure message="command exited with status 1"></failure>`,
+	} {
+		if !regexp.MustCompile(pattern).Match(content) {
+			t.Errorf("expected %#v in report %s", pattern, content)
+		}
+	}
+}
+
+func TestTAP(t *testing.T) {
+	cmd := exec.Command("./ets", "-s", "--tap", "--summary")
+	cmd.Stdin = strings.NewReader("TAP version 13\n1..2\nok 1 - first\n    ok 1 - sub\nnot ok 2 - second\n  ---\n  ...\n")
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `^TAP version 13
+1\.\.2
+# ts \[00:00:00\] \(\S+\)
+ok 1 - first
+    # ts \[00:00:00\] \(\S+\)
+    ok 1 - sub
+# ts \[00:00:00\] \(\S+\)
+not ok 2 - second
+  ---
+  \.\.\.
+$`
+	if !regexp.MustCompile(expected).Match(output) {
+		t.Errorf("unexpected output %#v", string(output))
+	}
+	if !regexp.MustCompile(`test\s+ok 1 - first: \S+\n\s+test\s+not ok 2 - second: `).MatchString(stderr.String()) {
+		t.Errorf("expected tests in summary %#v", stderr.String())
+	}
+}
+
+// fakeCommand installs a script as the command name in a directory, and
+// returns an environment with the directory first in PATH.
+func fakeCommand(t *testing.T, name string, script string) []string {
+	bindir := path.Join(tempdir, "fakebin")
+	if err := os.MkdirAll(bindir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(bindir, name), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return append(os.Environ(), "PATH="+bindir+":"+os.Getenv("PATH"))
+}
+
+// fakeSSH installs a fake ssh whose remote clock reads 2020-06-01 12:00:00
+// UTC, which echoes its arguments otherwise, and fails on the host "down".
+func fakeSSH(t *testing.T) []string {
+	return fakeCommand(t, "ssh", `#!/bin/sh
+case "$*" in
+*"read request"*) echo ready; read request; echo 1591012800.250000000 ;;
+*" down "*) echo unreachable; exit 255 ;;
+*) echo "$*" ;;
+esac
+`)
+}
+
+func TestDocker(t *testing.T) {
+	// A fake docker echoing its arguments with a docker logs timestamp.
+	env := fakeCommand(t, "docker", "#!/bin/sh\necho \"2020-06-01T12:00:00.5Z $*\"\necho \"2020-06-01T12:00:02.5Z done\"\n")
+
+	cmd := exec.Command("./ets", "docker", "-s", "-f", "%T", "logs", "--tail", "10", "web")
+	cmd.Env = env
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "00:00:00 web logs --timestamps --tail 10 web\n00:00:02 web done\n"
+	if string(output) != expected {
+		t.Errorf("expected %#v, got %#v", expected, string(output))
+	}
+
+	cmd = exec.Command("./ets", "docker", "-s", "-f", "%T", "logs", "-f", "web", "--tail", "10")
+	cmd.Env = env
+	output, err = cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = "00:00:00 web logs --timestamps -f web --tail 10\n00:00:02 web done\n"
+	if string(output) != expected {
+		t.Errorf("expected %#v, got %#v", expected, string(output))
+	}
+
+	cmd = exec.Command("./ets", "docker", "-f", "[ts]", "run", "--rm", "--name=db", "postgres")
+	cmd.Env = env
+	output, err = cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = "[ts] db 2020-06-01T12:00:00.5Z run --rm --name=db postgres\n[ts] db 2020-06-01T12:00:02.5Z done\n"
+	if string(output) != expected {
+		t.Errorf("expected %#v, got %#v", expected, string(output))
+	}
+}
+
+func TestK8s(t *testing.T) {
+	cmd := exec.Command("./ets", "--k8s", "-z", "Asia/Tokyo", "-f", "%H:%M:%S")
+	cmd.Stdin = strings.NewReader("[pod/web-5d8f/nginx] 2020-06-01T12:00:00.123456789Z started\n2020-06-01T12:00:01+00:00 plain\n")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "21:00:00 [pod/web-5d8f/nginx] started\n21:00:01 plain\n"
+	if string(output) != expected {
+		t.Errorf("expected %#v, got %#v", expected, string(output))
+	}
+}
+
+func TestSSH(t *testing.T) {
+	env := fakeSSH(t)
+
+	cmd := exec.Command("./ets", "ssh", "-f", "[ts]", "user@host", "--", "uptime", "-p")
+	cmd.Env = env
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "[ts] -tt user@host -- uptime -p\n"; string(output) != expected {
+		t.Errorf("expected %#v, got %#v", expected, string(output))
+	}
+
+	cmd = exec.Command("./ets", "ssh", "--remote-time", "-u", "-f", "%F %H:%M", "host", "uptime")
+	cmd.Env = env
+	output, err = cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "2020-06-01 12:00 -tt host -- uptime\n"; string(output) != expected {
+		t.Errorf("expected %#v, got %#v", expected, string(output))
+	}
+}
+
+func TestSSHHosts(t *testing.T) {
+	env := fakeSSH(t)
+	hosts := path.Join(tempdir, "hosts")
+	if err := ioutil.WriteFile(hosts, []byte("# web servers\nweb1\n\ndown\nweb22\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command("./ets", "ssh", "-f", "[ts]", "--hosts", hosts, "--", "uptime")
+	cmd.Env = env
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 255 {
+		t.Errorf("expected exit status 255, got %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(output), "\n"), "\n")
+	sort.Strings(lines)
+	expected := []string{
+		"[ts] down  unreachable",
+		"[ts] web1  -tt web1 -- uptime",
+		"[ts] web22 -tt web22 -- uptime",
+	}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("expected %#v, got %#v", expected, lines)
+	}
+	if !regexp.MustCompile(`^ets hosts:
+  web1 \s+\S+  exit status 0
+  down \s+\S+  exit status 255
+  web22\s+\S+  exit status 0
+$`).MatchString(stderr.String()) {
+		t.Errorf("unexpected host results %#v", stderr.String())
+	}
+}
+
+func TestSerial(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("serial devices are only supported on Linux")
+	}
+	// A pty stands in for a serial device.
+	master, device, err := pty.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer master.Close()
+	defer device.Close()
+
+	cmd := exec.Command("./ets", "-f", "[ts]", "--serial", device.Name(), "--baud", "9600")
+	var stdout strings.Builder
+	cmd.Stdout = &stdout
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(500 * time.Millisecond)
+	_, _ = master.Write([]byte("booting\r\nready\n"))
+	time.Sleep(500 * time.Millisecond)
+	_ = cmd.Process.Signal(syscall.SIGINT)
+	if err := cmd.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "[ts] booting\n[ts] ready\n"; stdout.String() != expected {
+		t.Errorf("expected %#v, got %#v", expected, stdout.String())
+	}
+}
+
+func TestFIFO(t *testing.T) {
+	fifo := path.Join(tempdir, "fifo")
+	cmd := exec.Command("./ets", "-f", "[ts]", "--fifo", fifo)
+	var stdout strings.Builder
+	cmd.Stdout = &stdout
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(500 * time.Millisecond)
+	for _, line := range []string{"writer1\n", "writer2\n"} {
+		f, err := os.OpenFile(fifo, os.O_WRONLY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = f.WriteString(line)
+		f.Close()
+	}
+	time.Sleep(500 * time.Millisecond)
+	_ = cmd.Process.Signal(syscall.SIGINT)
+	if err := cmd.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "[ts] writer1\n[ts] writer2\n"; stdout.String() != expected {
+		t.Errorf("expected %#v, got %#v", expected, stdout.String())
+	}
+	if _, err := os.Stat(fifo); !os.IsNotExist(err) {
+		t.Errorf("expected FIFO to be removed, got %v", err)
+	}
+}
+
+func TestListen(t *testing.T) {
+	socket := path.Join(tempdir, "ets.sock")
+	cmd := exec.Command("./ets", "-f", "[ts]", "--listen", "unix:"+socket)
+	var stdout strings.Builder
+	cmd.Stdout = &stdout
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(500 * time.Millisecond)
+	for _, line := range []string{"hello\n", "world\n"} {
+		conn, err := net.Dial("unix", socket)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = conn.Write([]byte(line))
+		conn.Close()
+		time.Sleep(100 * time.Millisecond)
+	}
+	time.Sleep(200 * time.Millisecond)
+	_ = cmd.Process.Signal(syscall.SIGINT)
+	if err := cmd.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	expected := `[ts] [ets] client #1 connected
+[ts] #1 hello
+[ts] [ets] client #1 disconnected
+[ts] [ets] client #2 connected
+[ts] #2 world
+[ts] [ets] client #2 disconnected
+`
+	if stdout.String() != expected {
+		t.Errorf("expected %#v, got %#v", expected, stdout.String())
+	}
+	if _, err := os.Stat(socket); !os.IsNotExist(err) {
+		t.Errorf("expected socket to be removed, got %v", err)
+	}
+}
+
+func TestExtraFDs(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command("./ets", "-f", "[ts]", "--fd", "3=worker")
+	cmd.ExtraFiles = []*os.File{r}
+	cmd.Stdin = strings.NewReader("main\n")
+	var stdout strings.Builder
+	cmd.Stdout = &stdout
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+	_, _ = w.Write([]byte("extra\n"))
+	w.Close()
+	if err := cmd.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(stdout.String(), "\n"), "\n")
+	sort.Strings(lines)
+	if expected := []string{"[ts] main", "[ts] worker extra"}; !reflect.DeepEqual(lines, expected) {
+		t.Errorf("expected %#v, got %#v", expected, lines)
+	}
+
+	if err := exec.Command("./ets", "--fd", "9", "true").Run(); err == nil {
+		t.Error("expected a missing file descriptor to fail")
+	}
+}
+
+func TestTmuxPane(t *testing.T) {
+	state := path.Join(tempdir, "tmux-state")
+	_ = os.Remove(state)
+	// A fake tmux tracking whether pane %7 is piped, and logging the
+	// pipe-pane command.
+	env := fakeCommand(t, "tmux", `#!/bin/sh
+case "$*" in
+*pane_id*) echo %7 ;;
+*pane_pipe*) if [ -e `+state+` ]; then echo 1; else echo 0; fi ;;
+pipe-pane*-O*) printf '%s\n' "$*" > `+state+` ;;
+pipe-pane*) rm `+state+` ;;
+esac
+`)
+	output := path.Join(tempdir, "pane.log")
+	cmd := exec.Command("./ets", "tmux-pane", "-s", "--tee", output, "mysession:1.2")
+	cmd.Env = env
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("command failed: %s: %s", err, out)
+	}
+	content, err := ioutil.ReadFile(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`^pipe-pane -O -t %7 exec '/\S+/ets' pipe '-s' >> '` + output + `'\n$`).Match(content) {
+		t.Errorf("unexpected pipe-pane command %#v", string(content))
+	}
+
+	cmd = exec.Command("./ets", "tmux-pane", "mysession:1.2")
+	cmd.Env = env
+	if out, err := cmd.CombinedOutput(); err != nil || !strings.Contains(string(out), "stopped timestamping pane %7") {
+		t.Fatalf("expected pipe to be stopped: %v: %s", err, out)
+	}
+	if _, err := os.Stat(state); !os.IsNotExist(err) {
+		t.Error("expected pipe-pane to be closed")
+	}
+}
+
+func TestPager(t *testing.T) {
+	cmd := exec.Command("./ets", "--pager", "-f", "[ts]")
+	cmd.Env = append(os.Environ(), "PAGER=sed 's/^/paged: /'")
+	cmd.Stdin = strings.NewReader("out1\nout2\n")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "paged: [ts] out1\npaged: [ts] out2\n"; string(output) != expected {
+		t.Errorf("expected %#v, got %#v", expected, string(output))
+	}
+
+	// Not a terminal.
+	cmd = exec.Command("./ets", "--pager=auto", "-f", "[ts]")
+	cmd.Env = append(os.Environ(), "PAGER=false")
+	cmd.Stdin = strings.NewReader("out1\n")
+	output, err = cmd.Output()
+	if err != nil || string(output) != "[ts] out1\n" {
+		t.Errorf("expected output not to be paged, got %#v, %v", string(output), err)
+	}
+
+	// Nothing to page.
+	cmd = exec.Command("./ets", "--pager", "--quiet", "-f", "[ts]")
+	cmd.Env = append(os.Environ(), "PAGER=false")
+	cmd.Stdin = strings.NewReader("out1\n")
+	output, err = cmd.Output()
+	if err != nil || string(output) != "" {
+		t.Errorf("expected the pager to be skipped, got %#v, %v", string(output), err)
+	}
+}
+
+func TestKeys(t *testing.T) {
+	run := func(input string, args ...string) string {
+		cmd := exec.Command("./ets", append(args, "-f", "[ts]", "sh", "-c", "read x; echo got $x")...)
+		ptmx, err := pty.StartWithSize(cmd, &pty.Winsize{Rows: 24, Cols: 80, X: 0, Y: 0})
+		if err != nil {
+			t.Fatalf("failed to start command in pty: %s", err)
+		}
+		defer func() { _ = ptmx.Close() }()
+		time.Sleep(500 * time.Millisecond)
+		_, _ = ptmx.Write([]byte(input))
+		output, err := ioutil.ReadAll(ptmx)
+		if len(output) == 0 && err != nil {
+			t.Fatalf("failed to read pty output: %s", err)
+		}
+		return string(output)
+	}
+
+	output := run("\x1dm\x1ds\x1dthello\n")
+	for _, pattern := range []string{
+		`(?m)^\[ts\] \[ets\] ===== MARK 1 =====\r$`,
+		`(?m)^\[ts\] \[ets\] elapsed 0:00:0\d \| 0 lines \| no output yet \| running \(pid \d+\)\r$`,
+		`(?m)^\[ts\] \[ets\] timestamps hidden\r$`,
+		`(?m)^got hello\r$`,
+	} {
+		if !regexp.MustCompile(pattern).MatchString(output) {
+			t.Errorf("expected %#v to match output %#v", pattern, output)
+		}
+	}
+
+	output = run("\x1dm\x1ds\x1dthello\n", "--no-keys")
+	if strings.Contains(output, "[ets]") || !regexp.MustCompile(`(?m)^\[ts\] got .*hello\r$`).MatchString(output) {
+		t.Errorf("expected input to be forwarded untouched, got output %#v", output)
+	}
+
+	// Notes are echoed, but not sent to the command.
+	output = run("\x1dnnote\x7f\x7fted it\rhello\n")
+	for _, pattern := range []string{
+		`(?m)^\[ts\] \[ets\] NOTE: noted it\r$`,
+		`(?m)^\[ts\] got hello\r$`,
+	} {
+		if !regexp.MustCompile(pattern).MatchString(output) {
+			t.Errorf("expected %#v to match output %#v", pattern, output)
+		}
+	}
+}
+
+func TestPause(t *testing.T) {
+	outfile := path.Join(tempdir, "pause.out")
+	out, err := os.Create(outfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	cmd := exec.Command("./ets", "--pause-buffer", "1", "-f", "[ts]")
+	cmd.Stdout = out
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(500 * time.Millisecond)
+	_, _ = stdin.Write([]byte("out1\n"))
+	time.Sleep(200 * time.Millisecond)
+	_ = cmd.Process.Signal(syscall.SIGUSR1)
+	time.Sleep(200 * time.Millisecond)
+	_, _ = stdin.Write([]byte("out2\nout3\n"))
+	time.Sleep(300 * time.Millisecond)
+	output, _ := ioutil.ReadFile(outfile)
+	if expected := "[ts] out1\n[ts] [ets] display paused, holding back up to 1 lines\n"; string(output) != expected {
+		t.Errorf("expected %#v while paused, got %#v", expected, string(output))
+	}
+	_ = cmd.Process.Signal(syscall.SIGUSR1)
+	_ = stdin.Close()
+	if err := cmd.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	output, _ = ioutil.ReadFile(outfile)
+	if expected := "[ts] out1\n" +
+		"[ts] [ets] display paused, holding back up to 1 lines\n" +
+		"[ts] [ets] display resumed, 1 lines held back\n" +
+		"[ts] out2\n" +
+		"[ts] out3\n"; string(output) != expected {
+		t.Errorf("expected %#v, got %#v", expected, string(output))
+	}
+}
+
+func TestBookmark(t *testing.T) {
+	cmd := exec.Command("./ets", "--summary", "-f", "[ts]")
+	var stdout, stderr strings.Builder
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(500 * time.Millisecond)
+	_, _ = stdin.Write([]byte("out1\n"))
+	time.Sleep(200 * time.Millisecond)
+	_ = cmd.Process.Signal(syscall.SIGUSR2)
+	time.Sleep(200 * time.Millisecond)
+	_ = cmd.Process.Signal(syscall.SIGUSR2)
+	time.Sleep(200 * time.Millisecond)
+	_ = stdin.Close()
+	if err := cmd.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "[ts] out1\n[ts] [ets] ===== MARK 1 =====\n[ts] [ets] ===== MARK 2 =====\n"; stdout.String() != expected {
+		t.Errorf("expected %#v, got %#v", expected, stdout.String())
+	}
+	if !regexp.MustCompile(`(?m)^  mark +1 at \d\d:\d\d:\d\d \(\+[\d.]+m?s\)\n  mark +2 at \d\d:\d\d:\d\d \(\+[\d.]+m?s\)$`).MatchString(stderr.String()) {
+		t.Errorf("bookmarks not found in summary %#v", stderr.String())
+	}
+}
+
+func TestTailBuffer(t *testing.T) {
+	cmd := exec.Command("./ets", "--tail-buffer", "2", "-f", "[ts]")
+	cmd.Stdin = strings.NewReader("a\nb\nc\nd\ne\n")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "[ts] [ets] 3 earlier lines dropped by --tail-buffer\n[ts] d\n[ts] e\n"; string(output) != expected {
+		t.Errorf("expected %#v, got %#v", expected, string(output))
+	}
+
+	// An output file is rewritten on SIGUSR1 and on exit.
+	outfile := path.Join(tempdir, "tail.log")
+	cmd = exec.Command("./ets", "--tail-buffer", "2", "-f", "[ts]", "-o", outfile)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(500 * time.Millisecond)
+	_, _ = stdin.Write([]byte("a\nb\n"))
+	time.Sleep(200 * time.Millisecond)
+	_ = cmd.Process.Signal(syscall.SIGUSR1)
+	time.Sleep(200 * time.Millisecond)
+	output, _ = ioutil.ReadFile(outfile)
+	if expected := "[ts] a\n[ts] b\n"; string(output) != expected {
+		t.Errorf("expected %#v on SIGUSR1, got %#v", expected, string(output))
+	}
+	_, _ = stdin.Write([]byte("c\nd\ne\n"))
+	_ = stdin.Close()
+	if err := cmd.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	output, _ = ioutil.ReadFile(outfile)
+	if expected := "[ts] [ets] 3 earlier lines dropped by --tail-buffer\n[ts] d\n[ts] e\n"; string(output) != expected {
+		t.Errorf("expected %#v, got %#v", expected, string(output))
+	}
+}
+
+func TestOnlyOnFailure(t *testing.T) {
+	for _, test := range []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"sh", "-c", "echo a; echo b"}, ""},
+		{[]string{"sh", "-c", "echo a; echo b; exit 3"}, "[ts] a\n[ts] b\n"},
+		{[]string{"--tail-buffer", "1", "sh", "-c", "echo a; echo b; exit 3"}, "[ts] [ets] 1 earlier line dropped by --tail-buffer\n[ts] b\n"},
+	} {
+		cmd := exec.Command("./ets", append([]string{"-f", "[ts]", "--only-on-failure"}, test.args...)...)
+		output, err := cmd.Output()
+		if exitErr, ok := err.(*exec.ExitError); (test.expected == "") != (err == nil) || (ok && exitErr.ExitCode() != 3) {
+			t.Errorf("%v: unexpected error %v", test.args, err)
+		}
+		if string(output) != test.expected {
+			t.Errorf("%v: expected %#v, got %#v", test.args, test.expected, string(output))
+		}
+	}
+}
+
+func TestKeepAlive(t *testing.T) {
+	cmd := exec.Command("./ets", "-f", "[ts]", "--only-on-failure", "--keepalive", "300ms", "sh", "-c", "echo a; sleep 1")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`^(\[ts\] \[ets\] still running, [\d.]+s elapsed, [01] lines? buffered\n){2,}$`).Match(output) {
+		t.Errorf("wrong output: %#v", string(output))
+	}
+
+	output, err = exec.Command("./ets", "--keepalive", "1m", "true").CombinedOutput()
+	if err == nil || !strings.Contains(string(output), "--keepalive requires") {
+		t.Errorf("expected --keepalive without buffering to be rejected, got %#v", string(output))
+	}
+}
+
+func TestNotify(t *testing.T) {
+	notifications := path.Join(tempdir, "notifications")
+	env := fakeCommand(t, "notify-send", "#!/bin/sh\nprintf '%s\\n' \"$@\" >"+notifications+"\n")
+	cmd := exec.Command("./ets", "--notify", "./basic", "-exitcode", "3")
+	cmd.Env = env
+	err := cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 3 {
+		t.Fatalf("expected exit code 3, got %v", err)
+	}
+	output, err := ioutil.ReadFile(notifications)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`^--app-name=ets\nets: \./basic -exitcode 3\nexited with status 3 after [\d.]+m?s\n$`).Match(output) {
+		t.Errorf("wrong notification: %#v", string(output))
+	}
+}
+
+func TestMail(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	// An SMTP server accepting every message.
+	messages := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			reader := bufio.NewReader(conn)
+			fmt.Fprint(conn, "220 test\r\n")
+			var data strings.Builder
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					break
+				}
+				switch command := strings.ToUpper(strings.Fields(line + "x")[0]); command {
+				case "EHLO", "HELO", "MAIL", "RCPT":
+					fmt.Fprint(conn, "250 ok\r\n")
+				case "DATA":
+					fmt.Fprint(conn, "354 go ahead\r\n")
+					for {
+						line, err := reader.ReadString('\n')
+						if err != nil || line == ".\r\n" {
+							break
+						}
+						data.WriteString(line)
+					}
+					messages <- data.String()
+					fmt.Fprint(conn, "250 ok\r\n")
+				case "QUIT":
+					fmt.Fprint(conn, "221 bye\r\n")
+				default:
+					fmt.Fprint(conn, "502 unknown\r\n")
+				}
+			}
+			conn.Close()
+		}
+	}()
+	for _, script := range []string{"echo one", "echo one; echo two; exit 3"} {
+		cmd := exec.Command("./ets", "-f", "[ts]", "--mail-to", "ops@example.com", "--mail-on", "failure", "--mail-lines", "1",
+			"--smtp-server", listener.Addr().String(), "sh", "-c", script)
+		output, _ := cmd.CombinedOutput()
+		if strings.Contains(string(output), "error sending mail") {
+			t.Errorf("unexpected output %#v", string(output))
+		}
+	}
+	select {
+	case message := <-messages:
+		for _, pattern := range []string{
+			`(?m)^From: ets@.+\r$`,
+			`(?m)^To: ops@example\.com\r$`,
+			`(?m)^Subject: ets: sh -c echo one; echo two; exit 3: exited with status 3 after [\d.]+m?s\r$`,
+			`(?m)^ets summary:\r$`,
+			`(?m)^  exit status +3\r$`,
+			`last 1 line of output:\r\n\[ts\] two\r\n$`,
+		} {
+			if !regexp.MustCompile(pattern).MatchString(message) {
+				t.Errorf("%#v does not match %s", message, pattern)
+			}
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no mail sent")
+	}
+	select {
+	case message := <-messages:
+		t.Errorf("unexpected mail %#v", message)
+	default:
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	requests := make(chan string, 10)
+	failed := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !failed {
+			failed = true
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		requests <- r.Header.Get("Content-Type") + "\n" + string(body)
+	}))
+	defer server.Close()
+	logfile := path.Join(tempdir, "webhook.log")
+	cmd := exec.Command("./ets", "--webhook", server.URL+"/hook", "-o", logfile, "sh", "-c", "echo hello; exit 2")
+	output, err := cmd.CombinedOutput()
+	if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 2 {
+		t.Fatalf("expected exit code 2, got %v", err)
+	}
+	if !strings.Contains(string(output), "503 Service Unavailable: unavailable; retrying in 1s") {
+		t.Errorf("retry not reported in %#v", string(output))
+	}
+	select {
+	case request := <-requests:
+		pattern := `^application/json\n\{"command":\["sh","-c","echo hello; exit 2"\],"exit_status":2,.*"lines":1,.*"log":"` +
+			regexp.QuoteMeta(logfile) + `"\}$`
+		if !regexp.MustCompile(pattern).MatchString(request) {
+			t.Errorf("wrong request %#v", request)
+		}
+	default:
+		t.Errorf("no summary posted")
+	}
+}
+
+func TestChatWebhooks(t *testing.T) {
+	requests := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests <- r.URL.Path + " " + string(body)
+	}))
+	defer server.Close()
+	cmd := exec.Command("./ets", "--slack-webhook", server.URL+"/slack", "--discord-webhook", server.URL+"/discord",
+		"--phase-pattern", "^==> (.*)", "--parse-timestamps", "rfc3339")
+	cmd.Stdin = strings.NewReader("2024-05-01T12:00:00Z ==> build\n2024-05-01T12:00:08Z ==> test\n" +
+		"2024-05-01T12:00:09Z ==> lint\n2024-05-01T12:00:12Z ==> docs\n")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%s: %s", err, output)
+	}
+	message := `:white_check_mark: ets: input ended after [\d.]+s\\nslowest phases: build 8\.0s, lint 3\.0s, test 1\.0s`
+	for _, pattern := range []string{`^/slack \{"text":"` + message + `"\}$`, `^/discord \{"content":"` + message + `"\}$`} {
+		select {
+		case request := <-requests:
+			if !regexp.MustCompile(pattern).MatchString(request) {
+				t.Errorf("%#v does not match %s", request, pattern)
+			}
+		default:
+			t.Errorf("no request matching %s", pattern)
+		}
+	}
+
+	cmd = exec.Command("./ets", "--slack-webhook", server.URL+"/slack", "sh", "-c", "echo x; exit 2")
+	if err := cmd.Run(); err == nil {
+		t.Error("expected exit code 2")
+	}
+	pattern := `^/slack \{"text":":x: ` + "`sh -c echo x; exit 2`" + ` exited with status 2 after [\d.]+m?s"\}$`
+	if request := <-requests; !regexp.MustCompile(pattern).MatchString(request) {
+		t.Errorf("%#v does not match %s", request, pattern)
+	}
+}
+
+func TestAlertWebhook(t *testing.T) {
+	requests := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests <- string(body)
+	}))
+	defer server.Close()
+	cmd := exec.Command("./ets", "-f", "[ts]", "--alert-webhook", server.URL, "--alert-on", "gap > 1s && lines >= 1",
+		"sh", "-c", "echo a; sleep 2.5; echo b; sleep 0.5")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "[ts] a\n[ts] [ets] alert: gap > 1s && lines >= 1\n[ts] b\n"; string(output) != expected {
+		t.Errorf("expected %#v, got %#v", expected, string(output))
+	}
+	pattern := `^\{"alert":"gap \\u003e 1s \\u0026\\u0026 lines \\u003e= 1","command":\["sh","-c",".*"\],"time":"[^"]+","elapsed":[\d.]+,"gap":[12]\.\d+,"lines":1\}$`
+	select {
+	case request := <-requests:
+		if !regexp.MustCompile(pattern).MatchString(request) {
+			t.Errorf("%#v does not match %s", request, pattern)
+		}
+	default:
+		t.Error("no alert posted")
+	}
+	select {
+	case request := <-requests:
+		t.Errorf("unexpected alert %#v", request)
+	default:
+	}
+
+	output, err = exec.Command("./ets", "--alert-webhook", server.URL, "--alert-on", "delta > 1s", "true").CombinedOutput()
+	if err == nil || !strings.Contains(string(output), "expected duration, gap, or lines") {
+		t.Errorf("expected an unknown variable to be rejected, got %#v", string(output))
+	}
+}
+
+func TestTitleAndBell(t *testing.T) {
+	cmd := exec.Command("./ets", "--set-title", "--bell", "sh", "-c", "echo out1; sleep 1.5")
+	ptmx, err := pty.StartWithSize(cmd, &pty.Winsize{Rows: 24, Cols: 80, X: 0, Y: 0})
+	if err != nil {
+		t.Fatalf("failed to start command in pty: %s", err)
+	}
+	defer func() { _ = ptmx.Close() }()
+	output, err := ioutil.ReadAll(ptmx)
+	if len(output) == 0 && err != nil {
+		t.Fatalf("failed to read pty output: %s", err)
+	}
+	if !strings.HasPrefix(string(output), "\x1b[22;0t\x1b]0;ets: sh -c echo out1; sleep 1.5 — 0s, no output yet\x07") {
+		t.Errorf("title not saved and set at the start of %#v", string(output))
+	}
+	if !regexp.MustCompile(", last output \\ds ago\x07").Match(output) {
+		t.Errorf("time since last output not found in title updates in %#v", string(output))
+	}
+	if !regexp.MustCompile("\x1b\\[23;0t\x1b\\]0;ets: sh -c echo out1; sleep 1\\.5 — exited with status 0 after [\\d.]+m?s\x07\a$").Match(output) {
+		t.Errorf("final title left in place and bell not found at the end of %#v", string(output))
+	}
+
+	// Cut short by a fatal error.
+	pidfile := path.Join(tempdir, "title.pid")
+	if err := ioutil.WriteFile(pidfile, []byte(strconv.Itoa(os.Getpid())), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(pidfile)
+	cmd = exec.Command("./ets", "--set-title", "--pidfile", pidfile, "echo", "out1")
+	ptmx, err = pty.StartWithSize(cmd, &pty.Winsize{Rows: 24, Cols: 80, X: 0, Y: 0})
+	if err != nil {
+		t.Fatalf("failed to start command in pty: %s", err)
+	}
+	defer func() { _ = ptmx.Close() }()
+	output, _ = ioutil.ReadAll(ptmx)
+	if !regexp.MustCompile("(?s)still running\r\n.*\x1b\\]0;\x07\x1b\\[23;0t$").Match(output) {
+		t.Errorf("title not restored after fatal error in %#v", string(output))
+	}
+}
+
+func TestLock(t *testing.T) {
+	lockfile := path.Join(tempdir, "lock")
+	holder := exec.Command("./ets", "--lock", lockfile, "sleep", "1")
+	if err := holder.Start(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(300 * time.Millisecond)
+
+	output, err := exec.Command("./ets", "--lock", lockfile, "--lock-mode", "fail", "-f", "[ts]", "echo", "hi").CombinedOutput()
+	if err == nil || !strings.Contains(string(output), fmt.Sprintf("lock %s is held by pid %d", lockfile, holder.Process.Pid)) {
+		t.Errorf("expected failure to take the lock, got %#v, %v", string(output), err)
+	}
+
+	output, err = exec.Command("./ets", "--lock", lockfile, "-f", "[ts]", "echo", "hi").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(fmt.Sprintf(`^\[ts\] \[ets\] waiting for lock %s held by pid %d\n\[ts\] \[ets\] acquired lock %[1]s after \d+ms\n\[ts\] hi\n$`,
+		regexp.QuoteMeta(lockfile), holder.Process.Pid)).Match(output) {
+		t.Errorf("wrong output: %#v", string(output))
+	}
+	_ = holder.Wait()
+}
+
+func TestPidfile(t *testing.T) {
+	pidfile := path.Join(tempdir, "command.pid")
+	etsPidfile := path.Join(tempdir, "ets.pid")
+	cmd := exec.Command("./ets", "--pidfile", pidfile, "--ets-pidfile", etsPidfile, "-f", "[ts]",
+		"sh", "-c", "sleep 0.2; echo $$ $PPID; cat "+pidfile+" "+etsPidfile)
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pid, ppid int
+	if _, err := fmt.Sscanf(string(output), "[ts] %d %d\n", &pid, &ppid); err != nil {
+		t.Fatalf("unexpected output %#v: %s", string(output), err)
+	}
+	if expected := fmt.Sprintf("[ts] %d %d\n[ts] %[1]d\n[ts] %[2]d\n", pid, ppid); string(output) != expected {
+		t.Errorf("expected %#v, got %#v", expected, string(output))
+	}
+	for _, f := range []string{pidfile, etsPidfile} {
+		if _, err := os.Stat(f); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed, got %v", f, err)
+		}
+	}
+
+	// A pidfile recording a running process.
+	if err := ioutil.WriteFile(pidfile, []byte(fmt.Sprintf("%d\n", os.Getpid())), 0644); err != nil {
+		t.Fatal(err)
+	}
+	output, err = exec.Command("./ets", "--pidfile", pidfile, "true").CombinedOutput()
+	if err == nil || !strings.Contains(string(output), "which is still running") {
+		t.Errorf("expected refusal to start, got %#v, %v", string(output), err)
+	}
+
+	// A stale pidfile.
+	exited := exec.Command("true")
+	if err := exited.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(pidfile, []byte(fmt.Sprintf("%d\n", exited.Process.Pid)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	output, err = exec.Command("./ets", "--pidfile", pidfile, "true").CombinedOutput()
+	if err != nil || !strings.Contains(string(output), "removing stale pidfile") {
+		t.Errorf("expected stale pidfile to be replaced, got %#v, %v", string(output), err)
+	}
+
+	// Removed on fatal errors too.
+	output, err = exec.Command("./ets", "--ets-pidfile", etsPidfile, "/nonexistent").CombinedOutput()
+	if err == nil {
+		t.Fatalf("expected failure to start the command, got %#v", string(output))
+	}
+	if _, err := os.Stat(etsPidfile); !os.IsNotExist(err) {
+		t.Errorf("expected %s to be removed after a fatal error", etsPidfile)
+	}
+}
+
+func TestDaemon(t *testing.T) {
+	logfile := path.Join(tempdir, "daemon.log")
+	start := time.Now()
+	output, err := exec.Command("./ets", "--daemon", "-o", logfile, "--summary", "-f", "[ts]",
+		"sh", "-c", "sleep 0.5; echo out1; [ -t 0 ] && echo tty").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) > 400*time.Millisecond {
+		t.Errorf("ets --daemon took %s to return", time.Since(start))
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(output)))
+	if err != nil {
+		t.Fatalf("expected pid, got %#v", string(output))
+	}
+	if sid, _, errno := syscall.RawSyscall(syscall.SYS_GETSID, uintptr(pid), 0, 0); errno != 0 || int(sid) != pid {
+		t.Errorf("expected daemon %d to lead its own session, got %d, %v", pid, sid, errno)
+	}
+	for i := 0; i < 50 && syscall.Kill(pid, 0) == nil; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	content, err := ioutil.ReadFile(logfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`^\[ts\] out1\n\[ts\] tty\nets summary:\n  command +sh -c .*\n  exit status +0\n`).Match(content) {
+		t.Errorf("wrong log: %#v", string(content))
+	}
+}
+
+func TestSplitOutput(t *testing.T) {
+	logfile := path.Join(tempdir, "chunks.log")
+	chunk := func(n int) string {
+		return path.Join(tempdir, fmt.Sprintf("chunks.%04d.log", n))
+	}
+	expected := []string{"[ts] a\n[ts] b\n", "[ts] c\n[ts] d\n", "[ts] e\n", "[ts] f\n"}
+	for _, input := range []string{"a\nb\nc\nd\ne\n", "f\n"} {
+		cmd := exec.Command("./ets", "-f", "[ts]", "-o", logfile, "--split-size", "16")
+		cmd.Stdin = strings.NewReader(input)
+		if err := cmd.Run(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, content := range expected {
+		actual, err := ioutil.ReadFile(chunk(i + 1))
+		if err != nil {
+			t.Error(err)
+		} else if string(actual) != content {
+			t.Errorf("expected %#v in %s, got %#v", content, chunk(i+1), string(actual))
+		}
+	}
+	if _, err := os.Stat(logfile); !os.IsNotExist(err) {
+		t.Errorf("expected no %s, got %v", logfile, err)
+	}
+
+	logfile = path.Join(tempdir, "interval")
+	if err := exec.Command("./ets", "-f", "[ts]", "-o", logfile, "--split-interval", "1s",
+		"sh", "-c", "echo a; sleep 1.2; echo b").Run(); err != nil {
+		t.Fatal(err)
+	}
+	for i, content := range []string{"[ts] a\n", "[ts] b\n"} {
+		file := fmt.Sprintf("%s.%04d", logfile, i+1)
+		if actual, err := ioutil.ReadFile(file); err != nil || string(actual) != content {
+			t.Errorf("expected %#v in %s, got %#v, %v", content, file, string(actual), err)
+		}
+	}
+
+	output, err := exec.Command("./ets", "--split-size", "1X", "-o", logfile, "true").CombinedOutput()
+	if err == nil || !strings.Contains(string(output), "invalid --split-size") {
+		t.Errorf("expected an invalid size to be rejected, got %#v", string(output))
+	}
+}
+
+func TestSdNotify(t *testing.T) {
+	socket := path.Join(tempdir, "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	messages := make(chan string)
+	go func() {
+		buf := make([]byte, 256)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				close(messages)
+				return
+			}
+			messages <- string(buf[:n])
+		}
+	}()
+
+	cmd := exec.Command("./ets", "--sd-notify", "--ready-pattern", "^listening", "-f", "[ts]",
+		"sh", "-c", "echo starting; sleep 0.3; echo listening; sleep 0.6; echo socket=$NOTIFY_SOCKET")
+	cmd.Env = append(os.Environ(), "NOTIFY_SOCKET="+socket, "WATCHDOG_USEC=400000")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "[ts] starting\n[ts] listening\n[ts] socket=\n"; string(output) != expected {
+		t.Errorf("expected %#v, got %#v", expected, string(output))
+	}
+	received := make([]string, 0)
+	for message := range messages {
+		received = append(received, message)
+		if message == "STOPPING=1" {
+			break
+		}
+	}
+	counts := make(map[string]int)
+	for _, message := range received {
+		counts[message]++
+	}
+	// Pings are due every 200ms, but skipped while the command is silent,
+	// from 300ms to 900ms.
+	if counts["READY=1"] != 1 || counts["WATCHDOG=1"] < 1 || counts["WATCHDOG=1"] > 3 ||
+		received[len(received)-1] != "STOPPING=1" {
+		t.Errorf("unexpected messages %#v", received)
+	}
+	if received[0] == "READY=1" {
+		t.Errorf("expected readiness to wait for the ready pattern, got messages %#v", received)
+	}
+}
+
+func TestCron(t *testing.T) {
+	output, err := exec.Command("./ets", "cron", "*/5 * * 13 *", "true").CombinedOutput()
+	if err == nil || !strings.Contains(string(output), `invalid month in schedule "*/5 * * 13 *"`) {
+		t.Errorf("expected invalid schedule to be rejected, got %#v, %v", string(output), err)
+	}
+
+	if testing.Short() {
+		t.Skip("skipping slow test in short mode")
+	}
+	logfile := path.Join(tempdir, "cron.log")
+	if err := ioutil.WriteFile(logfile, []byte("previous run\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command("./ets", "cron", "-f", "[ts]", "-o", logfile, "--keep", "1", "* * * * *", "--", "echo", "hi")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	// Runs start at the top of the minute.
+	deadline := time.Now().Truncate(time.Minute).Add(time.Minute + 2*time.Second)
+	time.Sleep(time.Until(deadline))
+	_ = cmd.Process.Signal(syscall.SIGTERM)
+	if err := cmd.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadFile(logfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`^\[ts\] \[ets\] run 1 started\n\[ts\] hi\n\[ts\] \[ets\] run 1 finished: exit status 0 after \d+ms, 1 line\nets summary:\n  command +echo hi\n  exit status +0\n  duration +\d+ms\n  runs +1; duration min \d+ms, mean \d+ms, max \d+ms; lines min 1, mean 1\.0, max 1\n`).Match(content) {
+		t.Errorf("wrong log: %#v", string(content))
+	}
+	if content, _ := ioutil.ReadFile(logfile + ".1"); string(content) != "previous run\n" {
+		t.Errorf("expected previous log to be rotated, got %#v", string(content))
+	}
+}
+
+func TestUntilSuccess(t *testing.T) {
+	counter := path.Join(tempdir, "attempts")
+	script := "n=$(cat " + counter + " 2>/dev/null || echo 0); n=$((n+1)); echo $n >" + counter + "; echo try $n; [ $n -ge 3 ]"
+
+	cmd := exec.Command("./ets", "--until-success", "--retry-delay", "100ms", "--summary", "-f", "[ts]", "sh", "-c", script)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`^\[ts\] \[ets\] attempt 1 started
+\[ts\] try 1
+\[ts\] \[ets\] attempt 1 failed: exit status 1 after \d+ms, 1 line; retrying in 100ms
+\[ts\] \[ets\] attempt 2 started
+\[ts\] try 2
+\[ts\] \[ets\] attempt 2 failed: exit status 1 after \d+ms, 1 line \([+-]\d+m?s, \+0 lines from previous\); retrying in 100ms
+\[ts\] \[ets\] attempt 3 started
+\[ts\] try 3
+\[ts\] \[ets\] attempt 3 succeeded: exit status 0 after \d+ms, 1 line \([+-]\d+m?s, \+0 lines from previous\)
+$`).Match(output) {
+		t.Errorf("wrong output: %#v", string(output))
+	}
+	if !regexp.MustCompile(`(?m)^  attempt +1: exit status 1 after \d+ms, 1 line\n  attempt +2: exit status 1 after .*\n  attempt +3: exit status 0 after .*\n  attempts +3; duration min \d+ms, mean \d+ms, max \d+ms; lines min 1, mean 1\.0, max 1$`).MatchString(stderr.String()) {
+		t.Errorf("attempts not found in summary %#v", stderr.String())
+	}
+
+	_ = os.Remove(counter)
+	cmd = exec.Command("./ets", "--until-success", "--max-attempts", "2", "--retry-delay", "0", "-f", "[ts]", "sh", "-c", script)
+	output, err = cmd.Output()
+	if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 1 {
+		t.Errorf("expected exit code 1, got %v", err)
+	}
+	if !regexp.MustCompile(`\[ts\] \[ets\] attempt 2 failed: exit status 1 after .*; giving up\n$`).Match(output) {
+		t.Errorf("wrong output: %#v", string(output))
+	}
+}
+
+func TestDiff(t *testing.T) {
+	before := path.Join(tempdir, "before.log")
+	after := path.Join(tempdir, "after.log")
+	writeLog := func(name string, content string) {
+		if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeLog(before, `[2020-07-03 10:00:00] ==> fetch
+[2020-07-03 10:00:01] fetched 12 packages
+[2020-07-03 10:00:02] [ets] annotation
+[2020-07-03 10:00:02] ==> build
+[2020-07-03 10:00:10] built in 8s
+[2020-07-03 10:00:11] ==> test
+[2020-07-03 10:00:20] 42 tests passed
+ets summary:
+  lines  7
+`)
+	writeLog(after, `[2020-07-03 23:59:58] ==> fetch
+[2020-07-03 23:59:59] fetched 13 packages
+[2020-07-04 00:00:00] ==> build
+[2020-07-04 00:00:20] built in 20s
+[2020-07-04 00:00:21] ==> test
+[2020-07-04 00:00:26] 43 tests passed
+`)
+
+	cmd := exec.Command("./ets", "diff", before, after)
+	output, err := cmd.Output()
+	if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 1 {
+		t.Errorf("expected exit code 1, got %v", err)
+	}
+	expected := fmt.Sprintf(`slower    +12s  +150%%  8s -> 20s  before "built in 8s" (%[1]s:5, %[2]s:4)
+faster     -4s   -44%%  9s -> 5s  before "42 tests passed" (%[1]s:7, %[2]s:6)
+total      +8s   +40%%  20s -> 28s
+`, before, after)
+	if string(output) != expected {
+		t.Errorf("wrong output: expected %#v, got %#v", expected, string(output))
+	}
+
+	cmd = exec.Command("./ets", "diff", "--phase-pattern", "^==> (.*)", "--threshold", "50", before, after)
+	output, err = cmd.Output()
+	if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 1 {
+		t.Errorf("expected exit code 1, got %v", err)
+	}
+	expected = `slower    +12s  +133%  9s -> 21s  phase "build"
+total      +8s   +40%  20s -> 28s
+`
+	if string(output) != expected {
+		t.Errorf("wrong output: expected %#v, got %#v", expected, string(output))
+	}
+
+	cmd = exec.Command("./ets", "diff", "-s", "-f", "%s", after, before)
+	if _, err := cmd.Output(); err == nil || !strings.Contains(err.Error(), "exit status 1") {
+		t.Errorf("expected failure on logs without matching timestamps, got %v", err)
+	}
+
+	for _, name := range []string{before, after} {
+		content, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeLog(name, regexp.MustCompile(`\d{4}-\d{2}-\d{2} `).ReplaceAllString(string(content), ""))
+	}
+	cmd = exec.Command("./ets", "diff", "-f", "[%T]", "--threshold", "100", after, before)
+	output, err = cmd.Output()
+	if err != nil {
+		t.Errorf("expected no regression the other way around, got %v", err)
+	}
+	if !strings.HasSuffix(string(output), "total      -8s   -29%  28s -> 20s\n") {
+		t.Errorf("wrong output: %#v", string(output))
+	}
+}
+
+func TestBaseline(t *testing.T) {
+	baselineFile := path.Join(tempdir, "baseline.json")
+	build := func(seconds string) *exec.Cmd {
+		return exec.Command("./ets", "--baseline", baselineFile, "--phase-pattern", "^==> (.*)", "--min-change", "100ms", "-f", "[ts]",
+			"sh", "-c", "echo '==> setup'; echo '==> build'; sleep "+seconds+"; echo done")
+	}
+
+	cmd := build("0.2")
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stderr.String(), "no baseline at "+baselineFile+" yet") {
+		t.Errorf("expected missing baseline to be reported, got %#v", stderr.String())
+	}
+
+	cmd = build("0.2")
+	cmd.Args = append(cmd.Args[:1], append([]string{"--update-baseline"}, cmd.Args[1:]...)...)
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadFile(baselineFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`(?s)"phases": \[\s*\{\s*"name": "setup",\s*"duration": [\d.e-]+\s*\},\s*\{\s*"name": "build",\s*"duration": 0\.2\d*\s*\}\s*\]`).Match(content) {
+		t.Errorf("wrong baseline: %s", content)
+	}
+
+	cmd = build("0.2")
+	stderr.Reset()
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		t.Errorf("expected no regression, got %v: %s", err, stderr.String())
+	}
+
+	cmd = build("0.6")
+	stderr.Reset()
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 1 {
+		t.Errorf("expected exit code 1, got %v", err)
+	}
+	if !regexp.MustCompile(`(?m)^ets baseline .*:\nslower +\+\d+ms +\+\d+%  \d+ms -> \d+ms  phase "build"\ntotal +\+\d+ms`).MatchString(stderr.String()) {
+		t.Errorf("regression not reported: %#v", stderr.String())
+	}
+
+	cmd = exec.Command("./ets", "--baseline", baselineFile, "--regression-threshold", "twenty", "true")
+	if err := cmd.Run(); err == nil {
+		t.Error("expected invalid --regression-threshold to be rejected")
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	cmd := exec.Command("./ets", "--histogram", "-q", "sh", "-c", "echo 1; echo 2; echo 3; sleep 0.2; echo 4; sleep 1.1; echo 5")
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`\nets gap histogram:
+ +(< 1ms|1ms - 10ms) +#+ +2
+(.*\n)* +100ms - 1s  #+ +1
+ +1s - 10s  #+ +1
+$`).MatchString(stderr.String()) {
+		t.Errorf("wrong histogram: %#v", stderr.String())
+	}
+
+	cmd = exec.Command("./ets", "--histogram", "-q", "echo", "once")
+	stderr.Reset()
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(stderr.String(), "\nets gap histogram:\n  no gaps between lines\n") {
+		t.Errorf("wrong histogram: %#v", stderr.String())
+	}
+}
+
+func TestSparkline(t *testing.T) {
+	script := "echo start; sleep 0.5; for i in 1 2 3 4 5; do echo $i; done"
+	cmd := exec.Command("./ets", "--sparkline", "-f", "[ts]", "sh", "-c", script)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	m := regexp.MustCompile(`(?m)^  sparkline +([▁▂▃▄▅▆▇█ ]+)  \(max [\d.]+ lines/s, (\d+)ms per char\)$`).FindStringSubmatch(stderr.String())
+	if m == nil {
+		t.Fatalf("sparkline not found in summary %#v", stderr.String())
+	}
+	bars := []rune(m[1])
+	if len(bars) < 20 || len(bars) > 40 {
+		t.Errorf("expected 20 to 40 characters, got %d: %q", len(bars), m[1])
+	}
+	if bars[0] == ' ' || !strings.ContainsRune(m[1], ' ') || !strings.ContainsRune(m[1], '█') {
+		t.Errorf("expected output at the start, a silence, and a burst: %q", m[1])
+	}
+
+	cmd = exec.Command("./ets", "--sparkline=gaps", "-q", "sh", "-c", script)
+	stderr.Reset()
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`(?m)^  sparkline +[▁▂▃▄▅▆▇█]+  \(max gap 5\d\dms, \d+ms per char\)$`).MatchString(stderr.String()) {
+		t.Errorf("gaps sparkline not found in summary %#v", stderr.String())
+	}
+
+	if err := exec.Command("./ets", "--sparkline=lines", "true").Run(); err == nil {
+		t.Error("expected invalid --sparkline mode to be rejected")
+	}
+}
+
+func TestGapPercentiles(t *testing.T) {
+	summaryFile := path.Join(tempdir, "summary.json")
+	script := "for i in 1 2 3 4 5 6 7 8 9; do echo $i; done; sleep 0.5; echo '==> two'; sleep 0.2; echo end"
+	cmd := exec.Command("./ets", "-q", "--phase-pattern", "^==> (.*)", "--summary-json", summaryFile, "sh", "-c", "echo '==> one'; "+script)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`(?m)^  gaps +p50 \d+m?s, p90 \d+ms, p99 5\d\dms, max 5\d\dms$`).MatchString(stderr.String()) {
+		t.Errorf("gap percentiles not found in summary %#v", stderr.String())
+	}
+	if !regexp.MustCompile(`(?m)^  phase durations +p50 \d+ms, p90 \d+ms, p99 \d+ms, max 5\d\dms$`).MatchString(stderr.String()) {
+		t.Errorf("phase percentiles not found in summary %#v", stderr.String())
+	}
+
+	content, err := ioutil.ReadFile(summaryFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pattern := range []string{
+		`"exit_status": 0,`,
+		`"lines": 12,`,
+		`"gaps": \{\s*"p50": [\d.e-]+,\s*"p90": [\d.e-]+,\s*"p99": 0\.5\d*,\s*"max": 0\.5\d*\s*\}`,
+		`"phases": \[\s*\{\s*"name": "one",\s*"start": "[^"]+",\s*"end": "[^"]+",\s*"duration": 0\.5\d*,\s*"lines": 10\s*\},\s*\{\s*"name": "two",`,
+		`"phase_durations": \{\s*"p50": 0\.2\d*,`,
+	} {
+		if !regexp.MustCompile(pattern).Match(content) {
+			t.Errorf("%s not found in JSON summary %s", pattern, content)
+		}
+	}
+}
+
+func TestPhasesJSON(t *testing.T) {
+	phasesFile := path.Join(tempdir, "phases.json")
+	cmd := exec.Command("./ets", "--phase-pattern", "^==> (.*)", "--phases-json", phasesFile, "sh", "-c",
+		"echo setup; echo '==> build'; echo compiling; sleep 0.2; echo '==> test'; echo ok")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadFile(phasesFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := `"\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d+(Z|[+-]\d{2}:\d{2})"`
+	if !regexp.MustCompile(`^\[
+  \{
+    "name": "build",
+    "start": ` + ts + `,
+    "end": ` + ts + `,
+    "duration": 0\.2\d*,
+    "lines": 2
+  \},
+  \{
+    "name": "test",
+    "start": ` + ts + `,
+    "end": ` + ts + `,
+    "duration": [\d.e-]+,
+    "lines": 2
+  \}
+\]
+$`).Match(content) {
+		t.Errorf("wrong phases: %s", content)
+	}
+
+	if err := exec.Command("./ets", "--phases-json", phasesFile, "true").Run(); err == nil {
+		t.Error("expected --phases-json without phase detection to be rejected")
+	}
+}
+
+func TestSplitByPhase(t *testing.T) {
+	dir := path.Join(tempdir, "phases")
+	cmd := exec.Command("./ets", "-q", "-z", "UTC", "-f", "%T", "--phase-pattern", "^==> (.*)", "--split-by-phase", dir,
+		"--parse-timestamps", "rfc3339")
+	cmd.Stdin = strings.NewReader("2024-05-01T12:00:00Z setup\n2024-05-01T12:00:01Z ==> build\n2024-05-01T12:00:02Z compiling\n" +
+		"2024-05-01T12:00:03Z ==> unit tests\n2024-05-01T12:00:05Z ok\n2024-05-01T12:00:05Z ==> build\n")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"build-20240501T120001.log":      "12:00:01 ==> build\n12:00:02 compiling\n",
+		"unit_tests-20240501T120003.log": "12:00:03 ==> unit tests\n12:00:05 ok\n",
+		"build-20240501T120005.log":      "12:00:05 ==> build\n",
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != len(expected) {
+		t.Errorf("expected %d files, got %d", len(expected), len(files))
+	}
+	for name, content := range expected {
+		actual, err := ioutil.ReadFile(path.Join(dir, name))
+		if err != nil {
+			t.Error(err)
+		} else if string(actual) != content {
+			t.Errorf("expected %#v in %s, got %#v", content, name, string(actual))
+		}
+	}
+
+	if err := exec.Command("./ets", "--split-by-phase", dir, "true").Run(); err == nil {
+		t.Error("expected --split-by-phase without phase detection to be rejected")
+	}
+}
+
+func TestFoldedOut(t *testing.T) {
+	foldedFile := path.Join(tempdir, "stacks.folded")
+	cmd := exec.Command("./ets", "--phase-pattern", "^==> (.*)", "--subphase-pattern", "^  -> (.*)", "--folded-out", foldedFile, "sh", "-c",
+		"sleep 0.1; echo '==> build'; sleep 0.1; echo '  -> compile'; sleep 0.3; echo '  -> link; strip'; sleep 0.2; echo '==> test'; sleep 0.2")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadFile(foldedFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`^sh -c sleep 0\.1.* 1\d\d
+sh -c .*;build 1\d\d
+sh -c .*;build;compile [23]\d\d
+sh -c .*;build;link, strip [12]\d\d
+sh -c .*;test [12]\d\d
+$`).Match(content) {
+		t.Errorf("wrong folded stacks: %s", content)
+	}
+
+	if err := exec.Command("./ets", "--subphase-pattern", "x", "true").Run(); err == nil {
+		t.Error("expected --subphase-pattern without --folded-out to be rejected")
+	}
+}
+
+func TestRateDirective(t *testing.T) {
+	cmd := exec.Command("./ets", "-f", "[%Q/s]")
+	cmd.Stdin = strings.NewReader("a\nb\nc\n")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(output) != "[  10.0/s] a\n[  20.0/s] b\n[  30.0/s] c\n" {
+		t.Errorf("wrong output: %#v", string(output))
+	}
+
+	cmd = exec.Command("./ets", "-s", "-f", "%T %Q", "sh", "-c", "echo a; sleep 0.5; echo b")
+	output, err = cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`^00:00:00  +\d+\.\d a\n00:00:00 +[1-4]\.\d b\n$`).Match(output) {
+		t.Errorf("wrong output: %#v", string(output))
+	}
+}
+
+func TestSmoothedDeltaDirective(t *testing.T) {
+	cmd := exec.Command("./ets", "-i", "-f", "%~", "sh", "-c", "sleep 0.2; echo a; echo b; sleep 1; echo c")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 0.2, then 0.9*0.2, then 0.9*0.18 + 0.1*1.
+	if !regexp.MustCompile(`^  0\.2\d\d a\n  0\.1[89]\d b\n  0\.2[67]\d c\n$`).Match(output) {
+		t.Errorf("wrong output: %#v", string(output))
+	}
+}
+
+func TestQualifiedDirectives(t *testing.T) {
+	cmd := exec.Command("./ets", "-f", "%Y %{elapsed}T %{delta}S %%{x}", "sh", "-c", "echo a; sleep 1.1; echo b")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`^\d{4} 00:00:00 00 %\{x\} a\n\d{4} 00:00:01 01 %\{x\} b\n$`).Match(output) {
+		t.Errorf("wrong output: %#v", string(output))
+	}
+
+	cmd = exec.Command("./ets", "-s", "-f", "%T %{absolute}Y", "echo", "a")
+	output, err = cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`^00:00:00 \d{4} a\n$`).Match(output) {
+		t.Errorf("wrong output: %#v", string(output))
+	}
+
+	cmd = exec.Command("./ets", "-f", "%{later}T", "true")
+	output, err = cmd.CombinedOutput()
+	if err == nil || !strings.Contains(string(output), "unknown mode %{later}") {
+		t.Errorf("expected error, got %#v", string(output))
+	}
+}
+
+func TestDaysDirective(t *testing.T) {
+	cmd := exec.Command("./ets", "-s", "-f", "[%Jd %T]", "--since", "50h", "echo", "a")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "[2d 02:00:00] a\n"; string(output) != expected {
+		t.Errorf("expected %#v, got %#v", expected, string(output))
+	}
+}
+
+func TestRelativeDates(t *testing.T) {
+	cmd := exec.Command("./ets", "--relative-dates", "--parse-timestamps", "rfc3339")
+	today := time.Now()
+	yesterday := today.AddDate(0, 0, -1).Add(-time.Minute)
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("%s a\n%s b\n%s c\n",
+		yesterday.Format(time.RFC3339), yesterday.Format(time.RFC3339), today.Format(time.RFC3339)))
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Day markers carry the time of the line starting the day, not that of
+	// the wall clock.
+	expected := fmt.Sprintf("[yesterday %[1]s] [ets] ===== %[2]s =====\n"+
+		"[yesterday %[1]s] a\n[yesterday %[1]s] b\n"+
+		"[today %[3]s] [ets] ===== %[4]s =====\n[today %[3]s] c\n",
+		yesterday.Format("15:04:05"), yesterday.Format("Monday, 2006-01-02"),
+		today.Format("15:04:05"), today.Format("Monday, 2006-01-02"))
+	if string(output) != expected {
+		t.Errorf("expected %#v, got %#v", expected, string(output))
+	}
+}
+
+func TestISOWeekDirectives(t *testing.T) {
+	cmd := exec.Command("./ets", "-z", "UTC", "-f", "%G-W%V-%u %g %j %Y", "--parse-timestamps", "rfc3339")
+	cmd.Stdin = strings.NewReader("2022-01-01T12:00:00Z a\n2024-12-30T12:00:00Z b\n")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "2021-W52-6 21 001 2022 a\n2025-W01-1 25 365 2024 b\n"; string(output) != expected {
+		t.Errorf("expected %#v, got %#v", expected, string(output))
+	}
+}
+
+func TestColonOffsetDirectives(t *testing.T) {
+	cmd := exec.Command("./ets", "-z", "Asia/Kolkata", "-f", "%FT%T%:z %::z %z %Z", "--parse-timestamps", "rfc3339")
+	cmd.Stdin = strings.NewReader("2024-05-01T12:00:00Z a\n")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "2024-05-01T17:30:00+05:30 +05:30:00 +0530 IST a\n"; string(output) != expected {
+		t.Errorf("expected %#v, got %#v", expected, string(output))
+	}
+
+	cmd = exec.Command("./ets", "-f", "%:y", "true")
+	output, err = cmd.CombinedOutput()
+	if err == nil || !strings.Contains(string(output), "expected %:z or %::z") {
+		t.Errorf("expected an error for %%:y, got %#v", string(output))
+	}
+}
+
+func TestAdaptiveDelta(t *testing.T) {
+	cmd := exec.Command("./ets", "-i", "--adaptive-delta", "--parse-timestamps", "rfc3339")
+	cmd.Stdin = strings.NewReader("2024-05-01T12:00:00Z a\n2024-05-01T12:00:00.012Z b\n2024-05-01T12:00:03.412Z c\n" +
+		"2024-05-01T12:01:06.612Z d\n2024-05-01T14:06:06.612Z e\n")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "[+0ms] a\n[+12ms] b\n[+3.4s] c\n[+1m 03.2s] d\n[+2h 05m 00.0s] e\n"; string(output) != expected {
+		t.Errorf("expected %#v, got %#v", expected, string(output))
+	}
+}
+
+func TestSecondTimezone(t *testing.T) {
+	cmd := exec.Command("./ets", "-z", "UTC", "--second-timezone", "America/New_York", "--parse-timestamps", "rfc3339")
+	cmd.Stdin = strings.NewReader("2024-05-01T14:03:05Z a\n2024-09-01T02:00:00Z b\n")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "[2024-05-01 14:03:05 UTC | 10:03:05 EDT] a\n[2024-09-01 02:00:00 UTC | 22:00:00 EDT] b\n"; string(output) != expected {
+		t.Errorf("expected %#v, got %#v", expected, string(output))
+	}
+
+	cmd = exec.Command("./ets", "-s", "-f", "%T %{tz2}F", "--second-timezone", "Asia/Tokyo", "--parse-timestamps", "rfc3339")
+	cmd.Stdin = strings.NewReader("2024-05-01T20:00:00Z a\n")
+	output, err = cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "00:00:00 2024-05-02 a\n"; string(output) != expected {
+		t.Errorf("expected %#v, got %#v", expected, string(output))
+	}
+
+	cmd = exec.Command("./ets", "-f", "%{tz2}T", "true")
+	output, err = cmd.CombinedOutput()
+	if err == nil || !strings.Contains(string(output), "require --second-timezone") {
+		t.Errorf("expected an error without --second-timezone, got %#v", string(output))
+	}
+}
+
+func TestDSTTransitions(t *testing.T) {
+	cmd := exec.Command("./ets", "-z", "America/New_York", "-f", "%T %Z", "--parse-timestamps", "rfc3339")
+	cmd.Stdin = strings.NewReader("2024-03-10T06:59:00Z a\n2024-03-10T07:01:00Z b\n2024-11-03T05:59:00Z c\n2024-11-03T06:01:00Z d\n")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Annotations carry the time of the line they precede, not that of the
+	// wall clock.
+	expected := "01:59:00 EST a\n" +
+		"03:01:00 EDT [ets] DST transition in America/New_York: clocks went forward 1h0m0s, from EST (-05:00) to EDT (-04:00)\n" +
+		"03:01:00 EDT b\n01:59:00 EDT c\n" +
+		"01:01:00 EST [ets] DST transition in America/New_York: clocks went back 1h0m0s, from EDT (-04:00) to EST (-05:00)\n" +
+		"01:01:00 EST d\n"
+	if string(output) != expected {
+		t.Errorf("expected %#v, got %#v", expected, string(output))
+	}
+
+	// Likewise for elapsed timestamps, measured from the first line.
+	cmd = exec.Command("./ets", "-s", "-f", "%T", "--squash-repeats", "1h", "--parse-timestamps", "rfc3339")
+	cmd.Stdin = strings.NewReader("2024-03-10T06:59:00Z a\n2024-03-10T07:00:00Z a\n2024-03-10T07:02:00Z b\n")
+	output, err = cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`^00:00:00 a\n00:03:00 b\n00:03:00 \[ets\] seen 1 more time since \d\d:\d\d:\d\d: a\n$`).Match(output) {
+		t.Errorf("wrong output: %#v", string(output))
+	}
+}
+
+func TestZoneRendering(t *testing.T) {
+	// To the second around the transitions of both zones, which are looked
+	// up once per zone.
+	cmd := exec.Command("./ets", "-z", "America/New_York", "--second-timezone", "Europe/London",
+		"-f", "%T %Z %z | %{tz2}T %{tz2}Z %{tz2}z", "--parse-timestamps", "rfc3339")
+	cmd.Stdin = strings.NewReader("2024-03-10T06:59:58Z a\n2024-03-10T06:59:59Z b\n2024-03-10T07:00:00Z c\n" +
+		"2024-03-31T00:59:59Z d\n2024-03-31T01:00:00Z e\n2024-11-03T05:59:59Z f\n2024-11-03T06:00:00Z g\n")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for _, line := range strings.SplitAfter(string(output), "\n") {
+		if !strings.Contains(line, "[ets]") {
+			lines = append(lines, line)
+		}
+	}
+	expected := "01:59:58 EST -0500 | 06:59:58 GMT +0000 a\n" +
+		"01:59:59 EST -0500 | 06:59:59 GMT +0000 b\n" +
+		"03:00:00 EDT -0400 | 07:00:00 GMT +0000 c\n" +
+		"20:59:59 EDT -0400 | 00:59:59 GMT +0000 d\n" +
+		"21:00:00 EDT -0400 | 02:00:00 BST +0100 e\n" +
+		"01:59:59 EDT -0400 | 05:59:59 GMT +0000 f\n" +
+		"01:00:00 EST -0500 | 06:00:00 GMT +0000 g\n"
+	if strings.Join(lines, "") != expected {
+		t.Errorf("expected %#v, got %#v", expected, string(output))
+	}
+}
+
+func TestDiffZoneAbbreviations(t *testing.T) {
+	before := path.Join(tempdir, "before-dst.log")
+	after := path.Join(tempdir, "after-dst.log")
+	// Both runs cross the end of DST, when 01:00-02:00 is repeated.
+	if err := ioutil.WriteFile(before, []byte("[2024-11-03 01:50:00 EDT] start\n[2024-11-03 01:10:00 EST] done\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(after, []byte("[2024-11-03 01:50:00 EDT] start\n[2024-11-03 01:20:00 EST] done\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command("./ets", "diff", "-z", "America/New_York", "-f", "[%F %T %Z]", before, after)
+	output, err := cmd.Output()
+	if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 1 {
+		t.Errorf("expected exit code 1, got %v", err)
+	}
+	expected := fmt.Sprintf(`slower  +10m0s   +50%%  20m0s -> 30m0s  before "done" (%s:2, %s:2)
+total   +10m0s   +50%%  20m0s -> 30m0s
+`, before, after)
+	if string(output) != expected {
+		t.Errorf("wrong output: expected %#v, got %#v", expected, string(output))
+	}
+}
+
+func TestHuman(t *testing.T) {
+	cmd := exec.Command("./ets", "--human", "--parse-timestamps", "rfc3339")
+	cmd.Stdin = strings.NewReader("2024-05-01T12:00:00Z a\n2024-05-01T12:00:02.312Z b\n2024-05-01T12:00:45.4Z c\n" +
+		"2024-05-01T12:03:12Z d\n2024-05-01T13:05:40Z e\n")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "[+0.0s] a\n[+2.3s] b\n[+45s] c\n[+3m12s] d\n[+1h05m] e\n"; string(output) != expected {
+		t.Errorf("expected %#v, got %#v", expected, string(output))
+	}
+}
+
+func TestMarkerPattern(t *testing.T) {
+	cmd := exec.Command("./ets", "-s", "--marker-pattern", "^==> ", "--parse-timestamps", "rfc3339")
+	cmd.Stdin = strings.NewReader("2024-05-01T12:00:00Z a\n2024-05-01T12:00:03Z ==> b\n2024-05-01T12:00:05Z c\n" +
+		"2024-05-01T12:01:00Z ==> d\n2024-05-01T12:01:07Z e\n")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "[00:00:00 +00:00:00] a\n[00:00:03 +00:00:00] ==> b\n[00:00:05 +00:00:02] c\n" +
+		"[00:01:00 +00:00:00] ==> d\n[00:01:07 +00:00:07] e\n"
+	if string(output) != expected {
+		t.Errorf("expected %#v, got %#v", expected, string(output))
+	}
+
+	cmd = exec.Command("./ets", "--marker-pattern", "x", "-f", "%T", "true")
+	output, err = cmd.CombinedOutput()
+	if err == nil || !strings.Contains(string(output), "requires %{mark} directives") {
+		t.Errorf("expected an error without %%{mark} directives, got %#v", string(output))
+	}
+}
+
+func TestMarkEvery(t *testing.T) {
+	cmd := exec.Command("./ets", "-f", "%T", "--mark-every", "1s", "sh", "-c", "echo a; echo b; sleep 1.5; echo c")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The separators fall on the seconds, wherever that is between lines.
+	separator := regexp.MustCompile(`(?m)^\d\d:\d\d:\d\d \[ets\] ----- \d+ lines? since \d\d:\d\d:\d\d -----$`)
+	if !separator.Match(output) || !regexp.MustCompile(`(?m)^\d\d:\d\d:\d\d c\r?$`).Match(output) {
+		t.Errorf("wrong output: %#v", string(output))
+	}
+}
+
+func TestAnnotateFIFO(t *testing.T) {
+	fifo := path.Join(tempdir, "notes.fifo")
+	cmd := exec.Command("./ets", "-f", "%T", "--summary", "--annotate-fifo", fifo,
+		"sh", "-c", "echo a; sleep 0.2; echo 'restarted the DB now' > "+fifo+"; sleep 0.2; echo b")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := regexp.MustCompile(`^\d\d:\d\d:\d\d a\r?\n\d\d:\d\d:\d\d \[ets\] NOTE: restarted the DB now\n\d\d:\d\d:\d\d b\r?\n` +
+		`(?s:.*)\n  note +\d\d:\d\d:\d\d \(\+\d+ms\): restarted the DB now\n`)
+	if !expected.Match(output) {
+		t.Errorf("wrong output: %#v", string(output))
+	}
+	if _, err := os.Stat(fifo); !os.IsNotExist(err) {
+		t.Errorf("expected the FIFO to be removed, got %v", err)
+	}
+}
+
+func TestRound(t *testing.T) {
+	cmd := exec.Command("./ets", "-s", "-f", "%T.%L", "--truncate", "1s", "sh", "-c", "sleep 0.2; echo a")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "00:00:00.000 a\n"; string(output) != expected {
+		t.Errorf("expected %#v, got %#v", expected, string(output))
+	}
+
+	cmd = exec.Command("./ets", "-i", "-f", "%T.%L", "--round", "100ms", "sh", "-c", "sleep 0.33; echo a")
+	output, err = cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`^00:00:00\.[345]00 a\n$`).Match(output) {
+		t.Errorf("wrong output: %#v", string(output))
+	}
+
+	cmd = exec.Command("./ets", "-f", "%S.%L", "--truncate", "1m", "echo", "a")
+	output, err = cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "00.000 a\n"; string(output) != expected {
+		t.Errorf("expected %#v, got %#v", expected, string(output))
+	}
+}
+
+func TestDedupTimestamps(t *testing.T) {
+	cmd := exec.Command("./ets", "-s", "-f", "[%T]", "--dedup-ts", "sh", "-c", "echo a; echo b; sleep 1.2; echo c")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "[00:00:00] a\n           b\n[00:00:01] c\n"; string(output) != expected {
+		t.Errorf("expected %#v, got %#v", expected, string(output))
+	}
+}
+
+func TestQuietFast(t *testing.T) {
+	cmd := exec.Command("./ets", "-i", "-f", "[%T]", "--quiet-fast", "500ms", "sh", "-c", "echo a; echo b; sleep 1.2; echo c")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "           a\n           b\n[00:00:01] c\n"; string(output) != expected {
+		t.Errorf("expected %#v, got %#v", expected, string(output))
+	}
+
+	cmd = exec.Command("./ets", "--quiet-fast", "500ms", "true")
+	output, err = cmd.CombinedOutput()
+	if err == nil || !strings.Contains(string(output), "--quiet-fast requires --incremental") {
+		t.Errorf("expected error, got %#v", string(output))
+	}
+}
+
+func TestElapsedFrom(t *testing.T) {
+	cmd := exec.Command("./ets", "-s", "-f", "[%T]", "--elapsed-from", "first-output", "sh", "-c", "sleep 1.2; echo a; echo b")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "[00:00:00] a\n[00:00:00] b\n"; string(output) != expected {
+		t.Errorf("expected %#v, got %#v", expected, string(output))
+	}
+
+	cmd = exec.Command("./ets", "-s", "-f", "[%T]", "--elapsed-from", "exec", "sh", "-c", "sleep 1.2; echo a")
+	output, err = cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "[00:00:01] a\n"; string(output) != expected {
+		t.Errorf("expected %#v, got %#v", expected, string(output))
+	}
+
+	cmd = exec.Command("./ets", "--elapsed-from", "later", "true")
+	output, err = cmd.CombinedOutput()
+	if err == nil || !strings.Contains(string(output), `invalid --elapsed-from "later"`) {
+		t.Errorf("expected error, got %#v", string(output))
+	}
+}
+
+func TestSince(t *testing.T) {
+	cmd := exec.Command("./ets", "-s", "-f", "[%T]", "--since", "90m", "echo", "a")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "[01:30:00] a\n"; string(output) != expected {
+		t.Errorf("expected %#v, got %#v", expected, string(output))
+	}
+
+	since := time.Now().Add(-2 * time.Hour).UTC().Format(time.RFC3339)
+	cmd = exec.Command("./ets", "-s", "-f", "[%H]", "--since", since, "echo", "a")
+	output, err = cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "[02] a\n"; string(output) != expected {
+		t.Errorf("expected %#v, got %#v", expected, string(output))
+	}
+
+	cmd = exec.Command("./ets", "-s", "--since", "yesterday", "true")
+	output, err = cmd.CombinedOutput()
+	if err == nil || !strings.Contains(string(output), `invalid --since "yesterday"`) {
+		t.Errorf("expected error, got %#v", string(output))
+	}
+}
+
+func TestActivityReport(t *testing.T) {
+	cmd := exec.Command("./ets", "-q", "--activity-report", "echo", "hello")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`\nets activity report \(per 1m\):\n  time +lines +bytes\n(  \d\d:\d\d +[01] +[06]\n){1,2}$`).Match(output) {
+		t.Errorf("wrong output: %#v", string(output))
+	}
+
+	cmd = exec.Command("./ets", "-q", "--activity-report=1s", "sh", "-c", "echo a; sleep 2.5; echo bb")
+	output, err = cmd.CombinedOutput()
+	if err != nil {
+		t.Fatal(err)
+	}
+	table := regexp.MustCompile(`\nets activity report \(per 1s\):\n  time +lines +bytes\n((?:  \d\d:\d\d:\d\d +\d+ +\d+\n)+)$`).FindSubmatch(output)
+	if table == nil {
+		t.Fatalf("wrong output: %#v", string(output))
+	}
+	rows := strings.Split(strings.TrimSuffix(string(table[1]), "\n"), "\n")
+	if len(rows) < 3 || !strings.HasSuffix(rows[0], "1           2") || !strings.HasSuffix(rows[len(rows)-1], "1           3") {
+		t.Errorf("wrong rows: %#v", rows)
+	}
+	for _, row := range rows[1 : len(rows)-1] {
+		if !strings.HasSuffix(row, "0           0") {
+			t.Errorf("expected quiet bucket: %#v", row)
+		}
+	}
+}
+
+func TestThroughput(t *testing.T) {
+	summaryFile := path.Join(tempdir, "throughput.json")
+	cmd := exec.Command("./ets", "-q", "--summary-json", summaryFile, "sh", "-c", "echo hello; echo world")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`(?m)^  bytes +12 B \(\d+(\.\d)? [KM]?i?B/s average, \d+(\.\d)? [KM]?i?B/s peak\)$`).Match(output) {
+		t.Errorf("bytes not found in summary %#v", string(output))
+	}
+	content, err := ioutil.ReadFile(summaryFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`"bytes": 12,\s*"throughput": \{\s*"average": [\d.e+-]+,\s*"peak": [\d.e+-]+\s*\}`).Match(content) {
+		t.Errorf("bytes not found in JSON summary %s", content)
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	cmd := exec.Command("./ets", "--checksum", "sha256", "printf", "hello\\nworld")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// printf 'hello\nworld' | sha256sum
+	if !regexp.MustCompile(`(?m)^  sha256 +26c60a61d01db5836ca70fefd44a6a016620413c8ef5f259a6c5612d4f79d3b8$`).Match(output) {
+		t.Errorf("checksum not found in summary %#v", string(output))
+	}
+
+	// The digest is of the output as written, line endings and lines
+	// dropped by --filter included.
+	for _, test := range []struct {
+		args  []string
+		input string
+	}{
+		{[]string{"printf", `a\r\nb\rc\n\r`}, "a\r\nb\rc\n\r"},
+		{[]string{"--summary"}, "a\r\nb\r\n"},
+		{[]string{"--summary", "--filter", "grep -v b"}, "a\nb\nc\n"},
+	} {
+		cmd = exec.Command("./ets", append([]string{"--checksum", "sha256"}, test.args...)...)
+		if test.args[0] != "printf" {
+			cmd.Stdin = strings.NewReader(test.input)
+		}
+		output, err = cmd.CombinedOutput()
+		if err != nil {
+			t.Fatal(err)
+		}
+		digest := fmt.Sprintf("%x", sha256.Sum256([]byte(test.input)))
+		if !regexp.MustCompile(`(?m)^  sha256 +` + digest + `$`).Match(output) {
+			t.Errorf("%v: expected checksum %s in summary, got %#v", test.args, digest, string(output))
+		}
+	}
+
+	cmd = exec.Command("./ets", "--checksum", "crc32", "true")
+	output, err = cmd.CombinedOutput()
+	if err == nil || !strings.Contains(string(output), `unknown checksum algorithm "crc32"`) {
+		t.Errorf("expected error, got %#v", string(output))
+	}
+}
+
+func TestRules(t *testing.T) {
+	cmd := exec.Command("./ets", "-f", "[ts]",
+		"--when", `line contains "b" && lineno > 1`, "--then", "annotate=found b,mark",
+		"--when", `(line matches "^c" || delta >= 1h) && !(elapsed > 1h)`, "--then", "color=red")
+	cmd.Stdin = strings.NewReader("b\na\nb\nc\n")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "[ts] b\n[ts] a\n[ts] b\n[ts] [ets] found b\n[ts] [ets] ===== MARK 1 =====\n[ts] \x1b[31mc\x1b[0m\n"
+	if string(output) != expected {
+		t.Errorf("expected %#v, got %#v", expected, string(output))
+	}
+
+	for _, args := range [][]string{
+		{"--when", "delta > 2", "--then", "mark"},
+		{"--when", "line matches", "--then", "mark"},
+		{"--when", "delta > 1s", "--then", "color=pink"},
+		{"--when", "delta > 1s"},
+	} {
+		cmd = exec.Command("./ets", append(args, "true")...)
+		output, err = cmd.CombinedOutput()
+		if err == nil || !regexp.MustCompile(`invalid --(when|then)|requires a --then`).Match(output) {
+			t.Errorf("%v: expected error, got %#v", args, string(output))
+		}
+	}
+}
+
+func TestScript(t *testing.T) {
+	script := path.Join(tempdir, "script.star")
+	if err := ioutil.WriteFile(script, []byte(`
+seen = {"lines": 0}
+
+def on_start():
+    annotate("starting")
+
+def on_line(line, info):
+    seen["lines"] += 1
+    if line.startswith("debug"):
+        drop()
+    elif line == "error":
+        color("red")
+        mark()
+    elif info.lineno == 2:
+        return line.upper()
+
+def on_exit(status):
+    annotate("%d lines, status %s" % (seen["lines"], status))
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command("./ets", "--script", script, "-f", "[ts]")
+	cmd.Stdin = strings.NewReader("a\ndebug 1\nb\nerror\n")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "[ts] [ets] starting\n[ts] a\n[ts] B\n[ts] \x1b[31merror\x1b[0m\n[ts] [ets] ===== MARK 1 =====\n[ts] [ets] 4 lines, status None\n"
+	if string(output) != expected {
+		t.Errorf("expected %#v, got %#v", expected, string(output))
+	}
+
+	// Errors disable the script.
+	if err := ioutil.WriteFile(script, []byte("def on_line(line):\n    return 1 / 0 if line == 'b' else line + '!'\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cmd = exec.Command("./ets", "--script", script, "-f", "[ts]")
+	cmd.Stdin = strings.NewReader("a\nb\nc\n")
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	output, err = cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "[ts] a!\n[ts] b\n[ts] c\n"; string(output) != expected {
+		t.Errorf("expected %#v, got %#v", expected, string(output))
+	}
+	if !strings.Contains(stderr.String(), "disabling it") || !strings.Contains(stderr.String(), "division by zero") {
+		t.Errorf("expected script error, got %#v", stderr.String())
+	}
+
+	if err := ioutil.WriteFile(script, []byte("x = 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	output, err = exec.Command("./ets", "--script", script, "true").CombinedOutput()
+	if err == nil || !strings.Contains(string(output), "none of on_start, on_line, and on_exit is defined") {
+		t.Errorf("expected error, got %#v", string(output))
+	}
+}
+
+func TestSample(t *testing.T) {
+	cmd := exec.Command("./ets", "--summary", "-f", "[ts]", "--sample", "2/5")
+	cmd.Stdin = strings.NewReader("1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n")
+	var stdout, stderr strings.Builder
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "[ts] 1\n[ts] 2\n[ts] 6\n[ts] 7\n[ts] 11\n"; stdout.String() != expected {
+		t.Errorf("expected %#v, got %#v", expected, stdout.String())
+	}
+	if !regexp.MustCompile(`(?m)^  lines +11 \(5 printed, 6 skipped by sampling\)$`).MatchString(stderr.String()) {
+		t.Errorf("skipped lines not found in summary %#v", stderr.String())
+	}
+
+	cmd = exec.Command("./ets", "-f", "[ts]", "--sample-every", "500ms", "sh", "-c", "for i in 1 2 3 4 5 6; do echo $i; sleep 0.2; done")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`^\[ts\] 1\r?\n\[ts\] [3-5]\r?\n(\[ts\] [5-6]\r?\n)?$`).Match(output) {
+		t.Errorf("wrong output %#v", string(output))
+	}
+
+	cmd = exec.Command("./ets", "--sample", "1/0", "true")
+	output, err = cmd.CombinedOutput()
+	if err == nil || !strings.Contains(string(output), `invalid sample ratio "1/0"`) {
+		t.Errorf("expected error, got %#v", string(output))
+	}
+}
+
+func TestSquashRepeats(t *testing.T) {
+	cmd := exec.Command("./ets", "-f", "[ts]", "--squash-repeats", "1m")
+	cmd.Stdin = strings.NewReader("a\nb\na\na\nc\n")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`^\[ts\] a\n\[ts\] b\n\[ts\] c\n\[ts\] \[ets\] seen 2 more times since \d\d:\d\d:\d\d: a\n$`).Match(output) {
+		t.Errorf("wrong output %#v", string(output))
+	}
+
+	cmd = exec.Command("./ets", "-f", "[ts]", "--squash-repeats", "500ms", "sh", "-c", "echo a; echo a; sleep 1.5; echo b; echo a")
+	output, err = cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`^\[ts\] a\r?\n\[ts\] \[ets\] seen 1 more time since \d\d:\d\d:\d\d: a\r?\n\[ts\] b\r?\n\[ts\] a\r?\n$`).Match(output) {
+		t.Errorf("wrong output %#v", string(output))
+	}
+	// A line recurring after its window with repeats not yet reported has
+	// them reported first.
+	cmd = exec.Command("./ets", "-s", "-f", "%T", "-u", "--squash-repeats", "2s", "--parse-timestamps", "rfc3339")
+	cmd.Stdin = strings.NewReader("2024-03-10T12:00:00Z x\n2024-03-10T12:00:00.2Z x\n2024-03-10T12:00:02.35Z x\n")
+	output, err = cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "00:00:00 x\n00:00:02 [ets] seen 1 more time since 12:00:00: x\n00:00:02 x\n"
+	if string(output) != expected {
+		t.Errorf("expected %#v, got %#v", expected, string(output))
+	}
+}
+
+func TestFlushInterval(t *testing.T) {
+	outputFile := path.Join(tempdir, "flush-interval.log")
+	cmd := exec.Command("./ets", "-f", "[ts]", "--flush-interval", "10s", "-o", outputFile, "sh", "-c", "echo a; sleep 1; echo b")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(500 * time.Millisecond)
+	if contents, _ := ioutil.ReadFile(outputFile); len(contents) != 0 {
+		t.Errorf("output flushed early: %#v", string(contents))
+	}
+	if err := cmd.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	contents, err := ioutil.ReadFile(outputFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "[ts] a\n[ts] b\n"; string(contents) != expected {
+		t.Errorf("expected %#v, got %#v", expected, string(contents))
+	}
+}
+
+func TestBatchLines(t *testing.T) {
+	outputFile := path.Join(tempdir, "batch-lines.log")
+	cmd := exec.Command("./ets", "--summary", "-f", "[ts]", "--batch-lines", "4", "-o", outputFile)
+	cmd.Stdin = strings.NewReader("1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`(?m)^  batches +3 \(3\.3 lines, 23 B on average; 4 lines, 28 B at most\)$`).Match(output) {
+		t.Errorf("batches not found in summary %#v", string(output))
+	}
+	contents, err := ioutil.ReadFile(outputFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "[ts] 1\n[ts] 2\n[ts] 3\n[ts] 4\n[ts] 5\n[ts] 6\n[ts] 7\n[ts] 8\n[ts] 9\n[ts] 10\n"; string(contents) != expected {
+		t.Errorf("expected %#v, got %#v", expected, string(contents))
+	}
+}
+
+func TestAudit(t *testing.T) {
+	cmd := exec.Command("./ets", "--audit", "-f", "%H:%M:%S", "-l", "label", "sh", "-c", "echo hello; echo world")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(string(output), "\n")
+	lines = lines[:len(lines)-1]
+	if len(lines) != 3 {
+		t.Fatalf("wrong output: %#v", string(output))
+	}
+	var head [sha256.Size]byte
+	tagged := regexp.MustCompile(`^(\d\d:\d\d:\d\d label) #([0-9a-f]{12}) (.*\n)$`)
+	for _, line := range lines[:2] {
+		m := tagged.FindStringSubmatch(line)
+		if m == nil {
+			t.Fatalf("untagged line %#v", line)
+		}
+		head = sha256.Sum256([]byte(string(head[:]) + m[1] + " " + m[3]))
+		if m[2] != hex.EncodeToString(head[:])[:12] {
+			t.Errorf("wrong tag in line %#v", line)
+		}
+	}
+	footer := regexp.MustCompile(`^\d\d:\d\d:\d\d label \[ets\] audit chain head ([0-9a-f]{64}) \(2 lines\)\n$`).FindStringSubmatch(lines[2])
+	if footer == nil || footer[1] != hex.EncodeToString(head[:]) {
+		t.Errorf("wrong footer %#v", lines[2])
+	}
+}
+
+func TestSign(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := path.Join(tempdir, "sign.pem")
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	teeFile := path.Join(tempdir, "sign.log")
+	cmd := exec.Command("./ets", "--sign", keyFile, "--tee", teeFile, "echo", "hello")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadFile(teeFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature, err := ioutil.ReadFile(teeFile + ".sig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ed25519.Verify(publicKey, content, signature) {
+		t.Errorf("invalid signature of %#v", string(content))
+	}
+
+	cmd = exec.Command("./ets", "--sign", keyFile, "--audit", "echo", "hello")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := regexp.MustCompile(`audit chain head ([0-9a-f]{64}) .*\n.* \[ets\] audit chain head signature (\S+)\n$`).FindStringSubmatch(string(output))
+	if m == nil {
+		t.Fatalf("signature not found in %#v", string(output))
+	}
+	head, _ := hex.DecodeString(m[1])
+	signature, _ = base64.StdEncoding.DecodeString(m[2])
+	if !ed25519.Verify(publicKey, head, signature) {
+		t.Errorf("invalid signature of chain head in %#v", string(output))
+	}
+
+	cmd = exec.Command("./ets", "--sign", keyFile, "true")
+	output, err = cmd.CombinedOutput()
+	if err == nil || !strings.Contains(string(output), "--sign requires --tee, --output-file, or --audit") {
+		t.Errorf("expected error, got %#v", string(output))
+	}
+}
+
+func TestEncrypt(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipientsFile := path.Join(tempdir, "recipients.txt")
+	if err := ioutil.WriteFile(recipientsFile, []byte("# test key\n"+identity.Recipient().String()+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	teeFile := path.Join(tempdir, "encrypted.log")
+	cmd := exec.Command("./ets", "--encrypt", recipientsFile, "--tee", teeFile, "-f", "[ts]", "echo", "secret")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(output) != "[ts] secret\n" {
+		t.Errorf("expected plain output on stdout, got %#v", string(output))
+	}
+	f, err := os.Open(teeFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := age.Decrypt(f, identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "[ts] secret\n" {
+		t.Errorf("expected %#v, got %#v", "[ts] secret\n", string(content))
+	}
+
+	cmd = exec.Command("./ets", "--encrypt", recipientsFile, "true")
+	output, err = cmd.CombinedOutput()
+	if err == nil || !strings.Contains(string(output), "--encrypt requires --tee") {
+		t.Errorf("expected error, got %#v", string(output))
+	}
+}
+
+func TestUpload(t *testing.T) {
+	bin := path.Join(tempdir, "upload-bin")
+	bucket := path.Join(tempdir, "upload-bucket")
+	for _, dir := range []string{bin, bucket} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A stand-in for aws s3 cp --only-show-errors src s3://bucket/prefix/name.
+	script := "#!/bin/sh\ncp \"$4\" \"" + bucket + "/${5#s3://bucket/prefix/}\"\n"
+	if err := ioutil.WriteFile(path.Join(bin, "aws"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	teeFile := path.Join(tempdir, "upload.log")
+	cmd := exec.Command("./ets", "--tee", teeFile, "--upload", "s3://bucket/prefix", "echo", "hello")
+	cmd.Env = append(os.Environ(), "PATH="+bin+":"+os.Getenv("PATH"))
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%s: %s", err, output)
+	}
+	content, err := ioutil.ReadFile(teeFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := ioutil.ReadDir(bucket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("expected the log and its manifest, got %d files", len(files))
+	}
+	if !regexp.MustCompile(`^\d{8}T\d{6}Z-upload\.log$`).MatchString(files[0].Name()) ||
+		files[1].Name() != files[0].Name()+".manifest.json" {
+		t.Errorf("wrong names %s, %s", files[0].Name(), files[1].Name())
+	}
+	uploaded, _ := ioutil.ReadFile(path.Join(bucket, files[0].Name()))
+	if string(uploaded) != string(content) {
+		t.Errorf("uploaded %#v, expected %#v", string(uploaded), string(content))
+	}
+	manifest, _ := ioutil.ReadFile(path.Join(bucket, files[1].Name()))
+	digest := sha256.Sum256(content)
+	for _, pattern := range []string{
+		`"file": "` + files[0].Name() + `",`,
+		`"bytes": ` + strconv.Itoa(len(content)) + `,`,
+		`"sha256": "` + hex.EncodeToString(digest[:]) + `",`,
+		`"summary": \{\s*"command": \[\s*"echo",\s*"hello"\s*\],\s*"exit_status": 0,`,
+	} {
+		if !regexp.MustCompile(pattern).Match(manifest) {
+			t.Errorf("%s not found in manifest %s", pattern, manifest)
+		}
+	}
+}
+
+func TestSyslog(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	cmd := exec.Command("./ets", "--syslog", "udp:"+conn.LocalAddr().String(), "--levels", "sh", "-c", "echo hello; echo ERROR world")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		`^<14>1 \d{4}-\d\d-\d\dT\d\d:\d\d:\d\d\.\d{6}(Z|[+-]\d\d:\d\d) \S+ sh \d+ - \[ets@32473 elapsed="\d+\.\d{6}" delta="\d+\.\d{6}" line="1" run="([0-9a-f]{16})"\] hello$`,
+		`^<11>1 \S+ \S+ sh \d+ - \[ets@32473 elapsed="\d+\.\d{6}" delta="\d+\.\d{6}" line="2" run="([0-9a-f]{16})"\] ERROR world$`,
+	}
+	runIDs := make([]string, 0)
+	buf := make([]byte, 2048)
+	for _, pattern := range expected {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := regexp.MustCompile(pattern).FindStringSubmatch(string(buf[:n]))
+		if m == nil {
+			t.Fatalf("%#v does not match %s", string(buf[:n]), pattern)
+		}
+		runIDs = append(runIDs, m[len(m)-1])
+	}
+	if runIDs[0] != runIDs[1] {
+		t.Errorf("different run IDs %v", runIDs)
+	}
+}
+
+func TestSinks(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	sinkFile := path.Join(tempdir, "sink.log")
+	metricsFile := path.Join(tempdir, "sink.prom")
+	cmd := exec.Command("./ets", "-f", "[t]", "--mark-slow", "200ms",
+		"--sink", "file="+sinkFile, "--sink-format", "file=<%{elapsed}S>",
+		"--sink", "metrics="+metricsFile, "--sink", "syslog=udp:"+conn.LocalAddr().String(),
+		"echo out1; sleep 0.5; echo out2")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`^\[t\] out1\r?\n\[t\] >>>>> \d+ms gap\r?\n\[t\] out2\r?\n$`).Match(output) {
+		t.Errorf("wrong output: %#v", string(output))
+	}
+
+	// Annotations are sent to the file sink, with the timestamp format of
+	// the sink.
+	content, err := ioutil.ReadFile(sinkFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`^<00> out1\n<00> >>>>> \d+ms gap\n<00> out2\n$`).Match(content) {
+		t.Errorf("wrong sink file: %#v", string(content))
+	}
+
+	// But not to the metrics and network sinks.
+	content, err = ioutil.ReadFile(metricsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`(?m)^ets_lines_total 2$`).Match(content) {
+		t.Errorf("wrong metrics file: %#v", string(content))
+	}
+	buf := make([]byte, 2048)
+	for _, text := range []string{"out1", "out2"} {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if message := strings.TrimRight(string(buf[:n]), "\r\n"); !strings.HasSuffix(message, "] "+text) {
+			t.Errorf("expected syslog message of %s, got %#v", text, message)
+		}
+	}
+	conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if n, _, err := conn.ReadFrom(buf); err == nil {
+		t.Errorf("unexpected syslog message %#v", string(buf[:n]))
+	}
+
+	cmd = exec.Command("./ets", "--sink", "nope=x", "true")
+	if output, err := cmd.CombinedOutput(); err == nil || !strings.Contains(string(output), `unknown sink "nope"`) {
+		t.Errorf("expected error for unknown sink, got %#v", string(output))
+	}
+}
+
+func TestGELF(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	long := strings.Repeat("x", 20000)
+	cmd := exec.Command("./ets", "--gelf", "udp://"+conn.LocalAddr().String(), "sh", "-c", "echo hello; echo "+long)
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	readMessage := func() map[string]interface{} {
+		buf := make([]byte, 65536)
+		var message []byte
+		for {
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n < 12 || buf[0] != 0x1e || buf[1] != 0x0f {
+				message = append(message, buf[:n]...)
+				break
+			}
+			message = append(message, buf[12:n]...)
+			if buf[10] == buf[11]-1 {
+				break
+			}
+		}
+		var fields map[string]interface{}
+		if err := json.Unmarshal(message, &fields); err != nil {
+			t.Fatalf("%s: %s", err, message)
+		}
+		return fields
+	}
+	first, second := readMessage(), readMessage()
+	if first["version"] != "1.1" || first["short_message"] != "hello" || first["level"] != 6.0 ||
+		first["_line"] != 1.0 || first["_command"] != "sh -c echo hello; echo "+long {
+		t.Errorf("wrong message %v", first)
+	}
+	if _, ok := first["_elapsed"].(float64); !ok {
+		t.Errorf("no elapsed time in %v", first)
+	}
+	if second["short_message"] != long || second["_line"] != 2.0 || second["_run"] != first["_run"] {
+		t.Errorf("wrong chunked message with fields %v %v", second["_line"], second["_run"])
+	}
+}
+
+func TestNATS(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	// A NATS server acknowledging publications as JetStream would.
+	published := make(chan string, 10)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		defer close(published)
+		fmt.Fprint(conn, "INFO {\"server_id\":\"test\"}\r\n")
+		reader := bufio.NewReader(conn)
+		seq := 0
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			fields := strings.Fields(line)
+			switch fields[0] {
+			case "PING":
+				fmt.Fprint(conn, "PONG\r\n")
+			case "PUB":
+				size, _ := strconv.Atoi(fields[len(fields)-1])
+				payload := make([]byte, size+2)
+				if _, err := io.ReadFull(reader, payload); err != nil {
+					return
+				}
+				published <- fields[1] + " " + string(payload[:size])
+				if len(fields) == 4 {
+					seq++
+					ack := fmt.Sprintf(`{"stream":"LOGS","seq":%d}`, seq)
+					fmt.Fprintf(conn, "MSG %s 1 %d\r\n%s\r\n", fields[2], len(ack), ack)
+				}
+			}
+		}
+	}()
+	cmd := exec.Command("./ets", "--nats", "nats://"+listener.Addr().String(), "--nats-subject", "ci.build",
+		"--nats-jetstream", "sh", "-c", "echo hello; echo world")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(output), "not acknowledged") {
+		t.Errorf("unexpected output %#v", string(output))
+	}
+	expected := []string{
+		`^ci\.build \{"message":"hello","timestamp":"[^"]+","elapsed":[\d.e-]+,"delta":[\d.e-]+,"line":1,"run":"[0-9a-f]{16}","command":\["sh","-c","echo hello; echo world"\]\}$`,
+		`^ci\.build \{"message":"world",.*"line":2,`,
+	}
+	for _, pattern := range expected {
+		message := <-published
+		if !regexp.MustCompile(pattern).MatchString(message) {
+			t.Errorf("%#v does not match %s", message, pattern)
+		}
+	}
+}
+
+func TestElastic(t *testing.T) {
+	requests := make(chan string, 10)
+	failed := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !failed {
+			// Make ets back off and retry.
+			failed = true
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		requests <- r.Method + " " + r.URL.Path + " " + r.Header.Get("Content-Type") + "\n" + string(body)
+		fmt.Fprint(w, `{"took":1,"errors":false,"items":[]}`)
+	}))
+	defer server.Close()
+	cmd := exec.Command("./ets", "--elastic", server.URL+"/logs", "sh", "-c", "echo hello; echo world")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(output), "error sending to Elasticsearch: 503 Service Unavailable: unavailable; retrying") {
+		t.Errorf("retry not reported in %#v", string(output))
+	}
+	select {
+	case request := <-requests:
+		pattern := `^POST /_bulk application/x-ndjson\n` +
+			`\{"index":\{"_index":"logs"\}\}\n\{"@timestamp":"[^"]+","message":"hello","elapsed":[\d.e-]+,"delta":[\d.e-]+,"line":1,"run":"[0-9a-f]{16}","command":\["sh","-c","echo hello; echo world"\]\}\n` +
+			`\{"index":\{"_index":"logs"\}\}\n\{"@timestamp":"[^"]+","message":"world",.*"line":2,.*\}\n$`
+		if !regexp.MustCompile(pattern).MatchString(request) {
+			t.Errorf("wrong request %#v", request)
+		}
+	default:
+		t.Errorf("no documents indexed")
+	}
+}
+
+func TestSplunkHEC(t *testing.T) {
+	requests := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		events, _ := ioutil.ReadAll(body)
+		requests <- r.URL.Path + " " + r.Header.Get("Authorization") + "\n" + string(events)
+		fmt.Fprint(w, `{"text":"Success","code":0}`)
+	}))
+	defer server.Close()
+	cmd := exec.Command("./ets", "--splunk-hec", server.URL, "--splunk-sourcetype", "build", "sh", "-c", "echo hello; echo world")
+	cmd.Env = append(os.Environ(), "SPLUNK_HEC_TOKEN=secret")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%s: %s", err, output)
+	}
+	select {
+	case request := <-requests:
+		pattern := `^/services/collector/event Splunk secret\n` +
+			`\{"time":\d+\.\d+,"host":"[^"]+","source":"sh -c echo hello; echo world","sourcetype":"build","event":"hello","fields":\{"elapsed":[\d.e-]+,"delta":[\d.e-]+,"line":1,"run":"[0-9a-f]{16}"\}\}\n` +
+			`\{.*"event":"world","fields":\{.*"line":2,.*\}\}\n$`
+		if !regexp.MustCompile(pattern).MatchString(request) {
+			t.Errorf("wrong request %#v", request)
+		}
+	default:
+		t.Errorf("no events sent")
+	}
+
+	cmd = exec.Command("./ets", "--splunk-hec", server.URL, "true")
+	cmd.Env = append(os.Environ(), "SPLUNK_HEC_TOKEN=")
+	output, err = cmd.CombinedOutput()
+	if err == nil || !strings.Contains(string(output), "--splunk-hec requires a token") {
+		t.Errorf("expected error, got %#v", string(output))
+	}
+}
+
+func TestFilter(t *testing.T) {
+	cmd := exec.Command("./ets", "-f", "[%T]", "--filter", "sed -u s/secret/xxx/", "sh", "-c", "echo one secret; echo two")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`^\[\d\d:\d\d:\d\d\] one xxx\n\[\d\d:\d\d:\d\d\] two\n$`).Match(output) {
+		t.Errorf("wrong output: %#v", string(output))
+	}
+
+	// A failing filter lets the rest of the output through.
+	cmd = exec.Command("./ets", "-f", "[%T]", "--filter", "read line; exit 3", "sh", "-c", "echo one; sleep 0.5; echo two")
+	output, err = cmd.CombinedOutput()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`^filter failed: exit status 3; passing the rest of the output through unfiltered\n\[\d\d:\d\d:\d\d\] two\n$`).Match(output) {
+		t.Errorf("wrong output: %#v", string(output))
+	}
+}
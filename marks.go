@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// markPeriods prints a separator line with the number of lines read during
+// each period of interval, aligned to the wall clock of the timezone of
+// printer, so that periods of 10m start on the ten minutes, until done is
+// closed.
+func markPeriods(printer *Printer, interval time.Duration, done <-chan struct{}) {
+	_, offset := time.Now().In(printer.Timestamper.TZ).Zone()
+	shift := time.Duration(offset) * time.Second
+	for {
+		now := time.Now()
+		next := now.Add(shift).Truncate(interval).Add(interval - shift)
+		select {
+		case <-done:
+			return
+		case <-time.After(next.Sub(now)):
+			printer.MarkPeriod(interval)
+		}
+	}
+}
+
+// MarkPeriod prints a separator line with the number of lines read since
+// the previous one, or since the start, every interval.
+func (p *Printer) MarkPeriod(interval time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	layout := "15:04:05"
+	if interval >= 24*time.Hour {
+		layout = "2006-01-02 15:04:05"
+	}
+	since := p.periodStart
+	if since.IsZero() {
+		since = p.Summary.Start
+	}
+	since = since.Add(p.Timestamper.Offset).In(p.Timestamper.TZ)
+	p.printAnnotation(fmt.Sprintf("----- %d %s since %s -----",
+		p.periodLines, pluralize(p.periodLines, "line", "lines"), since.Format(layout)))
+	p.periodStart = time.Now()
+	p.periodLines = 0
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/zmwangx/ets/sink"
+)
+
+// How often the metrics file is rewritten while lines keep coming.
+const metricsInterval = time.Second
+
+// metricsSink keeps counters of the lines of a run and writes them to a file
+// in the Prometheus text format, given with --sink metrics=path, for the
+// textfile collector of node_exporter to pick up. The file is rewritten at
+// most every second while lines come, and once more on close.
+type metricsSink struct {
+	path    string
+	lines   int
+	bytes   int
+	levels  map[string]int
+	last    *LineEvent
+	maxGap  time.Duration
+	written time.Time
+	failed  bool
+}
+
+func openMetricsSink(config sink.Config) (sink.Sink, error) {
+	if config.Arg == "" {
+		return nil, fmt.Errorf("metrics sink requires a path, as in --sink metrics=path")
+	}
+	return &metricsSink{path: config.Arg, levels: make(map[string]int)}, nil
+}
+
+func (s *metricsSink) Send(e *LineEvent) {
+	if e.Kind != sink.Output {
+		return
+	}
+	s.lines++
+	s.bytes += len(e.Text) + 1
+	if e.Level != "" {
+		s.levels[e.Level]++
+	}
+	if e.Delta > s.maxGap {
+		s.maxGap = e.Delta
+	}
+	last := *e
+	s.last = &last
+	if e.Time.Sub(s.written) >= metricsInterval {
+		s.write()
+		s.written = e.Time
+	}
+}
+
+func (s *metricsSink) Close() {
+	s.write()
+}
+
+// write replaces the metrics file, through a temporary file so that the
+// collector never reads it half written.
+func (s *metricsSink) write() {
+	if s.failed {
+		return
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), "."+filepath.Base(s.path)+".*")
+	if err == nil {
+		_, err = tmp.Write(s.format())
+		if cerr := tmp.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			err = os.Rename(tmp.Name(), s.path)
+		}
+		if err != nil {
+			os.Remove(tmp.Name())
+		}
+	}
+	if err != nil {
+		log.Printf("error writing metrics file: %s", err)
+		s.failed = true
+	}
+}
+
+// format returns the metrics in the Prometheus text format.
+func (s *metricsSink) format() []byte {
+	var b bytes.Buffer
+	metric := func(name, kind, help string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+	metric("ets_lines_total", "counter", "Lines of output of the command.")
+	fmt.Fprintf(&b, "ets_lines_total %d\n", s.lines)
+	metric("ets_output_bytes_total", "counter", "Bytes of output of the command, without ANSI escape sequences.")
+	fmt.Fprintf(&b, "ets_output_bytes_total %d\n", s.bytes)
+	if len(s.levels) > 0 {
+		levels := make([]string, 0, len(s.levels))
+		for level := range s.levels {
+			levels = append(levels, level)
+		}
+		sort.Strings(levels)
+		metric("ets_level_lines_total", "counter", "Lines of output by the level detected with --levels.")
+		for _, level := range levels {
+			fmt.Fprintf(&b, "ets_level_lines_total{level=%q} %d\n", level, s.levels[level])
+		}
+	}
+	metric("ets_max_gap_seconds", "gauge", "Longest time between two lines of output.")
+	fmt.Fprintf(&b, "ets_max_gap_seconds %g\n", s.maxGap.Seconds())
+	if s.last != nil {
+		metric("ets_elapsed_seconds", "gauge", "Time from the start of the run to the last line of output.")
+		fmt.Fprintf(&b, "ets_elapsed_seconds %g\n", s.last.Elapsed.Seconds())
+		metric("ets_last_line_timestamp_seconds", "gauge", "Unix time of the last line of output.")
+		fmt.Fprintf(&b, "ets_last_line_timestamp_seconds %.6f\n", float64(s.last.Time.UnixNano())/1e9)
+	}
+	return b.Bytes()
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zmwangx/ets/sink"
+)
+
+// Time allowed for the server to confirm the messages published when ets
+// exits.
+const natsFlushTimeout = 5 * time.Second
+
+// The event published to NATS for each line.
+type natsEvent struct {
+	Message   string    `json:"message"`
+	Timestamp time.Time `json:"timestamp"`
+	Elapsed   float64   `json:"elapsed"`
+	Delta     float64   `json:"delta"`
+	Line      int       `json:"line"`
+	Run       string    `json:"run"`
+	Command   []string  `json:"command,omitempty"`
+	Label     string    `json:"label,omitempty"`
+	Level     string    `json:"level,omitempty"`
+}
+
+// NATSSink publishes every line with its timing to a NATS subject, speaking
+// the NATS client protocol. With JetStream, each message is acknowledged by
+// the stream capturing the subject.
+type NATSSink struct {
+	Subject   string
+	JetStream bool
+
+	conn    net.Conn
+	reader  *bufio.Reader
+	command []string
+	inbox   string
+
+	mu      sync.Mutex
+	pending int
+	nacks   int
+	pong    chan struct{}
+	failed  bool
+}
+
+// NewNATSSink connects to the NATS server at spec, nats://[user:pass@]host
+// [:port], to publish lines of command to subject.
+func NewNATSSink(spec string, subject string, jetStream bool, command []string) (*NATSSink, error) {
+	u, err := url.Parse(spec)
+	if err != nil || u.Scheme != "nats" || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid NATS URL %q: expected nats://host:port", spec)
+	}
+	if subject == "" || strings.ContainsAny(subject, " \t\r\n") {
+		return nil, fmt.Errorf("invalid NATS subject %q", subject)
+	}
+	address := u.Host
+	if u.Port() == "" {
+		address = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	inbox, err := newRunID()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	s := &NATSSink{
+		Subject:   subject,
+		JetStream: jetStream,
+		conn:      conn,
+		reader:    bufio.NewReader(conn),
+		command:   command,
+		inbox:     "_INBOX." + inbox,
+		pong:      make(chan struct{}, 1),
+	}
+	if err := s.handshake(u.User); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("%s: %s", spec, err)
+	}
+	go s.read()
+	return s, nil
+}
+
+// handshake reads the INFO of the server, introduces ets, and waits for the
+// server to answer a PING, which it does only if it accepted the connection.
+func (s *NATSSink) handshake(user *url.Userinfo) error {
+	s.conn.SetDeadline(time.Now().Add(natsFlushTimeout))
+	defer s.conn.SetDeadline(time.Time{})
+	line, err := s.reader.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		return fmt.Errorf("unexpected greeting %q", strings.TrimSpace(line))
+	}
+	options := map[string]interface{}{
+		"verbose":  false,
+		"pedantic": false,
+		"name":     "ets",
+		"lang":     "go",
+		"version":  version,
+		"protocol": 1,
+	}
+	if user != nil {
+		if password, ok := user.Password(); ok {
+			options["user"], options["pass"] = user.Username(), password
+		} else {
+			options["auth_token"] = user.Username()
+		}
+	}
+	connect, _ := json.Marshal(options)
+	handshake := "CONNECT " + string(connect) + "\r\n"
+	if s.JetStream {
+		handshake += "SUB " + s.inbox + " 1\r\n"
+	}
+	if _, err := io.WriteString(s.conn, handshake+"PING\r\n"); err != nil {
+		return err
+	}
+	for {
+		line, err := s.reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		switch line = strings.TrimSpace(line); {
+		case line == "PONG":
+			return nil
+		case strings.HasPrefix(line, "-ERR"):
+			return errors.New(strings.Trim(strings.TrimSpace(strings.TrimPrefix(line, "-ERR")), "'"))
+		}
+	}
+}
+
+// read handles the messages of the server until the connection is closed:
+// PINGs, PONGs, errors, and JetStream acknowledgements.
+func (s *NATSSink) read() {
+	for {
+		line, err := s.reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "PING":
+			s.mu.Lock()
+			io.WriteString(s.conn, "PONG\r\n")
+			s.mu.Unlock()
+		case "PONG":
+			select {
+			case s.pong <- struct{}{}:
+			default:
+			}
+		case "-ERR":
+			log.Printf("error from NATS server: %s", strings.Trim(strings.TrimSpace(strings.TrimPrefix(line, "-ERR")), "'"))
+		case "MSG":
+			// MSG subject sid [reply] size, followed by the payload.
+			size, err := strconv.Atoi(fields[len(fields)-1])
+			if err != nil {
+				return
+			}
+			payload := make([]byte, size+2)
+			if _, err := io.ReadFull(s.reader, payload); err != nil {
+				return
+			}
+			s.ack(payload[:size])
+		}
+	}
+}
+
+// ack accounts for a JetStream acknowledgement, which reports an error if
+// the message was not stored.
+func (s *NATSSink) ack(payload []byte) {
+	var ack struct {
+		Error *struct {
+			Description string `json:"description"`
+		} `json:"error"`
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending--
+	if json.Unmarshal(payload, &ack) != nil || ack.Error != nil {
+		s.nacks++
+		if s.nacks == 1 && ack.Error != nil {
+			log.Printf("error publishing to JetStream: %s", ack.Error.Description)
+		}
+	}
+}
+
+// Send publishes the line of e. Failures are reported once.
+func (s *NATSSink) Send(e *LineEvent) {
+	if e.Kind != sink.Output {
+		return
+	}
+	payload, _ := json.Marshal(natsEvent{
+		Message:   e.Text,
+		Timestamp: e.Time,
+		Elapsed:   e.Elapsed.Seconds(),
+		Delta:     e.Delta.Seconds(),
+		Line:      e.Line,
+		Run:       e.Run,
+		Command:   s.command,
+		Label:     e.Label,
+		Level:     e.Level,
+	})
+	pub := "PUB " + s.Subject
+	if s.JetStream {
+		pub += " " + s.inbox
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := fmt.Fprintf(s.conn, "%s %d\r\n%s\r\n", pub, len(payload), payload)
+	if err != nil {
+		if !s.failed {
+			log.Printf("error publishing to NATS: %s", err)
+			s.failed = true
+		}
+		return
+	}
+	if s.JetStream {
+		s.pending++
+	}
+}
+
+// Close waits for the server to process the messages published, and with
+// JetStream, to acknowledge them, then disconnects.
+func (s *NATSSink) Close() {
+	defer s.conn.Close()
+	deadline := time.Now().Add(natsFlushTimeout)
+	s.mu.Lock()
+	_, err := io.WriteString(s.conn, "PING\r\n")
+	s.mu.Unlock()
+	if err == nil {
+		select {
+		case <-s.pong:
+		case <-time.After(time.Until(deadline)):
+			log.Printf("NATS server did not confirm the messages published within %s", natsFlushTimeout)
+			return
+		}
+	}
+	for s.JetStream && time.Now().Before(deadline) {
+		s.mu.Lock()
+		pending := s.pending
+		s.mu.Unlock()
+		if pending <= 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pending > 0 || s.nacks > 0 {
+		log.Printf("%d messages not acknowledged by JetStream", s.pending+s.nacks)
+	}
+}
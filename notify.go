@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// describeCompletion describes how the run summarized in s ended, e.g.
+// "exited with status 3 after 1m2.345s".
+func describeCompletion(s *Summary) string {
+	if !s.Exited {
+		return "input ended after " + formatSummaryDuration(s.Duration())
+	}
+	return fmt.Sprintf("exited with status %d after %s", s.ExitCode, formatSummaryDuration(s.Duration()))
+}
+
+// notify shows a desktop notification announcing the completion of the run
+// summarized in s, with notify-send, or osascript on macOS, or a toast on
+// Windows.
+func notify(s *Summary) error {
+	title := "ets"
+	if len(s.Command) > 0 {
+		title = "ets: " + strings.Join(s.Command, " ")
+	}
+	body := describeCompletion(s)
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("osascript", "-e",
+			fmt.Sprintf("display notification %s with title %s", appleScriptString(body), appleScriptString(title)))
+	case "windows":
+		cmd = exec.Command("powershell", "-NoProfile", "-Command", fmt.Sprintf(`
+[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] > $null
+$template = [Windows.UI.Notifications.ToastNotificationManager]::GetTemplateContent([Windows.UI.Notifications.ToastTemplateType]::ToastText02)
+$text = $template.GetElementsByTagName('text')
+$text.Item(0).AppendChild($template.CreateTextNode(%s)) > $null
+$text.Item(1).AppendChild($template.CreateTextNode(%s)) > $null
+[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier('ets').Show([Windows.UI.Notifications.ToastNotification]::new($template))
+`, powershellString(title), powershellString(body)))
+	default:
+		cmd = exec.Command("notify-send", "--app-name=ets", title, body)
+	}
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %s: %s", cmd.Args[0], err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func appleScriptString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+func powershellString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
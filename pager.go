@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+
+	"github.com/creack/pty"
+)
+
+// shouldPage interprets the argument of --pager: always, auto (only with ets
+// pipe or ets convert, and stdout a terminal), or never.
+func shouldPage(mode string, subcommand string) (bool, error) {
+	switch mode {
+	case "always":
+		return true, nil
+	case "auto":
+		_, err := pty.GetsizeFull(os.Stdout)
+		return (subcommand == "pipe" || subcommand == "convert") && err == nil, nil
+	case "never", "":
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid pager mode %q: expected always, auto, or never", mode)
+}
+
+// pagerCapture returns a temporary file to hold the output until it is paged.
+func pagerCapture() (*os.File, error) {
+	return ioutil.TempFile("", "ets-*.log")
+}
+
+// runPager shows the captured output in $PAGER, or less -R by default, then
+// removes the capture.
+func runPager(capture *os.File) error {
+	defer os.Remove(capture.Name())
+	defer capture.Close()
+	if _, err := capture.Seek(0, 0); err != nil {
+		return err
+	}
+	pager := os.Getenv("PAGER")
+	if pager == "" {
+		pager = "less -R"
+	}
+	cmd := exec.Command("sh", "-c", pager)
+	cmd.Stdin = capture
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
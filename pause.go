@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// TogglePause pauses the display, or resumes it if paused, and reports
+// whether it is now paused. Output held back while paused is written out on
+// resume, with the timestamps it had when it was read.
+func (p *Printer) TogglePause() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.setPaused(!p.paused)
+	return p.paused
+}
+
+// Resume resumes the display if paused.
+func (p *Printer) Resume() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.setPaused(false)
+}
+
+// setPaused pauses or resumes the display. Must be called with the printer
+// locked.
+func (p *Printer) setPaused(paused bool) {
+	if paused == p.paused {
+		return
+	}
+	if p.resumed == nil {
+		p.resumed = sync.NewCond(&p.mu)
+	}
+	p.paused = paused
+	if paused {
+		p.writeNotice(fmt.Sprintf("display paused, holding back up to %d lines", p.PauseBuffer))
+		return
+	}
+	p.writeNotice(fmt.Sprintf("display resumed, %d lines held back", p.heldLines))
+	_, _ = p.held.WriteTo(p.Out)
+	p.heldLines = 0
+	p.resumed.Broadcast()
+}
+
+// out returns the writer output goes to: Out, or the buffer of held output
+// while the display is paused. Must be called with the printer locked.
+func (p *Printer) out() io.Writer {
+	if p.paused {
+		return &p.held
+	}
+	return p.Out
+}
+
+// waitHeld blocks while the display is paused and the buffer of held output
+// is full. Must be called with the printer locked.
+func (p *Printer) waitHeld() {
+	for p.paused && p.heldLines >= p.PauseBuffer {
+		p.resumed.Wait()
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// writtenPidfiles maps the pidfiles written by writePidfile to the pids they
+// record, to be removed on exit, fatal errors included.
+var writtenPidfiles = make(map[string]int)
+
+// checkPidfile fails if the pidfile at path records the pid of a running
+// process, and removes it if the process is gone.
+func checkPidfile(path string) error {
+	pid, err := readPidfile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if pid > 0 && processExists(pid) {
+		return fmt.Errorf("pidfile %s records pid %d, which is still running", path, pid)
+	}
+	log.Printf("removing stale pidfile %s", path)
+	return os.Remove(path)
+}
+
+// writePidfile records pid in the pidfile at path, to be removed by
+// removePidfiles.
+func writePidfile(path string, pid int) error {
+	if err := ioutil.WriteFile(path, []byte(strconv.Itoa(pid)+"\n"), 0644); err != nil {
+		return err
+	}
+	writtenPidfiles[path] = pid
+	return nil
+}
+
+// removePidfiles removes the pidfiles written by writePidfile, unless they
+// have since been taken over by another process.
+func removePidfiles() {
+	for path, pid := range writtenPidfiles {
+		if recorded, err := readPidfile(path); err == nil && recorded == pid {
+			_ = os.Remove(path)
+		}
+	}
+}
+
+func readPidfile(path string) (int, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil {
+		// Unparsable, hence stale.
+		return 0, nil
+	}
+	return pid, nil
+}
+
+// processExists reports whether a process with the given pid exists,
+// whether or not we may signal it.
+func processExists(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
@@ -0,0 +1,842 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattn/go-runewidth"
+
+	"github.com/zmwangx/ets/sink"
+)
+
+// Printer prefixes lines with timestamps and writes them to Out, recording
+// statistics in Summary along the way.
+type Printer struct {
+	Out         io.Writer
+	Timestamper *Timestamper
+	Summary     *Summary
+
+	// ParseTimestamps, if not nil, extracts timestamps already present in
+	// the input, which replace the time each line is read.
+	ParseTimestamps TimestampParser
+	lastParsed      time.Time
+
+	// Filter, if not empty, is a shell command each stream is piped through
+	// before its lines are timestamped.
+	Filter string
+
+	// Label, if not empty, follows the timestamp on every line, to tell
+	// apart the output of several instances in the same log.
+	Label string
+
+	// Lines following a gap longer than SlowThreshold, if positive, are
+	// marked according to SlowStyle.
+	SlowThreshold time.Duration
+	SlowStyle     SlowStyle
+
+	// Levels, if not nil, detects the severity of each line, which is
+	// rendered according to LevelStyle.
+	Levels     *LevelDetector
+	LevelStyle LevelStyle
+
+	// GitHubActions enables passing GitHub Actions workflow commands through
+	// without a prefix, treating log groups as phases and annotating the run
+	// with their durations.
+	GitHubActions bool
+
+	// PhasePattern, if not nil, starts a new phase at each matching line,
+	// named by the first capturing group, or the whole match.
+	PhasePattern *regexp.Regexp
+
+	// PhaseTree, if not nil, records phases with their sub-phases.
+	PhaseTree *PhaseTree
+
+	// Tail, if not nil, is the --tail-buffer that Out writes to.
+	Tail *tailBuffer
+
+	// SplitByPhase, if not nil, also writes the lines of each phase to a
+	// file of its own.
+	SplitByPhase *phaseSplitter
+
+	// MarkerPattern, if not nil, makes each matching line the latest marker,
+	// from which directives qualified with mark measure.
+	MarkerPattern *regexp.Regexp
+
+	// The start of the current period of --mark-every, if not the start of
+	// the run, and the number of lines read during it.
+	periodStart time.Time
+	periodLines int
+
+	// TeamCity enables TeamCity service messages opening and closing a block
+	// for each phase, and reporting its duration as a build statistic.
+	TeamCity bool
+
+	// Buildkite enables Buildkite timestamp markers at the start of each
+	// line, so that the Buildkite UI shows the time ets read it.
+	Buildkite bool
+
+	// GoTest, if not nil, records the go test -json events in the output.
+	GoTest *GoTestReport
+
+	// TAP enables passing TAP output through without prefixes, emitting
+	// timestamps as comments preceding test points instead, and recording
+	// the duration of each test.
+	TAP           bool
+	lastTestPoint time.Time
+
+	// sinks are sent every line with its timing.
+	sinks sinkSet
+
+	// Audit, if not nil, tags every line with its hash in a chain.
+	Audit *AuditChain
+
+	// Repeats, if not nil, suppresses lines repeated within its window.
+	Repeats *RepeatSquasher
+
+	// DedupTimestamps blanks timestamps identical to the previous one, to
+	// group the lines of a burst. lastTimestamp is the previous one.
+	DedupTimestamps bool
+	lastTimestamp   string
+
+	// clock, if not nil, detects steps of the wall clock, which are
+	// annotated and recorded in the summary.
+	clock *clockWatch
+
+	// zones, if not nil, detect DST transitions in the timezones of
+	// absolute timestamps, which are annotated.
+	zones []*zoneWatch
+
+	// DayMarkers prints the full date of the first line, and of every line
+	// on a different day than the previous one, in an annotation, for
+	// timestamps giving the day relative to today. lastDay is the day of
+	// the previous line.
+	DayMarkers bool
+	lastDay    time.Time
+
+	// ElapsedFromFirstOutput measures elapsed timestamps from the first
+	// line of each run rather than its start.
+	ElapsedFromFirstOutput bool
+
+	// QuietFast blanks the timestamps of lines following the previous one
+	// within it.
+	QuietFast time.Duration
+
+	// Sampler, if not nil, selects the lines to print; the others are only
+	// counted in the summary.
+	Sampler *Sampler
+
+	// Rules decorate or alert on the lines matching their conditions.
+	Rules []*Rule
+
+	// Script, if not nil, is called for every line, and may drop, replace,
+	// or decorate it.
+	Script *Script
+
+	// SdNotifier, if not nil, is told about every line, to report readiness
+	// and liveness to systemd.
+	SdNotifier *SdNotifier
+
+	// StatusBar, if not nil, is rendered below the output.
+	StatusBar *StatusBar
+
+	// hideTimestamps drops the prefix from lines, toggled interactively.
+	hideTimestamps bool
+
+	// While the display is paused, output is held back in held, up to
+	// PauseBuffer lines, beyond which printing blocks until it is resumed.
+	PauseBuffer int
+	paused      bool
+	held        bytes.Buffer
+	heldLines   int
+	resumed     *sync.Cond
+
+	// mu serializes writes to the terminal.
+	mu sync.Mutex
+}
+
+func (p *Printer) PrintStream(r io.Reader) {
+	p.PrintLabeledStream(r, "")
+}
+
+// PrintTerminalStream prints the output of a command on a pseudo-terminal,
+// whose line feeds the terminal driver turned into CRLF.
+func (p *Printer) PrintTerminalStream(r io.Reader) {
+	p.printStream(r, "", true)
+}
+
+// PrintLabeledStream prints the lines read from r, labeled with label in
+// addition to Label, to tell apart several streams printed at once.
+func (p *Printer) PrintLabeledStream(r io.Reader, label string) {
+	p.printStream(r, label, false)
+}
+
+func (p *Printer) printStream(r io.Reader, label string, terminal bool) {
+	raw := &checksumWriter{printer: p, terminal: terminal}
+	defer raw.Flush()
+	r = io.TeeReader(r, raw)
+	if p.Filter != "" {
+		r = filterStream(r, p.Filter)
+	}
+	scanner := bufio.NewScanner(r)
+	// Split on \r\n|\r|\n, and return the line as well as the line ending (\r
+	// or \n is preserved, \r\n is collapsed to \n). Adaptation of
+	// bufio.ScanLines.
+	scanner.Split(func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+		if atEOF && len(data) == 0 {
+			return 0, nil, nil
+		}
+		lfpos := bytes.IndexByte(data, '\n')
+		crpos := bytes.IndexByte(data, '\r')
+		if crpos >= 0 {
+			if lfpos < 0 || lfpos > crpos+1 {
+				// We have a CR-terminated "line".
+				return crpos + 1, data[0 : crpos+1], nil
+			}
+			if lfpos == crpos+1 {
+				// We have a CRLF-terminated line.
+				return lfpos + 1, append(data[0:crpos], '\n'), nil
+			}
+		}
+		if lfpos >= 0 {
+			// We have a LF-terminated line.
+			return lfpos + 1, data[0 : lfpos+1], nil
+		}
+		// If we're at EOF, we have a final, non-terminated line. Return it.
+		if atEOF {
+			return len(data), data, nil
+		}
+		// Request more data.
+		return 0, nil, nil
+	})
+	for scanner.Scan() {
+		p.PrintLabeledLine(scanner.Text(), label)
+	}
+}
+
+// PrintLine prints a single line, including its line ending if any.
+func (p *Printer) PrintLine(line string) {
+	p.PrintLabeledLine(line, "")
+}
+
+// PrintLabeledLine prints a single line labeled with label in addition to
+// Label.
+func (p *Printer) PrintLabeledLine(line string, label string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.waitHeld()
+	if p.paused {
+		p.heldLines++
+	}
+	// Every line is counted by level, whether it ends up printed or not.
+	level := ""
+	if p.Levels != nil {
+		level = p.Levels.Detect(ansiEscapes.ReplaceAllString(line, ""))
+		if level != "" {
+			p.Summary.CountLevel(level)
+		}
+	}
+	if p.GitHubActions && p.printWorkflowCommand(line) {
+		return
+	}
+	now := time.Now()
+	if p.ParseTimestamps != nil {
+		if t, rest, ok := p.ParseTimestamps(line); ok {
+			if p.lastParsed.IsZero() && p.Summary.Lines == 0 {
+				// Measure from the first timestamp in the input.
+				p.Timestamper.Rebase(t)
+				p.Summary.Rebase(t)
+			}
+			p.lastParsed = t
+			line = rest
+		}
+		now = p.now()
+	}
+	if p.MarkerPattern != nil && p.MarkerPattern.MatchString(ansiEscapes.ReplaceAllString(line, "")) {
+		p.Timestamper.Mark(now)
+	}
+	if p.PhasePattern != nil || p.PhaseTree != nil {
+		p.matchPhases(ansiEscapes.ReplaceAllString(line, ""), now)
+	}
+	if p.GoTest != nil {
+		p.GoTest.Record(line, now)
+	}
+	p.SdNotifier.Line(line)
+	if p.ParseTimestamps == nil && p.Timestamper.Mode == AbsoluteTimeMode {
+		if p.clock == nil {
+			p.clock = newClockWatch(now)
+		} else if step, ok := p.clock.Check(now); ok {
+			p.Summary.ClockSteps = append(p.Summary.ClockSteps, step)
+			p.printAnnotation(step.String())
+		}
+	}
+	if p.Timestamper.Mode == AbsoluteTimeMode {
+		if p.zones == nil {
+			p.zones = []*zoneWatch{newZoneWatch(p.Timestamper.TZ)}
+			if p.Timestamper.SecondTZ != nil {
+				p.zones = append(p.zones, newZoneWatch(p.Timestamper.SecondTZ))
+			}
+		}
+		for _, zone := range p.zones {
+			if transition, ok := zone.Check(now.Add(p.Timestamper.Offset)); ok {
+				p.printAnnotation(transition)
+			}
+		}
+	}
+	if p.DayMarkers {
+		day := now.Add(p.Timestamper.Offset).In(p.Timestamper.TZ)
+		if p.lastDay.IsZero() || !sameDay(day, p.lastDay) {
+			p.printAnnotation(day.Format("===== Monday, 2006-01-02 ====="))
+		}
+		p.lastDay = day
+	}
+	var scripted scriptEffects
+	if p.Script != nil {
+		env := &ruleEnv{
+			delta:   now.Sub(p.Summary.lastLine),
+			elapsed: now.Sub(p.Summary.Start),
+			level:   level,
+			lineno:  p.Summary.Lines + 1,
+		}
+		line, scripted = p.Script.OnLine(line, env, strings.TrimPrefix(p.labeled("", label), " "))
+		if scripted.drop {
+			return
+		}
+	}
+	if p.ElapsedFromFirstOutput && p.Summary.Lines == 0 {
+		p.Timestamper.Rebase(now)
+	}
+	gap := p.Summary.RecordLine(now, len(line))
+	p.periodLines++
+	if p.Repeats != nil {
+		text := strings.TrimRight(ansiEscapes.ReplaceAllString(line, ""), "\r\n")
+		if p.Repeats.Squash(label+"\x00"+text, text, now) {
+			return
+		}
+		for _, report := range p.Repeats.Recurred() {
+			p.printAnnotation(report)
+		}
+	}
+	if !p.Sampler.Sample(now) {
+		p.Summary.Skipped++
+		return
+	}
+	delta := now.Sub(p.Timestamper.LastTimestamp)
+	prefix := p.Timestamper.AdvanceTo(now)
+	if delta < p.QuietFast {
+		prefix = blankTimestamp(prefix)
+	}
+	if p.DedupTimestamps {
+		if prefix == p.lastTimestamp {
+			prefix = blankTimestamp(prefix)
+		} else {
+			p.lastTimestamp = prefix
+		}
+	}
+	event := &LineEvent{
+		Text:    strings.TrimRight(ansiEscapes.ReplaceAllString(line, ""), "\r\n"),
+		Time:    now,
+		Line:    p.Summary.Lines,
+		Elapsed: now.Sub(p.Summary.Start),
+		Delta:   gap,
+		Level:   level,
+		Label:   strings.TrimPrefix(p.labeled("", label), " "),
+	}
+	var matched []*Rule
+	var ruleColor string
+	if len(p.Rules) > 0 {
+		matched, ruleColor = p.matchRules(line, now, gap)
+	}
+	if scripted.color != "" {
+		ruleColor = scripted.color
+	}
+	if matched = append(matched, scripted.actions...); len(matched) > 0 {
+		defer p.applyRules(matched, now)
+	}
+	if p.TAP {
+		event.Printed = p.formatTAPLine(line, p.labeled(prefix, label), now)
+		p.sinks.Send(event)
+		return
+	}
+	line = colorLine(line, ruleColor)
+	if p.hideTimestamps {
+		if p.Audit != nil {
+			line = p.Audit.Next("", line) + " " + line
+		}
+		event.Printed = p.marker(now) + line
+		p.sinks.Send(event)
+		p.splitLine(line)
+		return
+	}
+	if p.SlowThreshold > 0 && gap > p.SlowThreshold {
+		switch p.SlowStyle {
+		case SlowLine:
+			text := slowMarker(gap) + "\n"
+			p.emit(sink.Annotation, slowMarker(gap), p.marker(now)+p.chained(p.labeled(prefix, label), text)+" "+text, now)
+		case SlowColor:
+			prefix = slowColor + ansiEscapes.ReplaceAllString(prefix, "") + "\x1b[0m"
+		}
+	}
+	prefix = p.labeled(prefix, label)
+	if p.Levels != nil {
+		if p.LevelStyle&LevelTag != 0 {
+			prefix += " " + levelTag(level)
+		}
+		if p.LevelStyle&LevelColor != 0 && ruleColor == "" {
+			line = colorLine(line, levelColor(level))
+		}
+	}
+	text := p.chained(prefix, line) + " " + line
+	event.Printed = p.marker(now) + text
+	p.sinks.Send(event)
+	p.splitLine(text)
+}
+
+// splitLine writes text to the file of the open phase with --split-by-phase,
+// giving up on the phase if that fails.
+func (p *Printer) splitLine(text string) {
+	if err := p.SplitByPhase.Write(text); err != nil {
+		log.Printf("error writing phase file: %s", err)
+		p.SplitByPhase.End()
+	}
+}
+
+// matchRules returns the rules whose conditions hold for line, printed at
+// time now after gap, and the color they give it, that of the last one.
+func (p *Printer) matchRules(line string, now time.Time, gap time.Duration) ([]*Rule, string) {
+	text := strings.TrimRight(ansiEscapes.ReplaceAllString(line, ""), "\r\n")
+	env := &ruleEnv{
+		delta:   gap,
+		elapsed: now.Sub(p.Summary.Start),
+		line:    text,
+		lineno:  p.Summary.Lines,
+	}
+	if p.Levels != nil {
+		env.level = p.Levels.Detect(text)
+	}
+	var matched []*Rule
+	color := ""
+	for _, rule := range p.Rules {
+		if rule.Matches(env) {
+			matched = append(matched, rule)
+			if rule.Color != "" {
+				color = rule.Color
+			}
+		}
+	}
+	return matched, color
+}
+
+// applyRules takes the actions of the matched rules, other than coloring,
+// after their line is printed at time now.
+func (p *Printer) applyRules(matched []*Rule, now time.Time) {
+	for _, rule := range matched {
+		if rule.Annotation != "" {
+			p.printAnnotation(rule.Annotation)
+		}
+		if rule.Mark {
+			p.bookmark(now)
+		}
+		if rule.Bell {
+			ringBell()
+		}
+	}
+}
+
+// AddSink adds a sink to be sent every line with its timing, after the
+// output itself.
+func (p *Printer) AddSink(sink Sink) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sinks.Add(sink)
+}
+
+// CloseSinks closes the sinks, delivering what they have left.
+func (p *Printer) CloseSinks() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sinks.Close()
+}
+
+// chained returns prefix followed by the tag of the line made of prefix and
+// text in the audit chain, if any.
+func (p *Printer) chained(prefix string, text string) string {
+	if p.Audit == nil {
+		return prefix
+	}
+	return prefix + " " + p.Audit.Next(prefix, text)
+}
+
+// PrintAuditHead prints the full hash of the last line of the audit chain,
+// outside of the chain, to close the log.
+func (p *Printer) PrintAuditHead() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.Audit == nil {
+		return
+	}
+	now := time.Now()
+	texts := []string{fmt.Sprintf("audit chain head %s (%d %s)", p.Audit.Head(), p.Audit.lines, pluralize(p.Audit.lines, "line", "lines"))}
+	if p.Audit.Key != nil {
+		texts = append(texts, "audit chain head signature "+p.Audit.Signature())
+	}
+	for _, text := range texts {
+		text = annotationTag + " " + text
+		p.emit(sink.Annotation, text, p.marker(now)+p.labeled(p.Timestamper.TimestampString(now), "")+" "+text+"\n", now)
+	}
+}
+
+// PrintAnnotation prints a line of information from ets itself, such as
+// a periodic resource usage sample, marked as such. Annotations are not
+// counted as lines of output.
+func (p *Printer) PrintAnnotation(text string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.printAnnotation(text)
+}
+
+// StartRun starts recording a new run of command in a fresh summary, which
+// it returns, measuring elapsed time from now.
+func (p *Printer) StartRun(command []string) *Summary {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	previous := p.Summary
+	p.Summary = NewSummary(command, now)
+	if previous.Gaps != nil {
+		p.Summary.Gaps = NewGapHistogram()
+	}
+	if previous.Activity != nil {
+		p.Summary.Activity = NewSparkline(previous.Activity.mode(), now)
+	}
+	if previous.ActivityReport != nil {
+		p.Summary.ActivityReport = NewActivityReport(previous.ActivityReport.Bucket, now)
+	}
+	if previous.Checksum != nil {
+		p.Summary.Checksum, _ = NewChecksum(previous.Checksum.Algorithm)
+	}
+	// Batches are written across runs.
+	p.Summary.Batches = previous.Batches
+	p.Timestamper.Rebase(now)
+	return p.Summary
+}
+
+// RebaseTimestamps measures elapsed and incremental timestamps from t, such
+// as when the command starts.
+func (p *Printer) RebaseTimestamps(t time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Timestamper.Rebase(t)
+}
+
+// PrintSummary writes summary to the output.
+func (p *Printer) PrintSummary(summary *Summary) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var report bytes.Buffer
+	summary.Print(&report)
+	if summary.Gaps != nil {
+		summary.Gaps.Print(&report)
+	}
+	if summary.ActivityReport != nil {
+		summary.ActivityReport.Print(&report, summary.End)
+	}
+	p.emit(sink.Report, strings.TrimSuffix(report.String(), "\n"), report.String(), p.now())
+}
+
+// Bookmark prints a numbered bookmark line, recorded in the summary.
+func (p *Printer) Bookmark() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.bookmark(p.now())
+}
+
+// bookmark prints a numbered bookmark line for time now, which becomes the
+// latest marker.
+func (p *Printer) bookmark(now time.Time) {
+	n := p.Summary.RecordMark(now)
+	p.Timestamper.Mark(now)
+	p.printAnnotation(fmt.Sprintf("===== MARK %d =====", n))
+}
+
+// PrintNote prints a note from the operator, such as "restarted the DB
+// now", as a distinct annotation, recorded in the summary.
+func (p *Printer) PrintNote(text string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Summary.Notes = append(p.Summary.Notes, Note{p.now(), text})
+	p.printAnnotation("NOTE: " + text)
+}
+
+// printNotice prints an annotation straight to Out, even while the display
+// is paused, in response to the user.
+func (p *Printer) printNotice(text string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.writeNotice(text)
+}
+
+// writeNotice writes text as an annotation straight to Out. Notices are
+// feedback on the display rather than output, and are not sent to the
+// sinks. Must be called with the printer locked.
+func (p *Printer) writeNotice(text string) {
+	// Notices jumping ahead of held output are left out of the audit chain,
+	// which follows the order of the output.
+	fmt.Fprint(p.Out, p.formatAnnotation(text, p.now(), p.held.Len() == 0))
+}
+
+// printAnnotation prints text as an annotation, stamped with the current
+// time, or that of the latest line when timestamps are parsed from the
+// input. Must be called with the printer locked.
+func (p *Printer) printAnnotation(text string) {
+	now := p.now()
+	p.emit(sink.Annotation, annotationTag+" "+text, p.formatAnnotation(text, now, true), now)
+}
+
+// formatAnnotation returns text as an annotation printed at time now, tagged
+// in the audit chain if chained.
+func (p *Printer) formatAnnotation(text string, now time.Time, chained bool) string {
+	line := annotationTag + " " + text + "\n"
+	prefix := p.labeled(p.Timestamper.TimestampString(now), "")
+	if chained {
+		prefix = p.chained(prefix, line)
+	}
+	printed := p.marker(now) + prefix + " " + line
+	if p.TAP {
+		// Keep the TAP stream valid.
+		printed = "# " + printed
+	}
+	return printed
+}
+
+// emit sends what ets adds to the output at time now, other than lines of
+// output, to the sinks: text of kind, as printed. Must be called with the
+// printer locked.
+func (p *Printer) emit(kind sink.Kind, text string, printed string, now time.Time) {
+	p.sinks.Send(&LineEvent{
+		Kind:    kind,
+		Text:    text,
+		Time:    now,
+		Line:    p.Summary.Lines,
+		Elapsed: now.Sub(p.Summary.Start),
+		Label:   strings.TrimPrefix(p.labeled("", ""), " "),
+		Printed: printed,
+	})
+}
+
+// ToggleTimestamps hides the prefix of subsequent lines, or shows it again
+// if hidden, and reports whether it is now shown.
+func (p *Printer) ToggleTimestamps() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.hideTimestamps = !p.hideTimestamps
+	return !p.hideTimestamps
+}
+
+// marker returns the invisible marker, if any, starting a line printed at
+// time t.
+func (p *Printer) marker(t time.Time) string {
+	if p.Buildkite {
+		return buildkiteTimestamp(t)
+	}
+	return ""
+}
+
+// ReportRepeats prints the counts of the suppressed repeats that have come
+// due, or all of them with flush.
+func (p *Printer) ReportRepeats(flush bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, report := range p.Repeats.Reports(p.now(), flush) {
+		p.printAnnotation(report)
+	}
+}
+
+// ClosePhase ends the open phase, if any.
+func (p *Printer) ClosePhase() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.endPhase(p.now())
+}
+
+// Now returns the current time, which is that of the latest parsed
+// timestamp if timestamps are parsed from the input.
+func (p *Printer) Now() time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.now()
+}
+
+func (p *Printer) now() time.Time {
+	if p.ParseTimestamps != nil && !p.lastParsed.IsZero() {
+		return p.lastParsed
+	}
+	return time.Now()
+}
+
+// startPhase ends the open phase, if any, and starts a new one. Must be
+// called with the printer locked.
+func (p *Printer) startPhase(name string, t time.Time) {
+	p.endPhase(t)
+	phase := p.Summary.StartPhase(name, t)
+	p.PhaseTree.Start(0, name, t)
+	if err := p.SplitByPhase.Start(name, t.Add(p.Timestamper.Offset).In(p.Timestamper.TZ)); err != nil {
+		log.Printf("error creating phase file: %s", err)
+	}
+	if p.TeamCity {
+		p.emitDirective(teamcityBlockOpened(phase), t)
+	}
+}
+
+// matchPhases starts the phase or sub-phase started by line, if any. Must be
+// called with the printer locked.
+func (p *Printer) matchPhases(line string, t time.Time) {
+	if p.PhasePattern != nil {
+		if name, ok := matchPhase(p.PhasePattern, line); ok {
+			p.startPhase(name, t)
+			return
+		}
+	}
+	if depth, name, ok := p.PhaseTree.MatchSubphase(line); ok {
+		p.PhaseTree.Start(depth, name, t)
+	}
+}
+
+// endPhase ends the open phase, if any. Must be called with the printer
+// locked.
+func (p *Printer) endPhase(t time.Time) {
+	p.PhaseTree.End(t)
+	p.SplitByPhase.End()
+	phase := p.Summary.EndPhase(t)
+	if phase == nil {
+		return
+	}
+	if p.GitHubActions {
+		p.emitDirective(githubNotice(phase), t)
+	}
+	if p.TeamCity {
+		p.emitDirective(teamcityBlockClosed(phase), t)
+	}
+}
+
+// printWorkflowCommand passes line through as is if it is a workflow
+// command, starting or ending a phase as appropriate, and reports whether
+// it did. Must be called with the printer locked.
+func (p *Printer) printWorkflowCommand(line string) bool {
+	command, data, ok := parseWorkflowCommand(line)
+	if !ok {
+		return false
+	}
+	// Commands are only recognized on lines of their own.
+	if !strings.HasSuffix(line, "\n") {
+		line = strings.TrimSuffix(line, "\r") + "\n"
+	}
+	now := p.now()
+	switch command {
+	case "group":
+		p.endPhase(now)
+		p.emitDirective(line, now)
+		p.startPhase(data, now)
+	case "endgroup":
+		p.emitDirective(line, now)
+		p.endPhase(now)
+	default:
+		p.emitDirective(line, now)
+	}
+	return true
+}
+
+// emitDirective sends line, a directive for a CI system, to the sinks as
+// is. Must be called with the printer locked.
+func (p *Printer) emitDirective(line string, now time.Time) {
+	p.emit(sink.Directive, strings.TrimRight(ansiEscapes.ReplaceAllString(line, ""), "\r\n"), line, now)
+}
+
+// labeled appends Label and label, if not empty, to timestamp.
+func (p *Printer) labeled(timestamp string, label string) string {
+	for _, l := range []string{p.Label, label} {
+		if l != "" {
+			timestamp += " " + l
+		}
+	}
+	return timestamp
+}
+
+// blankTimestamp returns spaces as wide as timestamp, to keep the lines
+// aligned.
+func blankTimestamp(timestamp string) string {
+	return strings.Repeat(" ", runewidth.StringWidth(ansiEscapes.ReplaceAllString(timestamp, "")))
+}
+
+// PrefixWidth returns the display width of the prefix of a line, including
+// the label and the separating space.
+func (p *Printer) PrefixWidth() int {
+	plainTimestampString := ansiEscapes.ReplaceAllString(p.labeled(p.Timestamper.TimestampString(time.Now()), ""), "")
+	width := runewidth.StringWidth(plainTimestampString) + 1
+	if p.Levels != nil && p.LevelStyle&LevelTag != 0 {
+		width += levelTagWidth + 1
+	}
+	if p.Audit != nil {
+		width += auditTagDigits + 2
+	}
+	return width
+}
+
+// matchPhase returns the name of the phase started by line, if it matches
+// pattern.
+func matchPhase(pattern *regexp.Regexp, line string) (string, bool) {
+	line = strings.TrimRight(line, "\r\n")
+	m := pattern.FindStringSubmatch(line)
+	if m == nil {
+		return "", false
+	}
+	if len(m) > 1 {
+		return m[1], true
+	}
+	return m[0], true
+}
+
+// annotationTag marks lines injected by ets.
+const annotationTag = "[ets]"
+
+// colorLine wraps the content of line, excluding the line ending, in the
+// given SGR color sequence.
+func colorLine(line string, color string) string {
+	if color == "" {
+		return line
+	}
+	content := strings.TrimRight(line, "\r\n")
+	return color + content + "\x1b[0m" + line[len(content):]
+}
+
+// SlowStyle controls how lines following slow gaps are marked.
+type SlowStyle int
+
+const (
+	// SlowLine inserts a marker line stating the length of the gap.
+	SlowLine SlowStyle = iota
+	// SlowColor highlights the timestamp.
+	SlowColor
+)
+
+const slowColor = "\x1b[1;31m"
+
+func parseSlowStyle(s string) (SlowStyle, error) {
+	switch s {
+	case "line":
+		return SlowLine, nil
+	case "color":
+		return SlowColor, nil
+	}
+	return 0, fmt.Errorf("invalid slow gap marking style %q: expected line or color", s)
+}
+
+func slowMarker(gap time.Duration) string {
+	return fmt.Sprintf(">>>>> %s gap", gap.Round(100*time.Millisecond))
+}
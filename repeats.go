@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// RepeatSquasher suppresses lines identical to one seen within a window,
+// not only consecutive ones, counting them.
+type RepeatSquasher struct {
+	Window time.Duration
+
+	seen map[string]*repeatedLine
+	// recurred are the lines seen again after their window with repeats
+	// not yet reported.
+	recurred []*repeatedLine
+}
+
+type repeatedLine struct {
+	text     string
+	lastSeen time.Time
+	// count repeats were suppressed since the last report, the first at
+	// since.
+	count int
+	since time.Time
+}
+
+func NewRepeatSquasher(window time.Duration) *RepeatSquasher {
+	return &RepeatSquasher{Window: window, seen: make(map[string]*repeatedLine)}
+}
+
+// Squash reports whether the line with key, displayed as text, at time t
+// repeats one seen within the window and is to be suppressed.
+func (r *RepeatSquasher) Squash(key string, text string, t time.Time) bool {
+	if r == nil {
+		return false
+	}
+	if line, ok := r.seen[key]; ok && t.Sub(line.lastSeen) <= r.Window {
+		line.lastSeen = t
+		if line.count == 0 {
+			line.since = t
+		}
+		line.count++
+		return true
+	} else if ok && line.count > 0 {
+		r.recurred = append(r.recurred, line)
+	}
+	r.seen[key] = &repeatedLine{text: text, lastSeen: t}
+	return false
+}
+
+// Reports returns the counts of suppressed repeats due at time t, or all of
+// them with flush, as annotations in order of the first repeat, and forgets
+// the lines not seen within the window. A count is due once it spans the
+// window or its line is no longer repeated.
+func (r *RepeatSquasher) Reports(t time.Time, flush bool) []string {
+	if r == nil {
+		return nil
+	}
+	var due []*repeatedLine
+	for key, line := range r.seen {
+		expired := t.Sub(line.lastSeen) > r.Window
+		if line.count > 0 && (flush || expired || t.Sub(line.since) >= r.Window) {
+			due = append(due, &repeatedLine{text: line.text, count: line.count, since: line.since})
+			line.count = 0
+		}
+		if expired {
+			delete(r.seen, key)
+		}
+	}
+	due = append(due, r.recurred...)
+	r.recurred = nil
+	return formatRepeats(due)
+}
+
+// Recurred returns the counts of suppressed repeats of lines seen again
+// after their window, which are due before the line is printed again.
+func (r *RepeatSquasher) Recurred() []string {
+	if r == nil || len(r.recurred) == 0 {
+		return nil
+	}
+	due := r.recurred
+	r.recurred = nil
+	return formatRepeats(due)
+}
+
+// formatRepeats returns the counts of repeats of lines as annotations in
+// order of the first repeat.
+func formatRepeats(due []*repeatedLine) []string {
+	sort.Slice(due, func(i, j int) bool { return due[i].since.Before(due[j].since) })
+	reports := make([]string, len(due))
+	for i, line := range due {
+		reports[i] = fmt.Sprintf("seen %d more %s since %s: %s", line.count, pluralize(line.count, "time", "times"), line.since.Format("15:04:05"), line.text)
+	}
+	return reports
+}
+
+// reportRepeats prints the counts of the repeats squashed by printer as they
+// come due, checking every interval until done is closed.
+func reportRepeats(printer *Printer, interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			printer.ReportRepeats(false)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// replayLog writes the lines of the log at path, or stdin if path is empty,
+// to w without their timestamps, parsed by parser in the given mode, waiting
+// between lines as long as their timestamps are apart, divided by speed, and
+// at most maxWait if positive. ets annotations are left out; lines without a
+// timestamp are written as they are, right away.
+func replayLog(w io.Writer, path string, parser *FormatParser, mode TimestampMode, speed float64, maxWait time.Duration) error {
+	r := io.Reader(os.Stdin)
+	if path != "" && path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	clock := &logClock{hasDate: parser.HasDate, mode: mode}
+	var previous time.Duration
+	started := false
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadString('\n')
+		if line == "" && err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		content := strings.TrimRight(line, "\r\n")
+		// Colors are kept in the text, if the timestamp parses without them.
+		t, text, ok := parser.Parse(content)
+		if !ok {
+			t, text, ok = parser.Parse(ansiEscapes.ReplaceAllString(content, ""))
+		}
+		if ok && strings.HasPrefix(text, annotationTag) {
+			continue
+		}
+		if ok {
+			offset := clock.Offset(t)
+			if started {
+				wait := time.Duration(float64(offset-previous) / speed)
+				if maxWait > 0 && wait > maxWait {
+					wait = maxWait
+				}
+				time.Sleep(wait)
+			}
+			previous, started = offset, true
+			line = text + line[len(content):]
+		}
+		if _, err := fmt.Fprint(w, line); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// processTreeSample is a snapshot of the resource usage of a process and its
+// descendants.
+type processTreeSample struct {
+	t         time.Time
+	cpuTime   time.Duration
+	rss       int64
+	processes int
+}
+
+// sampleResources annotates the output with the CPU usage and RSS of the
+// process tree rooted at pid every interval, until done is closed.
+func sampleResources(printer *Printer, pid int, interval time.Duration, done <-chan struct{}) {
+	prev, err := sampleProcessTree(pid)
+	if err != nil {
+		printer.PrintAnnotation("resource sampling unavailable: " + err.Error())
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			sample, err := sampleProcessTree(pid)
+			if err != nil {
+				// Most likely the child has just exited.
+				continue
+			}
+			// CPU time of descendants that exited since the previous
+			// sample is lost, so the delta may come out negative.
+			cpu := sample.cpuTime - prev.cpuTime
+			if cpu < 0 {
+				cpu = 0
+			}
+			percent := 100 * cpu.Seconds() / sample.t.Sub(prev.t).Seconds()
+			printer.PrintAnnotation(fmt.Sprintf("cpu %.1f%%, rss %s, %d %s",
+				percent, formatBytes(sample.rss), sample.processes, pluralize(sample.processes, "process", "processes")))
+			prev = sample
+		}
+	}
+}
+
+func pluralize(n int, singular string, plural string) string {
+	if n == 1 {
+		return singular
+	}
+	return plural
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Clock ticks per second for the times in /proc/[pid]/stat. This is
+// USER_HZ, which is 100 on all mainstream architectures.
+const clockTicks = 100
+
+type procStat struct {
+	ppid    int
+	cpuTime time.Duration
+	rss     int64
+}
+
+// sampleProcessTree sums up the CPU time and RSS of pid and all its
+// descendants, found by walking /proc.
+func sampleProcessTree(pid int) (*processTreeSample, error) {
+	now := time.Now()
+	entries, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+	stats := make(map[int]*procStat)
+	children := make(map[int][]int)
+	for _, entry := range entries {
+		p, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		stat, err := readProcStat(p)
+		if err != nil {
+			// Processes may vanish while we're walking.
+			continue
+		}
+		stats[p] = stat
+		children[stat.ppid] = append(children[stat.ppid], p)
+	}
+	if _, ok := stats[pid]; !ok {
+		return nil, fmt.Errorf("process %d not found", pid)
+	}
+	sample := &processTreeSample{t: now}
+	queue := []int{pid}
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+		stat := stats[p]
+		sample.cpuTime += stat.cpuTime
+		sample.rss += stat.rss
+		sample.processes++
+		queue = append(queue, children[p]...)
+	}
+	return sample, nil
+}
+
+func readProcStat(pid int) (*procStat, error) {
+	content, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return nil, err
+	}
+	// The command name in parentheses may contain spaces; fields are
+	// counted from after the closing parenthesis, starting at field 3.
+	s := string(content)
+	i := strings.LastIndexByte(s, ')')
+	if i < 0 {
+		return nil, fmt.Errorf("malformed /proc/%d/stat", pid)
+	}
+	fields := strings.Fields(s[i+1:])
+	if len(fields) < 22 {
+		return nil, fmt.Errorf("malformed /proc/%d/stat", pid)
+	}
+	field := func(n int) int64 {
+		v, _ := strconv.ParseInt(fields[n-3], 10, 64)
+		return v
+	}
+	ticks := field(14) + field(15) // utime + stime
+	return &procStat{
+		ppid:    int(field(4)),
+		cpuTime: time.Duration(ticks) * time.Second / clockTicks,
+		rss:     field(24) * int64(os.Getpagesize()),
+	}, nil
+}
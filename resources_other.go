@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "errors"
+
+func sampleProcessTree(pid int) (*processTreeSample, error) {
+	return nil, errors.New("not supported on this platform")
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// runUntilSuccess calls run, which runs the command, until it exits zero,
+// waiting delay between attempts, for at most maxAttempts attempts if
+// positive, or until interrupted. Attempts are annotated in the output,
+// compared to the previous one, and recorded in the summary. It returns the
+// error of the last attempt.
+func runUntilSuccess(run func() error, printer *Printer, maxAttempts int, delay time.Duration) error {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	stats := &IterationStats{Name: "attempt", List: true}
+	printer.mu.Lock()
+	printer.Summary.Iterations = stats
+	printer.mu.Unlock()
+	for attempt := 1; ; attempt++ {
+		printer.PrintAnnotation(fmt.Sprintf("attempt %d started", attempt))
+		start := printer.Now()
+		printer.mu.Lock()
+		startLines := printer.Summary.Lines
+		printer.mu.Unlock()
+		err := run()
+		exitCode := 0
+		if err != nil {
+			exitErr, ok := err.(*exec.ExitError)
+			if !ok {
+				return err
+			}
+			exitCode = exitErr.ExitCode()
+		}
+		duration := printer.Now().Sub(start)
+		printer.mu.Lock()
+		stats.Record(Iteration{exitCode, duration, printer.Summary.Lines - startLines})
+		description := stats.Describe(attempt - 1)
+		printer.mu.Unlock()
+
+		if exitCode == 0 {
+			printer.PrintAnnotation(fmt.Sprintf("attempt %d succeeded: %s", attempt, description))
+			return nil
+		}
+		failure := fmt.Sprintf("attempt %d failed: %s", attempt, description)
+		select {
+		case <-sigs:
+			printer.PrintAnnotation(failure + "; interrupted")
+			return err
+		default:
+		}
+		if maxAttempts > 0 && attempt >= maxAttempts {
+			printer.PrintAnnotation(failure + "; giving up")
+			return err
+		}
+		printer.PrintAnnotation(fmt.Sprintf("%s; retrying in %s", failure, delay))
+		printer.StatusBar.SetState(fmt.Sprintf("waiting to retry (attempt %d)", attempt+1))
+		select {
+		case <-sigs:
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
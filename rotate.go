@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// rotatingFile appends to the file at path, which Rotate moves aside as
+// path.1, shifting older files up to path.keep, beyond which they are
+// overwritten.
+type rotatingFile struct {
+	path string
+	keep int
+	f    *os.File
+}
+
+func newRotatingFile(path string, keep int) (*rotatingFile, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &rotatingFile{path: path, keep: keep, f: f}, nil
+}
+
+func (r *rotatingFile) Write(b []byte) (int, error) {
+	return r.f.Write(b)
+}
+
+// Rotate starts a new file, unless the current one is empty.
+func (r *rotatingFile) Rotate() error {
+	if info, err := r.f.Stat(); err == nil && info.Size() == 0 {
+		return nil
+	}
+	_ = r.f.Close()
+	for i := r.keep; i > 0; i-- {
+		older := r.rotated(i - 1)
+		if _, err := os.Stat(older); err == nil {
+			if err := os.Rename(older, r.rotated(i)); err != nil {
+				return err
+			}
+		}
+	}
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	r.f = f
+	return nil
+}
+
+// rotated returns the path of the ith most recent rotated file, or of the
+// current file for 0.
+func (r *rotatingFile) rotated(i int) string {
+	if i == 0 {
+		return r.path
+	}
+	return fmt.Sprintf("%s.%d", r.path, i)
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Rule decorates or alerts on the lines for which its condition holds, as
+// given by --when and --then.
+type Rule struct {
+	when *ruleExpr
+
+	// Color, if not empty, colors the line.
+	Color string
+	// Mark drops a bookmark after the line.
+	Mark bool
+	// Bell rings the terminal bell.
+	Bell bool
+	// Annotation, if not empty, is printed after the line.
+	Annotation string
+}
+
+// ruleEnv holds the variables of rule conditions for a line, or of alert
+// conditions at some point of the run.
+type ruleEnv struct {
+	delta   time.Duration
+	elapsed time.Duration
+	gap     time.Duration
+	line    string
+	level   string
+	lineno  int
+}
+
+// Colors of the color= action.
+var ruleColors = map[string]string{
+	"red":     "\x1b[31m",
+	"green":   "\x1b[32m",
+	"yellow":  "\x1b[33m",
+	"blue":    "\x1b[34m",
+	"magenta": "\x1b[35m",
+	"cyan":    "\x1b[36m",
+	"gray":    "\x1b[90m",
+	"bold":    "\x1b[1m",
+}
+
+// NewRule parses a rule from the condition of --when and the actions of
+// --then.
+func NewRule(when string, then string) (*Rule, error) {
+	expr, err := parseRuleExpr(when, ruleVariables)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --when %q: %s", when, err)
+	}
+	if expr.typ != ruleBool {
+		return nil, fmt.Errorf("invalid --when %q: not a condition", when)
+	}
+	rule := &Rule{when: expr}
+	for _, action := range strings.Split(then, ",") {
+		action = strings.TrimSpace(action)
+		name, arg := action, ""
+		if i := strings.IndexByte(action, '='); i >= 0 {
+			name, arg = action[:i], action[i+1:]
+		}
+		switch {
+		case name == "color" && ruleColors[arg] != "":
+			rule.Color = ruleColors[arg]
+		case name == "mark" && arg == "":
+			rule.Mark = true
+		case name == "bell" && arg == "":
+			rule.Bell = true
+		case name == "annotate" && arg != "":
+			rule.Annotation = arg
+		default:
+			return nil, fmt.Errorf("invalid --then action %q: expected color=red (or green, yellow, blue, magenta, cyan, gray, bold), mark, bell, or annotate=text", action)
+		}
+	}
+	return rule, nil
+}
+
+// Matches reports whether the condition of the rule holds for env.
+func (r *Rule) Matches(env *ruleEnv) bool {
+	return r.when.eval(env).(bool)
+}
+
+// Types of the values of rule expressions.
+type ruleType int
+
+const (
+	ruleBool ruleType = iota
+	ruleDuration
+	ruleNumber
+	ruleString
+)
+
+func (t ruleType) String() string {
+	return [...]string{"condition", "duration", "number", "string"}[t]
+}
+
+// ruleExpr is a type-checked expression, evaluated to a bool, a
+// time.Duration, a float64, or a string according to its type.
+type ruleExpr struct {
+	typ  ruleType
+	eval func(env *ruleEnv) interface{}
+}
+
+var ruleVariables = map[string]*ruleExpr{
+	"delta":   {ruleDuration, func(env *ruleEnv) interface{} { return env.delta }},
+	"elapsed": {ruleDuration, func(env *ruleEnv) interface{} { return env.elapsed }},
+	"line":    {ruleString, func(env *ruleEnv) interface{} { return env.line }},
+	"level":   {ruleString, func(env *ruleEnv) interface{} { return env.level }},
+	"lineno":  {ruleNumber, func(env *ruleEnv) interface{} { return float64(env.lineno) }},
+}
+
+type ruleToken struct {
+	kind string // "ident", "duration", "number", "string", or the operator
+	text string
+}
+
+var ruleOperators = []string{"&&", "||", "<=", ">=", "==", "!=", "<", ">", "!", "(", ")"}
+
+func tokenizeRuleExpr(s string) ([]ruleToken, error) {
+	var tokens []ruleToken
+	for i := 0; i < len(s); {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '"':
+			j := i + 1
+			for j < len(s) && s[j] != '"' {
+				if s[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(s) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			text, err := strconv.Unquote(s[i : j+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string %s", s[i:j+1])
+			}
+			tokens = append(tokens, ruleToken{"string", text})
+			i = j + 1
+		case unicode.IsDigit(c) || c == '.':
+			j := i
+			for j < len(s) && (unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j])) || s[j] == '.') {
+				j++
+			}
+			kind := "number"
+			if unicode.IsLetter(rune(s[j-1])) {
+				kind = "duration"
+			}
+			tokens = append(tokens, ruleToken{kind, s[i:j]})
+			i = j
+		case unicode.IsLetter(c) || c == '_':
+			j := i
+			for j < len(s) && (unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j])) || s[j] == '_') {
+				j++
+			}
+			tokens = append(tokens, ruleToken{"ident", s[i:j]})
+			i = j
+		default:
+			matched := false
+			for _, op := range ruleOperators {
+				if strings.HasPrefix(s[i:], op) {
+					tokens = append(tokens, ruleToken{op, op})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected %q", c)
+			}
+		}
+	}
+	return tokens, nil
+}
+
+// ruleParser parses rule expressions by recursive descent:
+//
+//	expr    = and { "||" and }
+//	and     = unary { "&&" unary }
+//	unary   = "!" unary | compare
+//	compare = operand [ ( "<" | "<=" | ">" | ">=" | "==" | "!=" | "matches" | "contains" ) operand ]
+//	operand = "(" expr ")" | variable | duration | number | string
+type ruleParser struct {
+	tokens    []ruleToken
+	pos       int
+	variables map[string]*ruleExpr
+}
+
+// parseRuleExpr parses the expression s over variables.
+func parseRuleExpr(s string, variables map[string]*ruleExpr) (*ruleExpr, error) {
+	tokens, err := tokenizeRuleExpr(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &ruleParser{tokens: tokens, variables: variables}
+	expr, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q", p.tokens[p.pos].text)
+	}
+	return expr, nil
+}
+
+func (p *ruleParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos].kind
+	}
+	return ""
+}
+
+func (p *ruleParser) or() (*ruleExpr, error) {
+	return p.logical("||", p.and, func(a, b bool) bool { return a || b })
+}
+
+func (p *ruleParser) and() (*ruleExpr, error) {
+	return p.logical("&&", p.unary, func(a, b bool) bool { return a && b })
+}
+
+func (p *ruleParser) logical(op string, operand func() (*ruleExpr, error), combine func(a, b bool) bool) (*ruleExpr, error) {
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == op {
+		p.pos++
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		if left.typ != ruleBool || right.typ != ruleBool {
+			return nil, fmt.Errorf("%s needs conditions", op)
+		}
+		a, b := left, right
+		isOr := op == "||"
+		left = &ruleExpr{ruleBool, func(env *ruleEnv) interface{} {
+			x := a.eval(env).(bool)
+			if x == isOr {
+				return x
+			}
+			return combine(x, b.eval(env).(bool))
+		}}
+	}
+	return left, nil
+}
+
+func (p *ruleParser) unary() (*ruleExpr, error) {
+	if p.peek() == "!" {
+		p.pos++
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		if operand.typ != ruleBool {
+			return nil, fmt.Errorf("! needs a condition")
+		}
+		return &ruleExpr{ruleBool, func(env *ruleEnv) interface{} { return !operand.eval(env).(bool) }}, nil
+	}
+	return p.compare()
+}
+
+func (p *ruleParser) compare() (*ruleExpr, error) {
+	left, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	op := p.peek()
+	if op == "ident" {
+		op = p.tokens[p.pos].text
+	}
+	switch op {
+	case "<", "<=", ">", ">=", "==", "!=":
+		p.pos++
+		right, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		return compareRuleExprs(op, left, right)
+	case "matches", "contains":
+		p.pos++
+		if p.peek() != "string" || left.typ != ruleString {
+			return nil, fmt.Errorf("%s needs a string on the left and a string literal on the right", op)
+		}
+		arg := p.tokens[p.pos].text
+		p.pos++
+		if op == "contains" {
+			return &ruleExpr{ruleBool, func(env *ruleEnv) interface{} {
+				return strings.Contains(left.eval(env).(string), arg)
+			}}, nil
+		}
+		re, err := regexp.Compile(arg)
+		if err != nil {
+			return nil, err
+		}
+		return &ruleExpr{ruleBool, func(env *ruleEnv) interface{} {
+			return re.MatchString(left.eval(env).(string))
+		}}, nil
+	}
+	return left, nil
+}
+
+func compareRuleExprs(op string, left *ruleExpr, right *ruleExpr) (*ruleExpr, error) {
+	if left.typ != right.typ {
+		return nil, fmt.Errorf("cannot compare %s with %s", left.typ, right.typ)
+	}
+	if left.typ == ruleBool || (left.typ == ruleString && op != "==" && op != "!=") {
+		return nil, fmt.Errorf("cannot compare %ss with %s", left.typ, op)
+	}
+	// Compare as float64, or as strings for equality.
+	value := func(e *ruleExpr, env *ruleEnv) float64 {
+		switch v := e.eval(env).(type) {
+		case time.Duration:
+			return float64(v)
+		case float64:
+			return v
+		}
+		return 0
+	}
+	return &ruleExpr{ruleBool, func(env *ruleEnv) interface{} {
+		if left.typ == ruleString {
+			return (left.eval(env).(string) == right.eval(env).(string)) == (op == "==")
+		}
+		a, b := value(left, env), value(right, env)
+		switch op {
+		case "<":
+			return a < b
+		case "<=":
+			return a <= b
+		case ">":
+			return a > b
+		case ">=":
+			return a >= b
+		case "==":
+			return a == b
+		default:
+			return a != b
+		}
+	}}, nil
+}
+
+func (p *ruleParser) operand() (*ruleExpr, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("unexpected end")
+	}
+	token := p.tokens[p.pos]
+	p.pos++
+	switch token.kind {
+	case "(":
+		expr, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("missing )")
+		}
+		p.pos++
+		return expr, nil
+	case "ident":
+		variable, ok := p.variables[token.text]
+		if !ok {
+			names := make([]string, 0, len(p.variables))
+			for name := range p.variables {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			last := len(names) - 1
+			return nil, fmt.Errorf("unknown variable %q: expected %s, or %s", token.text, strings.Join(names[:last], ", "), names[last])
+		}
+		return variable, nil
+	case "duration":
+		d, err := time.ParseDuration(token.text)
+		if err != nil {
+			return nil, err
+		}
+		return &ruleExpr{ruleDuration, func(*ruleEnv) interface{} { return d }}, nil
+	case "number":
+		n, err := strconv.ParseFloat(token.text, 64)
+		if err != nil {
+			return nil, err
+		}
+		return &ruleExpr{ruleNumber, func(*ruleEnv) interface{} { return n }}, nil
+	case "string":
+		s := token.text
+		return &ruleExpr{ruleString, func(*ruleEnv) interface{} { return s }}, nil
+	}
+	return nil, fmt.Errorf("unexpected %q", token.text)
+}
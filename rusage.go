@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+// ResourceUsage is the resource usage of a terminated child, as reported by
+// wait4(2).
+type ResourceUsage struct {
+	UserTime               time.Duration
+	SystemTime             time.Duration
+	MaxRSS                 int64 // In bytes.
+	VoluntaryCtxSwitches   int64
+	InvoluntaryCtxSwitches int64
+
+	// Less commonly useful fields, reported by --time-verbose. The integral
+	// sizes are in kilobytes times clock ticks.
+	SharedTextSize   int64
+	UnsharedDataSize int64
+	UnsharedStack    int64
+	MajorFaults      int64
+	MinorFaults      int64
+	Swaps            int64
+	BlockInputs      int64
+	BlockOutputs     int64
+	MessagesSent     int64
+	MessagesReceived int64
+	Signals          int64
+
+	// Signal is the signal that terminated the child, or 0.
+	Signal syscall.Signal
+}
+
+func resourceUsageOf(state *os.ProcessState) *ResourceUsage {
+	if state == nil {
+		return nil
+	}
+	usage := &ResourceUsage{
+		UserTime:   state.UserTime(),
+		SystemTime: state.SystemTime(),
+	}
+	if rusage, ok := state.SysUsage().(*syscall.Rusage); ok {
+		usage.MaxRSS = int64(rusage.Maxrss) * maxrssUnit
+		usage.VoluntaryCtxSwitches = int64(rusage.Nvcsw)
+		usage.InvoluntaryCtxSwitches = int64(rusage.Nivcsw)
+		usage.SharedTextSize = int64(rusage.Ixrss)
+		usage.UnsharedDataSize = int64(rusage.Idrss)
+		usage.UnsharedStack = int64(rusage.Isrss)
+		usage.MajorFaults = int64(rusage.Majflt)
+		usage.MinorFaults = int64(rusage.Minflt)
+		usage.Swaps = int64(rusage.Nswap)
+		usage.BlockInputs = int64(rusage.Inblock)
+		usage.BlockOutputs = int64(rusage.Oublock)
+		usage.MessagesSent = int64(rusage.Msgsnd)
+		usage.MessagesReceived = int64(rusage.Msgrcv)
+		usage.Signals = int64(rusage.Nsignals)
+	}
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		usage.Signal = status.Signal()
+	}
+	return usage
+}
+
+// formatBytes formats n with a binary unit prefix, e.g. 12.3 MiB.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
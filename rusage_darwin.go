@@ -0,0 +1,7 @@
+//go:build darwin
+// +build darwin
+
+package main
+
+// ru_maxrss is in bytes on macOS.
+const maxrssUnit = 1
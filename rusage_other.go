@@ -0,0 +1,7 @@
+//go:build !darwin
+// +build !darwin
+
+package main
+
+// ru_maxrss is in kilobytes on Linux and the BSDs.
+const maxrssUnit = 1024
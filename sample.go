@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sampler selects the lines to print from a stream too heavy to capture in
+// full, either k of every n lines or at most one line per interval. The
+// lines left out are still counted.
+type Sampler struct {
+	Keep, Of int
+	Interval time.Duration
+
+	seen     int
+	lastKept time.Time
+}
+
+// ParseSampleRatio parses a ratio of lines to print, such as 1/100.
+func ParseSampleRatio(s string) (*Sampler, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) == 2 {
+		keep, err1 := strconv.Atoi(parts[0])
+		of, err2 := strconv.Atoi(parts[1])
+		if err1 == nil && err2 == nil && keep > 0 && keep <= of {
+			return &Sampler{Keep: keep, Of: of}, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid sample ratio %q: expected k/n with 0 < k <= n, such as 1/100", s)
+}
+
+// Sample reports whether to print the line read at time t. The first line
+// is always printed.
+func (s *Sampler) Sample(t time.Time) bool {
+	if s == nil {
+		return true
+	}
+	seen := s.seen
+	s.seen++
+	if s.Interval > 0 {
+		if seen > 0 && t.Sub(s.lastKept) < s.Interval {
+			return false
+		}
+		s.lastKept = t
+		return true
+	}
+	return seen%s.Of < s.Keep
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"go.starlark.net/resolve"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// Script is a Starlark script given by --script, whose hooks are called at
+// the start of the run (on_start), for every line (on_line), and at the end
+// (on_exit). Hooks act through builtins: drop and color for the current
+// line, and mark, bell, and annotate anywhere. on_line may also return a
+// string to replace the line with.
+type Script struct {
+	path    string
+	thread  *starlark.Thread
+	onStart starlark.Callable
+	onLine  starlark.Callable
+	onExit  starlark.Callable
+
+	// hook is the name of the hook being called, and effects what its
+	// calls to builtins asked for.
+	hook    string
+	effects scriptEffects
+
+	// failed is set once a hook fails, to stop calling the script.
+	failed bool
+}
+
+// scriptEffects are what a hook asked for through builtins.
+type scriptEffects struct {
+	drop  bool
+	color string
+	// actions are taken after the line, like those of --then.
+	actions []*Rule
+}
+
+// LoadScript runs the Starlark script at path, which defines some of the
+// on_start, on_line, and on_exit hooks.
+func LoadScript(path string) (*Script, error) {
+	s := &Script{
+		path:   path,
+		thread: &starlark.Thread{Name: "ets"},
+	}
+	s.thread.Print = func(_ *starlark.Thread, msg string) {
+		log.Print(msg)
+	}
+	// Allow the Python features scripts would miss, floats first of all,
+	// since the timing of lines is given in seconds.
+	resolve.AllowFloat = true
+	resolve.AllowLambda = true
+	resolve.AllowNestedDef = true
+	resolve.AllowSet = true
+	resolve.AllowGlobalReassign = true
+	builtins := s.builtins()
+	_, program, err := starlark.SourceProgram(path, nil, builtins.Has)
+	if err != nil {
+		return nil, err
+	}
+	// Unlike with starlark.ExecFile, the globals are left unfrozen, for
+	// hooks to keep state across lines, such as counters.
+	globals, err := program.Init(s.thread, builtins)
+	if err != nil {
+		return nil, scriptError(err)
+	}
+	for name, hook := range map[string]*starlark.Callable{
+		"on_start": &s.onStart,
+		"on_line":  &s.onLine,
+		"on_exit":  &s.onExit,
+	} {
+		value, ok := globals[name]
+		if !ok {
+			continue
+		}
+		if *hook, ok = value.(starlark.Callable); !ok {
+			return nil, fmt.Errorf("%s: %s is not a function", path, name)
+		}
+	}
+	if s.onStart == nil && s.onLine == nil && s.onExit == nil {
+		return nil, fmt.Errorf("%s: none of on_start, on_line, and on_exit is defined", path)
+	}
+	return s, nil
+}
+
+// builtins returns the functions available to the hooks of the script.
+func (s *Script) builtins() starlark.StringDict {
+	lineOnly := func(name string) error {
+		if s.hook != "on_line" {
+			return fmt.Errorf("%s: only allowed in on_line", name)
+		}
+		return nil
+	}
+	return starlark.StringDict{
+		"drop": starlark.NewBuiltin("drop", func(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			if err := starlark.UnpackArgs(b.Name(), args, kwargs); err != nil {
+				return nil, err
+			}
+			if err := lineOnly(b.Name()); err != nil {
+				return nil, err
+			}
+			s.effects.drop = true
+			return starlark.None, nil
+		}),
+		"color": starlark.NewBuiltin("color", func(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var name string
+			if err := starlark.UnpackArgs(b.Name(), args, kwargs, "name", &name); err != nil {
+				return nil, err
+			}
+			if err := lineOnly(b.Name()); err != nil {
+				return nil, err
+			}
+			color, ok := ruleColors[name]
+			if !ok {
+				return nil, fmt.Errorf("%s: unknown color %q: expected %s", b.Name(), name, strings.Join(ruleColorNames(), ", "))
+			}
+			s.effects.color = color
+			return starlark.None, nil
+		}),
+		"mark": starlark.NewBuiltin("mark", func(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			if err := starlark.UnpackArgs(b.Name(), args, kwargs); err != nil {
+				return nil, err
+			}
+			s.effects.actions = append(s.effects.actions, &Rule{Mark: true})
+			return starlark.None, nil
+		}),
+		"bell": starlark.NewBuiltin("bell", func(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			if err := starlark.UnpackArgs(b.Name(), args, kwargs); err != nil {
+				return nil, err
+			}
+			s.effects.actions = append(s.effects.actions, &Rule{Bell: true})
+			return starlark.None, nil
+		}),
+		"annotate": starlark.NewBuiltin("annotate", func(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var text string
+			if err := starlark.UnpackArgs(b.Name(), args, kwargs, "text", &text); err != nil {
+				return nil, err
+			}
+			s.effects.actions = append(s.effects.actions, &Rule{Annotation: text})
+			return starlark.None, nil
+		}),
+	}
+}
+
+// call calls hook, named name, with args, returning its result and the
+// effects it asked for. After an error, reported once, the script is no
+// longer called, and the result is nil.
+func (s *Script) call(name string, hook starlark.Callable, args ...starlark.Value) (starlark.Value, scriptEffects) {
+	if s == nil || s.failed || hook == nil {
+		return nil, scriptEffects{}
+	}
+	s.hook, s.effects = name, scriptEffects{}
+	result, err := starlark.Call(s.thread, hook, args, nil)
+	s.hook = ""
+	if err != nil {
+		log.Printf("error in %s, disabling it: %s", s.path, scriptError(err))
+		s.failed = true
+		return nil, scriptEffects{}
+	}
+	return result, s.effects
+}
+
+// OnLine calls on_line with line, without its line ending, and the details
+// of env and label, returning the line, replaced if on_line returned a
+// string, and the other effects asked for.
+func (s *Script) OnLine(line string, env *ruleEnv, label string) (string, scriptEffects) {
+	if s == nil || s.onLine == nil {
+		return line, scriptEffects{}
+	}
+	content := strings.TrimRight(line, "\r\n")
+	args := []starlark.Value{starlark.String(content)}
+	if fn, ok := s.onLine.(*starlark.Function); !ok || fn.NumParams() != 1 {
+		args = append(args, starlarkstruct.FromStringDict(starlark.String("line"), starlark.StringDict{
+			"lineno":  starlark.MakeInt(env.lineno),
+			"elapsed": starlark.Float(env.elapsed.Seconds()),
+			"delta":   starlark.Float(env.delta.Seconds()),
+			"level":   starlark.String(env.level),
+			"label":   starlark.String(label),
+		}))
+	}
+	result, effects := s.call("on_line", s.onLine, args...)
+	if replacement, ok := starlark.AsString(result); ok {
+		line = replacement + line[len(content):]
+	} else if result != nil && result != starlark.None {
+		log.Printf("error in %s, disabling it: on_line returned %s, expected a string or None", s.path, result.Type())
+		s.failed = true
+		return line, scriptEffects{}
+	}
+	return line, effects
+}
+
+// OnStart calls on_start, returning the effects asked for.
+func (s *Script) OnStart() scriptEffects {
+	if s == nil {
+		return scriptEffects{}
+	}
+	_, effects := s.call("on_start", s.onStart)
+	return effects
+}
+
+// OnExit calls on_exit with the exit status of the command, or None in
+// pipe mode, returning the effects asked for.
+func (s *Script) OnExit(summary *Summary) scriptEffects {
+	if s == nil {
+		return scriptEffects{}
+	}
+	var status starlark.Value = starlark.None
+	if summary.Exited {
+		status = starlark.MakeInt(summary.ExitCode)
+	}
+	_, effects := s.call("on_exit", s.onExit, status)
+	return effects
+}
+
+// scriptError includes the Starlark backtrace of err, if any.
+func scriptError(err error) error {
+	if evalErr, ok := err.(*starlark.EvalError); ok {
+		return fmt.Errorf("%s", evalErr.Backtrace())
+	}
+	return err
+}
+
+// ruleColorNames returns the names of the colors of rules, sorted.
+func ruleColorNames() []string {
+	names := make([]string, 0, len(ruleColors))
+	for name := range ruleColors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// StartScript calls the on_start hook of Script, if any.
+func (p *Printer) StartScript() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.applyRules(p.Script.OnStart().actions, p.now())
+}
+
+// ExitScript calls the on_exit hook of Script, if any, once the run is over.
+func (p *Printer) ExitScript() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.applyRules(p.Script.OnExit(p.Summary).actions, p.now())
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"log"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// SdNotifier reports the state of the service to systemd over
+// $NOTIFY_SOCKET: READY=1 on start, or once a line matches ReadyPattern;
+// WATCHDOG=1 at half the watchdog interval, as long as there was output since
+// the previous ping, so that a service gone silent is restarted; and
+// STOPPING=1 on shutdown.
+type SdNotifier struct {
+	conn         *net.UnixConn
+	readyPattern *regexp.Regexp
+
+	mu     sync.Mutex
+	ready  bool
+	active bool
+
+	done    chan struct{}
+	stopped sync.WaitGroup
+}
+
+// NewSdNotifier connects to $NOTIFY_SOCKET, and returns nil if not run by
+// systemd with a notification socket. The sd_notify variables are removed
+// from the environment, so that the command doesn't mistake itself for the
+// service.
+func NewSdNotifier(readyPattern *regexp.Regexp) (*SdNotifier, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	watchdogUsec := os.Getenv("WATCHDOG_USEC")
+	watchdogPid := os.Getenv("WATCHDOG_PID")
+	for _, name := range []string{"NOTIFY_SOCKET", "WATCHDOG_USEC", "WATCHDOG_PID"} {
+		_ = os.Unsetenv(name)
+	}
+	if socket == "" {
+		return nil, nil
+	}
+	if socket[0] == '@' {
+		// Abstract namespace.
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	n := &SdNotifier{
+		conn:         conn,
+		readyPattern: readyPattern,
+		done:         make(chan struct{}),
+	}
+	if usec, err := strconv.ParseInt(watchdogUsec, 10, 64); err == nil && usec > 0 &&
+		(watchdogPid == "" || watchdogPid == strconv.Itoa(os.Getpid())) {
+		n.stopped.Add(1)
+		go n.watchdog(time.Duration(usec) * time.Microsecond / 2)
+	}
+	return n, nil
+}
+
+// Started reports readiness, unless waiting for a line matching the ready
+// pattern.
+func (n *SdNotifier) Started() {
+	if n == nil || n.readyPattern != nil {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.setReady()
+}
+
+// Line records activity, and reports readiness if line is the first to match
+// the ready pattern.
+func (n *SdNotifier) Line(line string) {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.active = true
+	if !n.ready && n.readyPattern != nil && n.readyPattern.MatchString(ansiEscapes.ReplaceAllString(line, "")) {
+		n.setReady()
+	}
+}
+
+// Stop reports that the service is stopping, and stops pinging the watchdog.
+func (n *SdNotifier) Stop() {
+	if n == nil {
+		return
+	}
+	close(n.done)
+	n.stopped.Wait()
+	n.send("STOPPING=1")
+	_ = n.conn.Close()
+}
+
+// setReady must be called with the notifier locked.
+func (n *SdNotifier) setReady() {
+	if !n.ready {
+		n.ready = true
+		n.send("READY=1")
+	}
+}
+
+func (n *SdNotifier) watchdog(interval time.Duration) {
+	defer n.stopped.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.done:
+			return
+		case <-ticker.C:
+			n.mu.Lock()
+			if n.active {
+				n.active = false
+				n.send("WATCHDOG=1")
+			}
+			n.mu.Unlock()
+		}
+	}
+}
+
+func (n *SdNotifier) send(state string) {
+	if _, err := n.conn.Write([]byte(state)); err != nil {
+		log.Printf("error notifying systemd: %s", err)
+	}
+}
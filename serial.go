@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Interval between attempts to open a serial device that is missing.
+const serialRetryInterval = time.Second
+
+// readSerial timestamps the output of the serial device at path until
+// interrupted, reopening the device whenever it disappears, e.g. when it is
+// unplugged and plugged back in.
+func readSerial(path string, baud int, printer *Printer) error {
+	var mu sync.Mutex
+	var device *os.File
+	stopped := false
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	go func() {
+		<-sigs
+		mu.Lock()
+		defer mu.Unlock()
+		stopped = true
+		if device != nil {
+			_ = device.Close()
+		}
+	}()
+	isStopped := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return stopped
+	}
+
+	waiting := false
+	for !isStopped() {
+		f, err := openSerial(path, baud)
+		if err != nil {
+			if os.IsNotExist(err) || os.IsPermission(err) {
+				if !waiting {
+					printer.PrintAnnotation(fmt.Sprintf("waiting for %s: %s", path, err))
+					printer.StatusBar.SetState("waiting for " + path)
+					waiting = true
+				}
+				time.Sleep(serialRetryInterval)
+				continue
+			}
+			return err
+		}
+		mu.Lock()
+		device = f
+		if stopped {
+			_ = f.Close()
+		}
+		mu.Unlock()
+		if waiting {
+			printer.PrintAnnotation(fmt.Sprintf("%s connected", path))
+			waiting = false
+		}
+		printer.StatusBar.SetState("reading " + path)
+
+		printer.PrintStream(f)
+
+		mu.Lock()
+		device = nil
+		_ = f.Close()
+		mu.Unlock()
+		if !isStopped() {
+			printer.PrintAnnotation(fmt.Sprintf("%s disconnected", path))
+			printer.StatusBar.SetState("waiting for " + path)
+			waiting = true
+			time.Sleep(serialRetryInterval)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+func openSerial(path string, baud int) (*os.File, error) {
+	return nil, errors.New("serial devices are not supported on this platform")
+}
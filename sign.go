@@ -0,0 +1,43 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// readSigningKey reads an Ed25519 private key in PKCS #8 PEM form, as
+// generated by openssl genpkey -algorithm ed25519.
+func readSigningKey(path string) (ed25519.PrivateKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("%s: expected a PEM-encoded PRIVATE KEY", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	ed25519Key, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.New(path + ": not an Ed25519 key")
+	}
+	return ed25519Key, nil
+}
+
+// signFile writes the raw Ed25519 signature of the contents of path to
+// path.sig, from which it can be verified with e.g. openssl pkeyutl -verify
+// -rawin.
+func signFile(path string, key ed25519.PrivateKey) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path+".sig", ed25519.Sign(key, data), 0644)
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	flag "github.com/spf13/pflag"
+
+	"github.com/zmwangx/ets/sink"
+)
+
+// LineEvent and Sink are defined in the sink package, for third parties
+// to implement sinks of their own.
+type (
+	LineEvent = sink.LineEvent
+	Sink      = sink.Sink
+)
+
+// writerSink is the Sink writing the output, as printed, to the output of a
+// printer: stdout or the --output-file, along with the --tee file. Lines
+// are held back with the rest of the output while the display is paused.
+type writerSink struct {
+	printer *Printer
+}
+
+func (s writerSink) Send(e *LineEvent) {
+	fmt.Fprint(s.printer.out(), e.Printed)
+}
+
+// Close does nothing, as the output outlives the sinks: the summary and
+// other reports follow the last line.
+func (s writerSink) Close() {}
+
+// sinkSet fans events out to sinks, isolating each from the failures of
+// the others: a sink that panics is reported and dropped.
+type sinkSet struct {
+	sinks []Sink
+	run   string
+}
+
+// Add adds a sink.
+func (set *sinkSet) Add(sink Sink) {
+	if set.run == "" {
+		set.run, _ = newRunID()
+	}
+	set.sinks = append(set.sinks, sink)
+}
+
+// Len returns the number of sinks.
+func (set *sinkSet) Len() int {
+	return len(set.sinks)
+}
+
+// Send sends e to every sink.
+func (set *sinkSet) Send(e *LineEvent) {
+	e.Run = set.run
+	set.each(func(sink Sink) { sink.Send(e) })
+}
+
+// Close closes every sink.
+func (set *sinkSet) Close() {
+	set.each(func(sink Sink) { sink.Close() })
+	set.sinks = nil
+}
+
+func (set *sinkSet) each(f func(sink Sink)) {
+	kept := set.sinks[:0]
+	for _, sink := range set.sinks {
+		if err := callSink(sink, f); err != nil {
+			log.Printf("error in %T, disabling it: %s", sink, err)
+			continue
+		}
+		kept = append(kept, sink)
+	}
+	set.sinks = kept
+}
+
+func callSink(sink Sink, f func(sink Sink)) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	f(sink)
+	return nil
+}
+
+// newRunID returns a random ID for the lines of one invocation of ets.
+func newRunID() (string, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// formattedSink sends lines and annotations to a sink printed with a
+// timestamp format of its own, given with --sink-format, and directives and
+// reports as they are. Its elapsed timestamps follow the start of the run as
+// the lines give it, which --elapsed-from may move.
+type formattedSink struct {
+	Sink
+	timestamper *Timestamper
+}
+
+func (s formattedSink) Send(e *LineEvent) {
+	formatted := *e
+	s.timestamper.StartTimestamp = e.Time.Add(-e.Elapsed)
+	var prefix, text string
+	switch e.Kind {
+	case sink.Output:
+		prefix, text = s.timestamper.AdvanceTo(e.Time), e.Text
+	case sink.Annotation:
+		prefix, text = s.timestamper.TimestampString(e.Time), e.Text
+	default:
+		s.Sink.Send(e)
+		return
+	}
+	if e.Label != "" {
+		prefix += " " + e.Label
+	}
+	formatted.Printed = prefix + " " + text + "\n"
+	s.Sink.Send(&formatted)
+}
+
+// fileSink appends the output, as printed, to a file, given with --sink
+// file=path.
+type fileSink struct {
+	file   *os.File
+	failed bool
+}
+
+func openFileSink(config sink.Config) (sink.Sink, error) {
+	if config.Arg == "" {
+		return nil, fmt.Errorf("file sink requires a path, as in --sink file=path")
+	}
+	f, err := os.OpenFile(config.Arg, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &fileSink{file: f}, nil
+}
+
+func (s *fileSink) Send(e *LineEvent) {
+	if s.failed {
+		return
+	}
+	if _, err := s.file.WriteString(e.Printed); err != nil {
+		log.Printf("error writing to sink file: %s", err)
+		s.failed = true
+	}
+}
+
+func (s *fileSink) Close() {
+	if err := s.file.Close(); err != nil && !s.failed {
+		log.Printf("error writing to sink file: %s", err)
+	}
+}
+
+// The sinks built into ets. Network sinks are also selected by options of
+// their own, such as --syslog, standing for --sink syslog=address.
+func init() {
+	sink.Register("file", openFileSink)
+	sink.Register("metrics", openMetricsSink)
+	sink.Register("syslog", func(config sink.Config) (sink.Sink, error) {
+		s, err := NewSyslogSink(config.Arg, config.Command)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	})
+	sink.Register("gelf", func(config sink.Config) (sink.Sink, error) {
+		s, err := NewGELFSink(config.Arg, config.Command)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	})
+	sink.Register("elastic", func(config sink.Config) (sink.Sink, error) {
+		s, err := NewElasticSink(config.Arg, batchLimitsOf(config.Batch), config.Command)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	})
+	sink.Register("splunk-hec", func(config sink.Config) (sink.Sink, error) {
+		token := config.Options["splunk-token"]
+		if token == "" {
+			token = os.Getenv("SPLUNK_HEC_TOKEN")
+		}
+		sourcetype := config.Options["splunk-sourcetype"]
+		if sourcetype == "" {
+			sourcetype = "ets"
+		}
+		s, err := NewSplunkSink(config.Arg, token, sourcetype, batchLimitsOf(config.Batch), config.Command)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	})
+	sink.Register("nats", func(config sink.Config) (sink.Sink, error) {
+		subject := config.Options["nats-subject"]
+		if subject == "" {
+			subject = "ets"
+		}
+		s, err := NewNATSSink(config.Arg, subject, config.Options["nats-jetstream"] == "true", config.Command)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	})
+}
+
+// batchLimitsOf returns the limits of the batches of a sink.
+func batchLimitsOf(b sink.Batching) BatchLimits {
+	stats, _ := b.Stats.(*BatchStats)
+	return BatchLimits{Lines: b.Lines, Bytes: b.Bytes, Interval: b.Interval, Stats: stats}
+}
+
+// sinkArgs returns the arguments of --sink, preceded by those of the options
+// standing for --sink name=argument.
+func sinkArgs(opts *options) []string {
+	var args []string
+	for _, alias := range []struct{ name, arg string }{
+		{"syslog", opts.syslog},
+		{"gelf", opts.gelf},
+		{"elastic", opts.elastic},
+		{"splunk-hec", opts.splunkHEC},
+		{"nats", opts.nats},
+	} {
+		if alias.arg != "" {
+			args = append(args, alias.name+"="+alias.arg)
+		}
+	}
+	return append(args, opts.sinks...)
+}
+
+// sinkConfig returns the configuration sinks are opened with for the lines
+// of command, batched within limits if they send batches.
+func sinkConfig(flags *flag.FlagSet, limits BatchLimits, command []string) sink.Config {
+	config := sink.Config{
+		Command: command,
+		Options: make(map[string]string),
+		Batch:   sink.Batching{Lines: limits.Lines, Bytes: limits.Bytes, Interval: limits.Interval},
+	}
+	if limits.Stats != nil {
+		config.Batch.Stats = limits.Stats
+	}
+	flags.VisitAll(func(f *flag.Flag) {
+		config.Options[f.Name] = f.Value.String()
+	})
+	return config
+}
+
+// sinkSpec is a sink selected with --sink name=arg, with the timestamp
+// format given with --sink-format name=format, if any.
+type sinkSpec struct {
+	name   string
+	arg    string
+	format string
+}
+
+// parseSinkSpecs parses the arguments of --sink and --sink-format.
+func parseSinkSpecs(sinks []string, formats []string) ([]sinkSpec, error) {
+	specs := make([]sinkSpec, len(sinks))
+	for i, s := range sinks {
+		specs[i].name = s
+		if j := strings.IndexByte(s, '='); j >= 0 {
+			specs[i].name, specs[i].arg = s[:j], s[j+1:]
+		}
+		if specs[i].name == "" {
+			return nil, fmt.Errorf("invalid --sink %q: expected name=argument", s)
+		}
+	}
+	for _, s := range formats {
+		j := strings.IndexByte(s, '=')
+		if j <= 0 {
+			return nil, fmt.Errorf("invalid --sink-format %q: expected name=format", s)
+		}
+		found := false
+		for i := range specs {
+			if specs[i].name == s[:j] {
+				specs[i].format = s[j+1:]
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("--sink-format %s requires --sink %s", s[:j], s[:j])
+		}
+	}
+	return specs, nil
+}
@@ -0,0 +1,152 @@
+// Package sink defines the destinations ets delivers the lines of output
+// to, along with the terminal, and a registry of them selected with --sink
+// name=argument.
+//
+// A third-party sink registers itself under its name in an init function
+// of its package:
+//
+//	func init() {
+//		sink.Register("mysink", func(config sink.Config) (sink.Sink, error) {
+//			return newMySink(config.Arg)
+//		})
+//	}
+//
+// and is built into ets with a blank import of that package in a file of
+// the main package.
+package sink
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kind tells the lines of output of the command apart from those ets adds
+// to the output.
+type Kind int
+
+const (
+	// Output is a line of output of the command.
+	Output Kind = iota
+	// Annotation is a line of information from ets itself, such as a slow
+	// marker, a clock step, or a note, with what follows its timestamp in
+	// Text.
+	Annotation
+	// Directive is a line for a CI system to interpret, such as a GitHub
+	// Actions workflow command or a TeamCity service message, printed as
+	// is.
+	Directive
+	// Report is a report printed after a run, such as its summary, spanning
+	// several lines in Printed.
+	Report
+)
+
+// LineEvent is a line of output with its timing, as sent to sinks.
+type LineEvent struct {
+	// Kind is the kind of line. Sinks delivering the output of the command
+	// with its timing, rather than the output as printed, skip all but
+	// Output.
+	Kind Kind
+	// Text is the line without its line ending and ANSI escape sequences.
+	Text string
+	Time time.Time
+	// Line is the number of the line in the run.
+	Line int
+	// Elapsed and Delta are the time since the start of the run and the
+	// previous line.
+	Elapsed time.Duration
+	Delta   time.Duration
+	// Level is the level detected with --levels, if any.
+	Level string
+	Label string
+	// Run identifies the lines of one invocation of ets.
+	Run string
+	// Printed is the line as printed, with its timestamp and line ending,
+	// for sinks writing the output as is. It has the timestamp format of
+	// the sink if one is given with --sink-format.
+	Printed string
+}
+
+// Sink receives the lines of output with their timing, formats them, and
+// delivers them somewhere, such as the terminal, a file, or a log server,
+// reporting its own errors. Send and Close are never called concurrently;
+// a sink that panics is reported and dropped without affecting the others.
+type Sink interface {
+	Send(e *LineEvent)
+	// Close delivers what remains to be delivered, waiting for a while.
+	Close()
+}
+
+// Config is what a sink is opened with.
+type Config struct {
+	// Arg is the argument given to --sink after name=, such as a path or an
+	// address.
+	Arg string
+	// Command is the command whose output is sent, if any.
+	Command []string
+	// Options are the values of the options of ets by their long names,
+	// such as "splunk-token", for sinks taking settings.
+	Options map[string]string
+	// Batch bounds the batches of sinks sending lines in batches.
+	Batch Batching
+}
+
+// Batching bounds the batches of lines a sink sends at once, as given with
+// --batch-lines, --batch-bytes, and --flush-interval. Zero values leave the
+// choice to the sink.
+type Batching struct {
+	Lines    int
+	Bytes    int
+	Interval time.Duration
+	// Stats, if not nil, accounts for the batches sent, in the summary.
+	Stats interface {
+		Record(lines int, bytes int)
+	}
+}
+
+// Opener opens a sink.
+type Opener func(config Config) (Sink, error)
+
+var (
+	mu      sync.Mutex
+	openers = make(map[string]Opener)
+)
+
+// Register makes a sink available under name. It panics if name is
+// registered twice, or open is nil.
+func Register(name string, open Opener) {
+	mu.Lock()
+	defer mu.Unlock()
+	if open == nil {
+		panic("sink: Register opener is nil")
+	}
+	if _, dup := openers[name]; dup {
+		panic("sink: Register called twice for sink " + name)
+	}
+	openers[name] = open
+}
+
+// Names returns the sorted names of the registered sinks.
+func Names() []string {
+	mu.Lock()
+	defer mu.Unlock()
+	names := make([]string, 0, len(openers))
+	for name := range openers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Open opens the sink registered under name with config.
+func Open(name string, config Config) (Sink, error) {
+	mu.Lock()
+	open := openers[name]
+	mu.Unlock()
+	if open == nil {
+		return nil, fmt.Errorf("unknown sink %q: expected one of %s", name, strings.Join(Names(), ", "))
+	}
+	return open(config)
+}
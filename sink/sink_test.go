@@ -0,0 +1,51 @@
+package sink
+
+import (
+	"strings"
+	"testing"
+)
+
+type nopSink struct {
+	config Config
+}
+
+func (s *nopSink) Send(e *LineEvent) {}
+
+func (s *nopSink) Close() {}
+
+func expectPanic(t *testing.T, description string, f func()) {
+	t.Helper()
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected %s to panic", description)
+		}
+	}()
+	f()
+}
+
+func TestRegister(t *testing.T) {
+	open := func(config Config) (Sink, error) {
+		return &nopSink{config}, nil
+	}
+	Register("test-b", open)
+	Register("test-a", open)
+	expectPanic(t, "registering a name twice", func() { Register("test-a", open) })
+	expectPanic(t, "registering a nil opener", func() { Register("test-c", nil) })
+
+	names := Names()
+	if len(names) != 2 || names[0] != "test-a" || names[1] != "test-b" {
+		t.Errorf("expected [test-a test-b], got %v", names)
+	}
+
+	s, err := Open("test-a", Config{Arg: "arg"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.(*nopSink).config.Arg != "arg" {
+		t.Errorf("expected the sink to be opened with its argument, got %#v", s.(*nopSink).config)
+	}
+
+	if _, err := Open("test-unknown", Config{}); err == nil || !strings.Contains(err.Error(), "test-a, test-b") {
+		t.Errorf("expected an error listing the sinks, got %v", err)
+	}
+}
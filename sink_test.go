@@ -0,0 +1,191 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/zmwangx/ets/sink"
+)
+
+type recordingSink struct {
+	lines   []string
+	printed []string
+	closed  bool
+}
+
+func (s *recordingSink) Send(e *LineEvent) {
+	s.lines = append(s.lines, e.Text)
+	s.printed = append(s.printed, e.Printed)
+}
+
+func (s *recordingSink) Close() {
+	s.closed = true
+}
+
+type panickingSink struct {
+	sent int
+}
+
+func (s *panickingSink) Send(e *LineEvent) {
+	s.sent++
+	panic("sink exploded")
+}
+
+func (s *panickingSink) Close() {
+	panic("sink exploded again")
+}
+
+func TestSinkSetIsolatesPanics(t *testing.T) {
+	before, after := &recordingSink{}, &recordingSink{}
+	bad := &panickingSink{}
+	var set sinkSet
+	set.Add(before)
+	set.Add(bad)
+	set.Add(after)
+
+	for _, text := range []string{"out1", "out2", "out3"} {
+		set.Send(&LineEvent{Text: text})
+	}
+	set.Close()
+
+	for _, sink := range []*recordingSink{before, after} {
+		if len(sink.lines) != 3 || sink.lines[0] != "out1" || sink.lines[2] != "out3" {
+			t.Errorf("expected every line, got %#v", sink.lines)
+		}
+		if !sink.closed {
+			t.Error("expected sink to be closed")
+		}
+	}
+	if bad.sent != 1 {
+		t.Errorf("expected panicking sink to be dropped after the first line, sent %d", bad.sent)
+	}
+}
+
+func TestParseSinkSpecs(t *testing.T) {
+	specs, err := parseSinkSpecs([]string{"file=out.log", "metrics=a=b.prom", "custom"}, []string{"file=%T"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []sinkSpec{{"file", "out.log", "%T"}, {"metrics", "a=b.prom", ""}, {"custom", "", ""}}
+	if len(specs) != len(expected) {
+		t.Fatalf("expected %#v, got %#v", expected, specs)
+	}
+	for i := range expected {
+		if specs[i] != expected[i] {
+			t.Errorf("expected %#v, got %#v", expected[i], specs[i])
+		}
+	}
+
+	for _, c := range []struct{ sinks, formats []string }{
+		{[]string{"=out.log"}, nil},
+		{[]string{"file=out.log"}, []string{"%T"}},
+		{[]string{"file=out.log"}, []string{"metrics=%T"}},
+	} {
+		if _, err := parseSinkSpecs(c.sinks, c.formats); err == nil {
+			t.Errorf("expected error for --sink %q --sink-format %q", c.sinks, c.formats)
+		}
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "out.log")
+	if err := ioutil.WriteFile(path, []byte("previous\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sink.Open("file", sink.Config{}); err == nil {
+		t.Error("expected an error without a path")
+	}
+	s, err := sink.Open("file", sink.Config{Arg: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Send(&LineEvent{Kind: sink.Output, Text: "a", Printed: "[ts] a\n"})
+	s.Send(&LineEvent{Kind: sink.Annotation, Text: "[ets] note", Printed: "[ts] [ets] note\n"})
+	s.Send(&LineEvent{Kind: sink.Directive, Text: "::group::build", Printed: "::group::build\n"})
+	s.Close()
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "previous\n[ts] a\n[ts] [ets] note\n::group::build\n"
+	if string(content) != expected {
+		t.Errorf("expected %#v, got %#v", expected, string(content))
+	}
+}
+
+func TestMetricsSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ets.prom")
+	s, err := sink.Open("metrics", sink.Config{Arg: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	s.Send(&LineEvent{Text: "starting", Time: start.Add(time.Second), Elapsed: time.Second, Delta: time.Second})
+	s.Send(&LineEvent{Kind: sink.Annotation, Text: "[ets] note", Time: start.Add(2 * time.Second), Elapsed: 2 * time.Second})
+	s.Send(&LineEvent{Text: "error: failed", Level: "error", Time: start.Add(3500 * time.Millisecond), Elapsed: 3500 * time.Millisecond, Delta: 2500 * time.Millisecond})
+	s.Close()
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `# HELP ets_lines_total Lines of output of the command.
+# TYPE ets_lines_total counter
+ets_lines_total 2
+# HELP ets_output_bytes_total Bytes of output of the command, without ANSI escape sequences.
+# TYPE ets_output_bytes_total counter
+ets_output_bytes_total 23
+# HELP ets_level_lines_total Lines of output by the level detected with --levels.
+# TYPE ets_level_lines_total counter
+ets_level_lines_total{level="error"} 1
+# HELP ets_max_gap_seconds Longest time between two lines of output.
+# TYPE ets_max_gap_seconds gauge
+ets_max_gap_seconds 2.5
+# HELP ets_elapsed_seconds Time from the start of the run to the last line of output.
+# TYPE ets_elapsed_seconds gauge
+ets_elapsed_seconds 3.5
+# HELP ets_last_line_timestamp_seconds Unix time of the last line of output.
+# TYPE ets_last_line_timestamp_seconds gauge
+ets_last_line_timestamp_seconds 1591012803.500000
+`
+	if string(content) != expected {
+		t.Errorf("expected %s, got %s", expected, string(content))
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("expected no temporary file left, got %d files", len(files))
+	}
+}
+
+func TestFormattedSink(t *testing.T) {
+	timestamper, err := NewTimestamper("%H:%M:%S +%{elapsed}S", AbsoluteTimeMode, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := &recordingSink{}
+	s := formattedSink{Sink: recorder, timestamper: timestamper}
+	start := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	s.Send(&LineEvent{Text: "a", Time: start.Add(time.Second), Elapsed: time.Second, Label: "web", Printed: "[ts] web a\n"})
+	s.Send(&LineEvent{Kind: sink.Annotation, Text: "[ets] note", Time: start.Add(2 * time.Second), Elapsed: 2 * time.Second, Printed: "[ts] [ets] note\n"})
+	s.Send(&LineEvent{Kind: sink.Directive, Text: "::endgroup::", Time: start.Add(2 * time.Second), Printed: "::endgroup::\n"})
+	expected := []string{"12:00:01 +01 web a\n", "12:00:02 +02 [ets] note\n", "::endgroup::\n"}
+	if len(recorder.printed) != len(expected) {
+		t.Fatalf("expected %#v, got %#v", expected, recorder.printed)
+	}
+	for i := range expected {
+		if recorder.printed[i] != expected[i] {
+			t.Errorf("expected %#v, got %#v", expected[i], recorder.printed[i])
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// Maximum width of a sparkline in characters.
+const sparklineWidth = 40
+
+// Initial time covered by each bin of a sparkline, doubled whenever the run
+// outgrows the bins.
+const sparklineResolution = 10 * time.Millisecond
+
+var sparklineBars = []rune("▁▂▃▄▅▆▇█")
+
+var sparklineModes = []string{"rate", "gaps"}
+
+// Sparkline profiles a run over its duration, by the number of lines in
+// each slice of time, or in gaps mode, the longest gap overlapping each
+// slice. It keeps a fixed number of bins, merging adjacent ones as the run
+// goes on.
+type Sparkline struct {
+	gaps  bool
+	start time.Time
+	width time.Duration
+	bins  []float64
+}
+
+func NewSparkline(mode string, start time.Time) *Sparkline {
+	return &Sparkline{
+		gaps:  mode == "gaps",
+		start: start,
+		width: sparklineResolution,
+		bins:  make([]float64, 2*sparklineWidth),
+	}
+}
+
+func (s *Sparkline) mode() string {
+	if s.gaps {
+		return "gaps"
+	}
+	return "rate"
+}
+
+// Record accounts for a line at time t, following a gap.
+func (s *Sparkline) Record(t time.Time, gap time.Duration) {
+	if s.gaps {
+		s.extend(t, gap)
+		return
+	}
+	s.bins[s.bin(t)]++
+}
+
+// extend records a gap ending at time t in gaps mode.
+func (s *Sparkline) extend(t time.Time, gap time.Duration) {
+	last := s.bin(t)
+	first := s.bin(t.Add(-gap))
+	for i := first; i <= last; i++ {
+		s.bins[i] = math.Max(s.bins[i], gap.Seconds())
+	}
+}
+
+// bin returns the index of the bin of time t, merging bins as needed.
+func (s *Sparkline) bin(t time.Time) int {
+	elapsed := t.Sub(s.start)
+	if elapsed < 0 {
+		return 0
+	}
+	for elapsed/s.width >= time.Duration(len(s.bins)) {
+		s.merge()
+	}
+	return int(elapsed / s.width)
+}
+
+// merge halves the resolution of the sparkline.
+func (s *Sparkline) merge() {
+	s.bins = s.merged(s.bins)
+	s.bins = append(s.bins, make([]float64, 2*sparklineWidth-len(s.bins))...)
+	s.width *= 2
+}
+
+func (s *Sparkline) merged(bins []float64) []float64 {
+	merged := make([]float64, (len(bins)+1)/2)
+	for i, v := range bins {
+		if s.gaps {
+			merged[i/2] = math.Max(merged[i/2], v)
+		} else {
+			merged[i/2] += v
+		}
+	}
+	return merged
+}
+
+// Render draws the sparkline of the run ending at end, with the tail
+// following the last line, and describes its scale.
+func (s *Sparkline) Render(end time.Time, tail time.Duration) string {
+	if s.gaps {
+		s.extend(end, tail)
+	}
+	n := int((end.Sub(s.start) + s.width - 1) / s.width)
+	if n < 1 {
+		n = 1
+	}
+	if n > len(s.bins) {
+		n = len(s.bins)
+	}
+	bins, width := s.bins[:n], s.width
+	if n > sparklineWidth {
+		bins, width = s.merged(bins), 2*width
+	}
+	max := 0.0
+	for _, v := range bins {
+		max = math.Max(max, v)
+	}
+	var line strings.Builder
+	for _, v := range bins {
+		if v == 0 {
+			line.WriteRune(' ')
+			continue
+		}
+		level := int(math.Ceil(v/max*float64(len(sparklineBars)))) - 1
+		line.WriteRune(sparklineBars[level])
+	}
+	scale := fmt.Sprintf("max %.1f lines/s", max/width.Seconds())
+	if s.gaps {
+		scale = "max gap " + formatSummaryDuration(time.Duration(max*float64(time.Second)))
+	}
+	return fmt.Sprintf("%s  (%s, %s per char)", line.String(), scale, formatSummaryDuration(width))
+}
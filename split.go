@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Runs of characters not kept in the names of phase files.
+var phaseFileUnsafe = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// phaseSplitter writes the lines of each phase to a file of its own in dir,
+// named after the phase and the time it started.
+type phaseSplitter struct {
+	dir string
+	// The file of the open phase, if any.
+	f *os.File
+}
+
+func newPhaseSplitter(dir string) (*phaseSplitter, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &phaseSplitter{dir: dir}, nil
+}
+
+// Start closes the file of the previous phase and creates that of the phase
+// name started at t, numbered if another phase of the same name started in
+// the same second.
+func (s *phaseSplitter) Start(name string, t time.Time) error {
+	if s == nil {
+		return nil
+	}
+	s.End()
+	base := strings.Trim(phaseFileUnsafe.ReplaceAllString(name, "_"), "._")
+	if base == "" {
+		base = "phase"
+	}
+	base += "-" + t.Format("20060102T150405")
+	path := filepath.Join(s.dir, base+".log")
+	for i := 2; ; i++ {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if os.IsExist(err) {
+			path = filepath.Join(s.dir, fmt.Sprintf("%s-%d.log", base, i))
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		s.f = f
+		return nil
+	}
+}
+
+// Write writes text to the file of the open phase, if any. Lines outside
+// phases are only in the merged output.
+func (s *phaseSplitter) Write(text string) error {
+	if s == nil || s.f == nil {
+		return nil
+	}
+	_, err := s.f.WriteString(text)
+	return err
+}
+
+// End closes the file of the open phase, if any.
+func (s *phaseSplitter) End() {
+	if s == nil || s.f == nil {
+		return
+	}
+	_ = s.f.Close()
+	s.f = nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/zmwangx/ets/sink"
+)
+
+// Limits of the queue and batches of events sent to Splunk, and the time
+// allowed to send those left when ets exits.
+const (
+	splunkQueueSize    = 10000
+	splunkBatchSize    = 500
+	splunkInterval     = time.Second
+	splunkCloseTimeout = 10 * time.Second
+)
+
+// The HTTP Event Collector event of a line, with its timing as index-time
+// fields.
+type splunkEvent struct {
+	Time       float64           `json:"time"`
+	Host       string            `json:"host"`
+	Source     string            `json:"source"`
+	Sourcetype string            `json:"sourcetype"`
+	Event      string            `json:"event"`
+	Fields     splunkEventFields `json:"fields"`
+}
+
+type splunkEventFields struct {
+	Elapsed float64 `json:"elapsed"`
+	Delta   float64 `json:"delta"`
+	Line    int     `json:"line"`
+	Run     string  `json:"run"`
+	Label   string  `json:"label,omitempty"`
+	Level   string  `json:"level,omitempty"`
+}
+
+// SplunkSink sends every line with its timing to a Splunk HTTP Event
+// Collector, in gzipped batches, from a bounded queue.
+type SplunkSink struct {
+	url        string
+	token      string
+	hostname   string
+	source     string
+	sourcetype string
+	batcher    *eventBatcher
+}
+
+// NewSplunkSink starts sending lines of command to the collector at spec,
+// https://host:8088, with the events endpoint by default, authenticated by
+// token, as events of sourcetype, in batches within limits, of up to 500
+// events every second by default.
+func NewSplunkSink(spec string, token string, sourcetype string, limits BatchLimits, command []string) (*SplunkSink, error) {
+	u, err := url.Parse(spec)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid Splunk HEC URL %q: expected https://host:8088", spec)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/services/collector/event"
+	}
+	if token == "" {
+		return nil, fmt.Errorf("--splunk-hec requires a token, with --splunk-token or SPLUNK_HEC_TOKEN")
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	source := "ets"
+	if len(command) > 0 {
+		source = strings.Join(command, " ")
+	}
+	s := &SplunkSink{
+		url:        u.String(),
+		token:      token,
+		hostname:   hostname,
+		source:     source,
+		sourcetype: sourcetype,
+	}
+	limits = limits.withDefaults(splunkBatchSize, 0, splunkInterval)
+	s.batcher = newEventBatcher("Splunk", s.post, splunkQueueSize, limits)
+	return s, nil
+}
+
+// Send queues the line of e.
+func (s *SplunkSink) Send(e *LineEvent) {
+	if e.Kind != sink.Output {
+		return
+	}
+	event, _ := json.Marshal(splunkEvent{
+		Time:       float64(e.Time.UnixNano()/int64(time.Microsecond)) / 1e6,
+		Host:       s.hostname,
+		Source:     s.source,
+		Sourcetype: s.sourcetype,
+		Event:      e.Text,
+		Fields: splunkEventFields{
+			Elapsed: e.Elapsed.Seconds(),
+			Delta:   e.Delta.Seconds(),
+			Line:    e.Line,
+			Run:     e.Run,
+			Label:   e.Label,
+			Level:   e.Level,
+		},
+	})
+	s.batcher.Add(event)
+}
+
+// post sends events to the collector in one gzipped request.
+func (s *SplunkSink) post(events [][]byte) error {
+	var body bytes.Buffer
+	gz := gzip.NewWriter(&body)
+	for _, event := range events {
+		gz.Write(event)
+		gz.Write([]byte("\n"))
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", s.url, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Splunk "+s.token)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(content))
+	}
+	return nil
+}
+
+// Close sends the events queued, waiting for a while.
+func (s *SplunkSink) Close() {
+	s.batcher.Close(splunkCloseTimeout)
+}
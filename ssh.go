@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// sshHandshake is run on the remote host to measure the offset of its clock:
+// it signals that it is ready, waits for a request, and replies with the
+// time.
+const sshHandshake = `echo ready; read request; date +%s.%N`
+
+// sshCommand returns the command line running command on host with a remote
+// pty, for the arguments of ets ssh: [user@]host, optionally followed by --,
+// followed by the command.
+func sshCommand(args []string) (host string, command []string, err error) {
+	if len(args) == 0 {
+		return "", nil, fmt.Errorf("ets ssh requires a host")
+	}
+	host = args[0]
+	command, err = sshRemoteCommand(args[1:])
+	if err != nil {
+		return "", nil, err
+	}
+	return host, append([]string{"ssh", "-tt", host, "--"}, command...), nil
+}
+
+// sshRemoteCommand returns the command to run remotely, given as args,
+// optionally preceded by --.
+func sshRemoteCommand(args []string) ([]string, error) {
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("ets ssh requires a command")
+	}
+	return args, nil
+}
+
+// readHostsFile reads a list of hosts, one per line. Blank lines and lines
+// starting with # are ignored.
+func readHostsFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	hosts := make([]string, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		hosts = append(hosts, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("no hosts in %s", path)
+	}
+	return hosts, nil
+}
+
+type hostResult struct {
+	host     string
+	exitCode int
+	duration time.Duration
+	err      error
+}
+
+// runSSHHosts runs command on every host in parallel, labeling the output
+// of each with the host name, and returns the results in the order of hosts.
+func runSSHHosts(hosts []string, command []string, printer *Printer) []hostResult {
+	labelWidth := 0
+	for _, host := range hosts {
+		if len(host) > labelWidth {
+			labelWidth = len(host)
+		}
+	}
+
+	commands := make([]*exec.Cmd, len(hosts))
+	results := make([]hostResult, len(hosts))
+	var wg sync.WaitGroup
+	for i, host := range hosts {
+		commands[i] = exec.Command("ssh", append([]string{"-tt", host, "--"}, command...)...)
+		wg.Add(1)
+		go func(i int, host string) {
+			defer wg.Done()
+			start := time.Now()
+			err := runLabeledCommand(commands[i], printer, fmt.Sprintf("%-*s", labelWidth, host))
+			results[i] = hostResult{host: host, duration: time.Since(start)}
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				results[i].exitCode = exitErr.ExitCode()
+			} else if err != nil {
+				// Like ssh itself when it fails to connect.
+				results[i].exitCode = 255
+				results[i].err = err
+			}
+		}(i, host)
+	}
+
+	// Every ssh is in our process group and receives SIGINT from the
+	// terminal directly; keep going until they all exit.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	go func() {
+		for sig := range sigs {
+			if sig == syscall.SIGTERM {
+				for _, command := range commands {
+					if command.Process != nil {
+						_ = command.Process.Signal(sig)
+					}
+				}
+			}
+		}
+	}()
+
+	wg.Wait()
+	return results
+}
+
+// runLabeledCommand runs command with its output connected to a pipe, which
+// is printed with label.
+func runLabeledCommand(command *exec.Cmd, printer *Printer, label string) error {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = r.Close() }()
+	command.Stdout = w
+	command.Stderr = w
+	err = command.Start()
+	_ = w.Close()
+	if err != nil {
+		return err
+	}
+	printer.PrintLabeledStream(r, label)
+	return command.Wait()
+}
+
+// printHostResults writes the exit status and duration of the command on
+// each host to w.
+func printHostResults(w io.Writer, results []hostResult) {
+	hostWidth := 0
+	for _, result := range results {
+		if len(result.host) > hostWidth {
+			hostWidth = len(result.host)
+		}
+	}
+	fmt.Fprintln(w, "ets hosts:")
+	for _, result := range results {
+		status := fmt.Sprintf("exit status %d", result.exitCode)
+		if result.err != nil {
+			status = result.err.Error()
+		}
+		fmt.Fprintf(w, "  %-*s  %10s  %s\n", hostWidth, result.host, formatSummaryDuration(result.duration), status)
+	}
+}
+
+// hostsExitCode returns the exit code of the first host in the list on
+// which the command failed, or 0.
+func hostsExitCode(results []hostResult) int {
+	for _, result := range results {
+		if result.exitCode != 0 {
+			return result.exitCode
+		}
+	}
+	return 0
+}
+
+// measureSSHClockOffset returns the offset of the clock of host from the
+// local clock, assuming that the reply to the handshake takes as long as the
+// request.
+func measureSSHClockOffset(host string) (time.Duration, error) {
+	cmd := exec.Command("ssh", "-T", host, "--", sshHandshake)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return 0, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return 0, err
+	}
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = stdin.Close()
+		_ = cmd.Wait()
+	}()
+
+	reader := bufio.NewReader(stdout)
+	line, err := reader.ReadString('\n')
+	if err != nil || strings.TrimSpace(line) != "ready" {
+		return 0, fmt.Errorf("clock offset handshake with %s failed: unexpected response %q", host, line)
+	}
+	sent := time.Now()
+	if _, err := fmt.Fprintln(stdin, "time"); err != nil {
+		return 0, err
+	}
+	line, err = reader.ReadString('\n')
+	received := time.Now()
+	if err != nil {
+		return 0, fmt.Errorf("clock offset handshake with %s failed: %s", host, err)
+	}
+	remote, err := parseEpochSeconds(strings.TrimSpace(line))
+	if err != nil {
+		return 0, fmt.Errorf("clock offset handshake with %s failed: %s", host, err)
+	}
+	return remote.Sub(sent.Add(received.Sub(sent) / 2)), nil
+}
+
+// parseEpochSeconds parses seconds since the epoch with optional fractional
+// seconds, as output by date +%s.%N.
+func parseEpochSeconds(s string) (time.Time, error) {
+	// Without support for %N, e.g. on macOS, date prints it literally.
+	s = strings.TrimSuffix(s, ".N")
+	parts := strings.SplitN(s, ".", 2)
+	seconds, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q", s)
+	}
+	nanoseconds := int64(0)
+	if len(parts) == 2 {
+		digits := (parts[1] + "000000000")[:9]
+		if nanoseconds, err = strconv.ParseInt(digits, 10, 64); err != nil {
+			return time.Time{}, fmt.Errorf("invalid time %q", s)
+		}
+	}
+	return time.Unix(seconds, nanoseconds), nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/creack/pty"
+	"github.com/mattn/go-runewidth"
+)
+
+const (
+	statusBarInterval = 500 * time.Millisecond
+	// Window over which the line rate is computed.
+	statusBarRateWindow = 10 * time.Second
+)
+
+// StatusBar renders a sticky status line at the bottom of the terminal. The
+// rest of the screen is set up as a scroll region, so the status line never
+// ends up in the scrollback, and it is written directly to the terminal
+// rather than through the printer's output.
+type StatusBar struct {
+	printer *Printer
+	tty     *os.File
+	rows    uint16
+	cols    uint16
+
+	mu    sync.Mutex
+	state string
+
+	samples []lineSample
+	sigs    chan os.Signal
+	done    chan struct{}
+	stopped sync.WaitGroup
+}
+
+type lineSample struct {
+	t     time.Time
+	lines int
+}
+
+// StartStatusBar starts rendering a status bar for printer on tty, which
+// should be the terminal the printer writes to. It returns nil if tty isn't
+// a terminal or is too small.
+func StartStatusBar(printer *Printer, tty *os.File) *StatusBar {
+	winsize, err := pty.GetsizeFull(tty)
+	if err != nil || winsize.Rows < 3 {
+		return nil
+	}
+	b := &StatusBar{
+		printer: printer,
+		tty:     tty,
+		sigs:    make(chan os.Signal, 1),
+		done:    make(chan struct{}),
+	}
+	printer.mu.Lock()
+	b.setup(winsize)
+	printer.mu.Unlock()
+
+	signal.Notify(b.sigs, syscall.SIGWINCH)
+	b.stopped.Add(1)
+	go b.loop()
+	return b
+}
+
+// ReservedRows is the number of terminal rows taken up by the status bar.
+func (b *StatusBar) ReservedRows() uint16 {
+	if b == nil {
+		return 0
+	}
+	return 1
+}
+
+// SetState updates the description of the child's state.
+func (b *StatusBar) SetState(state string) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	b.state = state
+	b.mu.Unlock()
+}
+
+// Stop removes the status bar and restores the full terminal as the scroll
+// region.
+func (b *StatusBar) Stop() {
+	if b == nil {
+		return
+	}
+	signal.Stop(b.sigs)
+	close(b.done)
+	b.stopped.Wait()
+	b.printer.mu.Lock()
+	defer b.printer.mu.Unlock()
+	fmt.Fprintf(b.tty, "\x1b7\x1b[0;%dr\x1b[%d;0f\x1b[K\x1b8", b.rows, b.rows)
+}
+
+// setup reserves the bottom row of the terminal. Must be called with the
+// printer locked.
+func (b *StatusBar) setup(winsize *pty.Winsize) {
+	b.rows = winsize.Rows
+	b.cols = winsize.Cols
+	// Make room at the bottom, confine scrolling to the rows above, and
+	// return to where the cursor was.
+	fmt.Fprintf(b.tty, "\n\x1b7\x1b[0;%dr\x1b8\x1b[1A", b.rows-1)
+	b.draw()
+}
+
+func (b *StatusBar) loop() {
+	defer b.stopped.Done()
+	ticker := time.NewTicker(statusBarInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-b.sigs:
+			if winsize, err := pty.GetsizeFull(b.tty); err == nil && winsize.Rows >= 3 {
+				b.printer.mu.Lock()
+				b.setup(winsize)
+				b.printer.mu.Unlock()
+			}
+		case <-ticker.C:
+			b.printer.mu.Lock()
+			b.draw()
+			b.printer.mu.Unlock()
+		}
+	}
+}
+
+// draw renders the status line. Must be called with the printer locked.
+func (b *StatusBar) draw() {
+	now := time.Now()
+	summary := b.printer.Summary
+	b.samples = append(b.samples, lineSample{now, summary.Lines})
+	for len(b.samples) > 1 && now.Sub(b.samples[0].t) > statusBarRateWindow {
+		b.samples = b.samples[1:]
+	}
+	rate := 0.0
+	if oldest := b.samples[0]; now.Sub(oldest.t) > 0 {
+		rate = float64(summary.Lines-oldest.lines) / now.Sub(oldest.t).Seconds()
+	}
+
+	b.mu.Lock()
+	state := b.state
+	b.mu.Unlock()
+
+	fields := []string{
+		"elapsed " + formatClock(now.Sub(summary.Start)),
+		fmt.Sprintf("%.1f lines/s", rate),
+		describeLastOutput(summary, now),
+	}
+	if state != "" {
+		fields = append(fields, state)
+	}
+	status := " " + strings.Join(fields, " | ") + " "
+	status = runewidth.Truncate(status, int(b.cols), "")
+	fmt.Fprintf(b.tty, "\x1b7\x1b[%d;0f\x1b[K\x1b[7m%s\x1b[0m\x1b8", b.rows, status)
+}
+
+// describeLastOutput describes the time since the last line recorded in
+// summary.
+func describeLastOutput(summary *Summary, now time.Time) string {
+	if summary.Lines == 0 {
+		return "no output yet"
+	}
+	return "last output " + now.Sub(summary.lastLine).Truncate(time.Second).String() + " ago"
+}
+
+// formatClock formats d as H:MM:SS.
+func formatClock(d time.Duration) string {
+	d = d.Truncate(time.Second)
+	return fmt.Sprintf("%d:%02d:%02d", int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60)
+}
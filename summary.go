@@ -0,0 +1,377 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Summary collects statistics about a run, printed on exit when requested
+// with --summary.
+type Summary struct {
+	Command []string
+	Start   time.Time
+	End     time.Time
+	Lines   int
+	// Skipped is the number of lines left out by sampling.
+	Skipped int
+
+	// Bytes is the size of the lines, and peakBytes the most bytes in one
+	// second of the run, counted in peakBucket, the current second.
+	Bytes       int64
+	peakBytes   int64
+	peakBucket  time.Duration
+	bucketBytes int64
+
+	// The longest gap between consecutive lines, including the lead-in
+	// before the first line and the tail after the last one. MaxGapLine is
+	// the number of the line ending the gap, or 0 for the tail.
+	MaxGap     time.Duration
+	MaxGapLine int
+	lastLine   time.Time
+	// All the gaps counted towards MaxGap, for their percentiles.
+	gaps *durationSketch
+
+	// Exited is false in pipe mode, where there's no child to report on.
+	Exited   bool
+	ExitCode int
+	Usage    *ResourceUsage
+
+	LevelCounts map[string]int
+
+	// Phases are named sections of the output, such as GitHub Actions log
+	// groups or sections started by lines matching --phase-pattern, in order
+	// of appearance. At most one phase is open at a time.
+	Phases []*Phase
+
+	// Tests are the top-level test points of TAP output, with the time
+	// elapsed since the previous one.
+	Tests []TestResult
+
+	// Marks are the times of the bookmarks dropped during the run.
+	Marks []time.Time
+	// Notes are the notes from the operator recorded during the run.
+	Notes []Note
+
+	// ClockSteps are the steps of the wall clock detected during the run.
+	ClockSteps []ClockStep
+
+	// Iterations, if not nil, are the runs of the command in a repeat mode.
+	Iterations *IterationStats
+
+	// Gaps, if not nil, counts the gaps between consecutive lines.
+	Gaps *GapHistogram
+
+	// Activity, if not nil, profiles the output over the run.
+	Activity *Sparkline
+
+	// ActivityReport, if not nil, counts the output by wall-clock bucket.
+	ActivityReport *ActivityReport
+
+	// Checksum, if not nil, digests the raw output.
+	Checksum *Checksum
+
+	// Batches, if not nil, accounts for the batches of output written to
+	// files and sent to network sinks.
+	Batches *BatchStats
+}
+
+// Note is a note from the operator, given with --annotate-fifo or typed
+// after Ctrl-] n.
+type Note struct {
+	Time time.Time
+	Text string
+}
+
+type TestResult struct {
+	Name     string
+	Duration time.Duration
+}
+
+// Phase is a named section of the output. End is zero while the phase is
+// open.
+type Phase struct {
+	Name  string
+	Start time.Time
+	End   time.Time
+	Lines int
+}
+
+func (p *Phase) Duration() time.Duration {
+	return p.End.Sub(p.Start)
+}
+
+func NewSummary(command []string, start time.Time) *Summary {
+	return &Summary{
+		Command:     command,
+		Start:       start,
+		LevelCounts: make(map[string]int),
+		lastLine:    start,
+		gaps:        newDurationSketch(),
+	}
+}
+
+// Rebase moves the start of the run to start, before any line is recorded.
+func (s *Summary) Rebase(start time.Time) {
+	s.Start = start
+	s.lastLine = start
+	if s.Activity != nil {
+		s.Activity.start = start
+	}
+	if s.ActivityReport != nil {
+		s.ActivityReport.start = start
+	}
+}
+
+// RecordLine accounts for a line of n bytes printed at time t, and returns
+// the gap since the previous line (or the start of the run).
+func (s *Summary) RecordLine(t time.Time, n int) time.Duration {
+	gap := t.Sub(s.lastLine)
+	s.Lines++
+	s.recordBytes(t, n)
+	s.ActivityReport.Record(t, n)
+	s.recordGap(gap, s.Lines)
+	if s.Gaps != nil && s.Lines > 1 {
+		s.Gaps.Record(gap)
+	}
+	if s.Activity != nil {
+		s.Activity.Record(t, gap)
+	}
+	s.lastLine = t
+	if phase := s.OpenPhase(); phase != nil {
+		phase.Lines++
+	}
+	return gap
+}
+
+func (s *Summary) recordBytes(t time.Time, n int) {
+	s.Bytes += int64(n)
+	if bucket := t.Sub(s.Start).Truncate(time.Second); bucket != s.peakBucket {
+		s.peakBucket = bucket
+		s.bucketBytes = 0
+	}
+	s.bucketBytes += int64(n)
+	if s.bucketBytes > s.peakBytes {
+		s.peakBytes = s.bucketBytes
+	}
+}
+
+// Throughput returns the average and peak rates of output over the run in
+// bytes per second, the peak being the most output in one second, or the
+// average if higher, as it is for runs under a second.
+func (s *Summary) Throughput() (average float64, peak float64) {
+	if d := s.Duration(); d > 0 {
+		average = float64(s.Bytes) / d.Seconds()
+	}
+	peak = float64(s.peakBytes)
+	if average > peak {
+		peak = average
+	}
+	return average, peak
+}
+
+// StartPhase opens a phase at time t, ending the open phase, if any, and
+// returns it.
+func (s *Summary) StartPhase(name string, t time.Time) *Phase {
+	s.EndPhase(t)
+	phase := &Phase{Name: name, Start: t}
+	s.Phases = append(s.Phases, phase)
+	return phase
+}
+
+// EndPhase ends the open phase at time t and returns it, or returns nil if
+// no phase is open.
+func (s *Summary) EndPhase(t time.Time) *Phase {
+	phase := s.OpenPhase()
+	if phase != nil {
+		phase.End = t
+	}
+	return phase
+}
+
+// OpenPhase returns the open phase, or nil.
+func (s *Summary) OpenPhase() *Phase {
+	if len(s.Phases) == 0 {
+		return nil
+	}
+	if phase := s.Phases[len(s.Phases)-1]; phase.End.IsZero() {
+		return phase
+	}
+	return nil
+}
+
+func (s *Summary) RecordTest(name string, duration time.Duration) {
+	s.Tests = append(s.Tests, TestResult{name, duration})
+}
+
+// RecordMark records a bookmark at time t and returns its number.
+func (s *Summary) RecordMark(t time.Time) int {
+	s.Marks = append(s.Marks, t)
+	return len(s.Marks)
+}
+
+// Finish marks the end of the run, ending the open phase, if any.
+func (s *Summary) Finish(end time.Time) {
+	s.End = end
+	s.EndPhase(end)
+	s.recordGap(end.Sub(s.lastLine), 0)
+}
+
+func (s *Summary) recordGap(gap time.Duration, line int) {
+	s.gaps.Add(gap)
+	if gap > s.MaxGap {
+		s.MaxGap = gap
+		s.MaxGapLine = line
+	}
+}
+
+func (s *Summary) describeMaxGap() string {
+	if s.MaxGapLine == 0 {
+		if s.Lines == 0 {
+			return formatSummaryDuration(s.MaxGap) + " (no output)"
+		}
+		return formatSummaryDuration(s.MaxGap) + " (after last line)"
+	}
+	return fmt.Sprintf("%s (before line %d)", formatSummaryDuration(s.MaxGap), s.MaxGapLine)
+}
+
+// GapPercentiles returns the percentiles of the gaps counted towards
+// MaxGap.
+func (s *Summary) GapPercentiles() durationPercentiles {
+	return s.gaps.Percentiles()
+}
+
+// PhasePercentiles returns the percentiles of the durations of the phases,
+// of which there must be at least one.
+func (s *Summary) PhasePercentiles() durationPercentiles {
+	durations := make([]time.Duration, len(s.Phases))
+	for i, phase := range s.Phases {
+		durations[i] = phase.Duration()
+	}
+	return exactPercentiles(durations)
+}
+
+func (s *Summary) CountLevel(level string) {
+	s.LevelCounts[level]++
+}
+
+func (s *Summary) Duration() time.Duration {
+	return s.End.Sub(s.Start)
+}
+
+type summaryRow struct {
+	key   string
+	value string
+}
+
+func (s *Summary) rows() []summaryRow {
+	rows := make([]summaryRow, 0)
+	if len(s.Command) > 0 {
+		rows = append(rows, summaryRow{"command", strings.Join(s.Command, " ")})
+	}
+	if s.Exited {
+		rows = append(rows, summaryRow{"exit status", fmt.Sprint(s.ExitCode)})
+	}
+	rows = append(rows, summaryRow{"duration", formatSummaryDuration(s.Duration())})
+	if s.Iterations != nil {
+		rows = append(rows, s.Iterations.rows()...)
+	}
+	if s.Usage != nil {
+		rows = append(rows,
+			summaryRow{"user time", formatSummaryDuration(s.Usage.UserTime)},
+			summaryRow{"system time", formatSummaryDuration(s.Usage.SystemTime)},
+			summaryRow{"max rss", formatBytes(s.Usage.MaxRSS)},
+			summaryRow{"context switches", fmt.Sprintf("%d voluntary, %d involuntary",
+				s.Usage.VoluntaryCtxSwitches, s.Usage.InvoluntaryCtxSwitches)},
+		)
+	}
+	if s.Skipped > 0 {
+		rows = append(rows, summaryRow{"lines", fmt.Sprintf("%d (%d printed, %d skipped by sampling)", s.Lines, s.Lines-s.Skipped, s.Skipped)})
+	} else {
+		rows = append(rows, summaryRow{"lines", fmt.Sprint(s.Lines)})
+	}
+	average, peak := s.Throughput()
+	rows = append(rows, summaryRow{"bytes", fmt.Sprintf("%s (%s/s average, %s/s peak)",
+		formatBytes(s.Bytes), formatBytes(int64(average)), formatBytes(int64(peak)))})
+	if s.Checksum != nil {
+		rows = append(rows, summaryRow{s.Checksum.Algorithm, s.Checksum.Sum()})
+	}
+	if s.Batches != nil {
+		rows = append(rows, summaryRow{"batches", s.Batches.String()})
+	}
+	rows = append(rows, summaryRow{"max gap", s.describeMaxGap()})
+	rows = append(rows, summaryRow{"gaps", s.GapPercentiles().String()})
+	if s.Activity != nil {
+		rows = append(rows, summaryRow{"sparkline", s.Activity.Render(s.End, s.End.Sub(s.lastLine))})
+	}
+	if len(s.LevelCounts) > 0 {
+		rows = append(rows, summaryRow{"levels", formatLevelCounts(s.LevelCounts)})
+	}
+	for _, phase := range s.Phases {
+		rows = append(rows, summaryRow{"phase", fmt.Sprintf("%s: %s (%d %s)",
+			phase.Name, formatSummaryDuration(phase.Duration()), phase.Lines, pluralize(phase.Lines, "line", "lines"))})
+	}
+	if len(s.Phases) > 1 {
+		rows = append(rows, summaryRow{"phase durations", s.PhasePercentiles().String()})
+	}
+	for _, step := range s.ClockSteps {
+		rows = append(rows, summaryRow{"clock step", fmt.Sprintf("%s at %s", step, step.Time.Format("15:04:05"))})
+	}
+	for i, mark := range s.Marks {
+		rows = append(rows, summaryRow{"mark", fmt.Sprintf("%d at %s (+%s)",
+			i+1, mark.Format("15:04:05"), formatSummaryDuration(mark.Sub(s.Start)))})
+	}
+	for _, note := range s.Notes {
+		rows = append(rows, summaryRow{"note", fmt.Sprintf("%s (+%s): %s",
+			note.Time.Format("15:04:05"), formatSummaryDuration(note.Time.Sub(s.Start)), note.Text)})
+	}
+	for _, test := range s.Tests {
+		rows = append(rows, summaryRow{"test", fmt.Sprintf("%s: %s", test.Name, formatSummaryDuration(test.Duration))})
+	}
+	return rows
+}
+
+// Print writes the summary to w as aligned key-value rows.
+func (s *Summary) Print(w io.Writer) {
+	rows := s.rows()
+	keyWidth := 0
+	for _, row := range rows {
+		if len(row.key) > keyWidth {
+			keyWidth = len(row.key)
+		}
+	}
+	fmt.Fprintln(w, "ets summary:")
+	for _, row := range rows {
+		fmt.Fprintf(w, "  %-*s  %s\n", keyWidth, row.key, row.value)
+	}
+}
+
+func formatSummaryDuration(d time.Duration) string {
+	return d.Round(time.Millisecond).String()
+}
+
+// formatLevelCounts lists counts of the canonical levels in order of
+// severity, followed by custom levels in alphabetical order.
+func formatLevelCounts(counts map[string]int) string {
+	levels := make([]string, 0, len(counts))
+	for _, level := range levelNames {
+		if counts[level] > 0 {
+			levels = append(levels, level)
+		}
+	}
+	custom := make([]string, 0)
+	for level := range counts {
+		if _, ok := levelColors[level]; !ok {
+			custom = append(custom, level)
+		}
+	}
+	sort.Strings(custom)
+	levels = append(levels, custom...)
+	parts := make([]string, len(levels))
+	for i, level := range levels {
+		parts[i] = fmt.Sprintf("%s %d", level, counts[level])
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"time"
+)
+
+// The summary as written by --summary-json. Durations are in seconds.
+type jsonSummary struct {
+	Command        []string           `json:"command,omitempty"`
+	ExitStatus     *int               `json:"exit_status,omitempty"`
+	Start          time.Time          `json:"start"`
+	End            time.Time          `json:"end"`
+	Duration       float64            `json:"duration"`
+	Lines          int                `json:"lines"`
+	Skipped        int                `json:"skipped,omitempty"`
+	Bytes          int64              `json:"bytes"`
+	Throughput     throughputJSON     `json:"throughput"`
+	Checksum       string             `json:"checksum,omitempty"`
+	Batches        *batchesJSON       `json:"batches,omitempty"`
+	Gaps           percentilesJSON    `json:"gaps"`
+	Levels         map[string]int     `json:"levels,omitempty"`
+	Phases         []phaseJSON        `json:"phases,omitempty"`
+	PhaseDurations *percentilesJSON   `json:"phase_durations,omitempty"`
+	Usage          *resourceUsageJSON `json:"usage,omitempty"`
+	Marks          []time.Time        `json:"marks,omitempty"`
+	Notes          []noteJSON         `json:"notes,omitempty"`
+	ClockSteps     []clockStepJSON    `json:"clock_steps,omitempty"`
+	Tests          []testResultJSON   `json:"tests,omitempty"`
+	Iterations     []iterationJSON    `json:"iterations,omitempty"`
+}
+
+type percentilesJSON struct {
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+	P99 float64 `json:"p99"`
+	Max float64 `json:"max"`
+}
+
+// Rates in bytes per second.
+type throughputJSON struct {
+	Average float64 `json:"average"`
+	Peak    float64 `json:"peak"`
+}
+
+// Sizes of the batches of output, on average and at most.
+type batchesJSON struct {
+	Count        int     `json:"count"`
+	AverageLines float64 `json:"average_lines"`
+	AverageBytes float64 `json:"average_bytes"`
+	MaxLines     int     `json:"max_lines"`
+	MaxBytes     int64   `json:"max_bytes"`
+}
+
+type noteJSON struct {
+	Time time.Time `json:"time"`
+	Text string    `json:"text"`
+}
+
+type phaseJSON struct {
+	Name     string    `json:"name"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Duration float64   `json:"duration"`
+	Lines    int       `json:"lines"`
+}
+
+type resourceUsageJSON struct {
+	UserTime   float64 `json:"user_time"`
+	SystemTime float64 `json:"system_time"`
+	MaxRSS     int64   `json:"max_rss"`
+}
+
+type clockStepJSON struct {
+	Time time.Time `json:"time"`
+	Step float64   `json:"step"`
+}
+
+type testResultJSON struct {
+	Name     string  `json:"name"`
+	Duration float64 `json:"duration"`
+}
+
+type iterationJSON struct {
+	ExitStatus int     `json:"exit_status"`
+	Duration   float64 `json:"duration"`
+	Lines      int     `json:"lines"`
+}
+
+func newPercentilesJSON(p durationPercentiles) percentilesJSON {
+	return percentilesJSON{p.P50.Seconds(), p.P90.Seconds(), p.P99.Seconds(), p.Max.Seconds()}
+}
+
+func newPhasesJSON(phases []*Phase) []phaseJSON {
+	list := make([]phaseJSON, len(phases))
+	for i, phase := range phases {
+		list[i] = phaseJSON{phase.Name, phase.Start, phase.End, phase.Duration().Seconds(), phase.Lines}
+	}
+	return list
+}
+
+// writePhasesJSON writes the phases of the run to path as a JSON array.
+func writePhasesJSON(path string, s *Summary) error {
+	out, err := json.MarshalIndent(newPhasesJSON(s.Phases), "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(out, '\n'), 0644)
+}
+
+// writeSummaryJSON writes the summary to path as JSON.
+func writeSummaryJSON(path string, s *Summary) error {
+	out, err := json.MarshalIndent(newJSONSummary(s), "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(out, '\n'), 0644)
+}
+
+func newJSONSummary(s *Summary) jsonSummary {
+	j := jsonSummary{
+		Command:  s.Command,
+		Start:    s.Start,
+		End:      s.End,
+		Duration: s.Duration().Seconds(),
+		Lines:    s.Lines,
+		Skipped:  s.Skipped,
+		Bytes:    s.Bytes,
+		Gaps:     newPercentilesJSON(s.GapPercentiles()),
+		Phases:   newPhasesJSON(s.Phases),
+		Marks:    s.Marks,
+	}
+	j.Throughput.Average, j.Throughput.Peak = s.Throughput()
+	for _, note := range s.Notes {
+		j.Notes = append(j.Notes, noteJSON{note.Time, note.Text})
+	}
+	for _, step := range s.ClockSteps {
+		j.ClockSteps = append(j.ClockSteps, clockStepJSON{step.Time, step.Step.Seconds()})
+	}
+	if s.Batches != nil {
+		lines, bytes := s.Batches.Averages()
+		j.Batches = &batchesJSON{s.Batches.Batches, lines, bytes, s.Batches.MaxLines, s.Batches.MaxBytes}
+	}
+	if s.Checksum != nil {
+		j.Checksum = s.Checksum.String()
+	}
+	if s.Exited {
+		exitStatus := s.ExitCode
+		j.ExitStatus = &exitStatus
+	}
+	if len(s.LevelCounts) > 0 {
+		j.Levels = s.LevelCounts
+	}
+	if len(s.Phases) > 0 {
+		phaseDurations := newPercentilesJSON(s.PhasePercentiles())
+		j.PhaseDurations = &phaseDurations
+	}
+	if s.Usage != nil {
+		j.Usage = &resourceUsageJSON{s.Usage.UserTime.Seconds(), s.Usage.SystemTime.Seconds(), s.Usage.MaxRSS}
+	}
+	for _, test := range s.Tests {
+		j.Tests = append(j.Tests, testResultJSON{test.Name, test.Duration.Seconds()})
+	}
+	if s.Iterations != nil {
+		for _, it := range s.Iterations.Iterations {
+			j.Iterations = append(j.Iterations, iterationJSON{it.ExitCode, it.Duration.Seconds(), it.Lines})
+		}
+	}
+	return j
+}
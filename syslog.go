@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/zmwangx/ets/sink"
+)
+
+// Structured data ID of the timing fields, under the enterprise number
+// reserved for documentation by RFC 5612.
+const syslogSDID = "ets@32473"
+
+// Syslog severities of the canonical levels; other lines are informational.
+var syslogSeverities = map[string]int{
+	"error": 3,
+	"warn":  4,
+	"info":  6,
+	"debug": 7,
+}
+
+// Facility user-level messages.
+const syslogFacility = 1
+
+// SyslogSink sends every line to a syslog server as an RFC 5424 message
+// with an ets@32473 structured-data element, whose elapsed, delta, line,
+// run, and label parameters give the timing and origin of the line.
+type SyslogSink struct {
+	conn     net.Conn
+	stream   bool
+	hostname string
+	appName  string
+	procID   int
+	failed   bool
+}
+
+// parseSyslogAddress parses the argument of --syslog, udp:host:port,
+// tcp:host:port, or unix:path, into a network and an address.
+func parseSyslogAddress(s string) (network string, address string, err error) {
+	i := strings.IndexByte(s, ':')
+	if i >= 0 {
+		network, address = s[:i], s[i+1:]
+		if (network == "udp" || network == "tcp" || network == "unix") && address != "" {
+			if network == "unix" {
+				// Local syslog daemons, e.g. on /dev/log, read datagrams.
+				network = "unixgram"
+			}
+			return network, address, nil
+		}
+	}
+	return "", "", fmt.Errorf("invalid syslog address %q: expected udp:host:port, tcp:host:port, or unix:path", s)
+}
+
+// NewSyslogSink connects to the syslog server at spec, sending messages in
+// the name of command.
+func NewSyslogSink(spec string, command []string) (*SyslogSink, error) {
+	network, address, err := parseSyslogAddress(spec)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "-"
+	}
+	appName := "ets"
+	if len(command) > 0 {
+		appName = filepath.Base(command[0])
+	}
+	return &SyslogSink{
+		conn:     conn,
+		stream:   network == "tcp",
+		hostname: hostname,
+		appName:  syslogHeaderField(appName, 48),
+		procID:   os.Getpid(),
+	}, nil
+}
+
+// Send sends the line of e, at the severity of its level. Failures are
+// reported once.
+func (s *SyslogSink) Send(e *LineEvent) {
+	if e.Kind != sink.Output {
+		return
+	}
+	severity, ok := syslogSeverities[e.Level]
+	if !ok {
+		severity = syslogSeverities["info"]
+	}
+	sd := fmt.Sprintf(`[%s elapsed="%.6f" delta="%.6f" line="%d" run="%s"`,
+		syslogSDID, e.Elapsed.Seconds(), e.Delta.Seconds(), e.Line, e.Run)
+	if e.Label != "" {
+		sd += ` label="` + syslogParamValue(e.Label) + `"`
+	}
+	sd += "]"
+	msg := e.Text
+	message := fmt.Sprintf("<%d>1 %s %s %s %d - %s %s", syslogFacility*8+severity,
+		e.Time.Format("2006-01-02T15:04:05.000000Z07:00"), s.hostname, s.appName, s.procID, sd, msg)
+	if s.stream {
+		// Octet counting framing, per RFC 6587.
+		message = fmt.Sprintf("%d %s", len(message), message)
+	}
+	if _, err := s.conn.Write([]byte(message)); err != nil && !s.failed {
+		log.Printf("error sending to syslog: %s", err)
+		s.failed = true
+	}
+}
+
+func (s *SyslogSink) Close() {
+	s.conn.Close()
+}
+
+// syslogHeaderField makes s fit in a header field of at most max printable
+// ASCII characters.
+func syslogHeaderField(s string, max int) string {
+	s = strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' {
+			return '_'
+		}
+		return r
+	}, s)
+	if len(s) > max {
+		s = s[:max]
+	}
+	return s
+}
+
+// syslogParamValue escapes the characters special in structured data
+// parameter values.
+func syslogParamValue(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(s)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// tailBuffer keeps the last lines written to it, up to a number of lines or
+// of bytes, for --tail-buffer, dropping the oldest lines beyond the limit.
+// The lines are written out by Dump.
+type tailBuffer struct {
+	maxLines int
+	maxBytes int
+	lines    []string
+	bytes    int
+	// The end of the output if it doesn't end with a newline.
+	partial []byte
+	// The number of lines dropped to stay within bounds.
+	dropped int
+
+	out io.Writer
+	// file, if not nil, is the output file behind out, which Dump rewrites
+	// with the buffered lines rather than appending to it.
+	file *os.File
+}
+
+// parseTailBufferSize parses the size of a --tail-buffer, a number of lines,
+// or of bytes with a K, M, G, or T suffix.
+func parseTailBufferSize(s string) (lines int, bytes int, err error) {
+	if s != "" && strings.IndexByte("KMGT", s[len(s)-1]) >= 0 {
+		n, err := parseSize(s)
+		if err != nil || n > int64(^uint(0)>>1) {
+			return 0, 0, fmt.Errorf("expected a number of lines or a size, e.g. 10000 or 1M")
+		}
+		return 0, int(n), nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 {
+		return 0, 0, fmt.Errorf("expected a number of lines or a size, e.g. 10000 or 1M")
+	}
+	return n, 0, nil
+}
+
+func (b *tailBuffer) Write(data []byte) (int, error) {
+	n := len(data)
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			b.partial = append(b.partial, data...)
+			break
+		}
+		b.add(string(b.partial) + string(data[:i+1]))
+		b.partial = b.partial[:0]
+		data = data[i+1:]
+	}
+	return n, nil
+}
+
+// add appends line, dropping the oldest lines beyond the bounds.
+func (b *tailBuffer) add(line string) {
+	b.lines = append(b.lines, line)
+	b.bytes += len(line)
+	for len(b.lines) > 1 && ((b.maxLines > 0 && len(b.lines) > b.maxLines) || (b.maxBytes > 0 && b.bytes > b.maxBytes)) {
+		b.bytes -= len(b.lines[0])
+		b.lines = b.lines[1:]
+		b.dropped++
+	}
+}
+
+// Dump writes header, if any, and the buffered lines. To a file, they
+// replace its contents, and are kept; otherwise they are written out once.
+func (b *tailBuffer) Dump(header []byte) error {
+	out := b.out
+	if b.file != nil {
+		if err := b.file.Truncate(0); err != nil {
+			return err
+		}
+		if _, err := b.file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		out = b.file
+	}
+	var buf bytes.Buffer
+	buf.Write(header)
+	for _, line := range b.lines {
+		buf.WriteString(line)
+	}
+	buf.Write(b.partial)
+	_, err := buf.WriteTo(out)
+	if b.file == nil {
+		b.lines, b.bytes, b.partial, b.dropped = nil, 0, nil, 0
+	}
+	return err
+}
+
+// DumpTail writes out the lines kept by --tail-buffer, after an annotation
+// with the number of earlier lines dropped, if any.
+func (p *Printer) DumpTail() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.Tail == nil {
+		return
+	}
+	var header bytes.Buffer
+	if p.Tail.dropped > 0 {
+		header.WriteString(p.formatAnnotation(fmt.Sprintf("%d earlier %s dropped by --tail-buffer",
+			p.Tail.dropped, pluralize(p.Tail.dropped, "line", "lines")), p.now(), true))
+	}
+	if err := p.Tail.Dump(header.Bytes()); err != nil {
+		log.Printf("error writing the tail buffer: %s", err)
+	}
+}
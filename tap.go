@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// TAP test points, possibly indented as in subtests, capturing the
+// indentation and the test point.
+var tapTestPoint = regexp.MustCompile(`^(\s*)((?:not )?ok\b.*)$`)
+
+// formatTAPLine returns line as is, preceded by a comment with the timestamp
+// and duration of the test if it is a test point. Top-level test points are
+// recorded in the summary. Must be called with the printer locked.
+func (p *Printer) formatTAPLine(line string, prefix string, now time.Time) string {
+	m := tapTestPoint.FindStringSubmatch(strings.TrimRight(ansiEscapes.ReplaceAllString(line, ""), "\r\n"))
+	if m != nil {
+		start := p.lastTestPoint
+		if start.IsZero() {
+			start = p.Summary.Start
+		}
+		duration := now.Sub(start)
+		if m[1] == "" {
+			p.Summary.RecordTest(m[2], duration)
+			p.lastTestPoint = now
+		}
+		return fmt.Sprintf("%s# ts %s (%s)\n", m[1], prefix, formatSummaryDuration(duration)) + line
+	}
+	return line
+}
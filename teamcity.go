@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+var teamcityEscaper = strings.NewReplacer(
+	"|", "||",
+	"'", "|'",
+	"\n", "|n",
+	"\r", "|r",
+	"[", "|[",
+	"]", "|]",
+)
+
+// teamcityMessage formats a TeamCity service message with the given
+// attributes, given as alternating names and values.
+func teamcityMessage(name string, attrs ...string) string {
+	var b strings.Builder
+	b.WriteString("##teamcity[" + name)
+	for i := 0; i+1 < len(attrs); i += 2 {
+		fmt.Fprintf(&b, " %s='%s'", attrs[i], teamcityEscaper.Replace(attrs[i+1]))
+	}
+	b.WriteString("]\n")
+	return b.String()
+}
+
+func teamcityBlockOpened(phase *Phase) string {
+	return teamcityMessage("blockOpened", "name", phase.Name)
+}
+
+// teamcityBlockClosed closes the block of phase and reports its duration in
+// milliseconds as a build statistic.
+func teamcityBlockClosed(phase *Phase) string {
+	return teamcityMessage("blockClosed", "name", phase.Name) +
+		teamcityMessage("buildStatisticValue",
+			"key", "ets.phase."+phase.Name+".duration",
+			"value", fmt.Sprint(phase.Duration().Milliseconds()))
+}
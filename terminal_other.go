@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+func enterCbreakMode(f *os.File) (func(), error) {
+	return nil, errors.New("terminal modes are not supported on this platform")
+}
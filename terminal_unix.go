@@ -0,0 +1,38 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// enterCbreakMode disables line buffering and echo on the terminal f, so that
+// keys can be read as they are typed, while leaving signal generation and
+// output processing alone. It returns a function restoring the previous
+// settings.
+func enterCbreakMode(f *os.File) (func(), error) {
+	fd := int(f.Fd())
+	var termios syscall.Termios
+	if err := ioctl(fd, ioctlReadTermios, unsafe.Pointer(&termios)); err != nil {
+		return nil, &os.PathError{Op: "tcgetattr", Path: f.Name(), Err: err}
+	}
+	saved := termios
+	termios.Lflag &^= syscall.ICANON | syscall.ECHO
+	termios.Cc[syscall.VMIN] = 1
+	termios.Cc[syscall.VTIME] = 0
+	if err := ioctl(fd, ioctlWriteTermios, unsafe.Pointer(&termios)); err != nil {
+		return nil, &os.PathError{Op: "tcsetattr", Path: f.Name(), Err: err}
+	}
+	return func() { _ = ioctl(fd, ioctlWriteTermios, unsafe.Pointer(&saved)) }, nil
+}
+
+func ioctl(fd int, request uintptr, arg unsafe.Pointer) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), request, uintptr(arg))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
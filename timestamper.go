@@ -2,9 +2,13 @@ package main
 
 import (
 	"bytes"
+	"fmt"
 	"log"
 	"math"
+	"regexp"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/lestrrat-go/strftime"
@@ -18,55 +22,546 @@ const (
 	IncrementalTimeMode
 )
 
+// Reference points of elapsed timestamps accepted by --elapsed-from: the
+// start of ets, the start of the command, and its first line of output.
+var elapsedFromModes = []string{"now", "exec", "first-output"}
+
+// Named formats accepted in place of a format string.
+var formatAliases = map[string]string{
+	"jenkins": "[%Y-%m-%dT%H:%M:%S.%LZ]",
+}
+
+// Format aliases that are always rendered in UTC, unless another timezone
+// is explicitly requested.
+var utcFormatAliases = map[string]bool{
+	"jenkins": true,
+}
+
+// TimestampParser extracts the timestamp from a line already carrying one,
+// returning the time and the rest of the line.
+type TimestampParser func(line string) (t time.Time, rest string, ok bool)
+
+// Parsers of timestamps added by other tools, accepted by
+// --parse-timestamps.
+var timestampParsers = map[string]TimestampParser{
+	"jenkins": parseJenkinsTimestamp,
+	"rfc3339": parseRFC3339Timestamp,
+	"kubectl": parseKubectlTimestamp,
+}
+
+func timestampParserNames() []string {
+	names := make([]string, 0, len(timestampParsers))
+	for name := range timestampParsers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RFC 3339 timestamps with optional fractional seconds followed by a space,
+// as prepended by docker logs --timestamps and kubectl logs --timestamps.
+var rfc3339Timestamp = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(?:\.\d+)?(?:Z|[+-]\d{2}:\d{2})) `)
+
+func parseRFC3339Timestamp(line string) (time.Time, string, bool) {
+	m := rfc3339Timestamp.FindStringSubmatchIndex(line)
+	if m == nil {
+		return time.Time{}, line, false
+	}
+	t, err := time.Parse(time.RFC3339Nano, line[m[2]:m[3]])
+	if err != nil {
+		return time.Time{}, line, false
+	}
+	return t, line[m[1]:], true
+}
+
+// Names of the modes of qualified directives such as %{elapsed}T, which
+// render a value of another mode than that of the timestamper.
+var directiveModes = map[string]TimestampMode{
+	"absolute":    AbsoluteTimeMode,
+	"elapsed":     ElapsedTimeMode,
+	"delta":       IncrementalTimeMode,
+	"incremental": IncrementalTimeMode,
+}
+
+// Qualifiers of directives other than modes: tz2 renders absolute time in
+// the second timezone, such as %{tz2}T, and mark the time elapsed since the
+// latest marker, such as %{mark}T.
+const (
+	secondTimezoneQualifier = "tz2"
+	markQualifier           = "mark"
+)
+
+var directiveQualifiers = map[string]TimestampMode{
+	secondTimezoneQualifier: AbsoluteTimeMode,
+	markQualifier:           ElapsedTimeMode,
+}
+
+// formatSegment is a part of a format string rendering the value of one
+// mode, as modified by the qualifier, if any.
+type formatSegment struct {
+	mode      TimestampMode
+	qualifier string
+	formatter *strftime.Strftime
+}
+
 type Timestamper struct {
 	Mode           TimestampMode
 	TZ             *time.Location
-	Formatter      *strftime.Strftime
 	StartTimestamp time.Time
 	LastTimestamp  time.Time
+
+	// Offset is added to absolute timestamps, e.g. to show the time by the
+	// clock of a remote host.
+	Offset time.Duration
+
+	// SecondTZ is the timezone of directives qualified with tz2, such as
+	// %{tz2}T, for the time in another region.
+	SecondTZ *time.Location
+
+	// LastMark is the time of the latest marker, from which directives
+	// qualified with mark measure, if any; they measure from the start
+	// until then.
+	LastMark time.Time
+
+	// Since, if not zero, is the reference point of elapsed timestamps
+	// instead of the start, e.g. the start of a timeline ets joins late.
+	Since time.Time
+
+	// Round, if not 0, is the bucket displayed timestamps are rounded to,
+	// or truncated to with Truncate. Durations measured are unaffected.
+	Round    time.Duration
+	Truncate bool
+
+	// The output rate, shown by %Q, as of the timestamp being formatted.
+	rate        *lineRate
+	currentRate float64
+
+	// The exponentially smoothed delta between lines, shown by %~, and
+	// whether there has been a line yet.
+	smoothedDelta time.Duration
+	smoothed      bool
+
+	// zones caches the zones in effect in the locations of timestamps,
+	// shown by %Z and %z.
+	zones map[*time.Location]*zoneAbbreviations
+
+	segments []formatSegment
 }
 
+// Weight of the latest delta in the smoothed delta.
+const deltaSmoothing = 0.1
+
 func NewTimestamper(format string, mode TimestampMode, timezone *time.Location) (*Timestamper, error) {
-	formatter, err := strftime.New(format,
-		strftime.WithMilliseconds('L'),
-		strftime.WithUnixSeconds('s'),
-		strftime.WithSpecification('f', microseconds))
-	if err != nil {
-		return nil, err
-	}
 	now := time.Now()
-	return &Timestamper{
+	t := &Timestamper{
 		Mode:           mode,
 		TZ:             timezone,
-		Formatter:      formatter,
 		StartTimestamp: now,
 		LastTimestamp:  now,
-	}, nil
+		rate:           &lineRate{start: now},
+		zones:          make(map[*time.Location]*zoneAbbreviations),
+	}
+	options := []strftime.Option{
+		strftime.WithMilliseconds('L'),
+		strftime.WithUnixSeconds('s'),
+		strftime.WithSpecification('f', microseconds),
+		strftime.WithSpecification('J', days),
+		strftime.WithSpecification('K', relativeDay),
+		strftime.WithSpecification('P', adaptiveDuration),
+		strftime.WithSpecification('i', humanDuration),
+		strftime.WithSpecification('G', isoYear),
+		strftime.WithSpecification('g', isoYearWithoutCentury),
+		strftime.WithSpecification('Z', strftime.AppendFunc(func(b []byte, tm time.Time) []byte {
+			name, _ := t.zone(tm)
+			return append(b, name...)
+		})),
+		strftime.WithSpecification('z', strftime.AppendFunc(func(b []byte, tm time.Time) []byte {
+			_, offset := t.zone(tm)
+			return appendOffset(b, offset)
+		})),
+		strftime.WithSpecification(colonOffsetDirective, offsetWithColons("-07:00")),
+		strftime.WithSpecification(doubleColonOffsetDirective, offsetWithColons("-07:00:00")),
+		strftime.WithSpecification('Q', strftime.AppendFunc(func(b []byte, _ time.Time) []byte {
+			return append(b, fmt.Sprintf("%6.1f", t.currentRate)...)
+		})),
+		strftime.WithSpecification('~', strftime.AppendFunc(func(b []byte, _ time.Time) []byte {
+			return append(b, fmt.Sprintf("%7.3f", t.smoothedDelta.Seconds())...)
+		})),
+	}
+	parts, err := splitFormat(format, mode)
+	if err != nil {
+		return nil, err
+	}
+	for _, part := range parts {
+		formatter, err := strftime.New(part.format, options...)
+		if err != nil {
+			return nil, err
+		}
+		t.segments = append(t.segments, formatSegment{part.mode, part.qualifier, formatter})
+	}
+	return t, nil
+}
+
+type formatPart struct {
+	mode      TimestampMode
+	qualifier string
+	format    string
+}
+
+// splitFormat splits format into parts rendering the values of one mode
+// each: directives qualified with a mode, such as %{elapsed}T, and runs of
+// the rest, which render the value of mode. Directives qualified with tz2,
+// such as %{tz2}T, render absolute time in the second timezone.
+func splitFormat(format string, mode TimestampMode) ([]formatPart, error) {
+	var parts []formatPart
+	var current strings.Builder
+	add := func(part formatPart) {
+		if n := len(parts); n > 0 && parts[n-1].mode == part.mode && parts[n-1].qualifier == part.qualifier {
+			parts[n-1].format += part.format
+		} else {
+			parts = append(parts, part)
+		}
+	}
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i+1 == len(format) || format[i+1] != '{' {
+			current.WriteByte(format[i])
+			if format[i] == '%' && i+1 < len(format) {
+				// Copy the directive, which may be %%.
+				directive, n, err := directiveAt(format, i+1)
+				if err != nil {
+					return nil, err
+				}
+				current.WriteString(directive)
+				i += n
+			}
+			continue
+		}
+		end := strings.IndexByte(format[i:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated mode in format %q", format)
+		}
+		name := format[i+2 : i+end]
+		qualifier := ""
+		directiveMode, ok := directiveModes[name]
+		if !ok {
+			qualifier = name
+			directiveMode, ok = directiveQualifiers[name]
+		}
+		if !ok {
+			return nil, fmt.Errorf("unknown mode %%{%s} in format %q: expected absolute, elapsed, delta, tz2, or mark", name, format)
+		}
+		i += end + 1
+		if i == len(format) {
+			return nil, fmt.Errorf("format %q ends without a directive after %%{%s}", format, name)
+		}
+		if current.Len() > 0 {
+			add(formatPart{mode, "", current.String()})
+			current.Reset()
+		}
+		directive, n, err := directiveAt(format, i)
+		if err != nil {
+			return nil, err
+		}
+		add(formatPart{directiveMode, qualifier, "%" + directive})
+		i += n - 1
+	}
+	if current.Len() > 0 || len(parts) == 0 {
+		add(formatPart{mode, "", current.String()})
+	}
+	return parts, nil
+}
+
+// Stand-ins for %:z and %::z, which strftime cannot take as directives of
+// more than one byte. Neither byte occurs in UTF-8.
+const (
+	colonOffsetDirective       = '\xfe'
+	doubleColonOffsetDirective = '\xff'
+)
+
+// directiveAt returns the directive of format at i, following the %, and
+// its length in format, rewriting %:z and %::z to their stand-ins.
+func directiveAt(format string, i int) (string, int, error) {
+	colons := 0
+	for i+colons < len(format) && format[i+colons] == ':' {
+		colons++
+	}
+	if colons == 0 {
+		return format[i : i+1], 1, nil
+	}
+	if i+colons == len(format) {
+		return "", 0, fmt.Errorf("format %q ends without a directive after %%%s", format, format[i:])
+	}
+	if colons > 2 || format[i+colons] != 'z' {
+		return "", 0, fmt.Errorf("unknown directive %%%s in format %q: expected %%:z or %%::z", format[i:i+colons+1], format)
+	}
+	if colons == 1 {
+		return string([]byte{colonOffsetDirective}), 2, nil
+	}
+	return string([]byte{doubleColonOffsetDirective}), 3, nil
 }
 
+// Uses reports whether the format has directives qualified with qualifier,
+// such as tz2, which requires SecondTZ.
+func (t *Timestamper) Uses(qualifier string) bool {
+	for _, segment := range t.segments {
+		if segment.qualifier == qualifier {
+			return true
+		}
+	}
+	return false
+}
+
+// Mark sets the latest marker, from which directives qualified with mark
+// measure, to now.
+func (t *Timestamper) Mark(now time.Time) {
+	t.LastMark = now
+}
+
+// CurrentTimestampString returns the timestamp for the current time, which
+// becomes the reference point of the next incremental timestamp.
 func (t *Timestamper) CurrentTimestampString() string {
-	now := time.Now()
+	return t.AdvanceTo(time.Now())
+}
+
+// AdvanceTo returns the timestamp for now, which becomes the reference point
+// of the next incremental timestamp.
+func (t *Timestamper) AdvanceTo(now time.Time) string {
+	t.rate.Record(now)
+	delta := now.Sub(t.LastTimestamp)
+	if t.smoothed {
+		t.smoothedDelta += time.Duration(deltaSmoothing * float64(delta-t.smoothedDelta))
+	} else {
+		t.smoothedDelta = delta
+		t.smoothed = true
+	}
+	s := t.TimestampString(now)
+	t.LastTimestamp = now
+	return s
+}
+
+// Rebase makes start the reference point of elapsed and incremental
+// timestamps.
+func (t *Timestamper) Rebase(start time.Time) {
+	t.StartTimestamp = start
+	t.LastTimestamp = start
+	t.rate = &lineRate{start: start}
+	t.smoothedDelta = 0
+	t.smoothed = false
+}
+
+// TimestampString returns the timestamp for now without affecting subsequent
+// timestamps.
+func (t *Timestamper) TimestampString(now time.Time) string {
+	t.currentRate = t.rate.Rate(now)
+	if len(t.segments) == 1 {
+		return t.formatSegment(t.segments[0], now)
+	}
+	var b strings.Builder
+	for _, segment := range t.segments {
+		b.WriteString(t.formatSegment(segment, now))
+	}
+	return b.String()
+}
+
+func (t *Timestamper) formatSegment(segment formatSegment, now time.Time) string {
 	var s string
-	switch t.Mode {
+	switch segment.mode {
 	case AbsoluteTimeMode:
-		s = t.Formatter.FormatString(time.Now().In(t.TZ))
+		location := t.TZ
+		if segment.qualifier == secondTimezoneQualifier {
+			location = t.SecondTZ
+		}
+		s = segment.formatter.FormatString(t.roundTime(now.Add(t.Offset).In(location)))
 	case ElapsedTimeMode:
-		s = formatDuration(t.Formatter, now.Sub(t.StartTimestamp))
+		start := t.StartTimestamp
+		if !t.Since.IsZero() {
+			start = t.Since
+		}
+		if segment.qualifier == markQualifier && !t.LastMark.IsZero() {
+			start = t.LastMark
+		}
+		s = formatDuration(segment.formatter, t.roundDuration(now.Sub(start)))
 	case IncrementalTimeMode:
-		s = formatDuration(t.Formatter, now.Sub(t.LastTimestamp))
+		s = formatDuration(segment.formatter, t.roundDuration(now.Sub(t.LastTimestamp)))
 	default:
-		log.Panic("unknown mode ", t.Mode)
+		log.Panic("unknown mode ", segment.mode)
 	}
-	t.LastTimestamp = now
 	return s
 }
 
+// roundDuration rounds or truncates d to the bucket of Round, if any.
+func (t *Timestamper) roundDuration(d time.Duration) time.Duration {
+	switch {
+	case t.Round <= 0:
+		return d
+	case t.Truncate:
+		return d.Truncate(t.Round)
+	default:
+		return d.Round(t.Round)
+	}
+}
+
+// roundTime rounds or truncates now to the bucket of Round, if any, aligned
+// to the wall clock of its location, so that hours start on the hour.
+func (t *Timestamper) roundTime(now time.Time) time.Time {
+	if t.Round <= 0 {
+		return now
+	}
+	_, offset := now.Zone()
+	shift := time.Duration(offset) * time.Second
+	if t.Truncate {
+		return now.Add(shift).Truncate(t.Round).Add(-shift)
+	}
+	return now.Add(shift).Round(t.Round).Add(-shift)
+}
+
+// parseSince parses the reference point of --since, either a time in RFC
+// 3339 format, or as YYYY-MM-DD HH:MM:SS in location, or a duration before
+// now.
+func parseSince(s string, now time.Time, location *time.Location) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		t, err = time.ParseInLocation("2006-01-02 15:04:05", s, location)
+	}
+	if err != nil {
+		d, durationErr := time.ParseDuration(s)
+		if durationErr != nil || d < 0 {
+			return time.Time{}, fmt.Errorf("invalid --since %q: expected a time such as 2024-05-01T00:00:00Z or a duration ago such as 90m", s)
+		}
+		t = now.Add(-d)
+	}
+	if t.After(now) {
+		return time.Time{}, fmt.Errorf("invalid --since %q: in the future", s)
+	}
+	return t, nil
+}
+
+// isoYear renders the year of the ISO 8601 week of t, as %V numbers it,
+// which differs from the calendar year in the first and last days of some
+// years.
+var isoYear = strftime.AppendFunc(func(b []byte, t time.Time) []byte {
+	year, _ := t.ISOWeek()
+	return strconv.AppendInt(b, int64(year), 10)
+})
+
+var isoYearWithoutCentury = strftime.AppendFunc(func(b []byte, t time.Time) []byte {
+	year, _ := t.ISOWeek()
+	return append(b, fmt.Sprintf("%02d", year%100)...)
+})
+
+// zone returns the abbreviation and offset from UTC in seconds of the zone
+// in effect at tm in its location, looked up once per zone.
+func (t *Timestamper) zone(tm time.Time) (string, int) {
+	zones, ok := t.zones[tm.Location()]
+	if !ok {
+		zones = newZoneAbbreviations(tm.Location())
+		t.zones[tm.Location()] = zones
+	}
+	return zones.Zone(tm)
+}
+
+// appendOffset renders offset, in seconds east of UTC, as %z does, such as
+// -0700.
+func appendOffset(b []byte, offset int) []byte {
+	sign := byte('+')
+	if offset < 0 {
+		sign, offset = '-', -offset
+	}
+	return append(b, fmt.Sprintf("%c%02d%02d", sign, offset/3600, offset/60%60)...)
+}
+
+// offsetWithColons renders the UTC offset of t in layout, such as -07:00
+// for %:z as RFC 3339 has it.
+func offsetWithColons(layout string) strftime.Appender {
+	return strftime.AppendFunc(func(b []byte, t time.Time) []byte {
+		return t.AppendFormat(b, layout)
+	})
+}
+
+// relativeDay renders the day of t relative to today by the wall clock in
+// the location of t: today, yesterday, or its date as %F.
+var relativeDay = strftime.AppendFunc(func(b []byte, t time.Time) []byte {
+	today := time.Now().In(t.Location())
+	switch {
+	case sameDay(t, today):
+		return append(b, "today"...)
+	case sameDay(t, today.AddDate(0, 0, -1)):
+		return append(b, "yesterday"...)
+	}
+	return t.AppendFormat(b, "2006-01-02")
+})
+
+func sameDay(a time.Time, b time.Time) bool {
+	return a.Year() == b.Year() && a.YearDay() == b.YearDay()
+}
+
 func formatDuration(formatter *strftime.Strftime, duration time.Duration) string {
 	return formatter.FormatString(time.Unix(0, duration.Nanoseconds()).UTC())
 }
 
 var microseconds strftime.Appender
 
+// days renders the number of whole days of elapsed and incremental
+// timestamps, which %H wraps after 24 hours, or since the Unix epoch for
+// absolute timestamps.
+var days = strftime.AppendFunc(func(b []byte, t time.Time) []byte {
+	return strconv.AppendInt(b, t.Unix()/86400, 10)
+})
+
+// adaptiveDuration renders elapsed and incremental timestamps with units
+// chosen by their magnitude, e.g. +12ms, +3.4s, +1m 03.2s, or +2h 05m 00.0s.
+var adaptiveDuration = strftime.AppendFunc(func(b []byte, t time.Time) []byte {
+	return append(b, formatAdaptiveDuration(time.Duration(t.UnixNano()))...)
+})
+
+func formatAdaptiveDuration(d time.Duration) string {
+	sign := "+"
+	if d < 0 {
+		// Timestamps parsed from the input may go backwards.
+		sign, d = "-", -d
+	}
+	if d.Round(time.Millisecond) < time.Second {
+		return fmt.Sprintf("%s%dms", sign, d.Round(time.Millisecond).Milliseconds())
+	}
+	d = d.Round(100 * time.Millisecond)
+	hours, minutes := int(d/time.Hour), int(d%time.Hour/time.Minute)
+	seconds := (d % time.Minute).Seconds()
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%s%.1fs", sign, seconds)
+	case d < time.Hour:
+		return fmt.Sprintf("%s%dm %04.1fs", sign, minutes, seconds)
+	default:
+		return fmt.Sprintf("%s%dh %02dm %04.1fs", sign, hours, minutes, seconds)
+	}
+}
+
+// humanDuration renders elapsed and incremental timestamps with units
+// scaled to their magnitude, loosely, e.g. +2.3s, +45s, +3m12s, or +1h05m.
+var humanDuration = strftime.AppendFunc(func(b []byte, t time.Time) []byte {
+	return append(b, formatHumanDuration(time.Duration(t.UnixNano()))...)
+})
+
+func formatHumanDuration(d time.Duration) string {
+	sign := "+"
+	if d < 0 {
+		sign, d = "-", -d
+	}
+	if d.Round(100*time.Millisecond) < 10*time.Second {
+		return fmt.Sprintf("%s%.1fs", sign, d.Round(100*time.Millisecond).Seconds())
+	}
+	d = d.Round(time.Second)
+	hours, minutes, seconds := int(d/time.Hour), int(d%time.Hour/time.Minute), int(d%time.Minute/time.Second)
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%s%ds", sign, seconds)
+	case d < time.Hour:
+		return fmt.Sprintf("%s%dm%02ds", sign, minutes, seconds)
+	default:
+		return fmt.Sprintf("%s%dh%02dm", sign, hours, minutes)
+	}
+}
+
 func init() {
 	microseconds = strftime.AppendFunc(func(b []byte, t time.Time) []byte {
 		microsecond := int(t.Nanosecond()) / int(time.Microsecond)
@@ -79,3 +574,57 @@ func init() {
 		}
 	})
 }
+
+const (
+	// Window over which the output rate is computed.
+	lineRateWindow = 10 * time.Second
+	// Lines are counted in this many slices of the window.
+	lineRateSlices = 100
+	lineRateSlice  = lineRateWindow / lineRateSlices
+)
+
+// lineRate tracks the number of lines per second over a sliding window.
+type lineRate struct {
+	start  time.Time
+	counts [lineRateSlices]int
+	// Index of the current slice since the start.
+	slice int64
+	total int
+}
+
+// advance moves the window to now, dropping the counts of slices that fell
+// out of it.
+func (r *lineRate) advance(now time.Time) {
+	slice := int64(now.Sub(r.start) / lineRateSlice)
+	if slice-r.slice >= lineRateSlices {
+		r.counts = [lineRateSlices]int{}
+		r.total = 0
+		r.slice = slice
+	}
+	for r.slice < slice {
+		r.slice++
+		r.total -= r.counts[r.slice%lineRateSlices]
+		r.counts[r.slice%lineRateSlices] = 0
+	}
+}
+
+// Record counts a line at time now.
+func (r *lineRate) Record(now time.Time) {
+	r.advance(now)
+	r.counts[r.slice%lineRateSlices]++
+	r.total++
+}
+
+// Rate returns the number of lines per second over the window ending at
+// now, or since the start if more recent.
+func (r *lineRate) Rate(now time.Time) float64 {
+	r.advance(now)
+	window := now.Sub(r.start)
+	if window > lineRateWindow {
+		window = lineRateWindow
+	}
+	if window < lineRateSlice {
+		window = lineRateSlice
+	}
+	return float64(r.total) / window.Seconds()
+}
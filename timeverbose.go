@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// printTimeVerbose writes the resource usage report of GNU time -v for the
+// summarized run.
+func printTimeVerbose(w io.Writer, s *Summary) {
+	usage := s.Usage
+	if usage == nil {
+		usage = &ResourceUsage{}
+	}
+	elapsed := s.Duration()
+	cpu := usage.UserTime + usage.SystemTime
+
+	if usage.Signal != 0 {
+		fmt.Fprintf(w, "Command terminated by signal %d\n", usage.Signal)
+	} else if s.ExitCode != 0 {
+		fmt.Fprintf(w, "Command exited with non-zero status %d\n", s.ExitCode)
+	}
+
+	percent := "?%"
+	if elapsed > 0 {
+		percent = fmt.Sprintf("%d%%", int64(100*cpu.Seconds()/elapsed.Seconds()))
+	}
+	// Integral sizes are averaged over the CPU time in clock ticks.
+	ticks := int64(cpu / (10 * time.Millisecond))
+	average := func(integral int64) int64 {
+		if ticks == 0 {
+			return 0
+		}
+		return integral / ticks
+	}
+	exitStatus := s.ExitCode
+	if usage.Signal != 0 {
+		exitStatus = 0
+	}
+
+	fields := []struct {
+		name  string
+		value interface{}
+	}{
+		{"Command being timed", `"` + strings.Join(s.Command, " ") + `"`},
+		{"User time (seconds)", fmt.Sprintf("%.2f", usage.UserTime.Seconds())},
+		{"System time (seconds)", fmt.Sprintf("%.2f", usage.SystemTime.Seconds())},
+		{"Percent of CPU this job got", percent},
+		{"Elapsed (wall clock) time (h:mm:ss or m:ss)", formatTimeElapsed(elapsed)},
+		{"Average shared text size (kbytes)", average(usage.SharedTextSize)},
+		{"Average unshared data size (kbytes)", average(usage.UnsharedDataSize)},
+		{"Average stack size (kbytes)", average(usage.UnsharedStack)},
+		{"Average total size (kbytes)", average(usage.SharedTextSize + usage.UnsharedDataSize + usage.UnsharedStack)},
+		{"Maximum resident set size (kbytes)", usage.MaxRSS / 1024},
+		{"Average resident set size (kbytes)", 0},
+		{"Major (requiring I/O) page faults", usage.MajorFaults},
+		{"Minor (reclaiming a frame) page faults", usage.MinorFaults},
+		{"Voluntary context switches", usage.VoluntaryCtxSwitches},
+		{"Involuntary context switches", usage.InvoluntaryCtxSwitches},
+		{"Swaps", usage.Swaps},
+		{"File system inputs", usage.BlockInputs},
+		{"File system outputs", usage.BlockOutputs},
+		{"Socket messages sent", usage.MessagesSent},
+		{"Socket messages received", usage.MessagesReceived},
+		{"Signals delivered", usage.Signals},
+		{"Page size (bytes)", os.Getpagesize()},
+		{"Exit status", exitStatus},
+	}
+	for _, field := range fields {
+		fmt.Fprintf(w, "\t%s: %v\n", field.name, field.value)
+	}
+}
+
+// formatTimeElapsed formats d as h:mm:ss if at least an hour, and m:ss.cc
+// otherwise, like GNU time.
+func formatTimeElapsed(d time.Duration) string {
+	hours := int64(d / time.Hour)
+	minutes := int64(d/time.Minute) % 60
+	seconds := int64(d/time.Second) % 60
+	if hours > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", hours, minutes, seconds)
+	}
+	centiseconds := int64(d/(10*time.Millisecond)) % 100
+	return fmt.Sprintf("%d:%02d.%02d", minutes, seconds, centiseconds)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/creack/pty"
+)
+
+const titleInterval = time.Second
+
+// TitleUpdater keeps the title of the terminal updated with the state of the
+// run, such as "ets: make — 12m34s, last output 45s ago", written directly to
+// the terminal like the status bar. The previous title is saved on the
+// terminal's title stack while the run goes on.
+type TitleUpdater struct {
+	printer *Printer
+	tty     *os.File
+	name    string
+
+	done     chan struct{}
+	stopped  sync.WaitGroup
+	finished bool
+}
+
+// StartTitleUpdater starts updating the title of the terminal, if any, with
+// the progress of the run printed by printer. It returns nil if neither
+// stdout nor stderr is a terminal.
+func StartTitleUpdater(printer *Printer) *TitleUpdater {
+	tty := controllingTerminal()
+	if tty == nil {
+		return nil
+	}
+	name := "ets"
+	if len(printer.Summary.Command) > 0 {
+		name = "ets: " + strings.Join(printer.Summary.Command, " ")
+	}
+	u := &TitleUpdater{
+		printer: printer,
+		tty:     tty,
+		name:    name,
+		done:    make(chan struct{}),
+	}
+	printer.mu.Lock()
+	fmt.Fprint(tty, "\x1b[22;0t")
+	printer.mu.Unlock()
+	u.stopped.Add(1)
+	go u.loop()
+	return u
+}
+
+// Finish stops updating the title, and leaves it showing how the run
+// summarized in s ended after ets exits, for a glance at the tab to tell.
+// The previous title is popped off the title stack, to keep it balanced.
+func (u *TitleUpdater) Finish(s *Summary) {
+	if u == nil {
+		return
+	}
+	close(u.done)
+	u.stopped.Wait()
+	u.printer.mu.Lock()
+	defer u.printer.mu.Unlock()
+	fmt.Fprint(u.tty, "\x1b[23;0t")
+	u.set(describeCompletion(s))
+	u.finished = true
+}
+
+// Restore clears the title, and restores the one in place before the run on
+// terminals supporting the title stack, unless the run has finished. It is
+// meant for exits cutting the run short, which would otherwise leave a stale
+// title behind.
+func (u *TitleUpdater) Restore() {
+	if u == nil || u.finished {
+		return
+	}
+	close(u.done)
+	u.stopped.Wait()
+	u.printer.mu.Lock()
+	defer u.printer.mu.Unlock()
+	fmt.Fprint(u.tty, "\x1b]0;\x07\x1b[23;0t")
+}
+
+func (u *TitleUpdater) loop() {
+	defer u.stopped.Done()
+	ticker := time.NewTicker(titleInterval)
+	defer ticker.Stop()
+	for {
+		u.printer.mu.Lock()
+		now := time.Now()
+		summary := u.printer.Summary
+		u.set(now.Sub(summary.Start).Truncate(time.Second).String() + ", " + describeLastOutput(summary, now))
+		u.printer.mu.Unlock()
+		select {
+		case <-u.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// set sets the title to the name of the run followed by status. Must be
+// called with the printer locked.
+func (u *TitleUpdater) set(status string) {
+	fmt.Fprintf(u.tty, "\x1b]0;%s — %s\x07", u.name, status)
+}
+
+// ringBell rings the bell of the terminal, if any.
+func ringBell() {
+	if tty := controllingTerminal(); tty != nil {
+		fmt.Fprint(tty, "\a")
+	}
+}
+
+// controllingTerminal returns stdout or, failing that, stderr if it is a
+// terminal, or nil.
+func controllingTerminal() *os.File {
+	for _, f := range []*os.File{os.Stdout, os.Stderr} {
+		if _, err := pty.GetsizeFull(f); err == nil {
+			return f
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// toggleTmuxPane starts timestamping the output of the tmux pane target, or
+// the current pane if target is empty, into a file with tmux pipe-pane, by
+// running ets pipe with optionArgs. If the pane is already piped, the pipe
+// is closed instead, which ends the ets process cleanly. It returns a
+// message describing what was done.
+func toggleTmuxPane(target string, optionArgs []string, output string) (string, error) {
+	if target == "" && os.Getenv("TMUX") == "" {
+		return "", fmt.Errorf("not running inside tmux; give a target pane")
+	}
+	pane, err := tmuxPaneFormat(target, "#{pane_id}")
+	if err != nil {
+		return "", err
+	}
+	piped, err := tmuxPaneFormat(pane, "#{pane_pipe}")
+	if err != nil {
+		return "", err
+	}
+	if piped == "1" {
+		if err := runTmux("pipe-pane", "-t", pane); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("stopped timestamping pane %s", pane), nil
+	}
+
+	if output == "" {
+		output = fmt.Sprintf("ets-tmux-%s.log", strings.TrimPrefix(pane, "%"))
+	}
+	if output, err = filepath.Abs(output); err != nil {
+		return "", err
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	words := []string{"exec", shellSingleQuote(executable), "pipe"}
+	for _, arg := range optionArgs {
+		words = append(words, shellSingleQuote(arg))
+	}
+	words = append(words, ">>", shellSingleQuote(output))
+	if err := runTmux("pipe-pane", "-O", "-t", pane, strings.Join(words, " ")); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("timestamping pane %s into %s; run again to stop", pane, output), nil
+}
+
+// tmuxPaneFormat expands a tmux format for the pane target, or the current
+// pane if target is empty.
+func tmuxPaneFormat(target string, format string) (string, error) {
+	args := []string{"display-message", "-p"}
+	if target != "" {
+		args = append(args, "-t", target)
+	}
+	output, err := exec.Command("tmux", append(args, format)...).Output()
+	if err != nil {
+		return "", tmuxError(err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+func runTmux(args ...string) error {
+	if _, err := exec.Command("tmux", args...).Output(); err != nil {
+		return tmuxError(err)
+	}
+	return nil
+}
+
+func tmuxError(err error) error {
+	if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+		return fmt.Errorf("tmux: %s", strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	return fmt.Errorf("tmux: %s", err)
+}
+
+// withoutTeeOption returns args with any --tee option removed.
+func withoutTeeOption(args []string) []string {
+	filtered := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--tee":
+			i++
+		case strings.HasPrefix(args[i], "--tee="):
+		default:
+			filtered = append(filtered, args[i])
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Attempts at each upload, and the delay before the first retry, doubling
+// after each.
+var (
+	uploadAttempts   = 3
+	uploadRetryDelay = 2 * time.Second
+)
+
+// The manifest uploaded along with a log.
+type uploadManifest struct {
+	File      string      `json:"file"`
+	Bytes     int64       `json:"bytes"`
+	SHA256    string      `json:"sha256"`
+	Signature string      `json:"signature,omitempty"`
+	Summary   jsonSummary `json:"summary"`
+}
+
+// checkUploadDestination checks that dest is an s3:// or gs:// URL.
+func checkUploadDestination(dest string) error {
+	if (strings.HasPrefix(dest, "s3://") || strings.HasPrefix(dest, "gs://")) && len(dest) > len("s3://") {
+		return nil
+	}
+	return fmt.Errorf("invalid upload destination %q: expected s3://bucket/prefix/ or gs://bucket/prefix/", dest)
+}
+
+// uploadLog uploads the log at path, its signature if signed, and a manifest
+// including the summary s, under the prefix dest, named after the start of
+// the run and the name of the file, with the aws or gsutil command, and
+// returns the URL of the log.
+func uploadLog(dest string, path string, signed bool, s *Summary) (string, error) {
+	if !strings.HasSuffix(dest, "/") {
+		dest += "/"
+	}
+	name := s.Start.UTC().Format("20060102T150405Z") + "-" + filepath.Base(path)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	digest := sha256.Sum256(data)
+	manifest := uploadManifest{
+		File:    name,
+		Bytes:   int64(len(data)),
+		SHA256:  hex.EncodeToString(digest[:]),
+		Summary: newJSONSummary(s),
+	}
+	if err := uploadFile(path, dest+name); err != nil {
+		return "", err
+	}
+	if signed {
+		manifest.Signature = name + ".sig"
+		if err := uploadFile(path+".sig", dest+manifest.Signature); err != nil {
+			return "", err
+		}
+	}
+	out, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	manifestFile, err := ioutil.TempFile("", "ets-manifest-*.json")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(manifestFile.Name())
+	_, err = manifestFile.Write(append(out, '\n'))
+	if closeErr := manifestFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+	return dest + name, uploadFile(manifestFile.Name(), dest+name+".manifest.json")
+}
+
+// uploadFile copies path to url, retrying on failure.
+func uploadFile(path string, url string) error {
+	delay := uploadRetryDelay
+	var err error
+	for attempt := 1; attempt <= uploadAttempts; attempt++ {
+		if attempt > 1 {
+			log.Printf("error uploading to %s: %s; retrying in %s", url, err, delay)
+			time.Sleep(delay)
+			delay *= 2
+		}
+		var cmd *exec.Cmd
+		if strings.HasPrefix(url, "gs://") {
+			cmd = exec.Command("gsutil", "-q", "cp", path, url)
+		} else {
+			cmd = exec.Command("aws", "s3", "cp", "--only-show-errors", path, url)
+		}
+		output, runErr := cmd.CombinedOutput()
+		if runErr == nil {
+			return nil
+		}
+		err = fmt.Errorf("%s: %s: %s", cmd.Args[0], runErr, strings.TrimSpace(string(output)))
+	}
+	return err
+}
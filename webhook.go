@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Attempts at each webhook request, and the delay before the first retry,
+// doubling after each.
+var (
+	webhookAttempts   = 3
+	webhookRetryDelay = time.Second
+)
+
+// The payload posted by --webhook: the summary, as with --summary-json, and
+// where the log is.
+type webhookPayload struct {
+	jsonSummary
+	// The path of the log file, or its URL if uploaded.
+	Log string `json:"log,omitempty"`
+}
+
+// postWebhook posts the summary s of the run as JSON to url, along with the
+// location of the log, if any.
+func postWebhook(url string, s *Summary, logLocation string) error {
+	body, err := json.Marshal(webhookPayload{newJSONSummary(s), logLocation})
+	if err != nil {
+		return err
+	}
+	return postJSON(url, body)
+}
+
+// postJSON posts body to url as JSON, retrying on failure.
+func postJSON(url string, body []byte) error {
+	delay := webhookRetryDelay
+	for attempt := 1; ; attempt++ {
+		err := postJSONOnce(url, body)
+		if err == nil || attempt == webhookAttempts {
+			return err
+		}
+		log.Printf("error posting to %s: %s; retrying in %s", url, err, delay)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// postJSONOnce posts body to url as JSON, expecting a 2xx response.
+func postJSONOnce(url string, body []byte) error {
+	resp, err := http.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		content, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(content))
+	}
+	return nil
+}
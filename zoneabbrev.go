@@ -0,0 +1,95 @@
+package main
+
+import "time"
+
+// zoneAbbreviations caches the offsets from UTC of the time zone
+// abbreviations of a location, such as EST and EDT for America/New_York, to
+// read %Z back without probing the location on every line, and the zone in
+// effect around the latest time rendered, to write %Z and %z.
+type zoneAbbreviations struct {
+	location *time.Location
+	offsets  map[string]int
+	// The years probed, each of which has the abbreviations in use around
+	// it.
+	probed map[int]bool
+
+	// The zone in effect from from until until, in Unix seconds.
+	name        string
+	offset      int
+	from, until int64
+}
+
+func newZoneAbbreviations(location *time.Location) *zoneAbbreviations {
+	return &zoneAbbreviations{
+		location: location,
+		offsets:  make(map[string]int),
+		probed:   make(map[int]bool),
+	}
+}
+
+// Offset returns the offset from UTC in seconds of abbreviation in the
+// location around t, or of UTC and GMT if the location doesn't use them.
+func (z *zoneAbbreviations) Offset(abbreviation string, t time.Time) (int, bool) {
+	if offset, ok := z.offsets[abbreviation]; ok {
+		return offset, true
+	}
+	if year := t.Year(); !z.probed[year] {
+		z.probed[year] = true
+		// Zones change at most a few times a year; probing every two weeks
+		// finds both sides of DST.
+		for probe := time.Date(year, time.January, 1, 12, 0, 0, 0, time.UTC); probe.Year() == year; probe = probe.AddDate(0, 0, 14) {
+			name, offset := probe.In(z.location).Zone()
+			if _, ok := z.offsets[name]; !ok {
+				z.offsets[name] = offset
+			}
+		}
+		if offset, ok := z.offsets[abbreviation]; ok {
+			return offset, true
+		}
+	}
+	if abbreviation == "UTC" || abbreviation == "GMT" {
+		return 0, true
+	}
+	return 0, false
+}
+
+// Zones change at most a few times a year, and never twice within this span
+// of time, over which Zone looks for the bounds of the zone in effect.
+const zoneSpan = 7 * 24 * 60 * 60
+
+// Zone returns the abbreviation and the offset from UTC in seconds of the
+// zone in effect in the location at t. The zone is looked up once for the
+// span of time over which it remains in effect, to the nearest transition,
+// such as the switch from EST to EDT.
+func (z *zoneAbbreviations) Zone(t time.Time) (string, int) {
+	unix := t.Unix()
+	if z.from < z.until && unix >= z.from && unix < z.until {
+		return z.name, z.offset
+	}
+	z.name, z.offset = time.Unix(unix, 0).In(z.location).Zone()
+	z.from = z.bound(unix, unix-zoneSpan)
+	z.until = z.bound(unix, unix+zoneSpan) + 1
+	return z.name, z.offset
+}
+
+// bound returns the furthest second from at towards limit, at most, in
+// which the zone in effect at at remains in effect, by bisection.
+func (z *zoneAbbreviations) bound(at int64, limit int64) int64 {
+	same := func(unix int64) bool {
+		name, offset := time.Unix(unix, 0).In(z.location).Zone()
+		return name == z.name && offset == z.offset
+	}
+	if same(limit) {
+		return limit
+	}
+	// same(at) and !same(limit).
+	for at-limit > 1 || limit-at > 1 {
+		middle := at + (limit-at)/2
+		if same(middle) {
+			at = middle
+		} else {
+			limit = middle
+		}
+	}
+	return at
+}